@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 
 	"github.com/PlakarKorp/kloset/connectors/storage"
 	"github.com/PlakarKorp/kloset/repository"
@@ -28,6 +29,11 @@ type uiserver struct {
 	// only get a KContext, except sometimes you truly need an
 	// AppContext.
 	ctx *appcontext.AppContext
+
+	// owners of the chunks of the snapshots, for the unique bytes of the
+	// disk usage, rebuilt once the snapshots changed
+	chunkOwners   *utils.ChunkOwners
+	chunkOwnersMu sync.Mutex
 }
 
 type Item[T any] struct {
@@ -199,6 +205,8 @@ func SetupRoutes(server *http.ServeMux, repo *repository.Repository, ctx *appcon
 	server.Handle("GET /api/repository/snapshots", authToken(JSONAPIView(ui.repositorySnapshots)))
 	server.Handle("GET /api/repository/locate-pathname", authToken(JSONAPIView(ui.repositoryLocatePathname)))
 	server.Handle("GET /api/repository/importer-types", authToken(JSONAPIView(ui.repositoryImporterTypes)))
+	// holds are only placed and removed by plakar hold, the API lists them
+	server.Handle("GET /api/repository/holds", authToken(JSONAPIView(ui.repositoryHolds)))
 
 	server.Handle("GET /api/snapshot/{snapshot}", authToken(JSONAPIView(ui.snapshotHeader)))
 	server.Handle("GET /api/snapshot/reader/{snapshot_path...}", urlSigner.VerifyMiddleware(APIView(ui.snapshotReader)))
@@ -209,6 +217,8 @@ func SetupRoutes(server *http.ServeMux, repo *repository.Repository, ctx *appcon
 	server.Handle("GET /api/snapshot/vfs/chunks/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSChunks)))
 	server.Handle("GET /api/snapshot/vfs/search/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSSearch)))
 	server.Handle("GET /api/snapshot/vfs/errors/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSErrors)))
+	server.Handle("GET /api/snapshot/vfs/grep/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSGrep)))
+	server.Handle("GET /api/snapshot/vfs/du/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSDiskUsage)))
 
 	server.Handle("POST /api/snapshot/vfs/downloader/{snapshot_path...}", authToken(JSONAPIView(ui.snapshotVFSDownloader)))
 	server.Handle("GET /api/snapshot/vfs/downloader-sign-url/{id}", JSONAPIView(ui.snapshotVFSDownloaderSigned))
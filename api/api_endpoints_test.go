@@ -2,17 +2,20 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -79,6 +82,34 @@ func TestAPIRepositoryImporterTypes(t *testing.T) {
 	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
 }
 
+func TestAPIRepositoryHolds(t *testing.T) {
+	mux, repo, snap, _ := newAPIServer(t)
+	defer snap.Close()
+
+	w := doGET(t, mux, "/api/repository/holds")
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.JSONEq(t, `{"total":0,"items":[]}`, w.Body.String())
+
+	require.NoError(t, utils.PutHold(repo, &utils.Hold{
+		Snapshot:  snap.Header.Identifier,
+		CreatedAt: time.Now(),
+		Reason:    "audit",
+	}))
+
+	var items Items[struct {
+		Snapshot string `json:"snapshot"`
+		Reason   string `json:"reason"`
+		Active   bool   `json:"active"`
+	}]
+	w = doGET(t, mux, "/api/repository/holds")
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+	require.Equal(t, 1, items.Total)
+	require.Equal(t, hex.EncodeToString(snap.Header.Identifier[:]), items.Items[0].Snapshot)
+	require.Equal(t, "audit", items.Items[0].Reason)
+	require.True(t, items.Items[0].Active)
+}
+
 func TestAPISnapshotHeader(t *testing.T) {
 	mux, _, snap, _ := newAPIServer(t)
 	defer snap.Close()
@@ -146,6 +177,93 @@ func TestAPISnapshotVFSSearch(t *testing.T) {
 	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
 }
 
+func TestAPISnapshotVFSGrep(t *testing.T) {
+	mux, _, snap, _ := newAPIServer(t)
+	defer snap.Close()
+
+	indexID := snap.Header.GetIndexID()
+	id := hex.EncodeToString(indexID[:])
+
+	var page ItemsPage[utils.GrepMatch]
+	w := doGET(t, mux, "/api/snapshot/vfs/grep/"+id+":/subdir?pattern=HELLO&ignore_case=true")
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 2)
+	require.False(t, page.HasNext)
+	require.Equal(t, utils.GrepMatch{Path: "/subdir/dummy.txt", Line: 1, Text: "hello dummy"}, page.Items[0])
+
+	w = doGET(t, mux, "/api/snapshot/vfs/grep/"+id+":/subdir?pattern=hello&limit=1")
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	page = ItemsPage[utils.GrepMatch]{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	require.Len(t, page.Items, 1)
+	require.True(t, page.HasNext)
+
+	w = doGET(t, mux, "/api/snapshot/vfs/grep/"+id+":/subdir")
+	require.Equal(t, http.StatusBadRequest, w.Code, "body=%s", w.Body.String())
+
+	w = doGET(t, mux, "/api/snapshot/vfs/grep/"+id+":/subdir?pattern=(")
+	require.Equal(t, http.StatusBadRequest, w.Code, "body=%s", w.Body.String())
+}
+
+// cancelledAfter is a context cancelled once its error was checked n times.
+type cancelledAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelledAfter) Err() error {
+	if c.n == 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestAPISnapshotVFSDiskUsage(t *testing.T) {
+	mux, repo, snap, _ := newAPIServer(t)
+	defer snap.Close()
+
+	indexID := snap.Header.GetIndexID()
+	id := hex.EncodeToString(indexID[:])
+
+	var item Item[*utils.DiskUsage]
+	w := doGET(t, mux, "/api/snapshot/vfs/du/"+id+":/")
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &item))
+	require.Equal(t, uint64(20), item.Item.Size)
+	require.Equal(t, uint64(2), item.Item.Files)
+	require.Len(t, item.Item.Children, 1)
+	require.Empty(t, item.Item.Children[0].Children)
+
+	w = doGET(t, mux, "/api/snapshot/vfs/du/"+id+":/subdir?depth=1&unique=true")
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	item = Item[*utils.DiskUsage]{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &item))
+	require.Len(t, item.Item.Children, 2)
+	require.Equal(t, "dummy.txt", item.Item.Children[0].Name)
+	require.Equal(t, uint64(11), item.Item.Children[0].Size)
+	require.Equal(t, uint64(11), item.Item.Children[0].Unique)
+
+	w = doGET(t, mux, "/api/snapshot/vfs/du/"+id+":/subdir?depth=-1")
+	require.Equal(t, http.StatusBadRequest, w.Code, "body=%s", w.Body.String())
+
+	// a client going away is not an error, even when the cancellation is
+	// noticed while going over the other snapshots
+	other := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("other.txt", 0644, "hello other"),
+	})
+	defer other.Close()
+
+	req, err := http.NewRequestWithContext(&cancelledAfter{Context: context.Background(), n: 1},
+		"GET", "/api/snapshot/vfs/du/"+id+":/?depth=0&unique=true", nil)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, "body=%s", w.Body.String())
+	require.Empty(t, w.Body.String())
+}
+
 func TestAPIRepositoryLocatePathname(t *testing.T) {
 	mux, _, snap, _ := newAPIServer(t)
 	defer snap.Close()
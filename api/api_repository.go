@@ -12,11 +12,12 @@ import (
 
 	"github.com/PlakarKorp/kloset/connectors/storage"
 	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/header"
-	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/cached"
+	"github.com/PlakarKorp/plakar/utils"
 )
 
 type RepositoryInfoSnapshots struct {
@@ -255,9 +256,35 @@ func (ui *uiserver) repositoryImporterTypes(w http.ResponseWriter, r *http.Reque
 	return json.NewEncoder(w).Encode(items)
 }
 
-type TimelineLocation struct {
-	Snapshot header.Header `json:"snapshot"`
-	Entry    vfs.Entry     `json:"vfs_entry"`
+type TimelineLocation = utils.TimelineLocation
+
+func (ui *uiserver) repositoryHolds(w http.ResponseWriter, r *http.Request) error {
+	if !ui.norefresh {
+		if _, err := cached.RebuildStateFromStore(ui.ctx, ui.repository.Configuration().RepositoryID, ui.ctx.StoreConfig, false); err != nil {
+			return err
+		}
+	}
+
+	holds, err := utils.ListHolds(ui.repository)
+	if err != nil {
+		return err
+	}
+
+	type Entry struct {
+		*utils.Hold
+		Active bool `json:"active"`
+	}
+
+	now := time.Now()
+	items := Items[Entry]{
+		Total: len(holds),
+		Items: make([]Entry, len(holds)),
+	}
+	for i, hold := range holds {
+		items.Items[i] = Entry{Hold: hold, Active: hold.Active(now)}
+	}
+
+	return json.NewEncoder(w).Encode(items)
 }
 
 func (ui *uiserver) repositoryLocatePathname(w http.ResponseWriter, r *http.Request) error {
@@ -301,52 +328,31 @@ func (ui *uiserver) repositoryLocatePathname(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	totalSnapshots := int(0)
-	locations := make([]TimelineLocation, 0)
+	snapshotIDs := make([]objects.MAC, 0)
 	for snapshotID, err := range ui.repository.ListSnapshots() {
 		if err != nil {
 			// XXX - temporarily ignore errors in List snapshots iteration, it is safe here
 			continue
 		}
-		snap, err := snapshot.Load(ui.repository, snapshotID)
-		if err != nil {
-			return err
-		}
-
-		if importerType != "" && !strings.EqualFold(snap.Header.GetSource(0).Importer.Type, importerType) {
-			snap.Close()
-			continue
-		}
+		snapshotIDs = append(snapshotIDs, snapshotID)
+	}
 
-		if importerOrigin != "" && !strings.EqualFold(snap.Header.GetSource(0).Importer.Origin, importerOrigin) {
-			snap.Close()
-			continue
+	locations, err := utils.LocatePathname(ui.repository, snapshotIDs, resource, func(hdr *header.Header) bool {
+		if importerType != "" && !strings.EqualFold(hdr.GetSource(0).Importer.Type, importerType) {
+			return false
 		}
-
-		if importerDirectory != "" && !strings.EqualFold(snap.Header.GetSource(0).Importer.Directory, importerDirectory) {
-			snap.Close()
-			continue
+		if importerOrigin != "" && !strings.EqualFold(hdr.GetSource(0).Importer.Origin, importerOrigin) {
+			return false
 		}
-
-		pvfs, err := snap.Filesystem()
-		if err != nil {
-			snap.Close()
-			continue
-		}
-
-		entry, err := pvfs.GetEntry(resource)
-		if err != nil {
-			snap.Close()
-			continue
+		if importerDirectory != "" && !strings.EqualFold(hdr.GetSource(0).Importer.Directory, importerDirectory) {
+			return false
 		}
-
-		locations = append(locations, TimelineLocation{
-			Snapshot: *snap.Header,
-			Entry:    *entry,
-		})
-		totalSnapshots++
-		snap.Close()
+		return true
+	})
+	if err != nil {
+		return err
 	}
+	totalSnapshots := len(locations)
 
 	if limit == 0 {
 		limit = uint32(len(locations))
@@ -17,10 +17,10 @@ import (
 	"github.com/PlakarKorp/kloset/caching/lru"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
-	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/kloset/snapshot/header"
 	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/alecthomas/chroma/formatters"
 	"github.com/alecthomas/chroma/lexers"
 	"github.com/alecthomas/chroma/styles"
@@ -383,30 +383,7 @@ func (ui *uiserver) snapshotVFSBrowse(w http.ResponseWriter, r *http.Request) er
 }
 
 func (ui *uiserver) loadEntrySummaries(snap *snapshot.Snapshot, fsinfo *vfs.Entry) error {
-	if fsinfo.Summary != nil {
-		return nil
-	}
-
-	tree, err := snap.SummaryIdx()
-	if err != nil {
-		return err
-	}
-
-	key, found, err := tree.Find(fsinfo.Path())
-	if err != nil {
-		return err
-	}
-	if !found {
-		return fmt.Errorf("could not resolve pathname: %s", fsinfo.Path())
-	}
-
-	serializedSummary, err := ui.repository.GetBlobBytes(resources.RT_VFS_SUMMARY, key)
-	if err != nil {
-		return err
-	}
-
-	fsinfo.Summary, err = vfs.SummaryFromBytes(serializedSummary)
-	return err
+	return utils.LoadEntrySummary(ui.repository, snap, fsinfo)
 }
 
 func (ui *uiserver) snapshotVFSChildren(w http.ResponseWriter, r *http.Request) error {
@@ -660,6 +637,161 @@ func (ui *uiserver) snapshotVFSSearch(w http.ResponseWriter, r *http.Request) er
 	return json.NewEncoder(w).Encode(items)
 }
 
+var errGrepPageFull = errors.New("page full")
+
+func (ui *uiserver) snapshotVFSGrep(w http.ResponseWriter, r *http.Request) error {
+	snapshotID32, path, err := SnapshotPathParam(r, ui.repository, "snapshot_path")
+	if err != nil {
+		return err
+	}
+
+	pattern, ok, err := QueryParamToString(r, "pattern")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return parameterError("pattern", MissingArgument, ErrMissingField)
+	}
+
+	offset, err := QueryParamToInt64(r, "offset", 0, 0)
+	if err != nil {
+		return err
+	}
+
+	limit, err := QueryParamToInt64(r, "limit", 1, 50)
+	if err != nil {
+		return err
+	}
+
+	if len(r.URL.Query()["mime"]) > 20 {
+		return parameterError("mime", InvalidArgument, errors.New("too many mime types, you can only specify 20"))
+	}
+
+	opts := utils.GrepOptions{
+		Pattern:     pattern,
+		Fixed:       r.URL.Query().Get("fixed") == "true",
+		IgnoreCase:  r.URL.Query().Get("ignore_case") == "true",
+		FilesOnly:   r.URL.Query().Get("files_only") == "true",
+		Mimes:       r.URL.Query()["mime"],
+		Concurrency: 1,
+	}
+	if ui.ctx != nil {
+		opts.Concurrency = ui.ctx.MaxConcurrency
+	}
+	if _, err := opts.Compile(); err != nil {
+		return parameterError("pattern", InvalidArgument, err)
+	}
+
+	snap, err := loadsnap(ui.repository, snapshotID32)
+	if err != nil {
+		return err
+	}
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		path = "/"
+	}
+
+	items := ItemsPage[utils.GrepMatch]{
+		Items: []utils.GrepMatch{},
+	}
+
+	var i int64
+	err = utils.Grep(r.Context(), fs, path, &opts, func(match utils.GrepMatch) error {
+		if i >= offset+limit {
+			items.HasNext = true
+			return errGrepPageFull
+		}
+		if i >= offset {
+			items.Items = append(items.Items, match)
+		}
+		i++
+		return nil
+	})
+	if err != nil && !errors.Is(err, errGrepPageFull) {
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(items)
+}
+
+// getChunkOwners returns the index of the owners of the chunks of the
+// snapshots, only walking them all again if they changed since it was
+// last built.
+func (ui *uiserver) getChunkOwners(ctx context.Context) (*utils.ChunkOwners, error) {
+	ui.chunkOwnersMu.Lock()
+	defer ui.chunkOwnersMu.Unlock()
+
+	if ui.chunkOwners != nil {
+		current, err := ui.chunkOwners.Current(ui.repository)
+		if err != nil {
+			return nil, err
+		}
+		if current {
+			return ui.chunkOwners, nil
+		}
+	}
+
+	owners, err := utils.NewChunkOwners(ctx, ui.repository)
+	if err != nil {
+		return nil, err
+	}
+	ui.chunkOwners = owners
+	return owners, nil
+}
+
+func (ui *uiserver) snapshotVFSDiskUsage(w http.ResponseWriter, r *http.Request) error {
+	snapshotID32, path, err := SnapshotPathParam(r, ui.repository, "snapshot_path")
+	if err != nil {
+		return err
+	}
+
+	depth, err := QueryParamToInt64(r, "depth", 0, 1)
+	if err != nil {
+		return err
+	}
+
+	snap, err := loadsnap(ui.repository, snapshotID32)
+	if err != nil {
+		return err
+	}
+
+	if path == "" {
+		path = "/"
+	}
+
+	opts := utils.DiskUsageOptions{
+		Depth:  int(depth),
+		Files:  true,
+		Unique: r.URL.Query().Get("unique") == "true",
+	}
+	if opts.Unique {
+		opts.Owners, err = ui.getChunkOwners(r.Context())
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+	}
+	du, err := utils.GetDiskUsage(r.Context(), ui.repository, snap, path, &opts)
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(Item[*utils.DiskUsage]{Item: du})
+}
+
 func (ui *uiserver) snapshotVFSErrors(w http.ResponseWriter, r *http.Request) error {
 	snapshotID32, path, err := SnapshotPathParam(r, ui.repository, "snapshot_path")
 	if err != nil {
@@ -1,20 +1,25 @@
 package appcontext
 
 import (
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/caching"
 	"github.com/PlakarKorp/kloset/connectors"
 	"github.com/PlakarKorp/kloset/kcontext"
 	"github.com/PlakarKorp/pkg"
 	"github.com/PlakarKorp/plakar/config"
 	"github.com/PlakarKorp/plakar/cookies"
 	"github.com/PlakarKorp/plakar/utils"
+	"github.com/google/uuid"
 )
 
 type AppContext struct {
 	*kcontext.KContext
 
-	cookies *cookies.Manager `msgpack:"-"`
-	pkgmgr  *pkg.Manager     `msgpack:"-"`
-	Config  *config.Config   `msgpack:"-"`
+	cookies   *cookies.Manager    `msgpack:"-"`
+	pkgmgr    *pkg.Manager        `msgpack:"-"`
+	cacheCons caching.Constructor `msgpack:"-"`
+	Config    *config.Config      `msgpack:"-"`
 
 	ConfigDir string
 	secret    []byte
@@ -37,6 +42,7 @@ func NewAppContextFrom(ctx *AppContext) *AppContext {
 
 		cookies:   ctx.cookies,
 		pkgmgr:    ctx.pkgmgr,
+		cacheCons: ctx.cacheCons,
 		ConfigDir: ctx.ConfigDir,
 	}
 }
@@ -96,6 +102,22 @@ func (c *AppContext) GetPkgManager() *pkg.Manager {
 	return c.pkgmgr
 }
 
+// SetCacheConstructor sets up the cache manager on top of cons, which
+// also backs the caches opened with OpenCache.
+func (c *AppContext) SetCacheConstructor(cons caching.Constructor) {
+	c.cacheCons = cons
+	c.SetCache(caching.NewManager(cons))
+}
+
+// OpenCache opens a cache of the repository that the cache manager does
+// not know about, the caller must close it.
+func (c *AppContext) OpenCache(name string, repositoryID uuid.UUID) (caching.Cache, error) {
+	if c.cacheCons == nil {
+		return nil, fmt.Errorf("no cache configured")
+	}
+	return c.cacheCons(caching.CACHE_VERSION, name, repositoryID.String(), caching.None)
+}
+
 func (c *AppContext) ReloadConfig() error {
 	cfg, err := utils.LoadConfig(c.ConfigDir)
 	if err != nil {
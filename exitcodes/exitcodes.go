@@ -29,7 +29,13 @@ const (
 	AuthFailure = 77
 
 	// IntegrityFailure indicates a data integrity check failed
-	// (corrupted chunks, Merkle tree mismatch).
+	// (corrupted chunks, Merkle tree mismatch, restored files not
+	// matching the snapshot).
 	// Corresponds to EX_DATAERR from sysexits.h.
 	IntegrityFailure = 65
+
+	// SnapshotHeld indicates the removal of a snapshot was refused
+	// because a hold is placed on it.
+	// Corresponds to EX_TEMPFAIL from sysexits.h.
+	SnapshotHeld = 75
 )
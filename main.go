@@ -17,7 +17,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/PlakarKorp/kloset/caching"
 	"github.com/PlakarKorp/kloset/caching/pebble"
 	"github.com/PlakarKorp/kloset/connectors/storage"
 	"github.com/PlakarKorp/kloset/encryption"
@@ -38,6 +37,7 @@ import (
 	"github.com/denisbrodbeck/machineid"
 	"github.com/google/uuid"
 
+	_ "github.com/PlakarKorp/plakar/subcommands/anomaly"
 	_ "github.com/PlakarKorp/plakar/subcommands/archive"
 	_ "github.com/PlakarKorp/plakar/subcommands/backup"
 	_ "github.com/PlakarKorp/plakar/subcommands/cached"
@@ -48,13 +48,19 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/diag"
 	_ "github.com/PlakarKorp/plakar/subcommands/diff"
 	_ "github.com/PlakarKorp/plakar/subcommands/digest"
+	_ "github.com/PlakarKorp/plakar/subcommands/du"
 	_ "github.com/PlakarKorp/plakar/subcommands/dup"
+	_ "github.com/PlakarKorp/plakar/subcommands/dupes"
+	_ "github.com/PlakarKorp/plakar/subcommands/grep"
 	_ "github.com/PlakarKorp/plakar/subcommands/help"
+	_ "github.com/PlakarKorp/plakar/subcommands/history"
+	_ "github.com/PlakarKorp/plakar/subcommands/hold"
 	_ "github.com/PlakarKorp/plakar/subcommands/info"
 	_ "github.com/PlakarKorp/plakar/subcommands/locate"
 	_ "github.com/PlakarKorp/plakar/subcommands/login"
 	_ "github.com/PlakarKorp/plakar/subcommands/ls"
 	_ "github.com/PlakarKorp/plakar/subcommands/maintenance"
+	_ "github.com/PlakarKorp/plakar/subcommands/manifest"
 	_ "github.com/PlakarKorp/plakar/subcommands/mount"
 	_ "github.com/PlakarKorp/plakar/subcommands/pkg"
 	_ "github.com/PlakarKorp/plakar/subcommands/prune"
@@ -62,10 +68,12 @@ import (
 	_ "github.com/PlakarKorp/plakar/subcommands/repair"
 	_ "github.com/PlakarKorp/plakar/subcommands/restore"
 	_ "github.com/PlakarKorp/plakar/subcommands/rm"
+	_ "github.com/PlakarKorp/plakar/subcommands/scheduler"
 	_ "github.com/PlakarKorp/plakar/subcommands/server"
 	_ "github.com/PlakarKorp/plakar/subcommands/service"
 	_ "github.com/PlakarKorp/plakar/subcommands/sync"
 	_ "github.com/PlakarKorp/plakar/subcommands/ui"
+	_ "github.com/PlakarKorp/plakar/subcommands/undelete"
 	_ "github.com/PlakarKorp/plakar/subcommands/version"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
@@ -238,7 +246,7 @@ func entryPoint() int {
 		return 1
 	}
 	ctx.CacheDir = opt_cachedir
-	ctx.SetCache(caching.NewManager(pebble.Constructor(opt_cachedir)))
+	ctx.SetCacheConstructor(pebble.Constructor(opt_cachedir))
 	defer ctx.GetCache().Close()
 
 	err = os.MkdirAll(opt_datadir, 0700)
@@ -0,0 +1,303 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	plocate "github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+)
+
+const (
+	FindingModified = "modified"
+	FindingRetyped  = "retyped"
+	FindingEntropy  = "entropy"
+	FindingRenamed  = "renamed"
+)
+
+// Thresholds above which a change between two snapshots is anomalous,
+// percentages are of the regular files of the previous snapshot.
+type Thresholds struct {
+	MinFiles int     `json:"min_files"`
+	Modified float64 `json:"modified"`
+	Retyped  float64 `json:"retyped"`
+	Entropy  float64 `json:"entropy"`
+	Renamed  float64 `json:"renamed"`
+}
+
+func DefaultThresholds() Thresholds {
+	return Thresholds{
+		MinFiles: 10,
+		Modified: 50,
+		Retyped:  10,
+		Entropy:  1,
+		Renamed:  10,
+	}
+}
+
+func (t *Thresholds) validate() error {
+	if t.MinFiles < 1 {
+		return fmt.Errorf("invalid minimum number of files %d", t.MinFiles)
+	}
+	for name, pct := range map[string]float64{"modified": t.Modified, "retyped": t.Retyped, "renamed": t.Renamed} {
+		if pct <= 0 || pct > 100 {
+			return fmt.Errorf("invalid %s percentage %g", name, pct)
+		}
+	}
+	if t.Entropy <= 0 || t.Entropy > 8 {
+		return fmt.Errorf("invalid entropy increase %g", t.Entropy)
+	}
+	return nil
+}
+
+// Finding is a change that crossed its threshold.
+type Finding struct {
+	Kind      string  `json:"kind"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Message   string  `json:"message"`
+}
+
+// Analysis is the comparison of a snapshot with the previous one of the
+// same name.  Changed files are those modified in place or renamed, the
+// entropies are the means over them before and after the change.
+type Analysis struct {
+	Snapshot      objects.MAC `json:"snapshot"`
+	Previous      objects.MAC `json:"previous"`
+	Files         int         `json:"files"`
+	Modified      int         `json:"modified"`
+	Renamed       int         `json:"renamed"`
+	RenamedTo     string      `json:"renamed_to,omitempty"`
+	Retyped       int         `json:"retyped"`
+	EntropyBefore float64     `json:"entropy_before"`
+	EntropyAfter  float64     `json:"entropy_after"`
+	Findings      []Finding   `json:"findings"`
+}
+
+// file is what the analysis needs to know of a regular file.
+type file struct {
+	object      objects.MAC
+	contentType string
+	entropy     float64
+}
+
+// Previous returns the identifier of the most recent snapshot taken
+// before snap with the same name, if any.
+func Previous(repo *repository.Repository, snap *snapshot.Snapshot) (objects.MAC, bool, error) {
+	opts := plocate.NewDefaultLocateOptions(
+		plocate.WithName(snap.Header.Name),
+		plocate.WithBefore(snap.Header.Timestamp))
+
+	snapshotIDs, err := plocate.LocateSnapshotIDs(repo, opts)
+	if err != nil {
+		return objects.MAC{}, false, err
+	}
+
+	// newest first, and an empty name does not filter anything
+	for _, snapshotID := range snapshotIDs {
+		if snapshotID == snap.Header.Identifier {
+			continue
+		}
+		prev, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			return objects.MAC{}, false, err
+		}
+		name := prev.Header.Name
+		prev.Close()
+		if name == snap.Header.Name {
+			return snapshotID, true, nil
+		}
+	}
+	return objects.MAC{}, false, nil
+}
+
+// Analyze compares snap with the previous snapshot of the same name and
+// reports the changes crossing the thresholds.  It returns a nil
+// analysis if there is no previous snapshot.
+func Analyze(ctx context.Context, repo *repository.Repository, snap *snapshot.Snapshot, thresholds *Thresholds) (*Analysis, error) {
+	prevID, found, err := Previous(repo, snap)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+
+	prev, err := snapshot.Load(repo, prevID)
+	if err != nil {
+		return nil, err
+	}
+	defer prev.Close()
+
+	before, err := files(ctx, prev)
+	if err != nil {
+		return nil, fmt.Errorf("%x: %w", prevID[:4], err)
+	}
+	after, err := files(ctx, snap)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis := &Analysis{
+		Snapshot: snap.Header.Identifier,
+		Previous: prevID,
+		Files:    len(before),
+		Findings: []Finding{},
+	}
+
+	var changed int
+	change := func(old, new file) {
+		changed++
+		analysis.EntropyBefore += old.entropy
+		analysis.EntropyAfter += new.entropy
+		if isKnownType(old.contentType) && strings.HasPrefix(new.contentType, "application/octet-stream") {
+			analysis.Retyped++
+		}
+	}
+
+	removed := make(map[string]file)
+	for pathname, old := range before {
+		if _, ok := after[pathname]; !ok {
+			removed[pathname] = old
+		}
+	}
+	// a file renamed with a new extension keeps either its full name,
+	// as in report.pdf.locked, or its stem, as in report.locked
+	stems := make(map[string]file)
+	for pathname, old := range removed {
+		stems[strings.TrimSuffix(pathname, path.Ext(pathname))] = old
+	}
+
+	renamed := make(map[string]int)
+	for pathname, new := range after {
+		if old, ok := before[pathname]; ok {
+			if old.object != new.object {
+				analysis.Modified++
+				change(old, new)
+			}
+			continue
+		}
+
+		ext := path.Ext(pathname)
+		if ext == "" {
+			continue
+		}
+		stem := strings.TrimSuffix(pathname, ext)
+		old, ok := removed[stem]
+		if !ok {
+			old, ok = stems[stem]
+		}
+		if !ok {
+			continue
+		}
+		renamed[ext]++
+		change(old, new)
+	}
+	for ext, count := range renamed {
+		if count > analysis.Renamed || (count == analysis.Renamed && ext < analysis.RenamedTo) {
+			analysis.Renamed = count
+			analysis.RenamedTo = ext
+		}
+	}
+	if changed != 0 {
+		analysis.EntropyBefore /= float64(changed)
+		analysis.EntropyAfter /= float64(changed)
+	}
+
+	if analysis.Files < thresholds.MinFiles {
+		return analysis, nil
+	}
+
+	percent := func(n int) float64 {
+		return float64(n) * 100 / float64(analysis.Files)
+	}
+	if pct := percent(analysis.Modified); pct >= thresholds.Modified {
+		analysis.Findings = append(analysis.Findings, Finding{
+			Kind:      FindingModified,
+			Value:     pct,
+			Threshold: thresholds.Modified,
+			Message:   fmt.Sprintf("%.1f%% of files modified", pct),
+		})
+	}
+	if pct := percent(analysis.Retyped); pct >= thresholds.Retyped {
+		analysis.Findings = append(analysis.Findings, Finding{
+			Kind:      FindingRetyped,
+			Value:     pct,
+			Threshold: thresholds.Retyped,
+			Message:   fmt.Sprintf("%.1f%% of files turned into binary data", pct),
+		})
+	}
+	// a few files legitimately compressed or encrypted are not a trend
+	delta := analysis.EntropyAfter - analysis.EntropyBefore
+	if changed >= thresholds.MinFiles && delta >= thresholds.Entropy {
+		analysis.Findings = append(analysis.Findings, Finding{
+			Kind:      FindingEntropy,
+			Value:     delta,
+			Threshold: thresholds.Entropy,
+			Message: fmt.Sprintf("entropy of changed files rose from %.2f to %.2f bits per byte",
+				analysis.EntropyBefore, analysis.EntropyAfter),
+		})
+	}
+	if pct := percent(analysis.Renamed); pct >= thresholds.Renamed {
+		analysis.Findings = append(analysis.Findings, Finding{
+			Kind:      FindingRenamed,
+			Value:     pct,
+			Threshold: thresholds.Renamed,
+			Message:   fmt.Sprintf("%.1f%% of files renamed to %s", pct, analysis.RenamedTo),
+		})
+	}
+	return analysis, nil
+}
+
+// isKnownType returns true if the content type identifies a format,
+// rather than being unknown or generic binary data.
+func isKnownType(contentType string) bool {
+	return contentType != "" && !strings.HasPrefix(contentType, "application/octet-stream")
+}
+
+// files returns the regular files of the snapshot by pathname.
+func files(ctx context.Context, snap *snapshot.Snapshot) (map[string]file, error) {
+	pvfs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string]file)
+	for entry, err := range pvfs.Files("/") {
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !entry.Stat().Mode().IsRegular() {
+			continue
+		}
+		ret[entry.Path()] = file{
+			object:      entry.Object,
+			contentType: entry.GetContentType(),
+			entropy:     entry.GetEntropy(),
+		}
+	}
+	return ret, nil
+}
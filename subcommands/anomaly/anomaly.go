@@ -0,0 +1,152 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package anomaly
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	plocate "github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Anomaly{} }, 0, "anomaly")
+}
+
+type Anomaly struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *plocate.LocateOptions
+	Thresholds    Thresholds
+	OptJSON       bool
+	Snapshot      string
+}
+
+func (cmd *Anomaly) Parse(ctx *appcontext.AppContext, args []string) error {
+	cmd.LocateOptions = plocate.NewDefaultLocateOptions()
+	cmd.Thresholds = DefaultThresholds()
+
+	flags := flag.NewFlagSet("anomaly", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.BoolVar(&cmd.OptJSON, "json", false, "output the analysis as JSON")
+	flags.IntVar(&cmd.Thresholds.MinFiles, "min-files", cmd.Thresholds.MinFiles, "minimum number of files in the previous snapshot to check for anomalies")
+	flags.Float64Var(&cmd.Thresholds.Modified, "modified", cmd.Thresholds.Modified, "percentage of modified files considered anomalous")
+	flags.Float64Var(&cmd.Thresholds.Retyped, "retyped", cmd.Thresholds.Retyped, "percentage of files turned into binary data considered anomalous")
+	flags.Float64Var(&cmd.Thresholds.Entropy, "entropy", cmd.Thresholds.Entropy, "increase of the mean entropy of changed files, in bits per byte, considered anomalous")
+	flags.Float64Var(&cmd.Thresholds.Renamed, "renamed", cmd.Thresholds.Renamed, "percentage of files renamed to a same new extension considered anomalous")
+	cmd.LocateOptions.InstallLocateFlags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		return fmt.Errorf("at most one snapshot can be specified")
+	}
+	if flags.NArg() == 1 {
+		cmd.Snapshot = flags.Arg(0)
+		if !cmd.LocateOptions.Empty() {
+			ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
+		}
+	}
+	if err := cmd.Thresholds.validate(); err != nil {
+		return err
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+func (cmd *Anomaly) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	ret, err, _, _ := cmd.DoAnomaly(ctx, repo)
+	return ret, err
+}
+
+// DoAnomaly analyzes the snapshot and returns its identifier along with
+// a warning summarizing the anomalies found, if any.
+func (cmd *Anomaly) DoAnomaly(ctx *appcontext.AppContext, repo *repository.Repository) (int, error, objects.MAC, error) {
+	var snapshotID objects.MAC
+	if cmd.Snapshot != "" {
+		id, err := plocate.LocateSnapshotByPrefix(repo, cmd.Snapshot)
+		if err != nil {
+			return 1, fmt.Errorf("anomaly: %w", err), objects.MAC{}, nil
+		}
+		snapshotID = id
+	} else {
+		snapshotIDs, err := plocate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+		if err != nil {
+			return 1, fmt.Errorf("anomaly: could not fetch snapshots list: %w", err), objects.MAC{}, nil
+		}
+		if len(snapshotIDs) == 0 {
+			return 1, fmt.Errorf("anomaly: no snapshot found"), objects.MAC{}, nil
+		}
+		// newest first
+		snapshotID = snapshotIDs[0]
+	}
+
+	snap, err := snapshot.Load(repo, snapshotID)
+	if err != nil {
+		return 1, fmt.Errorf("anomaly: %x: %w", snapshotID[:4], err), objects.MAC{}, nil
+	}
+	defer snap.Close()
+
+	analysis, err := Analyze(ctx, repo, snap, &cmd.Thresholds)
+	if err != nil {
+		return 1, fmt.Errorf("anomaly: %x: %w", snapshotID[:4], err), snapshotID, nil
+	}
+
+	if cmd.OptJSON {
+		enc := json.NewEncoder(ctx.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(analysis); err != nil {
+			return 1, fmt.Errorf("anomaly: %w", err), snapshotID, nil
+		}
+	} else if analysis == nil {
+		fmt.Fprintf(ctx.Stdout, "anomaly: %x: no previous snapshot named %q\n", snapshotID[:4], snap.Header.Name)
+	} else if len(analysis.Findings) == 0 {
+		fmt.Fprintf(ctx.Stdout, "anomaly: %x: no anomaly found since %x\n", snapshotID[:4], analysis.Previous[:4])
+	} else {
+		for _, finding := range analysis.Findings {
+			fmt.Fprintf(ctx.Stdout, "anomaly: %x: %s since %x\n", snapshotID[:4], finding.Message, analysis.Previous[:4])
+		}
+	}
+
+	return 0, nil, snapshotID, analysis.Warning()
+}
+
+// Warning returns an error summarizing the findings, or nil if there
+// are none.
+func (analysis *Analysis) Warning() error {
+	if analysis == nil || len(analysis.Findings) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(analysis.Findings))
+	for _, finding := range analysis.Findings {
+		messages = append(messages, finding.Message)
+	}
+	return fmt.Errorf("anomalies detected since %x: %s", analysis.Previous[:4], strings.Join(messages, ", "))
+}
@@ -0,0 +1,198 @@
+package anomaly
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+const text = "The quick brown fox jumps over the lazy dog.\n"
+
+// generateSnapshot snapshots the files, by path relative to /docs,
+// under the given name.
+func generateSnapshot(t *testing.T, repo *repository.Repository, name string, files map[string]string) *snapshot.Snapshot {
+	// the lock of the previous snapshot is released asynchronously and
+	// may vanish while the next one lists the repository locks
+	t.Setenv("PLAKAR_LOCKLESS", "true")
+
+	mocks := []ptesting.MockFile{ptesting.NewMockDir("docs")}
+	for pathname, content := range files {
+		mocks = append(mocks, ptesting.NewMockFile("docs/"+pathname, 0644, content))
+	}
+	snap := ptesting.GenerateSnapshot(t, repo, mocks, ptesting.WithName(name))
+	t.Cleanup(func() { snap.Close() })
+
+	// snapshots are ordered by timestamp
+	time.Sleep(10 * time.Millisecond)
+	return snap
+}
+
+func randomContent(rnd *rand.Rand) string {
+	buf := make([]byte, 4096)
+	rnd.Read(buf)
+	return string(buf)
+}
+
+func documents(n int, content func(i int) (string, string)) map[string]string {
+	files := make(map[string]string)
+	for i := range n {
+		name, data := content(i)
+		files[name] = data
+	}
+	return files
+}
+
+func textDocuments(i int) (string, string) {
+	return fmt.Sprintf("doc%02d.txt", i), strings.Repeat(fmt.Sprintf("%d %s", i, text), 50)
+}
+
+func TestAnalyzeEncrypted(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	rnd := rand.New(rand.NewSource(1))
+
+	generateSnapshot(t, repo, "docs", documents(20, textDocuments))
+	// another name is not a predecessor
+	generateSnapshot(t, repo, "other", documents(20, textDocuments))
+	snap := generateSnapshot(t, repo, "docs", documents(20, func(i int) (string, string) {
+		name, _ := textDocuments(i)
+		return name + ".locked", randomContent(rnd)
+	}))
+
+	thresholds := DefaultThresholds()
+	analysis, err := Analyze(ctx, repo, snap, &thresholds)
+	require.NoError(t, err)
+	require.NotNil(t, analysis)
+
+	require.Equal(t, 20, analysis.Files)
+	require.Equal(t, 0, analysis.Modified)
+	require.Equal(t, 20, analysis.Renamed)
+	require.Equal(t, ".locked", analysis.RenamedTo)
+	require.Equal(t, 20, analysis.Retyped)
+	require.Less(t, analysis.EntropyBefore, 5.0)
+	require.Greater(t, analysis.EntropyAfter, 7.0)
+
+	var kinds []string
+	for _, finding := range analysis.Findings {
+		kinds = append(kinds, finding.Kind)
+	}
+	require.Equal(t, []string{FindingRetyped, FindingEntropy, FindingRenamed}, kinds)
+	require.ErrorContains(t, analysis.Warning(), "100.0% of files renamed to .locked")
+}
+
+func TestAnalyzeModified(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	generateSnapshot(t, repo, "docs", documents(20, textDocuments))
+	snap := generateSnapshot(t, repo, "docs", documents(20, func(i int) (string, string) {
+		name, content := textDocuments(i)
+		if i < 12 {
+			content += "edited\n"
+		}
+		return name, content
+	}))
+
+	thresholds := DefaultThresholds()
+	analysis, err := Analyze(ctx, repo, snap, &thresholds)
+	require.NoError(t, err)
+	require.Equal(t, 12, analysis.Modified)
+	require.Len(t, analysis.Findings, 1)
+	require.Equal(t, FindingModified, analysis.Findings[0].Kind)
+	require.InDelta(t, 60.0, analysis.Findings[0].Value, 0.01)
+
+	// too few files to tell
+	thresholds.MinFiles = 21
+	analysis, err = Analyze(ctx, repo, snap, &thresholds)
+	require.NoError(t, err)
+	require.Empty(t, analysis.Findings)
+	require.NoError(t, analysis.Warning())
+}
+
+func TestAnalyzeNoPrevious(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap := generateSnapshot(t, repo, "docs", documents(20, textDocuments))
+
+	thresholds := DefaultThresholds()
+	analysis, err := Analyze(ctx, repo, snap, &thresholds)
+	require.NoError(t, err)
+	require.Nil(t, analysis)
+	require.NoError(t, analysis.Warning())
+}
+
+func generateAnomaly(t *testing.T) (*repository.Repository, *appcontext.AppContext, *snapshot.Snapshot, *snapshot.Snapshot) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	prev := generateSnapshot(t, repo, "docs", documents(20, textDocuments))
+	snap := generateSnapshot(t, repo, "docs", documents(20, func(i int) (string, string) {
+		name, content := textDocuments(i)
+		return name, content + "edited\n"
+	}))
+	return repo, ctx, prev, snap
+}
+
+func TestExecuteCmdAnomaly(t *testing.T) {
+	repo, ctx, prev, snap := generateAnomaly(t)
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	cmd := &Anomaly{}
+	require.NoError(t, cmd.Parse(ctx, []string{}))
+	status, err, snapshotID, warning := cmd.DoAnomaly(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Equal(t, snap.Header.Identifier, snapshotID)
+	require.ErrorContains(t, warning, "100.0% of files modified")
+	require.Equal(t, fmt.Sprintf("anomaly: %x: 100.0%% of files modified since %x\n",
+		snap.Header.Identifier[:4], prev.Header.Identifier[:4]), bufOut.String())
+
+	bufOut.Reset()
+	cmd = &Anomaly{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json", "-modified", "100", fmt.Sprintf("%x", prev.Header.Identifier)}))
+	status, err, _, warning = cmd.DoAnomaly(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NoError(t, warning)
+	require.Equal(t, "null\n", bufOut.String())
+}
+
+func TestExecuteCmdAnomalyJSON(t *testing.T) {
+	repo, ctx, prev, _ := generateAnomaly(t)
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	cmd := &Anomaly{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var analysis Analysis
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &analysis))
+	require.Equal(t, prev.Header.Identifier, analysis.Previous)
+	require.Equal(t, 20, analysis.Modified)
+	require.Len(t, analysis.Findings, 1)
+}
+
+func TestParseAnomaly(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	require.Error(t, (&Anomaly{}).Parse(ctx, []string{"a", "b"}))
+	require.Error(t, (&Anomaly{}).Parse(ctx, []string{"-modified", "0"}))
+	require.Error(t, (&Anomaly{}).Parse(ctx, []string{"-renamed", "101"}))
+	require.Error(t, (&Anomaly{}).Parse(ctx, []string{"-entropy", "9"}))
+	require.Error(t, (&Anomaly{}).Parse(ctx, []string{"-min-files", "0"}))
+
+	cmd := &Anomaly{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-retyped", "5", "abcd"}))
+	require.Equal(t, "abcd", cmd.Snapshot)
+	require.Equal(t, 5.0, cmd.Thresholds.Retyped)
+	require.Equal(t, 50.0, cmd.Thresholds.Modified)
+}
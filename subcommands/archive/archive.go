@@ -19,13 +19,19 @@ package archive
 import (
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
 )
 
 func init() {
@@ -33,6 +39,9 @@ func init() {
 }
 
 func (cmd *Archive) Parse(ctx *appcontext.AppContext, args []string) error {
+	var optIncludes, optExcludes utils.PatternsFlag
+	var optIncludeFiles, optExcludeFiles utils.PatternsFlag
+
 	flags := flag.NewFlagSet("archive", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH]]\n", flags.Name())
@@ -43,6 +52,10 @@ func (cmd *Archive) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.StringVar(&cmd.Output, "output", "", "archive pathname")
 	flags.BoolVar(&cmd.Rebase, "rebase", false, "strip pathname when pulling")
 	flags.StringVar(&cmd.Format, "format", "tarball", "archive format: tar, tarball, zip")
+	flags.Var(&optIncludes, "include", "only archive pathnames matching this pattern (can be specified multiple times)")
+	flags.Var(&optExcludes, "exclude", "do not archive pathnames matching this pattern (can be specified multiple times)")
+	flags.Var(&optIncludeFiles, "include-file", "file containing include patterns, one per line (can be specified multiple times)")
+	flags.Var(&optExcludeFiles, "exclude-file", "file containing exclude patterns, one per line (can be specified multiple times)")
 	flags.Parse(args)
 
 	if flags.NArg() == 0 {
@@ -63,6 +76,31 @@ func (cmd *Archive) Parse(ctx *appcontext.AppContext, args []string) error {
 		cmd.Output = fmt.Sprintf("plakar-%s.%s", time.Now().UTC().Format(time.RFC3339), supportedFormats[cmd.Format])
 	}
 
+	for _, includeFile := range optIncludeFiles {
+		lines, err := utils.LoadIgnoreFile(includeFile)
+		if err != nil {
+			return err
+		}
+		optIncludes = append(optIncludes, lines...)
+	}
+
+	for _, excludeFile := range optExcludeFiles {
+		lines, err := utils.LoadIgnoreFile(excludeFile)
+		if err != nil {
+			return err
+		}
+		optExcludes = append(optExcludes, lines...)
+	}
+
+	// snapshot.Archive strips each of the archived paths, the selected
+	// files would all end up at the top of the archive
+	if cmd.Rebase && (len(optIncludes) != 0 || len(optExcludes) != 0) {
+		return fmt.Errorf("-rebase cannot be combined with include or exclude patterns")
+	}
+
+	cmd.Includes = optIncludes
+	cmd.Excludes = optExcludes
+
 	return nil
 }
 
@@ -73,9 +111,16 @@ type Archive struct {
 	Output         string
 	Format         string
 	SnapshotPrefix string
+	Includes       []string
+	Excludes       []string
 }
 
 func (cmd *Archive) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	filter, err := utils.NewPathFilter(cmd.Includes, cmd.Excludes)
+	if err != nil {
+		return 1, err
+	}
+
 	snap, pathname, err := locate.OpenSnapshotByPath(repo, cmd.SnapshotPrefix)
 	if err != nil {
 		return 1, fmt.Errorf("archive: could not open snapshot: %s", cmd.SnapshotPrefix)
@@ -91,9 +136,66 @@ func (cmd *Archive) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 		defer out.Close()
 	}
 
-	if err = snap.Archive(out, cmd.Format, []string{pathname}, cmd.Rebase); err != nil {
+	paths := []string{pathname}
+	if !filter.IsEmpty() {
+		paths, err = selectFiles(snap, pathname, filter)
+		if err != nil {
+			return 1, err
+		}
+	}
+
+	err = snap.Archive(out, cmd.Format, paths, cmd.Rebase)
+	if err != nil {
 		return 1, err
 	}
 
 	return 0, nil
 }
+
+// selectFiles returns the regular files below pathname selected by the
+// filter, so that snapshot.Archive only archives those.  Pathnames are
+// matched relative to the archived directory.
+func selectFiles(snap *snapshot.Snapshot, pathname string, filter *utils.PathFilter) ([]string, error) {
+	fsc, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := fsc.GetEntry(pathname)
+	if err != nil {
+		return nil, err
+	}
+	pathname = root.Path()
+
+	base := pathname
+	if !root.IsDir() {
+		base = path.Dir(pathname)
+	}
+
+	ctx := snap.AppContext()
+	paths := make([]string, 0)
+	err = fsc.WalkDir(pathname, func(entrypath string, e *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		relpath := "/" + strings.TrimLeft(strings.TrimPrefix(entrypath, base), "/")
+		if e.IsDir() {
+			if relpath != "/" && filter.Excludes(relpath, true) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if e.FileInfo.Lmode.IsRegular() && filter.Match(relpath, false) {
+			paths = append(paths, entrypath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
@@ -1,11 +1,14 @@
 package archive
 
 import (
+	"archive/tar"
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
@@ -118,3 +121,49 @@ func TestExecuteCmdArchiveDefault(t *testing.T) {
 	_, err = os.Stat(outputDir)
 	require.NoError(t, err)
 }
+
+func TestExecuteCmdArchiveFiltered(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockDir("another_subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+		ptesting.NewMockFile("subdir/foo.sql", 0644, "hello foo"),
+		ptesting.NewMockFile("another_subdir/bar.sql", 0644, "hello bar"),
+	})
+	defer snap.Close()
+
+	indexId := snap.Header.GetIndexID()
+	output := filepath.Join(t.TempDir(), "archive.tar")
+
+	cmd := &Archive{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-format", "tar", "-output", output, "-include", "*.sql", "-exclude", "another_subdir/", hex.EncodeToString(indexId[:])}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	fp, err := os.Open(output)
+	require.NoError(t, err)
+	defer fp.Close()
+
+	var names []string
+	rd := tar.NewReader(fp)
+	for {
+		header, err := rd.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+	}
+	require.Len(t, names, 1)
+	require.True(t, strings.HasSuffix(names[0], "subdir/foo.sql"), names[0])
+}
+
+func TestArchiveParseRebaseFiltered(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil), nil)
+
+	cmd := &Archive{}
+	err := cmd.Parse(ctx, []string{"-rebase", "-include", "*.sql", "abcd"})
+	require.ErrorContains(t, err, "-rebase cannot be combined")
+}
@@ -17,7 +17,7 @@
 package backup
 
 import (
-	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"maps"
@@ -40,6 +40,7 @@ import (
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/cached"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/subcommands/anomaly"
 	"github.com/PlakarKorp/plakar/utils"
 )
 
@@ -51,6 +52,7 @@ type Backup struct {
 	Excludes            []string
 	Sources             []string
 	OptCheck            bool
+	OptAnomaly          bool
 	Opts                map[string]string
 	DryRun              bool
 	PackfileTempStorage string
@@ -65,6 +67,14 @@ type Backup struct {
 	Category            string
 	Environment         string
 	Perimeter           string
+	Watch               bool
+	WatchDebounce       time.Duration
+	WatchInterval       time.Duration
+
+	// set in watch mode to only rescan the directories that changed
+	// since the parent snapshot.
+	changes   *changeSet
+	parentVFS *vfs.Filesystem
 }
 
 func init() {
@@ -133,11 +143,15 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.Var(&opt_ignore, "ignore", "gitignore pattern to exclude files, can be specified multiple times to add several exclusion patterns")
 	flags.StringVar(&cmd.PackfileTempStorage, "packfiles", "", "memory or a path to a directory to store temporary packfiles")
 	flags.BoolVar(&cmd.OptCheck, "check", false, "check the snapshot after creating it")
+	flags.BoolVar(&cmd.OptAnomaly, "anomaly", false, "compare the snapshot with the previous one of the same name for anomalies")
 	flags.Var(utils.NewOptsFlag(cmd.Opts), "o", "specify extra importer options")
 	flags.BoolVar(&cmd.DryRun, "dry-run", false, "do not actually perform a backup")
 	flags.BoolVar(&cmd.NoXattr, "no-xattr", false, "do not back up extended attributes")
 	flags.StringVar(&cmd.Cache, "cache", "vfs", "path to store vfs cache, 'no' for uncached and 'vfs' for the default in memory cache")
 	flags.BoolVar(&cmd.NoProgress, "no-progress", false, "do not display progress")
+	flags.BoolVar(&cmd.Watch, "watch", false, "keep running and snapshot the source as it changes")
+	flags.DurationVar(&cmd.WatchDebounce, "watch-debounce", 10*time.Second, "in watch mode, quiet period to wait for before taking a snapshot")
+	flags.DurationVar(&cmd.WatchInterval, "watch-interval", 10*time.Minute, "in watch mode, maximum delay between a change and its snapshot")
 
 	flags.Var(locate.NewTimeFlag(&cmd.ForcedTimestamp), "force-timestamp", "force a timestamp")
 	flags.Parse(args)
@@ -148,6 +162,23 @@ func (cmd *Backup) Parse(ctx *appcontext.AppContext, args []string) error {
 		}
 	}
 
+	if cmd.Watch {
+		if cmd.DryRun {
+			return fmt.Errorf("-watch and -dry-run are mutually exclusive")
+		}
+		if !cmd.ForcedTimestamp.IsZero() {
+			return fmt.Errorf("-watch and -force-timestamp are mutually exclusive")
+		}
+		if cmd.WatchDebounce <= 0 || cmd.WatchInterval < cmd.WatchDebounce {
+			return fmt.Errorf("invalid watch delays: debounce must be positive and not exceed the interval")
+		}
+		if flags.NArg() > 1 {
+			return fmt.Errorf("-watch supports a single source")
+		}
+		// the statistics pass would rescan the whole tree
+		cmd.NoProgress = true
+	}
+
 	for _, ignoreFile := range opt_ignore_files {
 		lines, err := LoadIgnoreFile(ignoreFile)
 		if err != nil {
@@ -194,6 +225,12 @@ func (cmd *Backup) Execute(ctx *appcontext.AppContext, repo *repository.Reposito
 }
 
 func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Repository) (int, error, objects.MAC, error) {
+	// the watch loop runs the backups itself, through copies without Watch
+	if cmd.Watch {
+		ret, err := cmd.watch(ctx, repo)
+		return ret, err, objects.MAC{}, nil
+	}
+
 	emitter := repo.Emitter("import")
 	defer emitter.Close()
 
@@ -223,32 +260,9 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 			scanDir = source
 		}
 
-		// We are going to mutate this, so do a copy
-		cmdOptsCopy := make(map[string]string)
-		maps.Copy(cmdOptsCopy, cmd.Opts)
-
-		if strings.HasPrefix(scanDir, "@") {
-			remote, ok := ctx.Config.GetSource(scanDir[1:])
-			if !ok {
-				return 1, fmt.Errorf("could not resolve importer: %s", scanDir), objects.MAC{}, nil
-			}
-			if _, ok := remote["location"]; !ok {
-				return 1, fmt.Errorf("could not resolve importer location: %s", scanDir), objects.MAC{}, nil
-			} else {
-				// inherit all the options -- but the ones
-				// specified in the command line takes the
-				// precedence.
-				for k, v := range remote {
-					if _, found := cmdOptsCopy[k]; !found {
-						cmdOptsCopy[k] = v
-					}
-				}
-			}
-		}
-
-		// Now that we have resolved the possible @ syntax let's apply the scandir.
-		if _, found := cmdOptsCopy["location"]; !found {
-			cmdOptsCopy["location"] = scanDir
+		cmdOptsCopy, err := cmd.importerConfig(ctx, scanDir)
+		if err != nil {
+			return 1, err, objects.MAC{}, nil
 		}
 
 		excludes := exclude.NewRuleSet()
@@ -265,6 +279,10 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		}
 		defer imp.Close(ctx)
 
+		if cmd.changes != nil && cmd.parentVFS != nil {
+			imp = newWatchImporter(imp, cmd.changes, cmd.parentVFS, excludes, cmd.NoXattr)
+		}
+
 		var (
 			typ  = imp.Type()
 			orig = imp.Origin()
@@ -333,9 +351,9 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 			return 0, nil, objects.MAC{}, nil
 		}
 
-		var parentVFS *vfs.Filesystem
+		parentVFS := cmd.parentVFS
 
-		if cmd.Cache == "vfs" {
+		if parentVFS == nil && cmd.Cache == "vfs" {
 			parentID, _, err := locate.Match(repo, &locate.LocateOptions{
 				Filters: locate.LocateFilters{
 					Latest: true,
@@ -407,11 +425,14 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 		return 1, fmt.Errorf("failed to commit snapshot: %w", err), objects.MAC{}, nil
 	}
 
-	if cmd.OptCheck {
+	if cmd.OptCheck || cmd.OptAnomaly {
 		_, err := cached.RebuildStateFromStore(ctx, repo.Configuration().RepositoryID, ctx.StoreConfig, false)
 		if err != nil {
 			return 1, fmt.Errorf("failed to rebuild state %w", err), objects.MAC{}, nil
 		}
+	}
+
+	if cmd.OptCheck {
 
 		checkOptions := &snapshot.CheckOptions{
 			FastCheck: false,
@@ -453,32 +474,70 @@ func (cmd *Backup) DoBackup(ctx *appcontext.AppContext, repo *repository.Reposit
 	if totalErrors > 0 {
 		warning = fmt.Errorf("%d errors during backup", totalErrors)
 	}
+
+	// anomalies do not fail the backup, they are reported as a warning
+	if cmd.OptAnomaly {
+		if err := cmd.anomaly(ctx, repo, snap.Header.Identifier); err != nil {
+			ctx.GetLogger().Warn("%s", err)
+			warning = errors.Join(warning, err)
+		}
+	}
 	return 0, nil, snap.Header.Identifier, warning
 }
 
-func LoadIgnoreFile(filename string) ([]string, error) {
-	fp, err := os.Open(filename)
+// anomaly compares the new snapshot with the previous one of the same
+// name and returns the anomalies found as an error.
+func (cmd *Backup) anomaly(ctx *appcontext.AppContext, repo *repository.Repository, snapshotID objects.MAC) error {
+	snap, err := snapshot.Load(repo, snapshotID)
+	if err != nil {
+		return fmt.Errorf("anomaly check failed: %w", err)
+	}
+	defer snap.Close()
+
+	thresholds := anomaly.DefaultThresholds()
+	analysis, err := anomaly.Analyze(ctx, repo, snap, &thresholds)
 	if err != nil {
-		return nil, fmt.Errorf("unable to open excludes file: %w", err)
+		return fmt.Errorf("anomaly check failed: %w", err)
 	}
-	defer fp.Close()
+	return analysis.Warning()
+}
 
-	var lines []string
-	scanner := bufio.NewScanner(fp)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#") {
-			continue
+// importerConfig returns the importer configuration for the given
+// source, resolving the @name syntax.
+func (cmd *Backup) importerConfig(ctx *appcontext.AppContext, scanDir string) (map[string]string, error) {
+	// We are going to mutate this, so do a copy
+	cmdOptsCopy := make(map[string]string)
+	maps.Copy(cmdOptsCopy, cmd.Opts)
+
+	if strings.HasPrefix(scanDir, "@") {
+		remote, ok := ctx.Config.GetSource(scanDir[1:])
+		if !ok {
+			return nil, fmt.Errorf("could not resolve importer: %s", scanDir)
 		}
-		if strings.Trim(line, " \t\r") == "" {
-			continue
+		if _, ok := remote["location"]; !ok {
+			return nil, fmt.Errorf("could not resolve importer location: %s", scanDir)
+		} else {
+			// inherit all the options -- but the ones
+			// specified in the command line takes the
+			// precedence.
+			for k, v := range remote {
+				if _, found := cmdOptsCopy[k]; !found {
+					cmdOptsCopy[k] = v
+				}
+			}
 		}
-		lines = append(lines, line)
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+
+	// Now that we have resolved the possible @ syntax let's apply the scandir.
+	if _, found := cmdOptsCopy["location"]; !found {
+		cmdOptsCopy["location"] = scanDir
 	}
-	return lines, nil
+
+	return cmdOptsCopy, nil
+}
+
+func LoadIgnoreFile(filename string) ([]string, error) {
+	return utils.LoadIgnoreFile(filename)
 }
 
 func executeHook(ctx *appcontext.AppContext, hook string) error {
@@ -282,6 +282,19 @@ func TestBackupCheckFlagParses(t *testing.T) {
 	require.True(t, cmd.OptCheck)
 }
 
+func TestBackupAnomalyFlagParses(t *testing.T) {
+	// Same as -check: the state must be rebuilt through cached before
+	// the new snapshot can be compared with the previous one.
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	_, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	t.Cleanup(ctx.Close)
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-anomaly", tmpBackupDir}))
+	require.True(t, cmd.OptAnomaly)
+	require.False(t, cmd.OptCheck)
+}
+
 func TestBackupPackfilesMemory(t *testing.T) {
 	status, err, _, _ := runBackup(t, []string{"-packfiles", "memory"}, nil)
 	require.NoError(t, err)
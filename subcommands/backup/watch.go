@@ -0,0 +1,596 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/kloset/exclude"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/cached"
+	"github.com/pkg/xattr"
+)
+
+const (
+	// the entries of the directory changed
+	changedEntries uint8 = 1 << iota
+	// the whole subtree must be rescanned, e.g. it was moved in
+	changedTree
+	// some directory below changed
+	changedBelow
+)
+
+// changeSet accumulates the directories reported as changed by the
+// watcher since the last snapshot.
+type changeSet struct {
+	mu       sync.Mutex
+	root     string
+	dirs     map[string]uint8
+	overflow bool
+	first    time.Time
+	last     time.Time
+
+	notify chan struct{}
+}
+
+func newChangeSet(root string) *changeSet {
+	return &changeSet{
+		root:   root,
+		dirs:   make(map[string]uint8),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (c *changeSet) touch() {
+	now := time.Now()
+	if c.first.IsZero() {
+		c.first = now
+	}
+	c.last = now
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *changeSet) mark(dir string, flag uint8) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dirs[dir] |= flag
+	for dir != c.root {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+		if c.dirs[dir]&changedBelow != 0 {
+			break
+		}
+		c.dirs[dir] |= changedBelow
+	}
+	c.touch()
+}
+
+// markEntries records that entries were created, removed or modified
+// in dir.
+func (c *changeSet) markEntries(dir string) {
+	c.mark(dir, changedEntries)
+}
+
+// markTree records that the whole tree below dir must be rescanned.
+func (c *changeSet) markTree(dir string) {
+	c.mark(dir, changedTree|changedEntries)
+}
+
+// markOverflow records that changes were lost and that a full scan is
+// needed.
+func (c *changeSet) markOverflow() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.overflow = true
+	c.touch()
+}
+
+func (c *changeSet) state(dir string) uint8 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dirs[dir]
+}
+
+func (c *changeSet) overflowed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.overflow
+}
+
+func (c *changeSet) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for _, flags := range c.dirs {
+		if flags&(changedEntries|changedTree) != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// pending returns the time of the first and last changes accumulated
+// since the last call to take.
+func (c *changeSet) pending() (first, last time.Time, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.first, c.last, !c.first.IsZero()
+}
+
+// take returns the accumulated changes and starts a new set.
+func (c *changeSet) take() *changeSet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	taken := &changeSet{
+		root:     c.root,
+		dirs:     c.dirs,
+		overflow: c.overflow,
+		first:    c.first,
+		last:     c.last,
+	}
+
+	c.dirs = make(map[string]uint8)
+	c.overflow = false
+	c.first = time.Time{}
+	c.last = time.Time{}
+
+	return taken
+}
+
+// wait blocks until changes are pending and either no change happened
+// for debounce or interval elapsed since the first one.  It returns
+// false if the context is canceled.
+func (c *changeSet) wait(ctx context.Context, debounce, interval time.Duration) bool {
+	for {
+		first, last, ok := c.pending()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-c.notify:
+			}
+			continue
+		}
+
+		deadline := last.Add(debounce)
+		if limit := first.Add(interval); limit.Before(deadline) {
+			deadline = limit
+		}
+
+		delay := time.Until(deadline)
+		if delay <= 0 {
+			return true
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		case <-c.notify:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// watchImporter wraps a filesystem importer and only reads from disk
+// the directories that changed, the rest of the tree being replayed
+// from the parent snapshot so that the builder reuses its entries.
+type watchImporter struct {
+	importer.Importer
+
+	changes  *changeSet
+	parent   *vfs.Filesystem
+	excludes *exclude.RuleSet
+	noxattr  bool
+
+	uidToName map[uint64]string
+	gidToName map[uint64]string
+}
+
+func newWatchImporter(imp importer.Importer, changes *changeSet, parent *vfs.Filesystem, excludes *exclude.RuleSet, noxattr bool) *watchImporter {
+	return &watchImporter{
+		Importer:  imp,
+		changes:   changes,
+		parent:    parent,
+		excludes:  excludes,
+		noxattr:   noxattr,
+		uidToName: make(map[uint64]string),
+		gidToName: make(map[uint64]string),
+	}
+}
+
+func (w *watchImporter) Flags() location.Flags {
+	return w.Importer.Flags() &^ location.FLAG_NEEDACK
+}
+
+func (w *watchImporter) Import(ctx context.Context, records chan<- *connectors.Record, results <-chan *connectors.Result) error {
+	defer close(records)
+
+	root := w.changes.root
+	for dir := filepath.Dir(root); ; dir = filepath.Dir(dir) {
+		info, err := os.Lstat(dir)
+		if err != nil {
+			records <- connectors.NewError(dir, err)
+		} else {
+			records <- connectors.NewRecord(dir, "", objects.FileInfoFromStat(info), nil, nil)
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	return w.walk(ctx, root, nil, records)
+}
+
+// walk emits the records for the tree rooted at dir.  The info
+// parameter holds the stat of dir when its parent was read from disk.
+func (w *watchImporter) walk(ctx context.Context, dir string, info fs.FileInfo, records chan<- *connectors.Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	flags := w.changes.state(dir)
+
+	if flags&changedTree != 0 {
+		return w.scan(ctx, dir, records)
+	}
+
+	entry, err := w.parent.GetEntryNoFollow(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return w.scan(ctx, dir, records)
+		}
+		return err
+	}
+
+	if flags == 0 {
+		if info == nil {
+			return w.replay(ctx, dir, true, records)
+		}
+		w.emit(dir, info, records)
+		return w.replay(ctx, dir, false, records)
+	}
+
+	if info == nil {
+		info, err = os.Lstat(dir)
+		if err != nil {
+			records <- connectors.NewError(dir, err)
+			return nil
+		}
+	}
+	w.emit(dir, info, records)
+
+	if flags&changedEntries == 0 {
+		// the entries are the same as in the parent, only some
+		// directories below changed.
+		children, err := entry.Getdents(w.parent)
+		if err != nil {
+			return err
+		}
+		for child, err := range children {
+			if err != nil {
+				return err
+			}
+			if child.IsDir() {
+				err = w.walk(ctx, child.Path(), nil, records)
+			} else {
+				w.emitEntry(child, records)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	dirents, err := os.ReadDir(dir)
+	if err != nil {
+		records <- connectors.NewError(dir, err)
+		return nil
+	}
+	for _, dirent := range dirents {
+		pathname := filepath.Join(dir, dirent.Name())
+		if w.excludes.IsExcluded(pathname, dirent.IsDir()) {
+			continue
+		}
+
+		info, err := dirent.Info()
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				records <- connectors.NewError(pathname, err)
+			}
+			continue
+		}
+
+		if info.IsDir() {
+			if err := w.walk(ctx, pathname, info, records); err != nil {
+				return err
+			}
+		} else {
+			w.emit(pathname, info, records)
+		}
+	}
+	return nil
+}
+
+// scan reads the tree rooted at dir from disk.
+func (w *watchImporter) scan(ctx context.Context, dir string, records chan<- *connectors.Record) error {
+	return filepath.WalkDir(dir, func(pathname string, d fs.DirEntry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				records <- connectors.NewError(pathname, err)
+			}
+			return nil
+		}
+
+		if pathname != dir && w.excludes.IsExcluded(pathname, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				records <- connectors.NewError(pathname, err)
+			}
+			return nil
+		}
+
+		w.emit(pathname, info, records)
+		return nil
+	})
+}
+
+// replay emits the tree rooted at dir as found in the parent snapshot.
+func (w *watchImporter) replay(ctx context.Context, dir string, self bool, records chan<- *connectors.Record) error {
+	return w.parent.WalkDir(dir, func(pathname string, entry *vfs.Entry, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if pathname == dir && !self {
+			return nil
+		}
+		w.emitEntry(entry, records)
+		return nil
+	})
+}
+
+func (w *watchImporter) emitEntry(entry *vfs.Entry, records chan<- *connectors.Record) {
+	pathname := entry.Path()
+
+	records <- connectors.NewRecord(pathname, entry.SymlinkTarget, entry.FileInfo, entry.ExtendedAttributes,
+		func() (io.ReadCloser, error) {
+			return os.Open(pathname)
+		})
+	for _, attr := range entry.ExtendedAttributes {
+		records <- connectors.NewXattr(pathname, attr, objects.AttributeExtended,
+			func() (io.ReadCloser, error) {
+				rd, err := entry.Xattr(w.parent, attr)
+				if err != nil {
+					return nil, err
+				}
+				return io.NopCloser(rd), nil
+			})
+	}
+}
+
+func (w *watchImporter) emit(pathname string, info fs.FileInfo, records chan<- *connectors.Record) {
+	var extendedAttributes []string
+	if !w.noxattr {
+		var err error
+		extendedAttributes, err = xattr.LList(pathname)
+		if err != nil {
+			records <- connectors.NewError(pathname, err)
+		}
+	}
+
+	fileinfo := objects.FileInfoFromStat(info)
+	fileinfo.Lusername, fileinfo.Lgroupname = w.lookupIDs(fileinfo.Uid(), fileinfo.Gid())
+
+	var target string
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		target, err = os.Readlink(pathname)
+		if err != nil {
+			records <- connectors.NewError(pathname, err)
+			return
+		}
+	}
+
+	records <- connectors.NewRecord(pathname, target, fileinfo, extendedAttributes,
+		func() (io.ReadCloser, error) {
+			return os.Open(pathname)
+		})
+	for _, attr := range extendedAttributes {
+		records <- connectors.NewXattr(pathname, attr, objects.AttributeExtended,
+			func() (io.ReadCloser, error) {
+				data, err := xattr.LGet(pathname, attr)
+				if err != nil {
+					return nil, err
+				}
+				return io.NopCloser(bytes.NewReader(data)), nil
+			})
+	}
+}
+
+func (w *watchImporter) lookupIDs(uid, gid uint64) (uname, gname string) {
+	if name, ok := w.uidToName[uid]; ok {
+		uname = name
+	} else if u, err := user.LookupId(fmt.Sprint(uid)); err == nil {
+		uname = u.Username
+		w.uidToName[uid] = uname
+	}
+
+	if name, ok := w.gidToName[gid]; ok {
+		gname = name
+	} else if g, err := user.LookupGroupId(fmt.Sprint(gid)); err == nil {
+		gname = g.Name
+		w.gidToName[gid] = gname
+	}
+
+	return
+}
+
+func (cmd *Backup) watch(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	scanDir := "fs:" + ctx.CWD
+	if len(cmd.Sources) != 0 {
+		scanDir = cmd.Sources[0]
+	}
+
+	config, err := cmd.importerConfig(ctx, scanDir)
+	if err != nil {
+		return 1, err
+	}
+
+	imp, err := importer.NewImporter(ctx.GetInner(), ctx.ImporterOpts(), config)
+	if err != nil {
+		return 1, fmt.Errorf("failed to create an importer for %s: %s", scanDir, err)
+	}
+	typ, root := imp.Type(), imp.Root()
+	imp.Close(ctx)
+
+	if typ != "fs" {
+		return 1, fmt.Errorf("watch mode is not supported for %s sources", typ)
+	}
+	if info, err := os.Lstat(root); err != nil {
+		return 1, err
+	} else if !info.IsDir() {
+		return 1, fmt.Errorf("watch mode requires a directory: %s", root)
+	}
+
+	excludes := exclude.NewRuleSet()
+	if err := excludes.AddRulesFromArray(cmd.Excludes); err != nil {
+		return 1, fmt.Errorf("failed to setup exclude rules: %w", err)
+	}
+
+	changes := newChangeSet(root)
+	watcher, err := newWatcher(root, excludes, changes)
+	if err != nil {
+		return 1, err
+	}
+	defer watcher.Close()
+
+	ctx.GetLogger().Info("watch: watching %s", root)
+
+	var parent objects.MAC
+	for {
+		taken := changes.take()
+
+		snapshotID, err := cmd.watchBackup(ctx, repo, parent, taken)
+		if err != nil {
+			// the changes are lost, the next run has to be a full scan
+			ctx.GetLogger().Error("watch: %s", err)
+			parent = objects.MAC{}
+		} else {
+			parent = snapshotID
+		}
+
+		if !changes.wait(ctx, cmd.WatchDebounce, cmd.WatchInterval) {
+			return 0, nil
+		}
+	}
+}
+
+func (cmd *Backup) watchBackup(ctx *appcontext.AppContext, repo *repository.Repository, parent objects.MAC, changes *changeSet) (objects.MAC, error) {
+	// DoBackup alters some of the options, work on a copy
+	run := *cmd
+	run.Watch = false
+
+	mode := "full scan"
+	if changes.overflowed() {
+		ctx.GetLogger().Warn("watch: event queue overflowed, performing a full scan")
+	} else if parent != (objects.MAC{}) {
+		// make sure the state knows about the parent, the final
+		// refresh of the previous backup is fire and forget.
+		_, err := cached.RebuildStateFromStore(ctx, repo.Configuration().RepositoryID, ctx.StoreConfig, false)
+		if err != nil {
+			return objects.MAC{}, fmt.Errorf("failed to rebuild state %w", err)
+		}
+
+		snap, err := snapshot.Load(repo, parent)
+		if err != nil {
+			return objects.MAC{}, fmt.Errorf("failed to load parent snapshot %x: %w", parent[:4], err)
+		}
+		defer snap.Close()
+
+		parentVFS, err := snap.FilesystemWithCache()
+		if err != nil {
+			return objects.MAC{}, fmt.Errorf("failed to get parent VFS for snapshot %x: %w", parent[:4], err)
+		}
+
+		run.changes = changes
+		run.parentVFS = parentVFS
+		mode = fmt.Sprintf("%d changed directories", changes.len())
+	}
+
+	status, err, snapshotID, warning := run.DoBackup(ctx, repo)
+	if err == nil && status != 0 {
+		err = fmt.Errorf("backup failed")
+	}
+	if err != nil {
+		return objects.MAC{}, err
+	}
+
+	ctx.GetLogger().Info("watch: created snapshot %x (%s)", snapshotID[:4], mode)
+
+	// entries that failed are not in the snapshot, have the next run
+	// look for them again.
+	if warning != nil {
+		ctx.GetLogger().Warn("watch: %s, next snapshot will perform a full scan", warning)
+		return objects.MAC{}, nil
+	}
+
+	return snapshotID, nil
+}
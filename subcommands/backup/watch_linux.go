@@ -0,0 +1,204 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	"github.com/PlakarKorp/kloset/exclude"
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CLOSE_WRITE |
+	unix.IN_CREATE | unix.IN_DELETE | unix.IN_DELETE_SELF |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_MOVE_SELF |
+	unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW | unix.IN_EXCL_UNLINK
+
+// watcher reports to a changeSet the directories modified below root,
+// using one inotify watch per directory.
+type watcher struct {
+	fd       int
+	file     *os.File
+	root     string
+	excludes *exclude.RuleSet
+	changes  *changeSet
+
+	// only accessed by the reading goroutine once started
+	paths map[int]string
+
+	done chan struct{}
+}
+
+func newWatcher(root string, excludes *exclude.RuleSet, changes *changeSet) (*watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize inotify: %w", err)
+	}
+
+	w := &watcher{
+		fd: fd,
+		// a non-blocking descriptor is handled by the runtime poller,
+		// so that closing the file interrupts a pending read.
+		file:     os.NewFile(uintptr(fd), "inotify"),
+		root:     root,
+		excludes: excludes,
+		changes:  changes,
+		paths:    make(map[int]string),
+		done:     make(chan struct{}),
+	}
+
+	if err := w.addTree(root); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+func (w *watcher) Close() error {
+	err := w.file.Close()
+	<-w.done
+	return err
+}
+
+// addTree sets up watches for dir and all the directories below it.
+func (w *watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(pathname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// vanished or unreadable directories are reported by
+			// the backup itself.
+			if d != nil && d.IsDir() && pathname != dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if pathname != dir && w.excludes.IsExcluded(pathname, true) {
+			return filepath.SkipDir
+		}
+
+		wd, err := unix.InotifyAddWatch(w.fd, pathname, watchMask)
+		if err != nil {
+			if errors.Is(err, unix.ENOSPC) {
+				return fmt.Errorf("too many directories to watch, consider raising fs.inotify.max_user_watches")
+			}
+			if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.EACCES) || errors.Is(err, unix.ENOTDIR) {
+				return nil
+			}
+			return fmt.Errorf("failed to watch %s: %w", pathname, err)
+		}
+		w.paths[wd] = pathname
+		return nil
+	})
+}
+
+// removeTree drops the watches for dir and all the directories below
+// it, used when a directory is moved since the watches would otherwise
+// keep reporting events under its former path.
+func (w *watcher) removeTree(dir string) {
+	for wd, pathname := range w.paths {
+		if pathname == dir || strings.HasPrefix(pathname, dir+"/") {
+			unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.paths, wd)
+		}
+	}
+}
+
+func (w *watcher) run() {
+	defer close(w.done)
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.changes.markOverflow()
+			}
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+
+			w.handle(int(event.Wd), event.Mask, name)
+		}
+	}
+}
+
+func (w *watcher) handle(wd int, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		w.changes.markOverflow()
+		return
+	}
+
+	dir, ok := w.paths[wd]
+	if !ok {
+		return
+	}
+
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+		return
+	}
+
+	if name == "" {
+		// event on the watched directory itself
+		if dir == w.root && mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0 {
+			w.changes.markOverflow()
+			return
+		}
+		w.changes.markEntries(dir)
+		return
+	}
+
+	pathname := filepath.Join(dir, name)
+	isDir := mask&unix.IN_ISDIR != 0
+	if w.excludes.IsExcluded(pathname, isDir) {
+		return
+	}
+
+	w.changes.markEntries(dir)
+
+	if !isDir {
+		return
+	}
+
+	if mask&unix.IN_MOVED_FROM != 0 {
+		w.removeTree(pathname)
+	}
+
+	if mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		w.changes.markTree(pathname)
+		if err := w.addTree(pathname); err != nil {
+			// without a watch changes below would go unnoticed
+			w.changes.markOverflow()
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/exclude"
+	"github.com/stretchr/testify/require"
+)
+
+func waitFor(t *testing.T, fn func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for inotify event")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a", "b"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "ignored"), 0755))
+
+	excludes := exclude.NewRuleSet()
+	require.NoError(t, excludes.AddRulesFromArray([]string{"ignored/"}))
+
+	changes := newChangeSet(root)
+	w, err := newWatcher(root, excludes, changes)
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "a", "b", "file"), []byte("data"), 0644))
+	waitFor(t, func() bool { return changes.state(filepath.Join(root, "a", "b"))&changedEntries != 0 })
+	require.Equal(t, changedBelow, changes.state(filepath.Join(root, "a")))
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "ignored", "file"), []byte("data"), 0644))
+
+	// new directories are watched as they appear
+	require.NoError(t, os.Mkdir(filepath.Join(root, "c"), 0755))
+	waitFor(t, func() bool { return changes.state(filepath.Join(root, "c"))&changedTree != 0 })
+	require.NoError(t, os.WriteFile(filepath.Join(root, "c", "file"), []byte("data"), 0644))
+
+	// and follow renames
+	require.NoError(t, os.Rename(filepath.Join(root, "a"), filepath.Join(root, "d")))
+	waitFor(t, func() bool { return changes.state(filepath.Join(root, "d"))&changedTree != 0 })
+
+	changes.take()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "d", "b", "file"), []byte("more data"), 0644))
+	waitFor(t, func() bool { return changes.state(filepath.Join(root, "d", "b"))&changedEntries != 0 })
+	require.Equal(t, uint8(0), changes.state(filepath.Join(root, "a", "b")))
+	require.Equal(t, uint8(0), changes.state(filepath.Join(root, "ignored")))
+}
@@ -0,0 +1,178 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/ui/stdio"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWatch(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	_, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+	defer ctx.Close()
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-watch", "-watch-debounce", "1s", tmpBackupDir}))
+	require.True(t, cmd.Watch)
+	require.True(t, cmd.NoProgress)
+	require.Equal(t, time.Second, cmd.WatchDebounce)
+
+	cmd = &Backup{}
+	require.Error(t, cmd.Parse(ctx, []string{"-watch", "-dry-run", tmpBackupDir}))
+
+	cmd = &Backup{}
+	require.Error(t, cmd.Parse(ctx, []string{"-watch", "-watch-debounce", "1h", "-watch-interval", "1m", tmpBackupDir}))
+
+	cmd = &Backup{}
+	require.Error(t, cmd.Parse(ctx, []string{"-watch", tmpBackupDir, tmpBackupDir}))
+}
+
+func TestChangeSet(t *testing.T) {
+	changes := newChangeSet("/data")
+
+	_, _, ok := changes.pending()
+	require.False(t, ok)
+
+	changes.markEntries("/data/a/b")
+	changes.markTree("/data/c")
+
+	require.Equal(t, changedEntries, changes.state("/data/a/b"))
+	require.Equal(t, changedBelow, changes.state("/data/a"))
+	require.Equal(t, changedBelow, changes.state("/data"))
+	require.Equal(t, changedTree|changedEntries, changes.state("/data/c"))
+	require.Equal(t, uint8(0), changes.state("/"))
+	require.Equal(t, uint8(0), changes.state("/data/d"))
+	require.Equal(t, 2, changes.len())
+
+	_, _, ok = changes.pending()
+	require.True(t, ok)
+
+	taken := changes.take()
+	require.Equal(t, changedEntries, taken.state("/data/a/b"))
+	require.Equal(t, uint8(0), changes.state("/data/a/b"))
+	_, _, ok = changes.pending()
+	require.False(t, ok)
+
+	changes.markOverflow()
+	require.True(t, changes.overflowed())
+	require.False(t, taken.overflowed())
+}
+
+func TestChangeSetWait(t *testing.T) {
+	changes := newChangeSet("/data")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.False(t, changes.wait(ctx, time.Millisecond, time.Second))
+
+	changes.markEntries("/data")
+	start := time.Now()
+	require.True(t, changes.wait(context.Background(), 50*time.Millisecond, time.Second))
+	require.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	// a constant stream of changes does not delay the snapshot past
+	// the interval.
+	changes.take()
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				changes.markEntries("/data")
+			}
+		}
+	}()
+	start = time.Now()
+	require.True(t, changes.wait(context.Background(), 50*time.Millisecond, 200*time.Millisecond))
+	close(done)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestWatchImporter(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, tmpBackupDir, ctx := generateFixtures(t, bufOut, bufErr)
+
+	renderer := stdio.New(ctx)
+	renderer.Run()
+	t.Cleanup(func() { renderer.Wait() })
+	t.Cleanup(ctx.Close)
+	ctx.MaxConcurrency = 1
+
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpBackupDir, "quiet"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpBackupDir, "quiet", "file"), []byte("before"), 0644))
+
+	cmd := &Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-progress", tmpBackupDir}))
+
+	// DoBackup alters the options, as in watch mode run on copies
+	full := *cmd
+	status, err, parentID, _ := full.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// changes reported by the watcher
+	require.NoError(t, os.WriteFile(filepath.Join(tmpBackupDir, "subdir", "new.txt"), []byte("new"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(tmpBackupDir, "another_subdir", "bar")))
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpBackupDir, "newdir", "deep"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpBackupDir, "newdir", "deep", "file"), []byte("deep"), 0644))
+
+	// a change the watcher did not report, must not be picked up
+	require.NoError(t, os.WriteFile(filepath.Join(tmpBackupDir, "quiet", "file"), []byte("after, longer"), 0644))
+
+	require.NoError(t, repo.RebuildState())
+
+	changes := newChangeSet(tmpBackupDir)
+	changes.markEntries(filepath.Join(tmpBackupDir, "subdir"))
+	changes.markEntries(filepath.Join(tmpBackupDir, "another_subdir"))
+	changes.markEntries(tmpBackupDir)
+	changes.markTree(filepath.Join(tmpBackupDir, "newdir"))
+
+	parent, err := snapshot.Load(repo, parentID)
+	require.NoError(t, err)
+	defer parent.Close()
+	parentVFS, err := parent.FilesystemWithCache()
+	require.NoError(t, err)
+
+	run := *cmd
+	run.changes = changes
+	run.parentVFS = parentVFS
+	status, err, snapshotID, _ := run.DoBackup(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.NoError(t, repo.RebuildState())
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+	fs, err := snap.Filesystem()
+	require.NoError(t, err)
+
+	readFile := func(name string) string {
+		fp, err := fs.Open(filepath.Join(tmpBackupDir, name))
+		require.NoError(t, err, name)
+		defer fp.Close()
+		data, err := io.ReadAll(fp)
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	require.Equal(t, "new", readFile("subdir/new.txt"))
+	require.Equal(t, "hello foo", readFile("subdir/foo.txt"))
+	require.Equal(t, "deep", readFile("newdir/deep/file"))
+	require.Equal(t, "before", readFile("quiet/file"))
+
+	_, err = fs.GetEntry(filepath.Join(tmpBackupDir, "another_subdir", "bar"))
+	require.Error(t, err)
+}
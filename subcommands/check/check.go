@@ -20,13 +20,16 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"time"
 
+	"github.com/PlakarKorp/go-human2duration"
 	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/exitcodes"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/dustin/go-humanize"
 	"github.com/google/uuid"
 )
 
@@ -37,6 +40,20 @@ type Check struct {
 	FastCheck     bool
 	NoVerify      bool
 	Snapshots     []string
+
+	// Incremental skips the objects verified by previous incremental
+	// checks, unless it was longer ago than ReverifyOlderThan.
+	Incremental       bool
+	ReverifyOlderThan time.Duration
+
+	// Sample is the fraction of the data verified, and Budget the
+	// maximum amount of data verified, by a sampled check.
+	Sample float64
+	Budget uint64
+
+	// Report is the file the damaged blobs and the files they affect
+	// are reported to, "-" for the standard output.
+	Report string
 }
 
 func init() {
@@ -44,6 +61,8 @@ func init() {
 }
 
 func (cmd *Check) Parse(ctx *appcontext.AppContext, args []string) error {
+	var reverify, sample, budget string
+
 	cmd.LocateOptions = locate.NewDefaultLocateOptions()
 
 	flags := flag.NewFlagSet("check", flag.ExitOnError)
@@ -55,10 +74,52 @@ func (cmd *Check) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	flags.BoolVar(&cmd.NoVerify, "no-verify", false, "disable signature verification")
 	flags.BoolVar(&cmd.FastCheck, "fast", false, "enable fast checking (no digest verification)")
+	flags.BoolVar(&cmd.Incremental, "incremental", false, "only verify the data not verified by previous incremental checks")
+	flags.StringVar(&reverify, "reverify-older-than", "", "with -incremental, verify again the data verified longer ago than this duration")
+	flags.StringVar(&sample, "sample", "", "only verify the content of a random sample of this percentage of the data")
+	flags.StringVar(&budget, "budget", "", "only verify the content of a random sample of at most this amount of data")
+	flags.StringVar(&cmd.Report, "report", "", "write a JSON report of the damaged data and the files it affects to this file, \"-\" for stdout")
 	cmd.LocateOptions.InstallLocateFlags(flags)
 
 	flags.Parse(args)
 
+	if reverify != "" {
+		if !cmd.Incremental {
+			return fmt.Errorf("-reverify-older-than requires -incremental")
+		}
+		duration, err := human2duration.ParseDuration(reverify)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", reverify, err)
+		}
+		if duration <= 0 {
+			return fmt.Errorf("invalid duration %q: must be positive", reverify)
+		}
+		cmd.ReverifyOlderThan = duration
+	}
+
+	if sample != "" {
+		fraction, err := parseSamplePercent(sample)
+		if err != nil {
+			return fmt.Errorf("invalid sample %q: %w", sample, err)
+		}
+		cmd.Sample = fraction
+	}
+
+	if budget != "" {
+		size, err := humanize.ParseBytes(budget)
+		if err != nil {
+			return fmt.Errorf("invalid budget %q: %w", budget, err)
+		}
+		if size == 0 {
+			return fmt.Errorf("invalid budget %q: must be positive", budget)
+		}
+		cmd.Budget = size
+	}
+
+	if (cmd.Sample != 0 || cmd.Budget != 0) && (cmd.FastCheck || cmd.Incremental) {
+		return fmt.Errorf("-sample and -budget can't be combined with -fast or -incremental")
+	}
+
 	if flags.NArg() != 0 && !cmd.LocateOptions.Empty() {
 		ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
 	}
@@ -101,6 +162,20 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		}
 	}
 
+	if cmd.Sample != 0 || cmd.Budget != 0 {
+		damaged, err := cmd.executeSample(ctx, repo, snapshots)
+		if err != nil {
+			return 1, err
+		}
+		if err := cmd.writeReport(ctx, repo, damaged); err != nil {
+			return 1, err
+		}
+		if len(damaged.blobs) != 0 {
+			return exitcodes.IntegrityFailure, fmt.Errorf("check failed for %d sampled chunks", len(damaged.blobs))
+		}
+		return 0, nil
+	}
+
 	opts := &snapshot.CheckOptions{
 		FastCheck: cmd.FastCheck,
 	}
@@ -111,9 +186,28 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 	}
 	defer checkCache.Close()
 
+	var verified *verifiedCache
+	if cmd.Incremental {
+		verified, err = openVerifiedCache(ctx, repo, time.Now(), cmd.ReverifyOlderThan)
+		if err != nil {
+			return 1, fmt.Errorf("failed to open the verification cache: %w", err)
+		}
+		defer verified.Close()
+
+		skipped, err := verified.seed(checkCache)
+		if err != nil {
+			return 1, fmt.Errorf("failed to load the verification cache: %w", err)
+		}
+		if skipped != 0 {
+			ctx.GetLogger().Info("check: skipping %d objects verified by previous runs", skipped)
+		}
+	}
+
 	emitter := repo.Emitter("check")
 	defer emitter.Close()
 
+	damaged := newDamagedBlobs()
+
 	var failures int
 	for _, arg := range snapshots {
 		snap, pathname, err := locate.OpenSnapshotByPath(repo, arg)
@@ -139,6 +233,29 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 			failed = true
 		}
 
+		// a fast check doesn't read the data, it verifies nothing
+		if verified != nil && !cmd.FastCheck {
+			n, err := verified.record(snap, pathname, checkCache)
+			if err != nil {
+				ctx.GetLogger().Warn("check: %x: failed to record the verified objects: %s", snap.Header.GetIndexShortID(), err)
+			} else if n != 0 {
+				ctx.GetLogger().Info("check: %x: %d new objects verified", snap.Header.GetIndexShortID(), n)
+			}
+		}
+
+		if cmd.Report != "" {
+			// the extended attributes and dirpacks are only verified
+			// for the report
+			found := len(damaged.blobs) + len(damaged.entries)
+			if err := damaged.collect(repo, snap, pathname, checkCache); err != nil {
+				ctx.GetLogger().Warn("check: %x: failed to collect the damaged data: %s", snap.Header.GetIndexShortID(), err)
+				failed = true
+			}
+			if len(damaged.blobs)+len(damaged.entries) != found {
+				failed = true
+			}
+		}
+
 		if failed {
 			failures++
 		}
@@ -146,6 +263,10 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		snap.Close()
 	}
 
+	if err := cmd.writeReport(ctx, repo, damaged); err != nil {
+		return 1, err
+	}
+
 	if failures != 0 {
 		snapshots := "snapshots"
 		if failures == 1 {
@@ -157,3 +278,22 @@ func (cmd *Check) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 
 	return 0, nil
 }
+
+// writeReport maps the damaged blobs to the files they affect in all the
+// snapshots of the repository, and writes the report if one was requested.
+func (cmd *Check) writeReport(ctx *appcontext.AppContext, repo *repository.Repository, damaged *damagedBlobs) error {
+	if cmd.Report == "" {
+		return nil
+	}
+
+	report, err := damaged.report(ctx, repo)
+	if err != nil {
+		return fmt.Errorf("failed to build the report: %w", err)
+	}
+	for _, affected := range report.Snapshots {
+		if affected.Error != "" {
+			ctx.GetLogger().Warn("check: %x: failed to list the damaged files: %s", affected.Snapshot[:4], affected.Error)
+		}
+	}
+	return writeReport(ctx, cmd.Report, report)
+}
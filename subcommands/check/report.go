@@ -0,0 +1,488 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package check
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/caching"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// File is a file or directory of a snapshot.
+type File struct {
+	Snapshot objects.MAC `json:"snapshot"`
+	Path     string      `json:"path"`
+}
+
+// DamagedBlob is a missing or corrupted blob, the packfiles holding a
+// copy of it, and the files whose content, extended attributes or
+// directory listing can't be restored because of it.
+type DamagedBlob struct {
+	Type      string        `json:"type"`
+	MAC       objects.MAC   `json:"mac"`
+	Packfiles []objects.MAC `json:"packfiles"`
+	Error     string        `json:"error"`
+	Files     []File        `json:"files"`
+}
+
+// DamagedEntry is a file or directory of a snapshot that couldn't be
+// read, the blob at fault being unknown.
+type DamagedEntry struct {
+	Snapshot objects.MAC `json:"snapshot"`
+	Path     string      `json:"path"`
+	Error    string      `json:"error"`
+}
+
+// DamagedPackfile is a packfile whose index couldn't be read, the blobs
+// it holds being unknown.
+type DamagedPackfile struct {
+	MAC   objects.MAC `json:"mac"`
+	Error string      `json:"error"`
+}
+
+// AffectedSnapshot is a snapshot with damaged files, or whose files
+// couldn't be listed.
+type AffectedSnapshot struct {
+	Snapshot     objects.MAC `json:"snapshot"`
+	DamagedFiles int         `json:"damaged_files"`
+	Error        string      `json:"error,omitempty"`
+}
+
+// Report maps the damaged blobs found by a check to the snapshots and the
+// files they affect, in all the snapshots of the repository.
+type Report struct {
+	Date      time.Time          `json:"date"`
+	Blobs     []*DamagedBlob     `json:"blobs"`
+	Entries   []DamagedEntry     `json:"entries"`
+	Packfiles []DamagedPackfile  `json:"packfiles"`
+	Snapshots []AffectedSnapshot `json:"snapshots"`
+}
+
+type blobKey struct {
+	typ resources.Type
+	mac objects.MAC
+}
+
+// damagedBlobs collects the damaged blobs found by the checks, and the
+// entries of the snapshots that couldn't be read.
+type damagedBlobs struct {
+	blobs   map[blobKey]*DamagedBlob
+	entries map[File]string
+}
+
+func newDamagedBlobs() *damagedBlobs {
+	return &damagedBlobs{
+		blobs:   make(map[blobKey]*DamagedBlob),
+		entries: make(map[File]string),
+	}
+}
+
+func (d *damagedBlobs) add(typ resources.Type, mac objects.MAC, reason string) {
+	key := blobKey{typ: typ, mac: mac}
+	if _, ok := d.blobs[key]; ok {
+		return
+	}
+	d.blobs[key] = &DamagedBlob{
+		Type:      typ.String(),
+		MAC:       mac,
+		Packfiles: make([]objects.MAC, 0),
+		Error:     reason,
+		Files:     make([]File, 0),
+	}
+}
+
+func (d *damagedBlobs) get(typ resources.Type, mac objects.MAC) (*DamagedBlob, bool) {
+	blob, ok := d.blobs[blobKey{typ: typ, mac: mac}]
+	return blob, ok
+}
+
+func (d *damagedBlobs) addEntry(snapshotID objects.MAC, pathname string, err error) {
+	d.entries[File{Snapshot: snapshotID, Path: pathname}] = err.Error()
+}
+
+// within returns true if key, the path of an entry or of one of its
+// extended attributes, is at or below pathname.
+func within(pathname, key string) bool {
+	pathname = strings.TrimSuffix(pathname, "/")
+	if !strings.HasPrefix(key, pathname) {
+		return false
+	}
+	rest := key[len(pathname):]
+	return rest == "" || strings.ContainsAny(rest[:1], "/:@")
+}
+
+// walkIndexes calls fn with the extended attributes below pathname and
+// the dirpacks of the directories below it, which the check of the files
+// doesn't read, and the pathname they belong to.
+func walkIndexes(snap *snapshot.Snapshot, pathname string, fn func(entrypath string, typ resources.Type, mac objects.MAC) error) error {
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return err
+	}
+
+	_, _, xattrs := fs.BTrees()
+	xattrIter, err := xattrs.ScanFrom(pathname)
+	if err != nil {
+		return err
+	}
+	for xattrIter.Next() {
+		key, mac := xattrIter.Current()
+		if !within(pathname, key) {
+			break
+		}
+		if err := fn(key, resources.RT_XATTR_ENTRY, mac); err != nil {
+			return err
+		}
+	}
+	if err := xattrIter.Err(); err != nil {
+		return err
+	}
+
+	dirpack, err := snap.DirPack()
+	if err != nil || dirpack == nil {
+		return err
+	}
+	dirpackIter, err := dirpack.ScanFrom(pathname)
+	if err != nil {
+		return err
+	}
+	for dirpackIter.Next() {
+		dir, mac := dirpackIter.Current()
+		if !within(pathname, dir) {
+			break
+		}
+		if err := fn(dir, resources.RT_OBJECT, mac); err != nil {
+			return err
+		}
+	}
+	return dirpackIter.Err()
+}
+
+// collect records the damaged blobs of the files below pathname, as
+// found by the check of the snapshot, and the entries that couldn't be
+// read.  The extended attributes and the dirpacks, which the check
+// doesn't read, are verified here.
+func (d *damagedBlobs) collect(repo *repository.Repository, snap *snapshot.Snapshot, pathname string, checkCache *caching.CheckCache) error {
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return err
+	}
+
+	err = fs.WalkDir(pathname, func(entrypath string, e *vfs.Entry, err error) error {
+		if err != nil {
+			d.addEntry(snap.Header.Identifier, entrypath, err)
+			return nil
+		}
+		if !e.HasObject() {
+			return nil
+		}
+
+		status, err := checkCache.GetObjectStatus(e.Object)
+		if err != nil {
+			return err
+		}
+		if len(status) == 0 {
+			return nil
+		}
+
+		object, err := snap.LookupObject(e.Object)
+		if err != nil {
+			d.add(resources.RT_OBJECT, e.Object, string(status))
+			return nil
+		}
+
+		damagedChunks := 0
+		for _, chunk := range object.Chunks {
+			status, err := checkCache.GetChunkStatus(chunk.ContentMAC)
+			if err != nil {
+				return err
+			}
+			if len(status) != 0 {
+				d.add(resources.RT_CHUNK, chunk.ContentMAC, string(status))
+				damagedChunks++
+			}
+		}
+
+		// the chunks are fine, their concatenation is not
+		if damagedChunks == 0 {
+			d.add(resources.RT_OBJECT, e.Object, string(status))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return walkIndexes(snap, pathname, func(entrypath string, typ resources.Type, mac objects.MAC) error {
+		if typ == resources.RT_XATTR_ENTRY {
+			xattr, err := fs.ResolveXattr(mac)
+			if err != nil {
+				d.add(typ, mac, err.Error())
+				return nil
+			}
+			mac = xattr.Object
+		}
+		return d.verifyObject(repo, snap, mac, checkCache)
+	})
+}
+
+// verifyObject reads the chunks of an object that the check didn't, and
+// records the damaged ones.
+func (d *damagedBlobs) verifyObject(repo *repository.Repository, snap *snapshot.Snapshot, mac objects.MAC, checkCache *caching.CheckCache) error {
+	object, err := snap.LookupObject(mac)
+	if err != nil {
+		d.add(resources.RT_OBJECT, mac, err.Error())
+		return nil
+	}
+
+	for _, chunk := range object.Chunks {
+		status, err := checkCache.GetChunkStatus(chunk.ContentMAC)
+		if err != nil {
+			return err
+		}
+		if status == nil {
+			status = []byte("")
+			if data, err := repo.GetBlobBytes(resources.RT_CHUNK, chunk.ContentMAC); err != nil {
+				status = []byte(snapshot.ErrChunkMissing.Error())
+			} else if repo.ComputeMAC(data) != chunk.ContentMAC {
+				status = []byte(snapshot.ErrChunkCorrupted.Error())
+			}
+			if err := checkCache.PutChunkStatus(chunk.ContentMAC, status); err != nil {
+				return err
+			}
+		}
+		if len(status) != 0 {
+			d.add(resources.RT_CHUNK, chunk.ContentMAC, string(status))
+		}
+	}
+	return nil
+}
+
+// report builds the report of the damaged blobs by going over the files of
+// all the snapshots of the repository to find those they affect, and
+// searches the packfiles holding a copy of them.
+func (d *damagedBlobs) report(ctx *appcontext.AppContext, repo *repository.Repository) (*Report, error) {
+	report := &Report{
+		Date:      time.Now(),
+		Blobs:     make([]*DamagedBlob, 0, len(d.blobs)),
+		Entries:   make([]DamagedEntry, 0, len(d.entries)),
+		Packfiles: make([]DamagedPackfile, 0),
+		Snapshots: make([]AffectedSnapshot, 0),
+	}
+
+	if len(d.blobs) != 0 || len(d.entries) != 0 {
+		// the damaged blobs of each object, resolved once
+		resolved := make(map[objects.MAC][]*DamagedBlob)
+
+		for snapshotID, err := range repo.ListSnapshots() {
+			if err != nil {
+				return nil, err
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			affected := AffectedSnapshot{Snapshot: snapshotID}
+			n, err := d.impact(repo, snapshotID, resolved)
+			if err != nil {
+				affected.Error = err.Error()
+			}
+			affected.DamagedFiles = n
+			if n != 0 || err != nil {
+				report.Snapshots = append(report.Snapshots, affected)
+			}
+		}
+	}
+
+	if len(d.blobs) != 0 {
+		match := func(mac objects.MAC) bool {
+			for key := range d.blobs {
+				if key.mac == mac {
+					return true
+				}
+			}
+			return false
+		}
+		for location, err := range utils.SearchBlobs(repo, match) {
+			if err != nil {
+				report.Packfiles = append(report.Packfiles, DamagedPackfile{MAC: location.Packfile, Error: err.Error()})
+				continue
+			}
+			if blob, ok := d.get(location.Type, location.MAC); ok {
+				blob.Packfiles = append(blob.Packfiles, location.Packfile)
+			}
+		}
+	}
+
+	for _, blob := range d.blobs {
+		report.Blobs = append(report.Blobs, blob)
+	}
+	for entry, reason := range d.entries {
+		report.Entries = append(report.Entries, DamagedEntry{Snapshot: entry.Snapshot, Path: entry.Path, Error: reason})
+	}
+
+	sort.Slice(report.Blobs, func(i, j int) bool {
+		if report.Blobs[i].Type != report.Blobs[j].Type {
+			return report.Blobs[i].Type < report.Blobs[j].Type
+		}
+		return bytes.Compare(report.Blobs[i].MAC[:], report.Blobs[j].MAC[:]) < 0
+	})
+	sort.Slice(report.Entries, func(i, j int) bool {
+		if report.Entries[i].Snapshot != report.Entries[j].Snapshot {
+			return bytes.Compare(report.Entries[i].Snapshot[:], report.Entries[j].Snapshot[:]) < 0
+		}
+		return report.Entries[i].Path < report.Entries[j].Path
+	})
+	return report, nil
+}
+
+// impact adds the files of the snapshot depending on damaged blobs to
+// these blobs, records the entries that can't be read, and returns the
+// number of files affected.
+func (d *damagedBlobs) impact(repo *repository.Repository, snapshotID objects.MAC, resolved map[objects.MAC][]*DamagedBlob) (int, error) {
+	snap, err := snapshot.Load(repo, snapshotID)
+	if err != nil {
+		return 0, err
+	}
+	defer snap.Close()
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return 0, err
+	}
+
+	affected := make(map[string]struct{})
+	depends := func(entrypath string, blobs []*DamagedBlob) {
+		if len(blobs) == 0 {
+			return
+		}
+		affected[entrypath] = struct{}{}
+		for _, blob := range blobs {
+			blob.Files = append(blob.Files, File{Snapshot: snapshotID, Path: entrypath})
+		}
+	}
+
+	err = fs.WalkDir("/", func(entrypath string, e *vfs.Entry, err error) error {
+		if err != nil {
+			d.addEntry(snapshotID, entrypath, err)
+			affected[entrypath] = struct{}{}
+			return nil
+		}
+		if !e.HasObject() {
+			return nil
+		}
+
+		blobs, ok := resolved[e.Object]
+		if !ok {
+			blobs = d.objectBlobs(snap, e.Object)
+			resolved[e.Object] = blobs
+		}
+		depends(entrypath, blobs)
+		return nil
+	})
+	if err != nil {
+		return len(affected), err
+	}
+
+	err = walkIndexes(snap, "/", func(entrypath string, typ resources.Type, mac objects.MAC) error {
+		if typ != resources.RT_XATTR_ENTRY {
+			blobs, ok := resolved[mac]
+			if !ok {
+				blobs = d.objectBlobs(snap, mac)
+				resolved[mac] = blobs
+			}
+			depends(entrypath, blobs)
+			return nil
+		}
+
+		if blob, ok := d.get(typ, mac); ok {
+			depends(entrypath, []*DamagedBlob{blob})
+			return nil
+		}
+		xattr, err := fs.ResolveXattr(mac)
+		if err != nil {
+			// not found damaged by the check, but it is
+			d.add(typ, mac, err.Error())
+			blob, _ := d.get(typ, mac)
+			depends(entrypath, []*DamagedBlob{blob})
+			return nil
+		}
+		blobs, ok := resolved[xattr.Object]
+		if !ok {
+			blobs = d.objectBlobs(snap, xattr.Object)
+			resolved[xattr.Object] = blobs
+		}
+		depends(xattr.Path, blobs)
+		return nil
+	})
+	return len(affected), err
+}
+
+// objectBlobs returns the damaged blobs an object depends on.
+func (d *damagedBlobs) objectBlobs(snap *snapshot.Snapshot, objectMAC objects.MAC) []*DamagedBlob {
+	if blob, ok := d.get(resources.RT_OBJECT, objectMAC); ok {
+		return []*DamagedBlob{blob}
+	}
+
+	object, err := snap.LookupObject(objectMAC)
+	if err != nil {
+		// not found damaged by the check, but it is
+		d.add(resources.RT_OBJECT, objectMAC, err.Error())
+		blob, _ := d.get(resources.RT_OBJECT, objectMAC)
+		return []*DamagedBlob{blob}
+	}
+
+	blobs := make([]*DamagedBlob, 0)
+	for _, chunk := range object.Chunks {
+		if blob, ok := d.get(resources.RT_CHUNK, chunk.ContentMAC); ok {
+			blobs = append(blobs, blob)
+		}
+	}
+	return blobs
+}
+
+// writeReport writes the report as JSON to the file, or to the standard
+// output if it is "-".
+func writeReport(ctx *appcontext.AppContext, filename string, report *Report) error {
+	var out io.Writer = ctx.Stdout
+	if filename != "-" {
+		fp, err := os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", filename, err)
+		}
+		defer fp.Close()
+		out = fp
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
@@ -0,0 +1,143 @@
+package check
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/plakar/exitcodes"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/ui/stdio"
+	"github.com/stretchr/testify/require"
+)
+
+func readReport(t *testing.T, filename string) Report {
+	t.Helper()
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	var report Report
+	require.NoError(t, json.Unmarshal(data, &report))
+	return report
+}
+
+func TestCheckReport(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	renderer := stdio.New(ctx)
+	renderer.Run()
+	t.Cleanup(func() { renderer.Wait() })
+	t.Cleanup(ctx.Close)
+
+	filename := filepath.Join(t.TempDir(), "report.json")
+
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-report", filename}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	report := readReport(t, filename)
+	require.Empty(t, report.Blobs)
+	require.Empty(t, report.Entries)
+	require.Empty(t, report.Packfiles)
+	require.Empty(t, report.Snapshots)
+
+	root := snap.Header.GetSource(0).Importer.Directory
+	pathname := path.Join(root, "subdir/foo.txt")
+	chunkMAC := corruptChunk(t, repo, snap, pathname)
+
+	cmd = &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-report", filename}))
+	status, err = cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "check failed for")
+	require.Equal(t, exitcodes.IntegrityFailure, status)
+
+	report = readReport(t, filename)
+	require.Len(t, report.Blobs, 1)
+	blob := report.Blobs[0]
+	require.Equal(t, "chunk", blob.Type)
+	require.Equal(t, chunkMAC, blob.MAC)
+	require.Len(t, blob.Packfiles, 1)
+	require.NotEmpty(t, blob.Error)
+	require.Equal(t, []File{{Snapshot: snap.Header.Identifier, Path: pathname}}, blob.Files)
+
+	require.Len(t, report.Snapshots, 1)
+	require.Equal(t, snap.Header.Identifier, report.Snapshots[0].Snapshot)
+	require.Equal(t, 1, report.Snapshots[0].DamagedFiles)
+
+	// a sampled check reports the damage it finds the same way
+	bufOut.Reset()
+	cmd = &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-sample", "100", "-report", "-"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "check failed for")
+	require.Equal(t, exitcodes.IntegrityFailure, status)
+	require.Contains(t, bufOut.String(), `"damaged_files": 1`)
+	require.Contains(t, bufOut.String(), pathname)
+}
+
+func TestCheckReportXattrs(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+
+	mtime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	snap := ptesting.GenerateSnapshot(t, repo, nil, ptesting.WithGenerator(func(ch chan<- *connectors.Record) {
+		ch <- &connectors.Record{
+			Pathname: "/",
+			FileInfo: objects.NewFileInfo("/", 0, 0755|os.ModeDir, mtime, 0, 0, 0, 0, 1),
+		}
+		ch <- connectors.NewRecord("/tagged.txt", "",
+			objects.NewFileInfo("tagged.txt", 6, 0644, mtime, 0, 0, 0, 0, 1),
+			[]string{"user.label"},
+			func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("tagged")), nil })
+		ch <- connectors.NewXattr("/tagged.txt", "user.label", objects.AttributeExtended,
+			func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("precious")), nil })
+	}))
+	defer snap.Close()
+
+	renderer := stdio.New(ctx)
+	renderer.Run()
+	t.Cleanup(func() { renderer.Wait() })
+	t.Cleanup(ctx.Close)
+
+	fs, err := snap.Filesystem()
+	require.NoError(t, err)
+	_, _, xattrs := fs.BTrees()
+	it, err := xattrs.ScanFrom("/")
+	require.NoError(t, err)
+	require.True(t, it.Next())
+	_, xattrMAC := it.Current()
+	xattr, err := fs.ResolveXattr(xattrMAC)
+	require.NoError(t, err)
+	chunkMAC := xattr.ResolvedObject.Chunks[0].ContentMAC
+	corruptBlob(t, repo, resources.RT_CHUNK, chunkMAC)
+
+	// the file content is fine, its extended attribute is not
+	filename := filepath.Join(t.TempDir(), "report.json")
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-report", filename}))
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "check failed for")
+	require.Equal(t, exitcodes.IntegrityFailure, status)
+
+	report := readReport(t, filename)
+	require.Len(t, report.Blobs, 1)
+	require.Equal(t, chunkMAC, report.Blobs[0].MAC)
+	require.Len(t, report.Blobs[0].Packfiles, 1)
+	require.Equal(t, []File{{Snapshot: snap.Header.Identifier, Path: "/tagged.txt"}}, report.Blobs[0].Files)
+	require.Len(t, report.Snapshots, 1)
+	require.Equal(t, 1, report.Snapshots[0].DamagedFiles)
+}
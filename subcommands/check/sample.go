@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package check
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/dustin/go-humanize"
+)
+
+// sampleConfidence is the confidence level of the estimate reported by a
+// sampled check.
+const sampleConfidence = 0.95
+
+// parseSamplePercent parses a percentage of the data, with or without the
+// percent sign, and returns it as a fraction.
+func parseSamplePercent(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if percent <= 0 || percent > 100 {
+		return 0, fmt.Errorf("must be between 0%% and 100%%")
+	}
+	return percent / 100, nil
+}
+
+// sampleKey returns the random key of a chunk in [0, 1).  MACs are
+// uniformly distributed, mixing them with a seed drawn for the run gives
+// every chunk a key of its own that is the same wherever it is found.
+func sampleKey(seed uint64, mac objects.MAC) float64 {
+	return float64((binary.LittleEndian.Uint64(mac[:8])^seed)>>11) / (1 << 53)
+}
+
+type sampleEntry struct {
+	key   float64
+	chunk objects.Chunk
+}
+
+// sampleHeap is a max-heap of chunks on their key.
+type sampleHeap []sampleEntry
+
+func (h sampleHeap) Len() int           { return len(h) }
+func (h sampleHeap) Less(i, j int) bool { return h[i].key > h[j].key }
+func (h sampleHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *sampleHeap) Push(x any)        { *h = append(*h, x.(sampleEntry)) }
+func (h *sampleHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// reservoir draws chunks uniformly at random as they are streamed: the
+// chunks whose key is below fraction are kept, and only those of lowest
+// key adding up to at most budget bytes if budget is set.  Each chunk has
+// the same chance to be drawn whatever its size.
+type reservoir struct {
+	budget uint64
+
+	// threshold is the key from which chunks are no longer drawn, it
+	// drops to the key of the chunks evicted to stay within budget.
+	threshold float64
+
+	entries sampleHeap
+	members map[objects.MAC]struct{}
+	size    uint64
+}
+
+func newReservoir(fraction float64, budget uint64) *reservoir {
+	return &reservoir{
+		budget:    budget,
+		threshold: fraction,
+		members:   make(map[objects.MAC]struct{}),
+	}
+}
+
+func (r *reservoir) add(key float64, chunk objects.Chunk) {
+	if key >= r.threshold || chunk.Length == 0 {
+		return
+	}
+	if _, ok := r.members[chunk.ContentMAC]; ok {
+		return
+	}
+
+	heap.Push(&r.entries, sampleEntry{key: key, chunk: chunk})
+	r.members[chunk.ContentMAC] = struct{}{}
+	r.size += uint64(chunk.Length)
+
+	for r.budget != 0 && r.size > r.budget {
+		evicted := heap.Pop(&r.entries).(sampleEntry)
+		delete(r.members, evicted.chunk.ContentMAC)
+		r.size -= uint64(evicted.chunk.Length)
+		r.threshold = evicted.key
+	}
+}
+
+// chunks returns the chunks drawn.
+func (r *reservoir) chunks() []objects.Chunk {
+	chunks := make([]objects.Chunk, 0, len(r.entries))
+	for _, entry := range r.entries {
+		chunks = append(chunks, entry.chunk)
+	}
+	return chunks
+}
+
+// drawSample walks the files of the snapshots, feeding their chunks to
+// the reservoir, and returns the number and total size of the chunks
+// seen.  The chunks of a content found in several files are only seen
+// once, those shared by different contents are seen for each of them.
+func drawSample(ctx *appcontext.AppContext, repo *repository.Repository, snapshots []string, sample *reservoir, seed uint64) (int, uint64, error) {
+	seenObjects := make(map[objects.MAC]struct{})
+	var count int
+	var size uint64
+
+	for _, arg := range snapshots {
+		snap, pathname, err := locate.OpenSnapshotByPath(repo, arg)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		err = func() error {
+			defer snap.Close()
+
+			fs, err := snap.Filesystem()
+			if err != nil {
+				return err
+			}
+
+			return fs.WalkDir(pathname, func(entrypath string, e *vfs.Entry, err error) error {
+				if err != nil {
+					return err
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if !e.HasObject() {
+					return nil
+				}
+				if _, ok := seenObjects[e.Object]; ok {
+					return nil
+				}
+				seenObjects[e.Object] = struct{}{}
+
+				object, err := snap.LookupObject(e.Object)
+				if err != nil {
+					return fmt.Errorf("%s: %w", entrypath, err)
+				}
+				for _, chunk := range object.Chunks {
+					count++
+					size += uint64(chunk.Length)
+					sample.add(sampleKey(seed, chunk.ContentMAC), chunk)
+				}
+				return nil
+			})
+		}()
+		if err != nil {
+			return 0, 0, fmt.Errorf("%x: %w", snap.Header.GetIndexShortID(), err)
+		}
+	}
+	return count, size, nil
+}
+
+// verifyChunk reads a chunk back from the repository and checks its MAC.
+func verifyChunk(repo *repository.Repository, mac objects.MAC) error {
+	data, err := repo.GetBlobBytes(resources.RT_CHUNK, mac)
+	if err != nil {
+		return fmt.Errorf("chunk is missing: %w", err)
+	}
+	computed := repo.ComputeMAC(data)
+	if !bytes.Equal(computed[:], mac[:]) {
+		return fmt.Errorf("chunk corrupted")
+	}
+	return nil
+}
+
+// corruptionUpperBound returns the fraction of the chunks that, at the
+// given confidence, is not exceeded by the corrupted chunks when none of
+// the n chunks drawn uniformly was found corrupted.
+func corruptionUpperBound(n int, confidence float64) float64 {
+	if n == 0 {
+		return 1
+	}
+	return 1 - math.Pow(1-confidence, 1/float64(n))
+}
+
+// executeSample verifies a random sample of the data of the snapshots
+// instead of all of it, and estimates the health of the repository.  Only
+// the content of the chunks drawn is verified, neither the signatures,
+// the filesystem and metadata of the snapshots nor the MACs of the
+// objects are.
+func (cmd *Check) executeSample(ctx *appcontext.AppContext, repo *repository.Repository, snapshots []string) (*damagedBlobs, error) {
+	fraction := 1.0
+	if cmd.Sample != 0 {
+		fraction = cmd.Sample
+	}
+	reservoir := newReservoir(fraction, cmd.Budget)
+
+	count, total, err := drawSample(ctx, repo, snapshots, reservoir, rand.Uint64())
+	if err != nil {
+		return nil, err
+	}
+
+	sample := reservoir.chunks()
+	if len(sample) == 0 {
+		ctx.GetLogger().Warn("check: no chunk was drawn, nothing was verified")
+		return newDamagedBlobs(), nil
+	}
+
+	var size, damagedSize uint64
+	failures := newDamagedBlobs()
+	for _, chunk := range sample {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		size += uint64(chunk.Length)
+		if err := verifyChunk(repo, chunk.ContentMAC); err != nil {
+			ctx.GetLogger().Warn("check: chunk %x: %s", chunk.ContentMAC, err)
+			failures.add(resources.RT_CHUNK, chunk.ContentMAC, err.Error())
+			damagedSize += uint64(chunk.Length)
+		}
+	}
+
+	ctx.GetLogger().Info("check: verified %d of %d chunks, %s of %s (%.2f%% of the data)",
+		len(sample), count, humanize.IBytes(size), humanize.IBytes(total),
+		100*float64(size)/float64(max(total, 1)))
+
+	if len(failures.blobs) != 0 {
+		ctx.GetLogger().Warn("check: %d sampled chunks are damaged, an estimated %.2f%% of the data is affected",
+			len(failures.blobs), 100*float64(damagedSize)/float64(size))
+	} else if size == total {
+		ctx.GetLogger().Info("check: no damage found, all the data was verified")
+	} else {
+		ctx.GetLogger().Info("check: no damage found, with %.0f%% confidence less than %.2f%% of the chunks are damaged",
+			100*sampleConfidence, 100*corruptionUpperBound(len(sample), sampleConfidence))
+	}
+
+	return failures, nil
+}
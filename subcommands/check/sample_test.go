@@ -0,0 +1,199 @@
+package check
+
+import (
+	"bytes"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/connectors/storage"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exitcodes"
+	"github.com/PlakarKorp/plakar/ui/stdio"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckParseSample(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-sample", "5%"}))
+	require.Equal(t, 0.05, cmd.Sample)
+
+	cmd = &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-sample", "50", "-budget", "10GB"}))
+	require.Equal(t, 0.5, cmd.Sample)
+	require.Equal(t, uint64(10_000_000_000), cmd.Budget)
+
+	for _, args := range [][]string{
+		{"-sample", "0"},
+		{"-sample", "101%"},
+		{"-sample", "some"},
+	} {
+		cmd = &Check{}
+		require.ErrorContains(t, cmd.Parse(ctx, args), "invalid sample", args)
+	}
+
+	cmd = &Check{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-budget", "0"}), "must be positive")
+	cmd = &Check{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-budget", "lots"}), "invalid budget")
+	cmd = &Check{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-fast", "-sample", "5"}), "can't be combined")
+	cmd = &Check{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-incremental", "-budget", "1GB"}), "can't be combined")
+}
+
+func TestReservoir(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	keys := make([]float64, 0)
+	chunks := make([]objects.Chunk, 0)
+	for i := range 100 {
+		keys = append(keys, rng.Float64())
+		chunks = append(chunks, objects.Chunk{ContentMAC: objects.RandomMAC(), Length: uint32(i + 1)})
+	}
+	draw := func(fraction float64, budget uint64) []objects.Chunk {
+		r := newReservoir(fraction, budget)
+		for i, chunk := range chunks {
+			r.add(keys[i], chunk)
+			// chunks seen again are only drawn once
+			r.add(keys[i], chunk)
+		}
+		return r.chunks()
+	}
+	sizeOf := func(chunks []objects.Chunk) uint64 {
+		var size uint64
+		for _, chunk := range chunks {
+			size += uint64(chunk.Length)
+		}
+		return size
+	}
+
+	require.Len(t, draw(1, 0), 100)
+	require.Len(t, draw(1, 5050), 100)
+	require.Empty(t, draw(1, 1))
+
+	sample := draw(1, 1000)
+	require.NotEmpty(t, sample)
+	require.LessOrEqual(t, sizeOf(sample), uint64(1000))
+
+	// the chunks drawn are those of lowest key
+	var threshold float64
+	for i, chunk := range chunks {
+		for _, drawn := range sample {
+			if drawn.ContentMAC == chunk.ContentMAC {
+				threshold = max(threshold, keys[i])
+			}
+		}
+	}
+	var below int
+	for _, key := range keys {
+		if key <= threshold {
+			below++
+		}
+	}
+	require.Equal(t, len(sample), below)
+
+	sample = draw(0.5, 0)
+	require.Greater(t, len(sample), 30)
+	require.Less(t, len(sample), 70)
+	require.LessOrEqual(t, len(draw(0.5, 1000)), len(sample))
+}
+
+func TestSampleKey(t *testing.T) {
+	mac := objects.RandomMAC()
+	require.Equal(t, sampleKey(42, mac), sampleKey(42, mac))
+	for range 100 {
+		key := sampleKey(rand.Uint64(), objects.RandomMAC())
+		require.GreaterOrEqual(t, key, 0.0)
+		require.Less(t, key, 1.0)
+	}
+}
+
+func TestCorruptionUpperBound(t *testing.T) {
+	require.Equal(t, 1.0, corruptionUpperBound(0, 0.95))
+	require.InDelta(t, 0.05, corruptionUpperBound(59, 0.95), 0.001)
+	require.InDelta(t, 0.0003, corruptionUpperBound(10000, 0.95), 0.0001)
+}
+
+// corruptChunk damages the first chunk of the file in its packfile, and
+// returns the chunk.
+func corruptChunk(t *testing.T, repo *repository.Repository, snap *snapshot.Snapshot, pathname string) objects.MAC {
+	t.Helper()
+	fs, err := snap.Filesystem()
+	require.NoError(t, err)
+	entry, err := fs.GetEntry(pathname)
+	require.NoError(t, err)
+	object, err := snap.LookupObject(entry.Object)
+	require.NoError(t, err)
+	chunkMAC := object.Chunks[0].ContentMAC
+	corruptBlob(t, repo, resources.RT_CHUNK, chunkMAC)
+	return chunkMAC
+}
+
+// corruptBlob flips a byte of a blob in the packfile holding it.
+func corruptBlob(t *testing.T, repo *repository.Repository, typ resources.Type, mac objects.MAC) {
+	t.Helper()
+	packfileMAC, exists, err := repo.GetPackfileForBlob(typ, mac)
+	require.NoError(t, err)
+	require.True(t, exists)
+	p, err := repo.GetPackfile(packfileMAC)
+	require.NoError(t, err)
+
+	rd, err := repo.Store().Get(repo.AppContext(), storage.StorageResourcePackfile, packfileMAC, nil)
+	require.NoError(t, err)
+	data, err := io.ReadAll(rd)
+	rd.Close()
+	require.NoError(t, err)
+
+	for _, blob := range p.Index {
+		if blob.Type == typ && blob.MAC == mac {
+			data[uint64(storage.STORAGE_HEADER_SIZE)+blob.Offset+uint64(blob.Length)/2] ^= 0xff
+		}
+	}
+
+	require.NoError(t, repo.Store().Delete(repo.AppContext(), storage.StorageResourcePackfile, packfileMAC))
+	_, err = repo.Store().Put(repo.AppContext(), storage.StorageResourcePackfile, packfileMAC, bytes.NewReader(data))
+	require.NoError(t, err)
+}
+
+func TestCheckSample(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	renderer := stdio.New(ctx)
+	renderer.Run()
+	t.Cleanup(func() { renderer.Wait() })
+	t.Cleanup(ctx.Close)
+
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-sample", "100"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "verified 4 of 4 chunks")
+	require.Contains(t, bufOut.String(), "all the data was verified")
+
+	cmd = &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-budget", "1B"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufErr.String(), "nothing was verified")
+
+	root := snap.Header.GetSource(0).Importer.Directory
+	corruptChunk(t, repo, snap, root+"/subdir/foo.txt")
+
+	cmd = &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-sample", "100%"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "check failed for")
+	require.Equal(t, exitcodes.IntegrityFailure, status)
+	require.Contains(t, bufErr.String(), "sampled chunks are damaged")
+}
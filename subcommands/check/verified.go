@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package check
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/caching"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const verifiedObjectPrefix = "__object__:"
+
+// verifiedObject records when an object was last fully verified, and
+// the packfiles that held it and its chunks then: once the data is moved
+// to other packfiles, it has to be verified again.
+type verifiedObject struct {
+	VerifiedAt int64
+	Packfile   objects.MAC
+	Chunks     []verifiedChunk
+}
+
+type verifiedChunk struct {
+	MAC      objects.MAC
+	Packfile objects.MAC
+}
+
+// verifiedCache records in the local cache when the objects of a
+// repository were last fully verified, so that an incremental check only
+// verifies the data added since, and the data verified too long ago.
+type verifiedCache struct {
+	cache  caching.Cache
+	repo   *repository.Repository
+	now    time.Time
+	maxAge time.Duration
+}
+
+func openVerifiedCache(ctx *appcontext.AppContext, repo *repository.Repository, now time.Time, maxAge time.Duration) (*verifiedCache, error) {
+	cache, err := ctx.OpenCache("verified", repo.Configuration().RepositoryID)
+	if err != nil {
+		return nil, err
+	}
+	return &verifiedCache{cache: cache, repo: repo, now: now, maxAge: maxAge}, nil
+}
+
+func verifiedKey(mac objects.MAC) []byte {
+	return fmt.Appendf(nil, "%s%x", verifiedObjectPrefix, mac)
+}
+
+// expiry returns the age at which the verification of an object
+// expires, somewhere between half and all of maxAge depending on its MAC,
+// so that the data verified by the same run is not all verified again by
+// the same later run.
+func expiry(mac objects.MAC, maxAge time.Duration) time.Duration {
+	spread := float64(binary.BigEndian.Uint16(mac[:2])) / (1 << 16)
+	return maxAge - time.Duration(spread*float64(maxAge/2))
+}
+
+func (v *verifiedCache) lookup(mac objects.MAC) (*verifiedObject, error) {
+	data, err := v.cache.Get(verifiedKey(mac))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var entry verifiedObject
+	if err := msgpack.Unmarshal(data, &entry); err != nil {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+// fresh returns true if the object was verified recently enough and
+// its data is still where it was verified.
+func (v *verifiedCache) fresh(mac objects.MAC, entry *verifiedObject) (bool, error) {
+	if v.maxAge != 0 && v.now.Sub(time.Unix(entry.VerifiedAt, 0)) >= expiry(mac, v.maxAge) {
+		return false, nil
+	}
+
+	located := func(typ resources.Type, mac, packfileMAC objects.MAC) (bool, error) {
+		location, exists, err := v.repo.GetPackfileForBlob(typ, mac)
+		return exists && location == packfileMAC, err
+	}
+
+	if ok, err := located(resources.RT_OBJECT, mac, entry.Packfile); !ok || err != nil {
+		return false, err
+	}
+	for _, chunk := range entry.Chunks {
+		if ok, err := located(resources.RT_CHUNK, chunk.MAC, chunk.Packfile); !ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// seed marks the objects still fresh as valid in the check cache so that
+// the check doesn't read them again, and returns how many there are.
+func (v *verifiedCache) seed(checkCache *caching.CheckCache) (int, error) {
+	count := 0
+	for key, data := range v.cache.Scan([]byte(verifiedObjectPrefix), false) {
+		buf, err := hex.DecodeString(strings.TrimPrefix(string(key), verifiedObjectPrefix))
+		if err != nil || len(buf) != len(objects.MAC{}) {
+			continue
+		}
+		var entry verifiedObject
+		if err := msgpack.Unmarshal(data, &entry); err != nil {
+			continue
+		}
+		fresh, err := v.fresh(objects.MAC(buf), &entry)
+		if err != nil {
+			return count, err
+		}
+		if !fresh {
+			continue
+		}
+		if err := checkCache.PutObjectStatus(objects.MAC(buf), []byte("")); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// locate returns the entry recording that an object is verified now,
+// where its data is.
+func (v *verifiedCache) locate(snap *snapshot.Snapshot, mac objects.MAC) (*verifiedObject, error) {
+	object, err := snap.LookupObject(mac)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &verifiedObject{VerifiedAt: v.now.Unix()}
+	entry.Packfile, _, err = v.repo.GetPackfileForBlob(resources.RT_OBJECT, mac)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range object.Chunks {
+		packfileMAC, _, err := v.repo.GetPackfileForBlob(resources.RT_CHUNK, chunk.ContentMAC)
+		if err != nil {
+			return nil, err
+		}
+		entry.Chunks = append(entry.Chunks, verifiedChunk{MAC: chunk.ContentMAC, Packfile: packfileMAC})
+	}
+	return entry, nil
+}
+
+// record stores the outcome of the check of the objects below pathname:
+// the objects found valid are recorded as verified now, unless they were
+// seeded, and the invalid ones are forgotten.
+func (v *verifiedCache) record(snap *snapshot.Snapshot, pathname string, checkCache *caching.CheckCache) (int, error) {
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = fs.WalkDir(pathname, func(entrypath string, e *vfs.Entry, err error) error {
+		if err != nil {
+			// the error was reported by the check already
+			return nil
+		}
+		if !e.HasObject() {
+			return nil
+		}
+
+		status, err := checkCache.GetObjectStatus(e.Object)
+		if err != nil {
+			return err
+		}
+		if status == nil {
+			return nil
+		}
+		if len(status) != 0 {
+			return v.cache.Delete(verifiedKey(e.Object))
+		}
+
+		if entry, err := v.lookup(e.Object); err != nil {
+			return err
+		} else if entry != nil {
+			fresh, err := v.fresh(e.Object, entry)
+			if err != nil || fresh {
+				return err
+			}
+		}
+
+		entry, err := v.locate(snap, e.Object)
+		if err != nil {
+			return err
+		}
+		data, err := msgpack.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		count++
+		return v.cache.Put(verifiedKey(e.Object), data)
+	})
+	return count, err
+}
+
+func (v *verifiedCache) Close() error {
+	return v.cache.Close()
+}
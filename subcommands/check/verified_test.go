@@ -0,0 +1,120 @@
+package check
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/ui/stdio"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// countVerified returns the number of objects still fresh at now.
+func countVerified(t *testing.T, ctx *appcontext.AppContext, repo *repository.Repository, now time.Time, maxAge time.Duration) int {
+	t.Helper()
+	verified, err := openVerifiedCache(ctx, repo, now, maxAge)
+	require.NoError(t, err)
+	defer verified.Close()
+
+	checkCache, err := ctx.GetCache().Check()
+	require.NoError(t, err)
+	defer checkCache.Close()
+
+	n, err := verified.seed(checkCache)
+	require.NoError(t, err)
+	return n
+}
+
+func TestVerifiedExpiry(t *testing.T) {
+	maxAge := 90 * 24 * time.Hour
+
+	// expirations are spread over the second half of maxAge
+	require.Equal(t, maxAge, expiry(objects.MAC{0x00, 0x00}, maxAge))
+	require.Equal(t, 3*maxAge/4, expiry(objects.MAC{0x80, 0x00}, maxAge))
+	last := expiry(objects.MAC{0xff, 0xff}, maxAge)
+	require.Greater(t, last, maxAge/2)
+	require.Less(t, last, maxAge/2+time.Hour)
+}
+
+func TestCheckParseIncremental(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-incremental", "-reverify-older-than", "30d"}))
+	require.True(t, cmd.Incremental)
+	require.Equal(t, 30*24*time.Hour, cmd.ReverifyOlderThan)
+
+	cmd = &Check{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-reverify-older-than", "30d"}), "requires -incremental")
+	cmd = &Check{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-incremental", "-reverify-older-than", "often"}), "invalid duration")
+	cmd = &Check{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-incremental", "-reverify-older-than", "0s"}), "must be positive")
+}
+
+func TestCheckIncremental(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	renderer := stdio.New(ctx)
+	renderer.Run()
+	t.Cleanup(func() { renderer.Wait() })
+	t.Cleanup(ctx.Close)
+
+	// a fast check reads no data, it records nothing
+	cmd := &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-fast", "-incremental"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Zero(t, countVerified(t, ctx, repo, time.Now(), 0))
+
+	cmd = &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-incremental"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// the four files of the snapshot have distinct contents
+	require.Equal(t, 4, countVerified(t, ctx, repo, time.Now(), 0))
+	require.Equal(t, 4, countVerified(t, ctx, repo, time.Now().Add(44*24*time.Hour), 90*24*time.Hour))
+	require.Zero(t, countVerified(t, ctx, repo, time.Now().Add(90*24*time.Hour), 90*24*time.Hour))
+
+	// the second run skips them and keeps their verification time
+	cmd = &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-incremental"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Equal(t, 4, countVerified(t, ctx, repo, time.Now(), 0))
+
+	// data moved to another packfile is verified again
+	verified, err := openVerifiedCache(ctx, repo, time.Now(), 0)
+	require.NoError(t, err)
+	var key []byte
+	var entry verifiedObject
+	for k, data := range verified.cache.Scan([]byte(verifiedObjectPrefix), false) {
+		key = append(key, k...)
+		require.NoError(t, msgpack.Unmarshal(data, &entry))
+		break
+	}
+	entry.Packfile = objects.MAC{0xde, 0xad}
+	data, err := msgpack.Marshal(&entry)
+	require.NoError(t, err)
+	require.NoError(t, verified.cache.Put(key, data))
+	require.NoError(t, verified.Close())
+	require.Equal(t, 3, countVerified(t, ctx, repo, time.Now(), 0))
+
+	cmd = &Check{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-incremental"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Equal(t, 4, countVerified(t, ctx, repo, time.Now(), 0))
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
 )
 
 type DiagBlobSearch struct {
@@ -48,41 +49,30 @@ func (cmd *DiagBlobSearch) Execute(ctx *appcontext.AppContext, repo *repository.
 
 	needleMAC := objects.MAC(b)
 
-	packfiles, err := repo.GetPackfiles()
-	if err != nil {
-		return 1, err
-	}
-
-	for _, packfileMac := range packfiles {
-		p, err := repo.GetPackfile(packfileMac)
+	for location, err := range utils.SearchBlobs(repo, func(mac objects.MAC) bool { return mac == needleMAC }) {
 		if err != nil {
 			return 1, err
 		}
 
-		for _, entry := range p.Index {
-			if entry.MAC == needleMAC {
-				fmt.Fprintf(ctx.Stdout, "Found candidate [%x] in packfile [%x] at : %d %d %s\n", entry.MAC, packfileMac, entry.Offset, entry.Length, entry.Type)
-				if entry.Type == resources.RT_OBJECT {
-					rd, err := repo.GetPackfileBlob(state.Location{Packfile: packfileMac, Offset: entry.Offset, Length: entry.Length})
-					if err != nil {
-						return 1, err
-					}
-
-					blob, err := io.ReadAll(rd)
-					if err != nil {
-						return 1, err
-					}
-
-					object, err := objects.NewObjectFromBytes(blob)
-					if err != nil {
-						return 1, err
-					}
-
-					fmt.Fprintf(ctx.Stdout, "object: %x\n", object.ContentMAC)
-					fmt.Fprintln(ctx.Stdout, "  type:", object.ContentType)
-				}
+		fmt.Fprintf(ctx.Stdout, "Found candidate [%x] in packfile [%x] at : %d %d %s\n", location.MAC, location.Packfile, location.Offset, location.Length, location.Type)
+		if location.Type == resources.RT_OBJECT {
+			rd, err := repo.GetPackfileBlob(state.Location{Packfile: location.Packfile, Offset: location.Offset, Length: location.Length})
+			if err != nil {
+				return 1, err
+			}
+
+			blob, err := io.ReadAll(rd)
+			if err != nil {
+				return 1, err
+			}
+
+			object, err := objects.NewObjectFromBytes(blob)
+			if err != nil {
+				return 1, err
 			}
 
+			fmt.Fprintf(ctx.Stdout, "object: %x\n", object.ContentMAC)
+			fmt.Fprintln(ctx.Stdout, "  type:", object.ContentType)
 		}
 	}
 
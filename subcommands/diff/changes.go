@@ -0,0 +1,576 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package diff
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+)
+
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeType     ChangeKind = "type"
+	ChangeModified ChangeKind = "modified"
+	ChangeXattrs   ChangeKind = "xattrs"
+	ChangeMetadata ChangeKind = "metadata"
+)
+
+// the fields of an entry a change can affect
+const (
+	FieldType    = "type"
+	FieldContent = "content"
+	FieldXattrs  = "xattrs"
+	FieldMode    = "mode"
+	FieldOwner   = "owner"
+	FieldMtime   = "mtime"
+)
+
+// ChangeState is the state of a changed entry on one side of the diff.
+type ChangeState struct {
+	Size       int64        `json:"size"`
+	Mode       string       `json:"mode"`
+	Uid        uint64       `json:"uid"`
+	Gid        uint64       `json:"gid"`
+	Mtime      time.Time    `json:"mtime"`
+	ContentMAC *objects.MAC `json:"content_mac,omitempty"`
+	Symlink    string       `json:"symlink_target,omitempty"`
+}
+
+// Change describes how an entry differs between the two sides, Kind is
+// the most significant of the changed Fields.
+type Change struct {
+	Path   string       `json:"path"`
+	Kind   ChangeKind   `json:"kind"`
+	Fields []string     `json:"fields,omitempty"`
+	Old    *ChangeState `json:"old,omitempty"`
+	New    *ChangeState `json:"new,omitempty"`
+}
+
+type Stat struct {
+	Count    int   `json:"count"`
+	OldBytes int64 `json:"old_bytes"`
+	NewBytes int64 `json:"new_bytes"`
+}
+
+type Stats struct {
+	Added    Stat `json:"added"`
+	Removed  Stat `json:"removed"`
+	Type     Stat `json:"type"`
+	Modified Stat `json:"modified"`
+	Xattrs   Stat `json:"xattrs"`
+	Metadata Stat `json:"metadata"`
+}
+
+func (s *Stats) stat(kind ChangeKind) *Stat {
+	switch kind {
+	case ChangeAdded:
+		return &s.Added
+	case ChangeRemoved:
+		return &s.Removed
+	case ChangeType:
+		return &s.Type
+	case ChangeModified:
+		return &s.Modified
+	case ChangeXattrs:
+		return &s.Xattrs
+	default:
+		return &s.Metadata
+	}
+}
+
+// Side is one of the compared trees, a snapshot or an importer location.
+type Side struct {
+	Snapshot string `json:"snapshot,omitempty"`
+	Location string `json:"location,omitempty"`
+	Path     string `json:"path"`
+}
+
+// ChangeSet is the structured result of a diff.
+type ChangeSet struct {
+	From    Side     `json:"from"`
+	To      Side     `json:"to"`
+	Changes []Change `json:"changes"`
+	Stats   Stats    `json:"stats"`
+}
+
+func (cs *ChangeSet) add(change Change) {
+	cs.Changes = append(cs.Changes, change)
+
+	stat := cs.Stats.stat(change.Kind)
+	stat.Count++
+	if change.Old != nil {
+		stat.OldBytes += change.Old.Size
+	}
+	if change.New != nil {
+		stat.NewBytes += change.New.Size
+	}
+}
+
+// node is the state of a pathname on one side of a diff.  A nil
+// ContentMAC or Xattrs means it is not known, and is not compared,
+// unless it can be resolved by loadContent or loadXattrs.  Object is
+// the snapshot object of the content, if any: two nodes of the same
+// repository with the same object have the same content.  An
+// unreadable node is neither compared nor reported.
+type node struct {
+	info       objects.FileInfo
+	symlink    string
+	object     *objects.MAC
+	contentMAC *objects.MAC
+	xattrs     map[string]objects.MAC
+	unreadable bool
+
+	loadContent func() (*objects.MAC, error)
+	loadXattrs  func() (map[string]objects.MAC, error)
+}
+
+func (n *node) hasContent() bool {
+	return n.contentMAC != nil || n.loadContent != nil
+}
+
+func (n *node) hasXattrs() bool {
+	return n.xattrs != nil || n.loadXattrs != nil
+}
+
+func (n *node) content() (*objects.MAC, error) {
+	if n.loadContent != nil {
+		mac, err := n.loadContent()
+		if err != nil {
+			return nil, err
+		}
+		n.contentMAC, n.loadContent = mac, nil
+	}
+	return n.contentMAC, nil
+}
+
+func (n *node) attributes() (map[string]objects.MAC, error) {
+	if n.loadXattrs != nil {
+		xattrs, err := n.loadXattrs()
+		if err != nil {
+			return nil, err
+		}
+		n.xattrs, n.loadXattrs = xattrs, nil
+	}
+	return n.xattrs, nil
+}
+
+func (n *node) state() *ChangeState {
+	return &ChangeState{
+		Size:       n.info.Size(),
+		Mode:       n.info.Mode().String(),
+		Uid:        n.info.Uid(),
+		Gid:        n.info.Gid(),
+		Mtime:      n.info.ModTime().UTC(),
+		ContentMAC: n.contentMAC,
+		Symlink:    n.symlink,
+	}
+}
+
+// sameContent tells whether the regular files a and b, of the same
+// size, have the same content.  When it is not known on either side,
+// they are deemed the same if their modification time is.
+func sameContent(a, b *node) (bool, error) {
+	if a.object != nil && b.object != nil {
+		return *a.object == *b.object, nil
+	}
+	if !a.hasContent() || !b.hasContent() {
+		return a.info.ModTime().Equal(b.info.ModTime()), nil
+	}
+
+	macA, err := a.content()
+	if err != nil {
+		return false, err
+	}
+	macB, err := b.content()
+	if err != nil {
+		return false, err
+	}
+	return *macA == *macB, nil
+}
+
+func sameXattrs(a, b *node) (bool, error) {
+	if !a.hasXattrs() || !b.hasXattrs() {
+		return true, nil
+	}
+
+	xattrsA, err := a.attributes()
+	if err != nil {
+		return false, err
+	}
+	xattrsB, err := b.attributes()
+	if err != nil {
+		return false, err
+	}
+	return maps.Equal(xattrsA, xattrsB), nil
+}
+
+// compareNodes returns the change from a to b, if any.  Contents and
+// extended attributes are only resolved when they have to be compared.
+func compareNodes(pathname string, a, b *node) (*Change, error) {
+	var fields []string
+
+	modeA, modeB := a.info.Mode(), b.info.Mode()
+	if modeA.Type() != modeB.Type() {
+		fields = append(fields, FieldType)
+	} else {
+		switch {
+		case modeA.IsRegular():
+			same := a.info.Size() == b.info.Size()
+			if same {
+				var err error
+				if same, err = sameContent(a, b); err != nil {
+					return nil, err
+				}
+			}
+			if !same {
+				fields = append(fields, FieldContent)
+			}
+		case modeA&fs.ModeSymlink != 0:
+			if a.symlink != b.symlink {
+				fields = append(fields, FieldContent)
+			}
+		}
+	}
+
+	same, err := sameXattrs(a, b)
+	if err != nil {
+		return nil, err
+	}
+	if !same {
+		fields = append(fields, FieldXattrs)
+	}
+	if modeA&^fs.ModeType != modeB&^fs.ModeType {
+		fields = append(fields, FieldMode)
+	}
+	if a.info.Uid() != b.info.Uid() || a.info.Gid() != b.info.Gid() {
+		fields = append(fields, FieldOwner)
+	}
+	if !a.info.ModTime().Equal(b.info.ModTime()) {
+		fields = append(fields, FieldMtime)
+	}
+
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	kind := ChangeMetadata
+	switch {
+	case slices.Contains(fields, FieldType):
+		kind = ChangeType
+	case slices.Contains(fields, FieldContent):
+		kind = ChangeModified
+	case slices.Contains(fields, FieldXattrs):
+		kind = ChangeXattrs
+	}
+
+	// the content MACs of the modified files are reported, resolving
+	// them only costs as much as the changes
+	if kind == ChangeModified && modeA.IsRegular() {
+		if _, err := a.content(); err != nil {
+			return nil, err
+		}
+		if _, err := b.content(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Change{
+		Path:   pathname,
+		Kind:   kind,
+		Fields: fields,
+		Old:    a.state(),
+		New:    b.state(),
+	}, nil
+}
+
+// tree is one side of a diff.
+type tree interface {
+	// lookup returns the node at pathname.
+	lookup(pathname string) (*node, error)
+
+	// children returns the nodes of the directory at pathname, by name.
+	children(pathname string) (map[string]*node, error)
+}
+
+// vfsTree is a snapshot side of a diff.
+type vfsTree struct {
+	repo *repository.Repository
+	pvfs *vfs.Filesystem
+}
+
+func (t vfsTree) lookup(pathname string) (*node, error) {
+	entry, err := t.pvfs.GetEntry(pathname)
+	if err != nil {
+		return nil, err
+	}
+	return t.node(entry), nil
+}
+
+func (t vfsTree) children(pathname string) (map[string]*node, error) {
+	it, err := t.pvfs.Children(pathname)
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[string]*node)
+	for entry, err := range it {
+		if err != nil {
+			return nil, err
+		}
+		children[entry.Name()] = t.node(entry)
+	}
+	return children, nil
+}
+
+// node returns the node of entry, its content MAC and extended
+// attributes are only fetched from the repository once needed.
+func (t vfsTree) node(entry *vfs.Entry) *node {
+	n := &node{
+		info:    entry.FileInfo,
+		symlink: entry.SymlinkTarget,
+	}
+
+	if entry.HasObject() {
+		object := entry.Object
+		n.object = &object
+		n.loadContent = func() (*objects.MAC, error) {
+			// directory listings do not resolve objects, opening the entry does
+			if entry.ResolvedObject == nil {
+				fp, err := entry.Open(t.pvfs)
+				if err != nil {
+					return nil, err
+				}
+				fp.Close()
+			}
+			mac := entry.ResolvedObject.ContentMAC
+			return &mac, nil
+		}
+	}
+
+	// hashed as the importer side does, which gives their content MAC
+	n.loadXattrs = func() (map[string]objects.MAC, error) {
+		xattrs := make(map[string]objects.MAC)
+		for _, name := range entry.ExtendedAttributes {
+			rd, err := entry.Xattr(t.pvfs, name)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			hasher := t.repo.GetMACHasher()
+			if _, err := io.Copy(hasher, rd); err != nil {
+				return nil, err
+			}
+			xattrs[name] = objects.MAC(hasher.Sum(nil))
+		}
+		return xattrs, nil
+	}
+	return n
+}
+
+// changeSet compares the tree at pathname1 in t1 to the one at
+// pathname2 in t2, the paths of the changes are those of the first.
+func changeSet(ctx context.Context, t1 tree, pathname1 string, t2 tree, pathname2 string) (*ChangeSet, error) {
+	cs := &ChangeSet{Changes: []Change{}}
+
+	n1, err := t1.lookup(pathname1)
+	if err != nil {
+		return nil, fmt.Errorf("could not open path %s: %w", pathname1, err)
+	}
+	n2, err := t2.lookup(pathname2)
+	if err != nil {
+		return nil, fmt.Errorf("could not open path %s: %w", pathname2, err)
+	}
+
+	if n1.unreadable || n2.unreadable {
+		return cs, nil
+	}
+
+	if !n1.info.IsDir() || !n2.info.IsDir() {
+		change, err := compareNodes(pathname1, n1, n2)
+		if err != nil {
+			return nil, err
+		}
+		if change != nil {
+			cs.add(*change)
+		}
+		return cs, nil
+	}
+
+	if err := diffDirectories(ctx, cs, t1, pathname1, t2, pathname2); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+func diffDirectories(ctx context.Context, cs *ChangeSet, t1 tree, path1 string, t2 tree, path2 string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	children1, err := t1.children(path1)
+	if err != nil {
+		return err
+	}
+	children2, err := t2.children(path2)
+	if err != nil {
+		return err
+	}
+
+	names := slices.Collect(maps.Keys(children1))
+	for name := range children2 {
+		if _, ok := children1[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		n1, ok1 := children1[name]
+		n2, ok2 := children2[name]
+		full1 := path.Join(path1, name)
+		full2 := path.Join(path2, name)
+
+		switch {
+		case (ok1 && n1.unreadable) || (ok2 && n2.unreadable):
+			continue
+		case ok1 && !ok2:
+			err = addTree(ctx, cs, t1, n1, full1, full1, ChangeRemoved)
+		case !ok1 && ok2:
+			err = addTree(ctx, cs, t2, n2, full2, full1, ChangeAdded)
+		default:
+			var change *Change
+			if change, err = compareNodes(full1, n1, n2); err != nil {
+				return err
+			}
+			if change != nil {
+				cs.add(*change)
+			}
+
+			switch {
+			case n1.info.IsDir() && n2.info.IsDir():
+				err = diffDirectories(ctx, cs, t1, full1, t2, full2)
+			case n1.info.IsDir():
+				err = addBelow(ctx, cs, t1, full1, full1, ChangeRemoved)
+			case n2.info.IsDir():
+				err = addBelow(ctx, cs, t2, full2, full1, ChangeAdded)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addTree records n, found at source in t and at pathname in the diff,
+// and everything below it as added or removed.
+func addTree(ctx context.Context, cs *ChangeSet, t tree, n *node, source string, pathname string, kind ChangeKind) error {
+	change := Change{Path: pathname, Kind: kind}
+	if kind == ChangeAdded {
+		change.New = n.state()
+	} else {
+		change.Old = n.state()
+	}
+	cs.add(change)
+
+	if n.info.IsDir() {
+		return addBelow(ctx, cs, t, source, pathname, kind)
+	}
+	return nil
+}
+
+func addBelow(ctx context.Context, cs *ChangeSet, t tree, source string, pathname string, kind ChangeKind) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	children, err := t.children(source)
+	if err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(children)) {
+		err := addTree(ctx, cs, t, children[name], path.Join(source, name), path.Join(pathname, name), kind)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeJSON(out io.Writer, cs *ChangeSet) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cs)
+}
+
+var changeCodes = map[ChangeKind]string{
+	ChangeAdded:    "A",
+	ChangeRemoved:  "D",
+	ChangeType:     "T",
+	ChangeModified: "M",
+	ChangeXattrs:   "X",
+	ChangeMetadata: "P",
+}
+
+// writeSummary prints one line per change: its code, its path and the
+// sizes or fields it affects.
+func writeSummary(out io.Writer, cs *ChangeSet) {
+	for _, change := range cs.Changes {
+		var details string
+		switch change.Kind {
+		case ChangeAdded:
+			details = humanize.IBytes(uint64(change.New.Size))
+		case ChangeRemoved:
+			details = humanize.IBytes(uint64(change.Old.Size))
+		case ChangeType, ChangeModified:
+			details = fmt.Sprintf("%s -> %s", humanize.IBytes(uint64(change.Old.Size)),
+				humanize.IBytes(uint64(change.New.Size)))
+		default:
+			details = strings.Join(change.Fields, ",")
+		}
+		fmt.Fprintf(out, "%s %s (%s)\n", changeCodes[change.Kind], utils.SanitizeText(change.Path), details)
+	}
+}
+
+// writeStat prints the totals of the changes by kind.
+func writeStat(out io.Writer, cs *ChangeSet) {
+	for _, kind := range []ChangeKind{ChangeAdded, ChangeRemoved, ChangeType, ChangeModified, ChangeXattrs, ChangeMetadata} {
+		stat := cs.Stats.stat(kind)
+		fmt.Fprintf(out, "%-9s %8d %10s -> %s\n", kind, stat.Count,
+			humanize.IBytes(uint64(stat.OldBytes)), humanize.IBytes(uint64(stat.NewBytes)))
+	}
+	fmt.Fprintf(out, "%d changes\n", len(cs.Changes))
+}
@@ -0,0 +1,237 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+type genFile struct {
+	path    string
+	mode    os.FileMode
+	content string
+	xattrs  map[string]string
+}
+
+func generateTree(t *testing.T, repo *repository.Repository, files []genFile) *snapshot.Snapshot {
+	mtime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	gen := func(ch chan<- *connectors.Record) {
+		ch <- &connectors.Record{
+			Pathname: "/",
+			FileInfo: objects.NewFileInfo("/", 0, 0755|os.ModeDir, mtime, 0, 0, 0, 0, 1),
+		}
+		for _, f := range files {
+			name := f.path[strings.LastIndex(f.path, "/")+1:]
+			if f.mode.IsDir() {
+				ch <- &connectors.Record{
+					Pathname: f.path,
+					FileInfo: objects.NewFileInfo(name, 0, f.mode, mtime, 0, 0, 0, 0, 1),
+				}
+				continue
+			}
+
+			var names []string
+			for xname := range f.xattrs {
+				names = append(names, xname)
+			}
+			content := f.content
+			ch <- connectors.NewRecord(f.path, "",
+				objects.NewFileInfo(name, int64(len(content)), f.mode, mtime, 0, 0, 0, 0, 1),
+				names,
+				func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(content)), nil })
+			for xname, value := range f.xattrs {
+				ch <- connectors.NewXattr(f.path, xname, objects.AttributeExtended,
+					func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(value)), nil })
+			}
+		}
+	}
+
+	// the lock of the previous snapshot is released asynchronously and
+	// may vanish while the next one lists the repository locks
+	t.Setenv("PLAKAR_LOCKLESS", "true")
+
+	snap := ptesting.GenerateSnapshot(t, repo, nil, ptesting.WithGenerator(gen))
+	t.Cleanup(func() { snap.Close() })
+	return snap
+}
+
+func generateChanges(t *testing.T) (*repository.Repository, *appcontext.AppContext, *snapshot.Snapshot, *snapshot.Snapshot) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	snap1 := generateTree(t, repo, []genFile{
+		{path: "/dir", mode: 0755 | os.ModeDir},
+		{path: "/dir/chmod.txt", mode: 0644, content: "chmod"},
+		{path: "/dir/gone.txt", mode: 0644, content: "gone"},
+		{path: "/dir/mod.txt", mode: 0644, content: "aaa"},
+		{path: "/dir/same.txt", mode: 0644, content: "same"},
+		{path: "/dir/swap", mode: 0644, content: "file"},
+		{path: "/dir/xattr.txt", mode: 0644, content: "xattr", xattrs: map[string]string{"user.a": "1"}},
+		{path: "/old", mode: 0755 | os.ModeDir},
+		{path: "/old/file.txt", mode: 0644, content: "old"},
+	})
+	snap2 := generateTree(t, repo, []genFile{
+		{path: "/dir", mode: 0755 | os.ModeDir},
+		{path: "/dir/chmod.txt", mode: 0600, content: "chmod"},
+		{path: "/dir/mod.txt", mode: 0644, content: "bbb"},
+		{path: "/dir/new.txt", mode: 0644, content: "brand new"},
+		{path: "/dir/same.txt", mode: 0644, content: "same"},
+		{path: "/dir/swap", mode: 0755 | os.ModeDir},
+		{path: "/dir/swap/inner.txt", mode: 0644, content: "inner"},
+		{path: "/dir/xattr.txt", mode: 0644, content: "xattr", xattrs: map[string]string{"user.a": "2"}},
+	})
+	return repo, ctx, snap1, snap2
+}
+
+func TestChangeSet(t *testing.T) {
+	repo, ctx, snap1, snap2 := generateChanges(t)
+
+	fs1, err := snap1.Filesystem()
+	require.NoError(t, err)
+	fs2, err := snap2.Filesystem()
+	require.NoError(t, err)
+
+	cs, err := changeSet(ctx, vfsTree{repo, fs1}, "/", vfsTree{repo, fs2}, "/")
+	require.NoError(t, err)
+
+	var got []string
+	for _, change := range cs.Changes {
+		got = append(got, fmt.Sprintf("%s %s %v", change.Kind, change.Path, change.Fields))
+	}
+	require.Equal(t, []string{
+		"metadata /dir/chmod.txt [mode]",
+		"removed /dir/gone.txt []",
+		"modified /dir/mod.txt [content]",
+		"added /dir/new.txt []",
+		"type /dir/swap [type mode]",
+		"added /dir/swap/inner.txt []",
+		"xattrs /dir/xattr.txt [xattrs]",
+		"removed /old []",
+		"removed /old/file.txt []",
+	}, got)
+
+	require.Equal(t, Stat{Count: 2, NewBytes: int64(len("brand new") + len("inner"))}, cs.Stats.Added)
+	require.Equal(t, Stat{Count: 3, OldBytes: int64(len("gone") + len("old"))}, cs.Stats.Removed)
+	require.Equal(t, Stat{Count: 1, OldBytes: 3, NewBytes: 3}, cs.Stats.Modified)
+
+	mod := cs.Changes[2]
+	require.NotNil(t, mod.Old.ContentMAC)
+	require.NotEqual(t, *mod.Old.ContentMAC, *mod.New.ContentMAC)
+
+	// added and removed entries are not resolved
+	require.Nil(t, cs.Changes[1].Old.ContentMAC)
+	require.Nil(t, cs.Changes[3].New.ContentMAC)
+
+	// a single file
+	cs, err = changeSet(ctx, vfsTree{repo, fs1}, "/dir/same.txt", vfsTree{repo, fs2}, "/dir/same.txt")
+	require.NoError(t, err)
+	require.Empty(t, cs.Changes)
+}
+
+func TestCompareNodesLazy(t *testing.T) {
+	mtime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	info := objects.NewFileInfo("file.txt", 3, 0644, mtime, 0, 0, 0, 0, 1)
+	object := objects.MAC{1}
+
+	unresolvable := func() *node {
+		return &node{
+			info:        info,
+			object:      &object,
+			loadContent: func() (*objects.MAC, error) { return nil, fmt.Errorf("content resolved") },
+			loadXattrs:  func() (map[string]objects.MAC, error) { return nil, fmt.Errorf("xattrs resolved") },
+		}
+	}
+
+	// the same object is the same content
+	other := unresolvable()
+	other.loadXattrs = nil
+	change, err := compareNodes("/file.txt", unresolvable(), other)
+	require.NoError(t, err)
+	require.Nil(t, change)
+
+	// nothing to compare to on the other side
+	change, err = compareNodes("/file.txt", unresolvable(), &node{info: info})
+	require.NoError(t, err)
+	require.Nil(t, change)
+
+	// a MAC on the other side has to be compared
+	contentMAC := objects.MAC{2}
+	_, err = compareNodes("/file.txt", unresolvable(), &node{info: info, contentMAC: &contentMAC})
+	require.ErrorContains(t, err, "content resolved")
+}
+
+func TestExecuteCmdDiffJSON(t *testing.T) {
+	repo, ctx, snap1, snap2 := generateChanges(t)
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	cmd := &Diff{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json",
+		fmt.Sprintf("%x:/dir", snap1.Header.Identifier), fmt.Sprintf("%x:/dir", snap2.Header.Identifier)}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var cs ChangeSet
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &cs))
+	require.Equal(t, "/dir", cs.From.Path)
+	require.Equal(t, "/dir", cs.To.Path)
+	require.Len(t, cs.Changes, 7)
+	require.Equal(t, ChangeMetadata, cs.Changes[0].Kind)
+	require.Equal(t, "-rw-r--r--", cs.Changes[0].Old.Mode)
+	require.Equal(t, "-rw-------", cs.Changes[0].New.Mode)
+}
+
+func TestExecuteCmdDiffSummaryStat(t *testing.T) {
+	repo, ctx, snap1, snap2 := generateChanges(t)
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	cmd := &Diff{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-summary", "-stat",
+		fmt.Sprintf("%x", snap1.Header.Identifier), fmt.Sprintf("%x", snap2.Header.Identifier)}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	lines := strings.Split(strings.TrimSpace(bufOut.String()), "\n")
+	require.Equal(t, []string{
+		"P /dir/chmod.txt (mode)",
+		"D /dir/gone.txt (4 B)",
+		"M /dir/mod.txt (3 B -> 3 B)",
+		"A /dir/new.txt (9 B)",
+		"T /dir/swap (4 B -> 0 B)",
+		"A /dir/swap/inner.txt (5 B)",
+		"X /dir/xattr.txt (xattrs)",
+		"D /old (0 B)",
+		"D /old/file.txt (3 B)",
+	}, lines[:9])
+	require.Equal(t, "added            2        0 B -> 14 B", lines[9])
+	require.Equal(t, "9 changes", lines[len(lines)-1])
+}
+
+func TestDiffParseStructured(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	require.Error(t, (&Diff{}).Parse(ctx, []string{"-json", "-stat", "a", "b"}))
+	require.Error(t, (&Diff{}).Parse(ctx, []string{"-highlight", "-summary", "a", "b"}))
+
+	repo, ctx, snap1, _ := generateChanges(t)
+	cmd := &Diff{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json", fmt.Sprintf("%x", snap1.Header.Identifier)}))
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "require a snapshot or an importer location")
+	require.Equal(t, 1, status)
+}
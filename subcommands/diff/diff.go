@@ -46,13 +46,25 @@ func (cmd *Diff) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags := flag.NewFlagSet("diff", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] SNAPSHOT:PATH SNAPSHOT[:PATH]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] SNAPSHOT[:PATH] LOCATION\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
 		flags.PrintDefaults()
 	}
 	flags.BoolVar(&cmd.Highlight, "highlight", false, "highlight output")
 	flags.BoolVar(&cmd.Recursive, "recursive", false, "recursive diff of directories")
+	flags.BoolVar(&cmd.JSON, "json", false, "output the changes as JSON")
+	flags.BoolVar(&cmd.Summary, "summary", false, "output one line per change")
+	flags.BoolVar(&cmd.Stat, "stat", false, "output the totals of the changes")
+	flags.BoolVar(&cmd.Deep, "deep", false, "compare the contents of the files of an importer location")
 	flags.Parse(args)
 
+	if cmd.JSON && (cmd.Summary || cmd.Stat) {
+		return fmt.Errorf("-json cannot be used with -summary or -stat")
+	}
+	if cmd.Highlight && cmd.structured() {
+		return fmt.Errorf("-highlight cannot be used with -json, -summary or -stat")
+	}
+
 	if flags.NArg() == 1 {
 		cmd.Path1 = flags.Arg(0)
 		cmd.Path2 = ""
@@ -62,6 +74,21 @@ func (cmd *Diff) Parse(ctx *appcontext.AppContext, args []string) error {
 	} else {
 		return fmt.Errorf("needs at least a snapshot ID and/or snapshot file to diff")
 	}
+
+	if isImporterLocation(cmd.Path1) {
+		return fmt.Errorf("an importer location can only be compared to a snapshot")
+	}
+	if cmd.Path2 != "" && isImporterLocation(cmd.Path2) {
+		if cmd.Highlight {
+			return fmt.Errorf("-highlight cannot be used with an importer location")
+		}
+		// only the changes are known, not the text differences
+		if !cmd.structured() {
+			cmd.Summary = true
+		}
+	} else if cmd.Deep {
+		return fmt.Errorf("-deep requires an importer location")
+	}
 	cmd.RepositorySecret = ctx.GetSecret()
 
 	return nil
@@ -72,10 +99,20 @@ type Diff struct {
 
 	Highlight bool
 	Recursive bool
+	JSON      bool
+	Summary   bool
+	Stat      bool
+	Deep      bool
 	Path1     string
 	Path2     string
 }
 
+// structured returns true if the changes are output instead of the
+// text diff.
+func (cmd *Diff) structured() bool {
+	return cmd.JSON || cmd.Summary || cmd.Stat
+}
+
 func (cmd *Diff) Name() string {
 	return "diff"
 }
@@ -92,11 +129,32 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 	}
 	id1 := fmt.Sprintf("%x", snap1.Header.GetIndexShortID())
 
+	if cmd.Path2 != "" && isImporterLocation(cmd.Path2) {
+		t2, root, err := scanImporter(ctx, repo, cmd.Path2, cmd.Deep)
+		if err != nil {
+			return 1, fmt.Errorf("diff: %w", err)
+		}
+		cs, err := changeSet(ctx, vfsTree{repo, vfs1}, pathname1, t2, root)
+		if err != nil {
+			return 1, fmt.Errorf("diff: could not diff pathnames: %w", err)
+		}
+		cs.From = Side{Snapshot: id1, Path: pathname1}
+		cs.To = Side{Location: cmd.Path2, Path: root}
+		status, err := cmd.writeChanges(ctx, cs)
+		if err == nil && len(t2.unreadable) != 0 {
+			return 1, fmt.Errorf("diff: %d entries of %s could not be read", len(t2.unreadable), cmd.Path2)
+		}
+		return status, err
+	}
+
 	var pathname2 string
 	var id2 string
 	var vfs2 fs.FS
 
 	if cmd.Path2 == "" {
+		if cmd.structured() {
+			return 1, fmt.Errorf("diff: -json, -summary and -stat require a snapshot or an importer location to compare to")
+		}
 		vfs2 = os.DirFS("/")
 		id2 = "local"
 	} else {
@@ -122,6 +180,16 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 		pathname2 = pathname1
 	}
 
+	if cmd.structured() {
+		cs, err := changeSet(ctx, vfsTree{repo, vfs1}, pathname1, vfsTree{repo, vfs2.(*vfs.Filesystem)}, pathname2)
+		if err != nil {
+			return 1, fmt.Errorf("diff: could not diff pathnames: %w", err)
+		}
+		cs.From = Side{Snapshot: id1, Path: pathname1}
+		cs.To = Side{Snapshot: id2, Path: pathname2}
+		return cmd.writeChanges(ctx, cs)
+	}
+
 	var (
 		out     = ctx.Stdout
 		builder = strings.Builder{}
@@ -144,6 +212,23 @@ func (cmd *Diff) Execute(ctx *appcontext.AppContext, repo *repository.Repository
 	return 0, nil
 }
 
+func (cmd *Diff) writeChanges(ctx *appcontext.AppContext, cs *ChangeSet) (int, error) {
+	switch {
+	case cmd.JSON:
+		if err := writeJSON(ctx.Stdout, cs); err != nil {
+			return 1, fmt.Errorf("diff: %w", err)
+		}
+	default:
+		if cmd.Summary {
+			writeSummary(ctx.Stdout, cs)
+		}
+		if cmd.Stat {
+			writeStat(ctx.Stdout, cs)
+		}
+	}
+	return 0, nil
+}
+
 func (cmd *Diff) diff_pathnames(out io.Writer, id1 string, vfs1 fs.FS, pathname1 string, id2 string, vfs2 fs.FS, pathname2 string) error {
 	fsobj1, err := vfs1.Open(pathname1)
 	if err != nil {
@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package diff
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// isImporterLocation returns true if loc names an importer, either as
+// @name or as proto:location, rather than a snapshot.
+func isImporterLocation(loc string) bool {
+	if strings.HasPrefix(loc, "@") {
+		return true
+	}
+	proto, _, found := strings.Cut(loc, ":")
+	return found && slices.Contains(importer.Backends(), proto)
+}
+
+// importerTree is the live side of a diff, as scanned by an importer.
+// Unreadable holds the pathnames that could not be fully read, they
+// are not compared.
+type importerTree struct {
+	nodes      map[string]*node
+	dirs       map[string]map[string]*node
+	unreadable []string
+}
+
+func (t *importerTree) lookup(pathname string) (*node, error) {
+	n, ok := t.nodes[pathname]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return n, nil
+}
+
+func (t *importerTree) children(pathname string) (map[string]*node, error) {
+	if _, ok := t.nodes[pathname]; !ok {
+		return nil, fs.ErrNotExist
+	}
+	return t.dirs[pathname], nil
+}
+
+// scanImporter runs the importer for loc and returns the tree of its
+// records along with its root.  Contents and extended attributes are
+// only read, and hashed with the repository MAC, when deep is set:
+// otherwise they are left unknown and regular files are compared by
+// size and modification time.
+func scanImporter(ctx *appcontext.AppContext, repo *repository.Repository, loc string, deep bool) (*importerTree, string, error) {
+	config := map[string]string{"location": loc}
+	if strings.HasPrefix(loc, "@") {
+		remote, ok := ctx.Config.GetSource(loc[1:])
+		if !ok {
+			return nil, "", fmt.Errorf("could not resolve importer: %s", loc)
+		}
+		if _, ok := remote["location"]; !ok {
+			return nil, "", fmt.Errorf("could not resolve importer location: %s", loc)
+		}
+		config = remote
+	}
+
+	imp, err := importer.NewImporter(ctx.GetInner(), ctx.ImporterOpts(), config)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create an importer for %s: %w", loc, err)
+	}
+	defer imp.Close(ctx)
+
+	t := &importerTree{
+		nodes: make(map[string]*node),
+		dirs:  make(map[string]map[string]*node),
+	}
+	xattrs := make(map[string]map[string]objects.MAC)
+	unknownXattrs := make(map[string]bool)
+
+	fail := func(pathname string, err error) {
+		ctx.GetLogger().Warn("diff: %s: %s", utils.SanitizeText(pathname), err)
+		t.unreadable = append(t.unreadable, pathname)
+	}
+
+	insert := func(pathname string, n *node) {
+		t.nodes[pathname] = n
+		if pathname != "/" {
+			parent := path.Dir(pathname)
+			if t.dirs[parent] == nil {
+				t.dirs[parent] = make(map[string]*node)
+			}
+			t.dirs[parent][path.Base(pathname)] = n
+		}
+	}
+
+	hash := func(rd io.Reader) (*objects.MAC, error) {
+		hasher := repo.GetMACHasher()
+		if _, err := io.Copy(hasher, rd); err != nil {
+			return nil, err
+		}
+		mac := objects.MAC(hasher.Sum(nil))
+		return &mac, nil
+	}
+
+	scan := func(record *connectors.Record) {
+		pathname := record.Pathname
+
+		switch {
+		case record.Err != nil:
+			fail(pathname, record.Err)
+			if record.IsXattr {
+				unknownXattrs[pathname] = true
+			} else if n, ok := t.nodes[pathname]; ok {
+				n.unreadable = true
+			} else {
+				insert(pathname, &node{unreadable: true})
+			}
+
+		case record.IsXattr:
+			if !deep {
+				return
+			}
+			mac, err := hash(record.Reader)
+			if err != nil {
+				fail(pathname, fmt.Errorf("%s: %w", utils.SanitizeText(record.XattrName), err))
+				unknownXattrs[pathname] = true
+				return
+			}
+			if xattrs[pathname] == nil {
+				xattrs[pathname] = make(map[string]objects.MAC)
+			}
+			xattrs[pathname][record.XattrName] = *mac
+
+		default:
+			n := &node{info: record.FileInfo, symlink: record.Target}
+			if prev, ok := t.nodes[pathname]; ok {
+				n.unreadable = prev.unreadable
+			}
+			if deep {
+				n.xattrs = make(map[string]objects.MAC)
+				if record.FileInfo.Mode().IsRegular() {
+					// the content stays unknown and is compared by
+					// size and modification time
+					mac, err := hash(record.Reader)
+					if err != nil {
+						fail(pathname, err)
+					} else {
+						n.contentMAC = mac
+					}
+				}
+			}
+			insert(pathname, n)
+		}
+	}
+
+	records := make(chan *connectors.Record, ctx.MaxConcurrency)
+	var results chan *connectors.Result
+	if (imp.Flags() & location.FLAG_NEEDACK) != 0 {
+		results = make(chan *connectors.Result, ctx.MaxConcurrency)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for record := range records {
+			scan(record)
+			if results == nil {
+				record.Close()
+			} else {
+				results <- record.Ok()
+			}
+		}
+		if results != nil {
+			close(results)
+		}
+	}()
+
+	err = imp.Import(ctx, records, results)
+	<-done
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to scan %s: %w", loc, err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	// attributes may come in before the record of their entry
+	for pathname, attrs := range xattrs {
+		if n, ok := t.nodes[pathname]; ok {
+			n.xattrs = attrs
+		}
+	}
+	for pathname := range unknownXattrs {
+		if n, ok := t.nodes[pathname]; ok {
+			n.xattrs = nil
+		}
+	}
+
+	return t, imp.Root(), nil
+}
@@ -0,0 +1,151 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/PlakarKorp/integrations/fs/importer"
+	"github.com/PlakarKorp/kloset/connectors/importer"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+// backupDir snapshots dir through the fs importer.
+func backupDir(t *testing.T, repo *repository.Repository, ctx *appcontext.AppContext, dir string) *snapshot.Snapshot {
+	t.Setenv("PLAKAR_LOCKLESS", "true")
+
+	builder, err := snapshot.Create(repo, repository.DefaultType, "", objects.NilMac, &snapshot.BuilderOptions{})
+	require.NoError(t, err)
+
+	imp, err := importer.NewImporter(ctx.GetInner(), ctx.ImporterOpts(), map[string]string{"location": "fs:" + dir})
+	require.NoError(t, err)
+
+	source, err := snapshot.NewSource(repo.AppContext(), imp)
+	require.NoError(t, err)
+	require.NoError(t, builder.Backup(source))
+	require.NoError(t, builder.Commit())
+	require.NoError(t, builder.Close())
+	require.NoError(t, repo.RebuildState())
+
+	snap, err := snapshot.Load(repo, builder.Header.Identifier)
+	require.NoError(t, err)
+	t.Cleanup(func() { snap.Close() })
+	return snap
+}
+
+func TestDiffImporter(t *testing.T) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		pathname := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(pathname), 0755))
+		require.NoError(t, os.WriteFile(pathname, []byte(content), 0644))
+	}
+	write("same.txt", "same")
+	write("quiet.txt", "aaa")
+	write("gone.txt", "gone")
+	write("sub/grown.txt", "small")
+
+	snap := backupDir(t, repo, ctx, dir)
+
+	// same size and modification time, only the content tells
+	info, err := os.Stat(filepath.Join(dir, "quiet.txt"))
+	require.NoError(t, err)
+	write("quiet.txt", "bbb")
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "quiet.txt"), time.Time{}, info.ModTime()))
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "gone.txt")))
+	write("new.txt", "brand new")
+	write("sub/grown.txt", "not so small")
+
+	run := func(args ...string) []string {
+		bufOut.Reset()
+		cmd := &Diff{}
+		args = append(args, fmt.Sprintf("%x:%s", snap.Header.Identifier, dir), "fs:"+dir)
+		require.NoError(t, cmd.Parse(ctx, args))
+		status, err := cmd.Execute(ctx, repo)
+		require.NoError(t, err)
+		require.Equal(t, 0, status)
+		return strings.Split(strings.TrimSpace(bufOut.String()), "\n")
+	}
+
+	// the summary is the default output
+	require.Equal(t, []string{
+		"D " + dir + "/gone.txt (4 B)",
+		"A " + dir + "/new.txt (9 B)",
+		"M " + dir + "/sub/grown.txt (5 B -> 12 B)",
+	}, run())
+
+	require.Equal(t, []string{
+		"D " + dir + "/gone.txt (4 B)",
+		"A " + dir + "/new.txt (9 B)",
+		"M " + dir + "/quiet.txt (3 B -> 3 B)",
+		"M " + dir + "/sub/grown.txt (5 B -> 12 B)",
+	}, run("-deep"))
+
+	run("-json")
+	var cs ChangeSet
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &cs))
+	require.Equal(t, "fs:"+dir, cs.To.Location)
+	require.Equal(t, dir, cs.To.Path)
+	require.Empty(t, cs.To.Snapshot)
+	require.Equal(t, 1, cs.Stats.Added.Count)
+}
+
+func TestDiffParseImporter(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	require.ErrorContains(t, (&Diff{}).Parse(ctx, []string{"-deep", "a", "b"}), "-deep requires")
+	require.ErrorContains(t, (&Diff{}).Parse(ctx, []string{"fs:/tmp", "a"}), "importer location")
+	require.ErrorContains(t, (&Diff{}).Parse(ctx, []string{"-highlight", "a", "fs:/tmp"}), "-highlight")
+
+	cmd := &Diff{}
+	require.NoError(t, cmd.Parse(ctx, []string{"a", "@source"}))
+	require.True(t, cmd.Summary)
+
+	cmd = &Diff{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-stat", "-deep", "a", "fs:/tmp"}))
+	require.False(t, cmd.Summary)
+}
+
+func TestDiffImporterUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret.txt")
+	require.NoError(t, os.WriteFile(secret, []byte("secret"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.txt"), []byte("other"), 0644))
+
+	snap := backupDir(t, repo, ctx, dir)
+
+	require.NoError(t, os.Chmod(secret, 0))
+	t.Cleanup(func() { os.Chmod(secret, 0644) })
+
+	cmd := &Diff{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-deep", fmt.Sprintf("%x:%s", snap.Header.Identifier, dir), "fs:" + dir}))
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "1 entries")
+	require.Equal(t, 1, status)
+
+	// the file is still there, its content is merely unknown
+	require.NotContains(t, bufOut.String(), "secret.txt")
+}
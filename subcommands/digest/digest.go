@@ -43,20 +43,25 @@ func (cmd *Digest) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags := flag.NewFlagSet("digest", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH]]...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] -verify DIR SNAPSHOT[:PATH]\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
 		flags.PrintDefaults()
 	}
 
 	flags.StringVar(&opt_hashing, "hashing", "SHA256", "hashing algorithm to use")
+	flags.StringVar(&cmd.Verify, "verify", "", "verify that the local directory matches the snapshot")
 	flags.Parse(args)
 
 	if flags.NArg() == 0 {
 		return fmt.Errorf("at least one parameter is required")
 	}
+	if cmd.Verify != "" && flags.NArg() != 1 {
+		return fmt.Errorf("-verify requires exactly one snapshot")
+	}
 
-	hashingFunction := strings.ToUpper(opt_hashing)
-	if hashing.GetHasher(hashingFunction) == nil {
-		return fmt.Errorf("unsupported hashing algorithm: %s", hashingFunction)
+	hashingFunction, err := ParseHashing(opt_hashing)
+	if err != nil {
+		return err
 	}
 
 	cmd.RepositorySecret = ctx.GetSecret()
@@ -66,14 +71,38 @@ func (cmd *Digest) Parse(ctx *appcontext.AppContext, args []string) error {
 	return nil
 }
 
+// ParseHashing returns the name of the hashing algorithm as expected by
+// Sum, or an error if it is not supported.
+func ParseHashing(name string) (string, error) {
+	algorithm := strings.ToUpper(name)
+	if hashing.GetHasher(algorithm) == nil {
+		return "", fmt.Errorf("unsupported hashing algorithm: %s", algorithm)
+	}
+	return algorithm, nil
+}
+
+// Sum returns the digest of the content of rd.
+func Sum(algorithm string, rd io.Reader) ([]byte, error) {
+	hasher := hashing.GetHasher(algorithm)
+	if _, err := io.Copy(hasher, rd); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
+
 type Digest struct {
 	subcommands.SubcommandBase
 
 	HashingFunction string
+	Verify          string
 	Targets         []string
 }
 
 func (cmd *Digest) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if cmd.Verify != "" {
+		return cmd.verify(ctx, repo)
+	}
+
 	errors := 0
 	for _, snapshotPath := range cmd.Targets {
 		snap, pathname, err := locate.OpenSnapshotByPath(repo, snapshotPath)
@@ -128,12 +157,10 @@ func (cmd *Digest) displayDigests(ctx *appcontext.AppContext, fs *vfs.Filesystem
 	}
 	defer rd.Close()
 
-	algorithm := cmd.HashingFunction
-	hasher := hashing.GetHasher(algorithm)
-	if _, err := io.Copy(hasher, rd); err != nil {
+	digest, err := Sum(cmd.HashingFunction, rd)
+	if err != nil {
 		return err
 	}
-	digest := hasher.Sum(nil)
-	fmt.Fprintf(ctx.Stdout, "%s (%s) = %x\n", algorithm, utils.SanitizeText(pathname), digest)
+	fmt.Fprintf(ctx.Stdout, "%s (%s) = %x\n", cmd.HashingFunction, utils.SanitizeText(pathname), digest)
 	return nil
 }
@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+const (
+	VerifyMissing  = "missing"
+	VerifyExtra    = "extra"
+	VerifyMismatch = "mismatch"
+)
+
+// Difference is a regular file that does not match between the local
+// directory and the snapshot, by path relative to both.
+type Difference struct {
+	Kind string
+	Path string
+}
+
+// verify compares the regular files of the local directory with those
+// of the snapshot and fails if any is missing, extra or different.
+func (cmd *Digest) verify(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	info, err := os.Stat(cmd.Verify)
+	if err != nil {
+		return 1, fmt.Errorf("digest: %w", err)
+	}
+	if !info.IsDir() {
+		return 1, fmt.Errorf("digest: %s: not a directory", utils.SanitizeText(cmd.Verify))
+	}
+
+	snap, pathname, err := locate.OpenSnapshotByPath(repo, cmd.Targets[0])
+	if err != nil {
+		return 1, fmt.Errorf("digest: %s: %w", utils.SanitizeText(cmd.Targets[0]), err)
+	}
+	defer snap.Close()
+
+	differences, verified, errors, err := cmd.verifyTree(ctx, snap, pathname)
+	if err != nil {
+		return 1, fmt.Errorf("digest: %w", err)
+	}
+
+	for _, difference := range differences {
+		fmt.Fprintf(ctx.Stdout, "%s %s\n", difference.Kind, utils.SanitizeText(difference.Path))
+	}
+
+	if errors != 0 {
+		return 1, fmt.Errorf("errors occurred")
+	}
+	if len(differences) != 0 {
+		return 1, fmt.Errorf("digest: %s does not match %x:%s: %d differences",
+			utils.SanitizeText(cmd.Verify), snap.Header.Identifier[:4], utils.SanitizeText(pathname),
+			len(differences))
+	}
+
+	ctx.GetLogger().Info("digest: %s matches %x:%s: %d files verified",
+		utils.SanitizeText(cmd.Verify), snap.Header.Identifier[:4], utils.SanitizeText(pathname), verified)
+	return 0, nil
+}
+
+// verifyTree walks the local directory and the snapshot below pathname and
+// returns the differences between their regular files ordered by path,
+// the number of matching files and the number of files that could not
+// be read, which are logged.
+func (cmd *Digest) verifyTree(ctx *appcontext.AppContext, snap *snapshot.Snapshot, pathname string) ([]Difference, int, int, error) {
+	pvfs, err := snap.Filesystem()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	root, err := pvfs.GetEntry(pathname)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if !root.IsDir() {
+		return nil, 0, 0, fmt.Errorf("%x:%s: not a directory", snap.Header.Identifier[:4], utils.SanitizeText(pathname))
+	}
+
+	expected := make(map[string]*vfs.Entry)
+	for entry, err := range pvfs.Files(root.Path()) {
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, 0, 0, err
+		}
+		if !entry.Stat().Mode().IsRegular() {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(entry.Path(), root.Path()), "/")
+		expected[rel] = entry
+	}
+
+	var differences []Difference
+	verified, errors := 0, 0
+	seen := make(map[string]struct{})
+
+	err = filepath.WalkDir(cmd.Verify, func(pathname string, d fs.DirEntry, err error) error {
+		if err != nil {
+			ctx.GetLogger().Error("digest: %s: %s", utils.SanitizeText(pathname), err)
+			errors++
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(cmd.Verify, pathname)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		entry, ok := expected[rel]
+		if !ok {
+			if d.Type().IsRegular() {
+				differences = append(differences, Difference{Kind: VerifyExtra, Path: rel})
+			}
+			return nil
+		}
+		seen[rel] = struct{}{}
+
+		match, err := cmd.compare(pvfs, entry, pathname, d)
+		if err != nil {
+			ctx.GetLogger().Error("digest: %s: %s", utils.SanitizeText(pathname), err)
+			errors++
+		} else if match {
+			verified++
+		} else {
+			differences = append(differences, Difference{Kind: VerifyMismatch, Path: rel})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	for rel := range expected {
+		if _, ok := seen[rel]; !ok {
+			differences = append(differences, Difference{Kind: VerifyMissing, Path: rel})
+		}
+	}
+
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].Path < differences[j].Path
+	})
+	return differences, verified, errors, nil
+}
+
+// compare returns true if the local file has the type and the digest of
+// the snapshot entry.  Sizes are compared first to avoid reading files
+// that cannot match.
+func (cmd *Digest) compare(pvfs *vfs.Filesystem, entry *vfs.Entry, pathname string, d fs.DirEntry) (bool, error) {
+	if !d.Type().IsRegular() {
+		return false, nil
+	}
+
+	info, err := d.Info()
+	if err != nil {
+		return false, err
+	}
+	if info.Size() != entry.Size() {
+		return false, nil
+	}
+
+	fp, err := os.Open(pathname)
+	if err != nil {
+		return false, err
+	}
+	defer fp.Close()
+
+	local, err := Sum(cmd.HashingFunction, fp)
+	if err != nil {
+		return false, err
+	}
+
+	rd, err := entry.Open(pvfs)
+	if err != nil {
+		return false, err
+	}
+	defer rd.Close()
+
+	remote, err := Sum(cmd.HashingFunction, rd)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(local, remote), nil
+}
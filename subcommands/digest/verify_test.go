@@ -0,0 +1,70 @@
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteCmdDigestVerify(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		pathname := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(pathname), 0755))
+		require.NoError(t, os.WriteFile(pathname, []byte(content), 0644))
+	}
+	write("dummy.txt", "hello dummy")
+	write("foo.txt", "hello foo")
+	write("to_exclude", "*/subdir/to_exclude\n")
+
+	target := fmt.Sprintf("%x:/subdir", snap.Header.Identifier)
+	verify := func(args ...string) (int, error) {
+		bufOut.Reset()
+		cmd := &Digest{}
+		require.NoError(t, cmd.Parse(ctx, append(args, "-verify", dir, target)))
+		return cmd.Execute(ctx, repo)
+	}
+
+	status, err := verify()
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "3 files verified")
+
+	status, err = verify("-hashing", "BLAKE3")
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// same size, different content
+	write("foo.txt", "hello bar")
+	write("sub/new.txt", "new")
+	require.NoError(t, os.Remove(filepath.Join(dir, "to_exclude")))
+	// a directory where a file is expected
+	require.NoError(t, os.Remove(filepath.Join(dir, "dummy.txt")))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "dummy.txt"), 0755))
+
+	status, err = verify()
+	require.ErrorContains(t, err, "4 differences")
+	require.Equal(t, 1, status)
+	require.Equal(t, "missing dummy.txt\nmismatch foo.txt\nextra sub/new.txt\nmissing to_exclude\n", bufOut.String())
+}
+
+func TestParseDigestVerify(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	require.ErrorContains(t, (&Digest{}).Parse(ctx, []string{"-verify", "/tmp", "a", "b"}), "-verify")
+
+	cmd := &Digest{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-verify", "/tmp", "a"}))
+	require.Equal(t, "/tmp", cmd.Verify)
+	require.Equal(t, []string{"a"}, cmd.Targets)
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package du
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+
+	plocate "github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Du{} }, 0, "du")
+}
+
+type Du struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *plocate.LocateOptions
+	OptDepth      int
+	OptTop        int
+	OptGroup      string
+	OptUnique     bool
+	OptJSON       bool
+	Path          string
+}
+
+func (cmd *Du) Parse(ctx *appcontext.AppContext, args []string) error {
+	cmd.LocateOptions = plocate.NewDefaultLocateOptions()
+
+	flags := flag.NewFlagSet("du", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH]]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.IntVar(&cmd.OptDepth, "depth", -1, "only show directories up to the given depth below the path")
+	flags.IntVar(&cmd.OptTop, "top", 0, "only show the given number of largest files and directories")
+	flags.StringVar(&cmd.OptGroup, "group", "", "group files by content type (type) or extension (ext)")
+	flags.BoolVar(&cmd.OptUnique, "unique", false, "show the bytes not shared with any other snapshot")
+	flags.BoolVar(&cmd.OptJSON, "json", false, "output the disk usage as JSON")
+	cmd.LocateOptions.InstallLocateFlags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		return fmt.Errorf("at most one snapshot can be specified")
+	}
+	if flags.NArg() == 1 {
+		cmd.Path = flags.Arg(0)
+		if !cmd.LocateOptions.Empty() {
+			ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
+		}
+	}
+	if cmd.OptTop < 0 {
+		return fmt.Errorf("invalid number of entries %d", cmd.OptTop)
+	}
+	switch cmd.OptGroup {
+	case "", utils.DiskUsageByType, utils.DiskUsageByExtension:
+	default:
+		return fmt.Errorf("invalid grouping %q, must be %q or %q", cmd.OptGroup,
+			utils.DiskUsageByType, utils.DiskUsageByExtension)
+	}
+	if cmd.OptGroup != "" && cmd.OptDepth >= 0 {
+		return fmt.Errorf("-depth cannot be used with -group")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+func (cmd *Du) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	snapPath := cmd.Path
+	if snapPath == "" {
+		snapshotIDs, err := plocate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+		if err != nil {
+			return 1, fmt.Errorf("du: could not fetch snapshots list: %w", err)
+		}
+		if len(snapshotIDs) == 0 {
+			return 1, fmt.Errorf("du: no snapshot found")
+		}
+		// newest first
+		snapPath = fmt.Sprintf("%x", snapshotIDs[0])
+	}
+
+	snap, pathname, err := plocate.OpenSnapshotByPath(repo, snapPath)
+	if err != nil {
+		return 1, fmt.Errorf("du: %s: %w", utils.SanitizeText(snapPath), err)
+	}
+	defer snap.Close()
+
+	if cmd.OptGroup != "" {
+		groups, err := utils.GetDiskUsageGroups(ctx, repo, snap, pathname, cmd.OptGroup, cmd.OptUnique)
+		if err != nil {
+			return 1, fmt.Errorf("du: %x:%s: %w", snap.Header.Identifier[:4], utils.SanitizeText(pathname), err)
+		}
+		if cmd.OptTop != 0 && len(groups) > cmd.OptTop {
+			groups = groups[:cmd.OptTop]
+		}
+		if cmd.OptJSON {
+			return cmd.encode(ctx, groups)
+		}
+		for _, group := range groups {
+			fmt.Fprintf(ctx.Stdout, "%s%8d %s\n", cmd.sizes(group.Size, group.Unique),
+				group.Files, utils.SanitizeText(group.Group))
+		}
+		return 0, nil
+	}
+
+	opts := utils.DiskUsageOptions{
+		Depth:  cmd.OptDepth,
+		Files:  cmd.OptTop != 0,
+		Unique: cmd.OptUnique,
+	}
+	du, err := utils.GetDiskUsage(ctx, repo, snap, pathname, &opts)
+	if err != nil {
+		return 1, fmt.Errorf("du: %x:%s: %w", snap.Header.Identifier[:4], utils.SanitizeText(pathname), err)
+	}
+
+	if cmd.OptTop != 0 {
+		top := cmd.top(du)
+		if cmd.OptJSON {
+			return cmd.encode(ctx, top)
+		}
+		for _, entry := range top {
+			name := entry.Path
+			if entry.Dir {
+				name += "/"
+			}
+			fmt.Fprintf(ctx.Stdout, "%s%s\n", cmd.sizes(entry.Size, entry.Unique), utils.SanitizeText(name))
+		}
+		return 0, nil
+	}
+
+	if cmd.OptJSON {
+		return cmd.encode(ctx, du)
+	}
+
+	// like du(1), directories are listed after their content
+	var walk func(entry *utils.DiskUsage)
+	walk = func(entry *utils.DiskUsage) {
+		for _, child := range entry.Children {
+			walk(child)
+		}
+		fmt.Fprintf(ctx.Stdout, "%s%s\n", cmd.sizes(entry.Size, entry.Unique), utils.SanitizeText(entry.Path))
+	}
+	walk(du)
+	return 0, nil
+}
+
+// top returns the largest entries below root, by unique bytes if
+// requested.
+func (cmd *Du) top(root *utils.DiskUsage) []*utils.DiskUsage {
+	var entries []*utils.DiskUsage
+	var walk func(entry *utils.DiskUsage)
+	walk = func(entry *utils.DiskUsage) {
+		for _, child := range entry.Children {
+			entries = append(entries, child)
+			walk(child)
+		}
+	}
+	walk(root)
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if cmd.OptUnique && entries[i].Unique != entries[j].Unique {
+			return entries[i].Unique > entries[j].Unique
+		}
+		return entries[i].Size > entries[j].Size
+	})
+	if len(entries) > cmd.OptTop {
+		entries = entries[:cmd.OptTop]
+	}
+
+	// only the entries themselves, not their whole subtree
+	ret := make([]*utils.DiskUsage, 0, len(entries))
+	for _, entry := range entries {
+		flat := *entry
+		flat.Children = nil
+		ret = append(ret, &flat)
+	}
+	return ret
+}
+
+// sizes formats the size, followed by the unique bytes if requested.
+func (cmd *Du) sizes(size, unique uint64) string {
+	if cmd.OptUnique {
+		return fmt.Sprintf("%10s %10s ", humanize.IBytes(size), humanize.IBytes(unique))
+	}
+	return fmt.Sprintf("%10s ", humanize.IBytes(size))
+}
+
+func (cmd *Du) encode(ctx *appcontext.AppContext, v any) (int, error) {
+	enc := json.NewEncoder(ctx.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return 1, fmt.Errorf("du: %w", err)
+	}
+	return 0, nil
+}
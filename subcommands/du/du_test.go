@@ -0,0 +1,217 @@
+package du
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func randomContent(rnd *rand.Rand, size int) string {
+	buf := make([]byte, size)
+	rnd.Read(buf)
+	return string(buf)
+}
+
+// generateSnapshots creates two snapshots sharing their text files, only
+// the binary file of the second one is not found in the first one.
+func generateSnapshots(t *testing.T) (*repository.Repository, *appcontext.AppContext, *snapshot.Snapshot) {
+	// the lock of the previous snapshot is released asynchronously and
+	// may vanish while the next one lists the repository locks
+	t.Setenv("PLAKAR_LOCKLESS", "true")
+
+	rnd := rand.New(rand.NewSource(1))
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	var snap *snapshot.Snapshot
+	for range 2 {
+		snap = ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockDir("docs"),
+			ptesting.NewMockDir("docs/sub"),
+			ptesting.NewMockDir("other"),
+			ptesting.NewMockFile("docs/a.txt", 0644, strings.Repeat("a", 100)),
+			ptesting.NewMockFile("docs/sub/b.txt", 0644, strings.Repeat("b", 250)),
+			ptesting.NewMockFile("docs/sub/c.bin", 0644, randomContent(rnd, 300)),
+			ptesting.NewMockFile("other/d.txt", 0644, strings.Repeat("d", 50)),
+		})
+		t.Cleanup(func() { snap.Close() })
+		time.Sleep(10 * time.Millisecond)
+	}
+	return repo, ctx, snap
+}
+
+func TestGetDiskUsage(t *testing.T) {
+	repo, _, snap := generateSnapshots(t)
+
+	du, err := utils.GetDiskUsage(context.Background(), repo, snap, "/docs", &utils.DiskUsageOptions{Depth: -1})
+	require.NoError(t, err)
+	require.Equal(t, uint64(650), du.Size)
+	require.Equal(t, uint64(3), du.Files)
+	require.Len(t, du.Children, 1)
+	require.Equal(t, "/docs/sub", du.Children[0].Path)
+	require.Equal(t, uint64(550), du.Children[0].Size)
+	require.Zero(t, du.Unique)
+
+	du, err = utils.GetDiskUsage(context.Background(), repo, snap, "/", &utils.DiskUsageOptions{Depth: 0})
+	require.NoError(t, err)
+	require.Equal(t, uint64(700), du.Size)
+	require.Empty(t, du.Children)
+
+	du, err = utils.GetDiskUsage(context.Background(), repo, snap, "/docs", &utils.DiskUsageOptions{Depth: 1, Files: true, Unique: true})
+	require.NoError(t, err)
+	require.Len(t, du.Children, 2)
+	require.Equal(t, "a.txt", du.Children[0].Name)
+	require.False(t, du.Children[0].Dir)
+	require.Zero(t, du.Children[0].Unique)
+	require.Equal(t, "sub", du.Children[1].Name)
+	require.Empty(t, du.Children[1].Children)
+	// the files below the depth account for their directory
+	require.Equal(t, uint64(300), du.Children[1].Unique)
+	require.Equal(t, uint64(300), du.Unique)
+
+	_, err = utils.GetDiskUsage(context.Background(), repo, snap, "/nope", &utils.DiskUsageOptions{Depth: -1})
+	require.Error(t, err)
+}
+
+func TestChunkOwners(t *testing.T) {
+	repo, _, snap := generateSnapshots(t)
+
+	owners, err := utils.NewChunkOwners(context.Background(), repo)
+	require.NoError(t, err)
+	current, err := owners.Current(repo)
+	require.NoError(t, err)
+	require.True(t, current)
+
+	opts := &utils.DiskUsageOptions{Depth: 0, Unique: true, Owners: owners}
+	du, err := utils.GetDiskUsage(context.Background(), repo, snap, "/", opts)
+	require.NoError(t, err)
+	require.Equal(t, uint64(300), du.Unique)
+
+	// the index no longer holds once the snapshots change
+	other := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockFile("e.txt", 0644, strings.Repeat("e", 10)),
+	})
+	defer other.Close()
+	current, err = owners.Current(repo)
+	require.NoError(t, err)
+	require.False(t, current)
+}
+
+func TestGetDiskUsageGroups(t *testing.T) {
+	repo, _, snap := generateSnapshots(t)
+
+	groups, err := utils.GetDiskUsageGroups(context.Background(), repo, snap, "/docs", utils.DiskUsageByExtension, false)
+	require.NoError(t, err)
+	require.Equal(t, []utils.DiskUsageGroup{
+		{Group: ".txt", Size: 350, Files: 2},
+		{Group: ".bin", Size: 300, Files: 1},
+	}, groups)
+
+	groups, err = utils.GetDiskUsageGroups(context.Background(), repo, snap, "/docs", utils.DiskUsageByExtension, true)
+	require.NoError(t, err)
+	require.Equal(t, ".bin", groups[0].Group)
+	require.Equal(t, uint64(300), groups[0].Unique)
+
+	groups, err = utils.GetDiskUsageGroups(context.Background(), repo, snap, "/docs/sub/b.txt", utils.DiskUsageByType, false)
+	require.NoError(t, err)
+	require.Equal(t, []utils.DiskUsageGroup{{Group: "text/plain", Size: 250, Files: 1}}, groups)
+
+	_, err = utils.GetDiskUsageGroups(context.Background(), repo, snap, "/docs", "size", false)
+	require.Error(t, err)
+}
+
+func run(t *testing.T, repo *repository.Repository, ctx *appcontext.AppContext, args ...string) []string {
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	cmd := &Du{}
+	require.NoError(t, cmd.Parse(ctx, args))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(bufOut.String()), "\n") {
+		lines = append(lines, strings.Join(strings.Fields(line), " "))
+	}
+	return lines
+}
+
+func TestExecuteCmdDu(t *testing.T) {
+	repo, ctx, snap := generateSnapshots(t)
+	id := fmt.Sprintf("%x", snap.Header.Identifier)
+
+	require.Equal(t, []string{
+		"550 B /docs/sub",
+		"650 B /docs",
+		"50 B /other",
+		"700 B /",
+	}, run(t, repo, ctx))
+
+	require.Equal(t, []string{
+		"550 B 300 B /docs/sub",
+		"650 B 300 B /docs",
+	}, run(t, repo, ctx, "-unique", id+":/docs"))
+
+	require.Equal(t, []string{
+		"650 B /docs/",
+		"550 B /docs/sub/",
+		"300 B /docs/sub/c.bin",
+	}, run(t, repo, ctx, "-top", "3"))
+
+	// ties on unique bytes are broken by size
+	require.Equal(t, []string{
+		"550 B 300 B /docs/sub/",
+		"300 B 300 B /docs/sub/c.bin",
+		"250 B 0 B /docs/sub/b.txt",
+	}, run(t, repo, ctx, "-top", "3", "-unique", "-depth", "2", id+":/docs"))
+
+	require.Equal(t, []string{
+		"400 B 3 .txt",
+		"300 B 1 .bin",
+	}, run(t, repo, ctx, "-group", "ext"))
+}
+
+func TestExecuteCmdDuJSON(t *testing.T) {
+	repo, ctx, snap := generateSnapshots(t)
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	cmd := &Du{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json", "-depth", "1", fmt.Sprintf("%x:/docs", snap.Header.Identifier)}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var du utils.DiskUsage
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &du))
+	require.Equal(t, "/docs", du.Path)
+	require.Equal(t, uint64(650), du.Size)
+	require.Len(t, du.Children, 1)
+}
+
+func TestParseDu(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	require.Error(t, (&Du{}).Parse(ctx, []string{"a", "b"}))
+	require.Error(t, (&Du{}).Parse(ctx, []string{"-top", "-1"}))
+	require.Error(t, (&Du{}).Parse(ctx, []string{"-group", "size"}))
+	require.Error(t, (&Du{}).Parse(ctx, []string{"-group", "type", "-depth", "1"}))
+
+	cmd := &Du{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-group", "ext", "-top", "5", "abcd:/etc"}))
+	require.Equal(t, "abcd:/etc", cmd.Path)
+	require.Equal(t, -1, cmd.OptDepth)
+	require.Equal(t, 5, cmd.OptTop)
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package dupes
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+
+	plocate "github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Dupes{} }, 0, "dupes")
+}
+
+type Dupes struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *plocate.LocateOptions
+	OptMinSize    uint64
+	OptJSON       bool
+	Paths         []string
+}
+
+// File is a regular file of a snapshot.
+type File struct {
+	Snapshot objects.MAC `json:"snapshot"`
+	Path     string      `json:"path"`
+}
+
+// DuplicateSet is a content found at several pathnames.  The wasted size
+// is that of all the copies but one.
+type DuplicateSet struct {
+	ContentMAC objects.MAC `json:"content_mac"`
+	Size       uint64      `json:"size"`
+	Wasted     uint64      `json:"wasted"`
+	Files      []File      `json:"files"`
+}
+
+func (cmd *Dupes) Parse(ctx *appcontext.AppContext, args []string) error {
+	var minSize string
+
+	cmd.LocateOptions = plocate.NewDefaultLocateOptions()
+
+	flags := flag.NewFlagSet("dupes", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH]]...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&minSize, "min-size", "1", "ignore files smaller than the given size")
+	flags.BoolVar(&cmd.OptJSON, "json", false, "output the duplicate sets as JSON")
+	cmd.LocateOptions.InstallLocateFlags(flags)
+	flags.Parse(args)
+
+	size, err := humanize.ParseBytes(minSize)
+	if err != nil {
+		return fmt.Errorf("invalid minimum size %q: %w", minSize, err)
+	}
+	cmd.OptMinSize = size
+
+	cmd.Paths = flags.Args()
+	if len(cmd.Paths) != 0 && !cmd.LocateOptions.Empty() {
+		ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+func (cmd *Dupes) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	paths := cmd.Paths
+	if len(paths) == 0 {
+		snapshotIDs, err := plocate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+		if err != nil {
+			return 1, fmt.Errorf("dupes: could not fetch snapshots list: %w", err)
+		}
+		if len(snapshotIDs) == 0 {
+			return 1, fmt.Errorf("dupes: no snapshot found")
+		}
+		// newest first
+		paths = []string{fmt.Sprintf("%x", snapshotIDs[0])}
+	}
+
+	finder := NewFinder(repo, cmd.OptMinSize)
+	for _, snapPath := range paths {
+		snap, pathname, err := plocate.OpenSnapshotByPath(repo, snapPath)
+		if err != nil {
+			return 1, fmt.Errorf("dupes: %s: %w", utils.SanitizeText(snapPath), err)
+		}
+		err = finder.Add(ctx, snap, pathname)
+		snap.Close()
+		if err != nil {
+			return 1, fmt.Errorf("dupes: %s: %w", utils.SanitizeText(snapPath), err)
+		}
+	}
+
+	sets, err := finder.Duplicates(ctx)
+	if err != nil {
+		return 1, fmt.Errorf("dupes: %w", err)
+	}
+
+	if cmd.OptJSON {
+		enc := json.NewEncoder(ctx.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(sets); err != nil {
+			return 1, fmt.Errorf("dupes: %w", err)
+		}
+		return 0, nil
+	}
+
+	var wasted uint64
+	for i, set := range sets {
+		if i != 0 {
+			fmt.Fprintln(ctx.Stdout)
+		}
+		fmt.Fprintf(ctx.Stdout, "%x: %d copies of %s, %s wasted\n", set.ContentMAC[:4],
+			len(set.Files), humanize.IBytes(set.Size), humanize.IBytes(set.Wasted))
+		for _, file := range set.Files {
+			fmt.Fprintf(ctx.Stdout, "\t%x:%s\n", file.Snapshot[:4], utils.SanitizeText(file.Path))
+		}
+		wasted += set.Wasted
+	}
+	if len(sets) != 0 {
+		fmt.Fprintf(ctx.Stdout, "\n%d duplicate sets, %s wasted\n", len(sets), humanize.IBytes(wasted))
+	}
+	return 0, nil
+}
+
+type candidate struct {
+	file   File
+	object objects.MAC
+}
+
+// Finder collects the regular files of snapshots and groups them by
+// content.  Only files sharing their size with another one have their
+// object resolved to compare contents.
+type Finder struct {
+	repo    *repository.Repository
+	minSize uint64
+	bySize  map[uint64][]candidate
+}
+
+func NewFinder(repo *repository.Repository, minSize uint64) *Finder {
+	return &Finder{
+		repo:    repo,
+		minSize: minSize,
+		bySize:  make(map[uint64][]candidate),
+	}
+}
+
+// Add collects the regular files at or below pathname in snap.
+func (f *Finder) Add(ctx context.Context, snap *snapshot.Snapshot, pathname string) error {
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return err
+	}
+
+	root, err := fs.GetEntry(pathname)
+	if err != nil {
+		return err
+	}
+
+	entries := fs.Files(root.Path())
+	if !root.IsDir() {
+		entries = func(yield func(*vfs.Entry, error) bool) {
+			yield(root, nil)
+		}
+	}
+
+	for entry, err := range entries {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !entry.Stat().Mode().IsRegular() || !entry.HasObject() {
+			continue
+		}
+		size := uint64(entry.Size())
+		if size < f.minSize {
+			continue
+		}
+		f.bySize[size] = append(f.bySize[size], candidate{
+			file:   File{Snapshot: snap.Header.Identifier, Path: entry.Path()},
+			object: entry.Object,
+		})
+	}
+	return nil
+}
+
+// Duplicates returns the contents found at several pathnames, the one
+// wasting the most space first.  A pathname holding the same content in
+// several snapshots is the same file and only reported once, in the
+// first snapshot it was added from.
+func (f *Finder) Duplicates(ctx context.Context) ([]DuplicateSet, error) {
+	// objects are shared between snapshots, only resolve each once
+	contents := make(map[objects.MAC]objects.MAC)
+
+	sets := make([]DuplicateSet, 0)
+	for size, candidates := range f.bySize {
+		if len(candidates) < 2 {
+			continue
+		}
+
+		byContent := make(map[objects.MAC]*DuplicateSet)
+		var order []objects.MAC
+		seen := make(map[objects.MAC]map[string]struct{})
+		for _, c := range candidates {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			contentMAC, ok := contents[c.object]
+			if !ok {
+				buffer, err := f.repo.GetBlobBytes(resources.RT_OBJECT, c.object)
+				if err != nil {
+					return nil, fmt.Errorf("%x:%s: %w", c.file.Snapshot[:4], utils.SanitizeText(c.file.Path), err)
+				}
+				object, err := objects.NewObjectFromBytes(buffer)
+				if err != nil {
+					return nil, fmt.Errorf("%x:%s: %w", c.file.Snapshot[:4], utils.SanitizeText(c.file.Path), err)
+				}
+				contentMAC = object.ContentMAC
+				contents[c.object] = contentMAC
+			}
+
+			if seen[contentMAC] == nil {
+				seen[contentMAC] = make(map[string]struct{})
+			}
+			if _, ok := seen[contentMAC][c.file.Path]; ok {
+				continue
+			}
+			seen[contentMAC][c.file.Path] = struct{}{}
+
+			set, ok := byContent[contentMAC]
+			if !ok {
+				set = &DuplicateSet{ContentMAC: contentMAC, Size: size}
+				byContent[contentMAC] = set
+				order = append(order, contentMAC)
+			}
+			set.Files = append(set.Files, c.file)
+		}
+
+		for _, contentMAC := range order {
+			set := byContent[contentMAC]
+			if len(set.Files) < 2 {
+				continue
+			}
+			set.Wasted = set.Size * uint64(len(set.Files)-1)
+			sets = append(sets, *set)
+		}
+	}
+
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i].Wasted != sets[j].Wasted {
+			return sets[i].Wasted > sets[j].Wasted
+		}
+		if sets[i].Size != sets[j].Size {
+			return sets[i].Size > sets[j].Size
+		}
+		return sets[i].Files[0].Path < sets[j].Files[0].Path
+	})
+	return sets, nil
+}
@@ -0,0 +1,141 @@
+package dupes
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+const hello = "hello world"
+
+// generateSnapshots creates a snapshot with duplicates in several
+// directories and a second one adding another copy.
+func generateSnapshots(t *testing.T) (*repository.Repository, *appcontext.AppContext, *snapshot.Snapshot, *snapshot.Snapshot) {
+	// the lock of the previous snapshot is released asynchronously and
+	// may vanish while the next one lists the repository locks
+	t.Setenv("PLAKAR_LOCKLESS", "true")
+
+	rnd := rand.New(rand.NewSource(1))
+	blob := make([]byte, 100)
+	rnd.Read(blob)
+
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	files := []ptesting.MockFile{
+		ptesting.NewMockDir("a"),
+		ptesting.NewMockDir("b"),
+		ptesting.NewMockDir("c"),
+		ptesting.NewMockFile("a/x.txt", 0644, hello),
+		ptesting.NewMockFile("b/x.txt", 0644, hello),
+		ptesting.NewMockFile("c/y.txt", 0644, hello),
+		// same size, different content
+		ptesting.NewMockFile("c/z.txt", 0644, "hello wordl"),
+		ptesting.NewMockFile("a/big.bin", 0644, string(blob)),
+		ptesting.NewMockFile("b/big.copy", 0644, string(blob)),
+		ptesting.NewMockFile("a/empty", 0644, ""),
+		ptesting.NewMockFile("b/empty", 0644, ""),
+	}
+	first := ptesting.GenerateSnapshot(t, repo, files)
+	t.Cleanup(func() { first.Close() })
+	time.Sleep(10 * time.Millisecond)
+
+	files = append(files, ptesting.NewMockDir("d"), ptesting.NewMockFile("d/x.txt", 0644, hello))
+	second := ptesting.GenerateSnapshot(t, repo, files)
+	t.Cleanup(func() { second.Close() })
+	return repo, ctx, first, second
+}
+
+func paths(set DuplicateSet) []string {
+	var ret []string
+	for _, file := range set.Files {
+		ret = append(ret, file.Path)
+	}
+	return ret
+}
+
+func TestFinder(t *testing.T) {
+	repo, ctx, first, second := generateSnapshots(t)
+
+	finder := NewFinder(repo, 1)
+	require.NoError(t, finder.Add(ctx, first, "/"))
+	sets, err := finder.Duplicates(ctx)
+	require.NoError(t, err)
+	require.Len(t, sets, 2)
+
+	require.Equal(t, []string{"/a/big.bin", "/b/big.copy"}, paths(sets[0]))
+	require.Equal(t, uint64(100), sets[0].Wasted)
+	require.Equal(t, []string{"/a/x.txt", "/b/x.txt", "/c/y.txt"}, paths(sets[1]))
+	require.Equal(t, uint64(len(hello)), sets[1].Size)
+	require.Equal(t, uint64(2*len(hello)), sets[1].Wasted)
+
+	// the files of the first snapshot are only reported once
+	finder = NewFinder(repo, 1)
+	require.NoError(t, finder.Add(ctx, first, "/"))
+	require.NoError(t, finder.Add(ctx, second, "/"))
+	sets, err = finder.Duplicates(ctx)
+	require.NoError(t, err)
+	require.Len(t, sets, 2)
+	require.Equal(t, []string{"/a/x.txt", "/b/x.txt", "/c/y.txt", "/d/x.txt"}, paths(sets[1]))
+	require.Equal(t, first.Header.Identifier, sets[1].Files[0].Snapshot)
+	require.Equal(t, second.Header.Identifier, sets[1].Files[3].Snapshot)
+
+	// a single file is its own set
+	finder = NewFinder(repo, 0)
+	require.NoError(t, finder.Add(ctx, first, "/a/x.txt"))
+	sets, err = finder.Duplicates(ctx)
+	require.NoError(t, err)
+	require.Empty(t, sets)
+}
+
+func TestExecuteCmdDupes(t *testing.T) {
+	repo, ctx, first, second := generateSnapshots(t)
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	// the latest snapshot by default
+	cmd := &Dupes{}
+	require.NoError(t, cmd.Parse(ctx, []string{}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	lines := strings.Split(bufOut.String(), "\n")
+	id := fmt.Sprintf("%x", second.Header.Identifier[:4])
+	require.Equal(t, "\t"+id+":/a/big.bin", lines[1])
+	require.Contains(t, lines[4], ": 4 copies of 11 B, 33 B wasted")
+	require.Equal(t, "2 duplicate sets, 133 B wasted", lines[len(lines)-2])
+
+	bufOut.Reset()
+	cmd = &Dupes{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json", "-min-size", "0", fmt.Sprintf("%x:/a", first.Header.Identifier),
+		fmt.Sprintf("%x:/b", first.Header.Identifier)}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	var sets []DuplicateSet
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &sets))
+	require.Len(t, sets, 3)
+	require.Equal(t, []string{"/a/empty", "/b/empty"}, paths(sets[2]))
+	require.Zero(t, sets[2].Wasted)
+}
+
+func TestParseDupes(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	require.Error(t, (&Dupes{}).Parse(ctx, []string{"-min-size", "big"}))
+
+	cmd := &Dupes{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-min-size", "1KiB", "a", "b:/etc"}))
+	require.Equal(t, uint64(1024), cmd.OptMinSize)
+	require.Equal(t, []string{"a", "b:/etc"}, cmd.Paths)
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package grep
+
+import (
+	"flag"
+	"fmt"
+
+	plocate "github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Grep{} }, 0, "grep")
+}
+
+type Grep struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *plocate.LocateOptions
+	GrepOptions   utils.GrepOptions
+	Paths         []string
+}
+
+func (cmd *Grep) Parse(ctx *appcontext.AppContext, args []string) error {
+	var mimes utils.PatternsFlag
+
+	cmd.LocateOptions = plocate.NewDefaultLocateOptions()
+
+	flags := flag.NewFlagSet("grep", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] PATTERN [SNAPSHOT[:PATH]]...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.BoolVar(&cmd.GrepOptions.Fixed, "F", false, "match the pattern as a fixed string")
+	flags.BoolVar(&cmd.GrepOptions.IgnoreCase, "i", false, "match case-insensitively")
+	flags.BoolVar(&cmd.GrepOptions.FilesOnly, "l", false, "only print the names of matching files")
+	flags.Var(&mimes, "mime", "only search files of the given content type (can be specified multiple times)")
+	cmd.LocateOptions.InstallLocateFlags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("a pattern is required")
+	}
+
+	cmd.GrepOptions.Pattern = flags.Arg(0)
+	cmd.GrepOptions.Mimes = mimes
+	cmd.GrepOptions.Concurrency = ctx.MaxConcurrency
+	if _, err := cmd.GrepOptions.Compile(); err != nil {
+		return fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	cmd.Paths = flags.Args()[1:]
+	if len(cmd.Paths) != 0 && !cmd.LocateOptions.Empty() {
+		ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+func (cmd *Grep) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	paths := cmd.Paths
+	if len(paths) == 0 {
+		snapshotIDs, err := plocate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+		if err != nil {
+			return 1, fmt.Errorf("grep: could not fetch snapshots list: %w", err)
+		}
+		for _, snapshotID := range snapshotIDs {
+			paths = append(paths, fmt.Sprintf("%x", snapshotID))
+		}
+	}
+
+	errors := 0
+	for _, snapPath := range paths {
+		snap, pathname, err := plocate.OpenSnapshotByPath(repo, snapPath)
+		if err != nil {
+			ctx.GetLogger().Error("grep: %s: %s", utils.SanitizeText(snapPath), err)
+			errors++
+			continue
+		}
+
+		n, err := cmd.grep(ctx, snap, pathname)
+		snap.Close()
+		errors += n
+		if err != nil {
+			return 1, err
+		}
+	}
+
+	if errors != 0 {
+		return 1, fmt.Errorf("errors occurred")
+	}
+	return 0, nil
+}
+
+// grep prints the matches below pathname in snap and returns the number
+// of files that could not be searched.
+func (cmd *Grep) grep(ctx *appcontext.AppContext, snap *snapshot.Snapshot, pathname string) (int, error) {
+	if pathname == "" {
+		pathname = "/"
+	}
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		ctx.GetLogger().Error("grep: %x: %s", snap.Header.Identifier[:4], err)
+		return 1, nil
+	}
+
+	errors := 0
+	err = utils.Grep(ctx, fs, pathname, &cmd.GrepOptions, func(match utils.GrepMatch) error {
+		switch {
+		case match.Error != "":
+			ctx.GetLogger().Error("grep: %x:%s: %s", snap.Header.Identifier[:4],
+				utils.SanitizeText(match.Path), match.Error)
+			errors++
+		case match.Binary:
+			fmt.Fprintf(ctx.Stdout, "%x:%s: binary file matches\n", snap.Header.Identifier[:4],
+				utils.SanitizeText(match.Path))
+		case cmd.GrepOptions.FilesOnly:
+			fmt.Fprintf(ctx.Stdout, "%x:%s\n", snap.Header.Identifier[:4],
+				utils.SanitizeText(match.Path))
+		default:
+			fmt.Fprintf(ctx.Stdout, "%x:%s:%d:%s\n", snap.Header.Identifier[:4],
+				utils.SanitizeText(match.Path), match.Line, utils.SanitizeText(match.Text))
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return errors, ctx.Err()
+		}
+		ctx.GetLogger().Error("grep: %x:%s: %s", snap.Header.Identifier[:4], utils.SanitizeText(pathname), err)
+		errors++
+	}
+	return errors, nil
+}
@@ -0,0 +1,136 @@
+package grep
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func generateSnapshot(t *testing.T, bufOut *bytes.Buffer) (*repository.Repository, *snapshot.Snapshot, *appcontext.AppContext) {
+	files := []ptesting.MockFile{
+		ptesting.NewMockDir("dir"),
+		ptesting.NewMockFile("dir/blob.bin", 0644, "line\x00binary\n"),
+	}
+	for i := range 20 {
+		files = append(files, ptesting.NewMockFile(fmt.Sprintf("dir/%02d.txt", i), 0644,
+			fmt.Sprintf("header\nLine %d\r\nfooter %d\n", i, i)))
+	}
+
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, files)
+	t.Cleanup(func() { snap.Close() })
+	if bufOut != nil {
+		ctx.Stdout = bufOut
+	}
+	ctx.MaxConcurrency = 4
+	return repo, snap, ctx
+}
+
+func TestParseGrep(t *testing.T) {
+	_, _, ctx := generateSnapshot(t, nil)
+
+	cmd := &Grep{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-F", "-i", "-l", "-mime", "text", "-mime", "application/json", "a.b", "abcd:/etc"}))
+	require.Equal(t, utils.GrepOptions{
+		Pattern:     "a.b",
+		Fixed:       true,
+		IgnoreCase:  true,
+		FilesOnly:   true,
+		Mimes:       []string{"text", "application/json"},
+		Concurrency: 4,
+	}, cmd.GrepOptions)
+	require.Equal(t, []string{"abcd:/etc"}, cmd.Paths)
+
+	require.Error(t, (&Grep{}).Parse(ctx, []string{}))
+	require.ErrorContains(t, (&Grep{}).Parse(ctx, []string{"("}), "invalid pattern")
+}
+
+func TestGrepOrdered(t *testing.T) {
+	_, snap, _ := generateSnapshot(t, nil)
+
+	fs, err := snap.Filesystem()
+	require.NoError(t, err)
+
+	var matches []utils.GrepMatch
+	opts := &utils.GrepOptions{Pattern: "line", IgnoreCase: true, Concurrency: 4}
+	err = utils.Grep(context.Background(), fs, "/dir", opts, func(match utils.GrepMatch) error {
+		matches = append(matches, match)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, matches, 21)
+	for i := range 20 {
+		require.Equal(t, utils.GrepMatch{
+			Path: fmt.Sprintf("/dir/%02d.txt", i),
+			Line: 2,
+			Text: fmt.Sprintf("Line %d", i),
+		}, matches[i])
+	}
+	require.Equal(t, utils.GrepMatch{Path: "/dir/blob.bin", Binary: true}, matches[20])
+
+	// stopping early is reported as is
+	stop := fmt.Errorf("stop")
+	err = utils.Grep(context.Background(), fs, "/dir", opts, func(match utils.GrepMatch) error { return stop })
+	require.ErrorIs(t, err, stop)
+}
+
+func TestExecuteCmdGrep(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap, ctx := generateSnapshot(t, bufOut)
+	id := fmt.Sprintf("%x", snap.Header.Identifier[:4])
+
+	cmd := &Grep{}
+	require.NoError(t, cmd.Parse(ctx, []string{"footer 1[0-9]", fmt.Sprintf("%x:/dir", snap.Header.Identifier)}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	lines := strings.Split(strings.TrimSpace(bufOut.String()), "\n")
+	require.Len(t, lines, 10)
+	require.Equal(t, id+":/dir/10.txt:3:footer 10", lines[0])
+
+	// all snapshots, files only
+	bufOut.Reset()
+	cmd = &Grep{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-l", "-F", "footer 7"}))
+	_, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, id+":/dir/07.txt\n", bufOut.String())
+
+	bufOut.Reset()
+	cmd = &Grep{}
+	require.NoError(t, cmd.Parse(ctx, []string{"binary"}))
+	_, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, id+":/dir/blob.bin: binary file matches\n", bufOut.String())
+}
+
+func TestExecuteCmdGrepMime(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, _, ctx := generateSnapshot(t, bufOut)
+
+	cmd := &Grep{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-l", "-mime", "image", "line"}))
+	_, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Empty(t, bufOut.String())
+}
+
+func TestExecuteCmdGrepMissing(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t, nil)
+
+	cmd := &Grep{}
+	require.NoError(t, cmd.Parse(ctx, []string{"line", fmt.Sprintf("%x:/nope", snap.Header.Identifier)}))
+	status, err := cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package history
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"time"
+
+	plocate "github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/subcommands/restore"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &History{} }, 0, "history")
+}
+
+type History struct {
+	subcommands.SubcommandBase
+
+	LocateOptions *plocate.LocateOptions
+	OptJSON       bool
+	OptRestore    int
+	OptTo         string
+	Pathname      string
+}
+
+// Version is a distinct state of the pathname, as seen in a run of
+// consecutive snapshots.
+type Version struct {
+	Version       int         `json:"version"`
+	FirstSnapshot objects.MAC `json:"first_snapshot"`
+	LastSnapshot  objects.MAC `json:"last_snapshot"`
+	FirstSeen     time.Time   `json:"first_seen"`
+	LastSeen      time.Time   `json:"last_seen"`
+	Snapshots     int         `json:"snapshots"`
+	Size          int64       `json:"size"`
+	Mode          fs.FileMode `json:"mode"`
+	SymlinkTarget string      `json:"symlink_target,omitempty"`
+	ContentMAC    objects.MAC `json:"content_mac"`
+}
+
+func (cmd *History) Parse(ctx *appcontext.AppContext, args []string) error {
+	cmd.LocateOptions = plocate.NewDefaultLocateOptions()
+
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] PATH\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.BoolVar(&cmd.OptJSON, "json", false, "output versions as JSON")
+	flags.IntVar(&cmd.OptRestore, "restore", 0, "restore the given version of the path")
+	flags.StringVar(&cmd.OptTo, "to", "", "base directory where the version is restored")
+	cmd.LocateOptions.InstallLocateFlags(flags)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("exactly one path must be specified")
+	}
+	if cmd.OptRestore < 0 {
+		return fmt.Errorf("invalid version %d", cmd.OptRestore)
+	}
+	if cmd.OptTo != "" && cmd.OptRestore == 0 {
+		return fmt.Errorf("-to requires -restore")
+	}
+	if cmd.OptJSON && cmd.OptRestore != 0 {
+		return fmt.Errorf("-json cannot be used with -restore")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Pathname = path.Clean("/" + flags.Arg(0))
+
+	return nil
+}
+
+func (cmd *History) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	snapshotIDs, err := plocate.LocateSnapshotIDs(repo, cmd.LocateOptions)
+	if err != nil {
+		return 1, fmt.Errorf("history: could not fetch snapshots list: %w", err)
+	}
+
+	// oldest first, so that versions are numbered in order of appearance
+	slices.Reverse(snapshotIDs)
+
+	locations, err := utils.LocatePathname(repo, snapshotIDs, cmd.Pathname, nil)
+	if err != nil {
+		return 1, fmt.Errorf("history: %w", err)
+	}
+	if len(locations) == 0 {
+		return 1, fmt.Errorf("history: %s: not found in any snapshot", utils.SanitizeText(cmd.Pathname))
+	}
+
+	versions := Versions(locations)
+
+	if cmd.OptRestore != 0 {
+		if cmd.OptRestore > len(versions) {
+			return 1, fmt.Errorf("history: %s: no version %d, last is %d",
+				utils.SanitizeText(cmd.Pathname), cmd.OptRestore, len(versions))
+		}
+		return cmd.restore(ctx, repo, versions[cmd.OptRestore-1])
+	}
+
+	if cmd.OptJSON {
+		enc := json.NewEncoder(ctx.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(versions); err != nil {
+			return 1, fmt.Errorf("history: %w", err)
+		}
+		return 0, nil
+	}
+
+	for _, v := range versions {
+		mac := "-"
+		if v.ContentMAC != (objects.MAC{}) {
+			mac = fmt.Sprintf("%x", v.ContentMAC)
+		}
+		fmt.Fprintf(ctx.Stdout, "%d %x %x %s %s %8s %s %s\n",
+			v.Version,
+			v.FirstSnapshot[:4],
+			v.LastSnapshot[:4],
+			v.FirstSeen.UTC().Format(time.RFC3339),
+			v.LastSeen.UTC().Format(time.RFC3339),
+			humanize.IBytes(uint64(v.Size)),
+			v.Mode,
+			mac)
+	}
+	return 0, nil
+}
+
+// restore restores the version from the last snapshot it was seen in.
+func (cmd *History) restore(ctx *appcontext.AppContext, repo *repository.Repository, v Version) (int, error) {
+	args := []string{}
+	if cmd.OptTo != "" {
+		args = append(args, "-to", cmd.OptTo)
+	}
+	args = append(args, fmt.Sprintf("%x:%s", v.LastSnapshot, cmd.Pathname))
+
+	subcommand := &restore.Restore{}
+	if err := subcommand.Parse(ctx, args); err != nil {
+		return 1, err
+	}
+	return subcommand.Execute(ctx, repo)
+}
+
+// Versions groups the locations of a pathname, ordered by snapshot,
+// into the distinct versions it went through.  A pathname that changes
+// and comes back to a previous state starts a new version.
+func Versions(locations []utils.TimelineLocation) []Version {
+	var versions []Version
+	for _, location := range locations {
+		entry := &location.Entry
+
+		var contentMAC objects.MAC
+		if entry.ResolvedObject != nil {
+			contentMAC = entry.ResolvedObject.ContentMAC
+		}
+
+		if n := len(versions); n != 0 {
+			last := &versions[n-1]
+			if last.Size == entry.Size() &&
+				last.Mode == entry.FileInfo.Mode() &&
+				last.SymlinkTarget == entry.SymlinkTarget &&
+				last.ContentMAC == contentMAC {
+				last.LastSnapshot = location.Snapshot.Identifier
+				last.LastSeen = location.Snapshot.Timestamp
+				last.Snapshots++
+				continue
+			}
+		}
+
+		versions = append(versions, Version{
+			Version:       len(versions) + 1,
+			FirstSnapshot: location.Snapshot.Identifier,
+			LastSnapshot:  location.Snapshot.Identifier,
+			FirstSeen:     location.Snapshot.Timestamp,
+			LastSeen:      location.Snapshot.Timestamp,
+			Snapshots:     1,
+			Size:          entry.Size(),
+			Mode:          entry.FileInfo.Mode(),
+			SymlinkTarget: entry.SymlinkTarget,
+			ContentMAC:    contentMAC,
+		})
+	}
+	return versions
+}
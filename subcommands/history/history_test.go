@@ -0,0 +1,116 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/PlakarKorp/integrations/fs/exporter"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+// generateHistory creates three snapshots where subdir/foo.txt changes
+// between the first and the second one.
+func generateHistory(t *testing.T) (*repository.Repository, *appcontext.AppContext) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	for _, content := range []string{"old foo", "new foo", "new foo"} {
+		snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+			ptesting.NewMockDir("subdir"),
+			ptesting.NewMockFile("subdir/foo.txt", 0644, content),
+		})
+		snap.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	return repo, ctx
+}
+
+func TestParseHistory(t *testing.T) {
+	_, ctx := generateHistory(t)
+
+	cmd := &History{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-name", "foo", "subdir/foo.txt/"}))
+	require.Equal(t, "/subdir/foo.txt", cmd.Pathname)
+	require.Equal(t, "foo", cmd.LocateOptions.Filters.Name)
+
+	require.Error(t, (&History{}).Parse(ctx, []string{}))
+	require.Error(t, (&History{}).Parse(ctx, []string{"/a", "/b"}))
+	require.Error(t, (&History{}).Parse(ctx, []string{"-to", "/tmp", "/a"}))
+	require.Error(t, (&History{}).Parse(ctx, []string{"-json", "-restore", "1", "/a"}))
+}
+
+func TestExecuteCmdHistory(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := generateHistory(t)
+	ctx.Stdout = bufOut
+
+	cmd := &History{}
+	require.NoError(t, cmd.Parse(ctx, []string{"/subdir/foo.txt"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// 1 3a1b2c3d 3a1b2c3d 2026-10-17T10:00:00Z 2026-10-17T10:00:00Z      7 B -rw-r--r-- 4f0e...
+	lines := strings.Split(strings.TrimSpace(bufOut.String()), "\n")
+	require.Len(t, lines, 2)
+	require.True(t, strings.HasPrefix(lines[0], "1 "))
+	require.True(t, strings.HasPrefix(lines[1], "2 "))
+	require.Contains(t, lines[0], "-rw-r--r--")
+
+	bufOut.Reset()
+	cmd = &History{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json", "/subdir/foo.txt"}))
+	_, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+
+	var versions []Version
+	require.NoError(t, json.Unmarshal(bufOut.Bytes(), &versions))
+	require.Len(t, versions, 2)
+	require.Equal(t, 1, versions[0].Snapshots)
+	require.Equal(t, 2, versions[1].Snapshots)
+	require.Equal(t, versions[0].FirstSnapshot, versions[0].LastSnapshot)
+	require.NotEqual(t, versions[1].FirstSnapshot, versions[1].LastSnapshot)
+	require.NotEqual(t, versions[0].ContentMAC, versions[1].ContentMAC)
+	require.Equal(t, int64(len("old foo")), versions[0].Size)
+}
+
+func TestExecuteCmdHistoryNotFound(t *testing.T) {
+	repo, ctx := generateHistory(t)
+
+	cmd := &History{}
+	require.NoError(t, cmd.Parse(ctx, []string{"/subdir/nope.txt"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "not found in any snapshot")
+	require.Equal(t, 1, status)
+
+	cmd = &History{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-restore", "3", "/subdir/foo.txt"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "no version 3")
+	require.Equal(t, 1, status)
+}
+
+func TestExecuteCmdHistoryRestore(t *testing.T) {
+	repo, ctx := generateHistory(t)
+
+	dir, err := os.MkdirTemp("", "tmp_to_restore")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cmd := &History{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-restore", "1", "-to", dir, "/subdir/foo.txt"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	data, err := os.ReadFile(filepath.Join(dir, "foo.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "old foo", string(data))
+}
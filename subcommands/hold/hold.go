@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package hold
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &HoldAdd{} }, 0, "hold", "add")
+	subcommands.Register(func() subcommands.Subcommand { return &HoldRm{} }, 0, "hold", "rm")
+	subcommands.Register(func() subcommands.Subcommand { return &HoldLs{} }, 0, "hold", "ls")
+	subcommands.Register(func() subcommands.Subcommand { return &Hold{} }, 0, "hold")
+}
+
+type Hold struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *Hold) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("hold", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s add | ls | rm\n", flags.Name())
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("invalid argument: %s", flags.Arg(0))
+	}
+	return fmt.Errorf("no action specified")
+}
+
+func (cmd *Hold) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	return 1, fmt.Errorf("no action specified")
+}
+
+type HoldAdd struct {
+	subcommands.SubcommandBase
+
+	Until     time.Time
+	Reason    string
+	Snapshots []string
+}
+
+func (cmd *HoldAdd) Parse(ctx *appcontext.AppContext, args []string) error {
+	var until string
+
+	flags := flag.NewFlagSet("hold add", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] SNAPSHOT...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&until, "until", "", "hold the snapshots until this date or for this duration")
+	flags.StringVar(&cmd.Reason, "reason", "", "record the reason of the hold")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no snapshot specified")
+	}
+
+	if until != "" {
+		now := time.Now()
+		t, err := utils.ParseUntilFlag(until, now)
+		if err != nil {
+			return fmt.Errorf("invalid -until %q: %w", until, err)
+		}
+		if !t.After(now) {
+			return fmt.Errorf("invalid -until %q: date is in the past", until)
+		}
+		cmd.Until = t
+	}
+
+	cmd.Snapshots = flags.Args()
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+// locateSnapshots resolves the snapshot identifiers given on the command
+// line.
+func locateSnapshots(repo *repository.Repository, ids []string) ([]objects.MAC, error) {
+	opts := locate.NewDefaultLocateOptions()
+	opts.Filters.IDs = ids
+
+	matches, err := locate.LocateSnapshotIDs(repo, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no snapshots matched the selection")
+	}
+	return matches, nil
+}
+
+func (cmd *HoldAdd) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	matches, err := locateSnapshots(repo, cmd.Snapshots)
+	if err != nil {
+		return 1, fmt.Errorf("hold: %w", err)
+	}
+
+	for _, snapshotID := range matches {
+		hold := &utils.Hold{
+			Snapshot:  snapshotID,
+			CreatedAt: time.Now(),
+			Until:     cmd.Until,
+			Reason:    cmd.Reason,
+		}
+		if err := utils.PutHold(repo, hold); err != nil {
+			return 1, fmt.Errorf("hold: %x: %w", snapshotID[:4], err)
+		}
+		ctx.GetLogger().Info("hold: %x %s", snapshotID[:4], hold)
+	}
+	return 0, nil
+}
+
+type HoldRm struct {
+	subcommands.SubcommandBase
+
+	Snapshots []string
+}
+
+func (cmd *HoldRm) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("hold rm", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s SNAPSHOT...\n", flags.Name())
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no snapshot specified")
+	}
+
+	cmd.Snapshots = flags.Args()
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+func (cmd *HoldRm) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	matches, err := locateSnapshots(repo, cmd.Snapshots)
+	if err != nil {
+		return 1, fmt.Errorf("hold: %w", err)
+	}
+
+	for _, snapshotID := range matches {
+		hold, err := utils.GetHold(repo, snapshotID)
+		if err != nil {
+			return 1, fmt.Errorf("hold: %x: %w", snapshotID[:4], err)
+		}
+		if hold == nil {
+			ctx.GetLogger().Warn("hold: %x is not held", snapshotID[:4])
+			continue
+		}
+		if err := utils.RemoveHold(repo, snapshotID); err != nil {
+			return 1, fmt.Errorf("hold: %x: %w", snapshotID[:4], err)
+		}
+		ctx.GetLogger().Info("hold: %x released", snapshotID[:4])
+	}
+	return 0, nil
+}
+
+type HoldLs struct {
+	subcommands.SubcommandBase
+}
+
+func (cmd *HoldLs) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("hold ls", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s\n", flags.Name())
+	}
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("invalid argument: %s", flags.Arg(0))
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+func (cmd *HoldLs) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	holds, err := utils.ListHolds(repo)
+	if err != nil {
+		return 1, fmt.Errorf("hold: %w", err)
+	}
+
+	now := time.Now()
+	for _, hold := range holds {
+		status := "active"
+		if !hold.Active(now) {
+			status = "expired"
+		}
+		until := "-"
+		if !hold.Until.IsZero() {
+			until = hold.Until.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(ctx.Stdout, "%s %x %-7s %-20s %s\n",
+			hold.CreatedAt.UTC().Format(time.RFC3339), hold.Snapshot[:4],
+			status, until, utils.SanitizeText(hold.Reason))
+	}
+	return 0, nil
+}
@@ -0,0 +1,152 @@
+package hold
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/versioning"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func generateSnapshot(t *testing.T, bufOut, bufErr *bytes.Buffer) (*repository.Repository, *appcontext.AppContext, *snapshot.Snapshot) {
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+	})
+	t.Cleanup(func() { snap.Close() })
+	return repo, ctx, snap
+}
+
+func TestHoldAddParse(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+
+	cmd := &HoldAdd{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-until", "1w", "-reason", "audit", "abcd"}))
+	require.Equal(t, "audit", cmd.Reason)
+	require.Equal(t, []string{"abcd"}, cmd.Snapshots)
+	require.WithinDuration(t, time.Now().Add(7*24*time.Hour), cmd.Until, time.Minute)
+
+	cmd = &HoldAdd{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-until", "2020-01-01", "abcd"}), "in the past")
+	cmd = &HoldAdd{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{}), "no snapshot specified")
+}
+
+func TestHoldAddLsRm(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx, snap := generateSnapshot(t, bufOut, bufErr)
+	id := fmt.Sprintf("%x", snap.Header.GetIndexID())
+
+	hold, err := utils.GetHold(repo, snap.Header.Identifier)
+	require.NoError(t, err)
+	require.Nil(t, hold)
+
+	add := &HoldAdd{}
+	require.NoError(t, add.Parse(ctx, []string{"-reason", "legal case 42", id[:8]}))
+	status, err := add.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	hold, err = utils.GetHold(repo, snap.Header.Identifier)
+	require.NoError(t, err)
+	require.NotNil(t, hold)
+	require.Equal(t, "legal case 42", hold.Reason)
+	require.True(t, hold.Until.IsZero())
+	active, err := utils.ActiveHold(repo, snap.Header.Identifier, time.Now())
+	require.NoError(t, err)
+	require.Equal(t, hold, active)
+
+	// adding again replaces the hold
+	add = &HoldAdd{}
+	require.NoError(t, add.Parse(ctx, []string{"-until", "1d", id}))
+	_, err = add.Execute(ctx, repo)
+	require.NoError(t, err)
+	hold, err = utils.GetHold(repo, snap.Header.Identifier)
+	require.NoError(t, err)
+	require.Empty(t, hold.Reason)
+	require.False(t, hold.Until.IsZero())
+	active, err = utils.ActiveHold(repo, snap.Header.Identifier, time.Now().Add(48*time.Hour))
+	require.NoError(t, err)
+	require.Nil(t, active)
+
+	bufOut.Reset()
+	ls := &HoldLs{}
+	require.NoError(t, ls.Parse(ctx, []string{}))
+	_, err = ls.Execute(ctx, repo)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(bufOut.String()), "\n")
+	require.Len(t, lines, 1)
+	require.Contains(t, lines[0], id[:8]+" active")
+
+	rm := &HoldRm{}
+	require.NoError(t, rm.Parse(ctx, []string{id}))
+	_, err = rm.Execute(ctx, repo)
+	require.NoError(t, err)
+
+	hold, err = utils.GetHold(repo, snap.Header.Identifier)
+	require.NoError(t, err)
+	require.Nil(t, hold)
+
+	holds, err := utils.ListHolds(repo)
+	require.NoError(t, err)
+	require.Empty(t, holds)
+
+	// releasing a snapshot that is not held only warns
+	rm = &HoldRm{}
+	require.NoError(t, rm.Parse(ctx, []string{id}))
+	_, err = rm.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Contains(t, bufErr.String(), "is not held")
+}
+
+func TestHoldAddUnknownSnapshot(t *testing.T) {
+	repo, ctx, _ := generateSnapshot(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+
+	add := &HoldAdd{}
+	require.NoError(t, add.Parse(ctx, []string{"ffffffff"}))
+	status, err := add.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+}
+
+func TestHoldVersion(t *testing.T) {
+	repo, _, snap := generateSnapshot(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+	snapshotID := snap.Header.Identifier
+
+	require.NoError(t, utils.PutHold(repo, &utils.Hold{Snapshot: snapshotID, CreatedAt: time.Now()}))
+	hold, err := utils.GetHold(repo, snapshotID)
+	require.NoError(t, err)
+	require.Equal(t, versioning.FromString(utils.HOLD_VERSION), hold.Version)
+	require.NoError(t, utils.RemoveHold(repo, snapshotID))
+
+	// records written by a newer version are not interpreted
+	data, err := json.Marshal(&utils.Hold{
+		Version:  versioning.NewVersion(2, 0, 0),
+		Snapshot: snapshotID,
+	})
+	require.NoError(t, err)
+
+	stateID := objects.RandomMAC()
+	sc, err := repo.AppContext().GetCache().Scan(stateID)
+	require.NoError(t, err)
+	repoWriter := repo.NewRepositoryWriter(sc, stateID, repository.DefaultType, "")
+	require.NoError(t, repoWriter.PutBlob(utils.RT_HOLD, utils.HoldMAC(repo, snapshotID), data, false))
+	repoWriter.PackerManager.Wait()
+	require.NoError(t, repoWriter.CommitTransaction(stateID))
+	require.NoError(t, repo.RebuildState())
+
+	_, err = utils.GetHold(repo, snapshotID)
+	require.ErrorContains(t, err, "is newer than current version")
+}
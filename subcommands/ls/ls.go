@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io/fs"
 	"os/user"
+	"sort"
 	"strings"
 	"time"
 
@@ -53,6 +54,7 @@ func (cmd *Ls) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.BoolVar(&cmd.DisplayUUID, "uuid", false, "display uuid instead of short ID")
 	flags.BoolVar(&cmd.Recursive, "recursive", false, "recursive listing")
 	flags.BoolVar(&cmd.ShowTags, "tags", false, "show tags")
+	flags.BoolVar(&cmd.Deleted, "deleted", false, "list deleted snapshots that can still be restored")
 
 	cmd.LocateOptions.InstallLocateFlags(flags)
 
@@ -61,6 +63,9 @@ func (cmd *Ls) Parse(ctx *appcontext.AppContext, args []string) error {
 	switch flags.NArg() {
 	case 0: // nothing
 	case 1:
+		if cmd.Deleted {
+			return fmt.Errorf("-deleted does not take a snapshot argument")
+		}
 		cmd.Path = []string{flags.Arg(0)}
 	default:
 		return fmt.Errorf("too many arguments")
@@ -77,11 +82,19 @@ type Ls struct {
 	Recursive     bool
 	DisplayUUID   bool
 	Path          []string
+	Deleted       bool
 
 	ShowTags bool
 }
 
 func (cmd *Ls) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	if cmd.Deleted {
+		if err := cmd.list_deleted_snapshots(ctx, repo); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
 	if len(cmd.Path) == 0 {
 		if err := cmd.list_snapshots(ctx, repo); err != nil {
 			return 1, err
@@ -107,38 +120,68 @@ func (cmd *Ls) list_snapshots(ctx *appcontext.AppContext, repo *repository.Repos
 			return fmt.Errorf("ls: could not fetch snapshot: %w", err)
 		}
 
-		tags := ""
-		if cmd.ShowTags && len(snap.Header.Tags) > 0 {
-			tagList := strings.Join(snap.Header.Tags, ",")
-			if tagList != "" {
-				tags = " tags=" + strings.Join(snap.Header.Tags, ",")
-			}
-		}
+		cmd.print_snapshot(ctx, snap, "")
+		snap.Close()
+	}
+	return nil
+}
 
-		if !cmd.DisplayUUID {
-			fmt.Fprintf(ctx.Stdout, "%s %10s%10s%10s %s%s\n",
-				snap.Header.Timestamp.UTC().Format(time.RFC3339),
-				hex.EncodeToString(snap.Header.GetIndexShortID()),
-				humanize.IBytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
-				snap.Header.Duration.Round(time.Second),
-				utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory),
-				tags)
-		} else {
-			indexID := snap.Header.GetIndexID()
-			fmt.Fprintf(ctx.Stdout, "%s %3s%10s%10s %s%s\n",
-				snap.Header.Timestamp.UTC().Format(time.RFC3339),
-				hex.EncodeToString(indexID[:]),
-				humanize.IBytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
-				snap.Header.Duration.Round(time.Second),
-				utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory),
-				tags)
-		}
+func (cmd *Ls) list_deleted_snapshots(ctx *appcontext.AppContext, repo *repository.Repository) error {
+	type deleted struct {
+		id objects.MAC
+		at time.Time
+	}
+
+	snapshots := make([]deleted, 0)
+	for snapshotID, deletedAt := range repo.ListDeletedSnapShots() {
+		snapshots = append(snapshots, deleted{id: snapshotID, at: deletedAt})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].at.Before(snapshots[j].at)
+	})
 
+	for _, d := range snapshots {
+		// maintenance may already have reclaimed the data of the snapshot
+		snap, err := snapshot.Load(repo, d.id)
+		if err != nil {
+			ctx.GetLogger().Warn("ls: %x: snapshot can no longer be restored: %s", d.id[:4], err)
+			continue
+		}
+		cmd.print_snapshot(ctx, snap, " deleted="+d.at.UTC().Format(time.RFC3339))
 		snap.Close()
 	}
 	return nil
 }
 
+func (cmd *Ls) print_snapshot(ctx *appcontext.AppContext, snap *snapshot.Snapshot, suffix string) {
+	tags := ""
+	if cmd.ShowTags && len(snap.Header.Tags) > 0 {
+		tagList := strings.Join(snap.Header.Tags, ",")
+		if tagList != "" {
+			tags = " tags=" + strings.Join(snap.Header.Tags, ",")
+		}
+	}
+
+	if !cmd.DisplayUUID {
+		fmt.Fprintf(ctx.Stdout, "%s %10s%10s%10s %s%s\n",
+			snap.Header.Timestamp.UTC().Format(time.RFC3339),
+			hex.EncodeToString(snap.Header.GetIndexShortID()),
+			humanize.IBytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
+			snap.Header.Duration.Round(time.Second),
+			utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory),
+			tags+suffix)
+	} else {
+		indexID := snap.Header.GetIndexID()
+		fmt.Fprintf(ctx.Stdout, "%s %3s%10s%10s %s%s\n",
+			snap.Header.Timestamp.UTC().Format(time.RFC3339),
+			hex.EncodeToString(indexID[:]),
+			humanize.IBytes(snap.Header.GetSource(0).Summary.Directory.Size+snap.Header.GetSource(0).Summary.Below.Size),
+			snap.Header.Duration.Round(time.Second),
+			utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory),
+			tags+suffix)
+	}
+}
+
 func (cmd *Ls) list_snapshot(ctx *appcontext.AppContext, repo *repository.Repository, snapshotPath string, recursive bool) error {
 	snap, pathname, err := locate.OpenSnapshotByPath(repo, snapshotPath)
 	if err != nil {
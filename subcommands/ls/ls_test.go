@@ -209,3 +209,35 @@ func TestExecuteCmdLsFilterUuid(t *testing.T) {
 	require.Equal(t, hex.EncodeToString(indexId[:]), fields[1])
 	require.Equal(t, snap.Header.GetSource(0).Importer.Directory, fields[len(fields)-1])
 }
+
+func TestLsDeleted(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bytes.NewBuffer(nil), nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+	})
+	defer snap.Close()
+
+	cmd := &Ls{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-deleted", "abcd"}), "does not take a snapshot")
+
+	cmd = &Ls{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-deleted"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Empty(t, bufOut.String())
+
+	require.NoError(t, repo.DeleteSnapshot(snap.Header.Identifier))
+	require.NoError(t, repo.RebuildState())
+
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	lines := strings.Split(strings.Trim(bufOut.String(), "\n"), "\n")
+	require.Len(t, lines, 1)
+	require.Contains(t, lines[0], hex.EncodeToString(snap.Header.GetIndexShortID()))
+	require.Contains(t, lines[0], " deleted=")
+}
@@ -21,32 +21,98 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/PlakarKorp/go-human2duration"
 	"github.com/PlakarKorp/kloset/caching"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/repository/state"
 	"github.com/PlakarKorp/kloset/resources"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/dustin/go-humanize"
 	"golang.org/x/sync/errgroup"
 )
 
 const defaultDuration = 7 * 24 * time.Hour
 
+// maxPinnedReport is the number of snapshots listed by a dry run.
+const maxPinnedReport = 5
+
 func init() {
 	subcommands.Register(func() subcommands.Subcommand { return &Maintenance{} }, 0, "maintenance")
 }
 
 func (cmd *Maintenance) Parse(ctx *appcontext.AppContext, args []string) error {
+	var grace, recovery, repack, repackBudget string
+
 	flags := flag.NewFlagSet("maintenance", flag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Fprintf(flags.Output(), "Usage: %s\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
 	}
+	flags.BoolVar(&cmd.DryRun, "dry-run", false, "report what would be removed without modifying the repository")
+	flags.StringVar(&grace, "grace", "", "delay the removal of unused packfiles by this duration (default 7d)")
+	flags.StringVar(&recovery, "recovery", "", "keep deleted snapshots restorable for this duration (default 7d)")
+	flags.StringVar(&repack, "repack", "", "rewrite packfiles whose ratio of live data is below this threshold (e.g. 0.3 or 30%)")
+	flags.StringVar(&repackBudget, "repack-budget", "", "maximum amount of live data rewritten by a repack run (default unlimited)")
 	flags.Parse(args)
 
+	if grace != "" {
+		duration, err := human2duration.ParseDuration(grace)
+		if err != nil {
+			return fmt.Errorf("invalid grace period %q: %w", grace, err)
+		}
+		if duration <= 0 {
+			return fmt.Errorf("invalid grace period %q: must be positive", grace)
+		}
+		cmd.Grace = duration
+	}
+
+	// Like the grace period, the recovery period can be set in the
+	// environment until it becomes configurable per repository.
+	if recovery == "" {
+		recovery = os.Getenv("PLAKAR_RECOVERYPERIOD")
+	}
+
+	duration := defaultDuration
+	if recovery != "" {
+		var err error
+		duration, err = human2duration.ParseDuration(recovery)
+		if err != nil {
+			return fmt.Errorf("invalid recovery period %q: %w", recovery, err)
+		}
+		if duration < 0 {
+			return fmt.Errorf("invalid recovery period %q: negative duration", recovery)
+		}
+	}
+	cmd.Recovery = duration
+
+	if repack != "" {
+		threshold, err := parseRepackThreshold(repack)
+		if err != nil {
+			return fmt.Errorf("invalid repack threshold %q: %w", repack, err)
+		}
+		cmd.Repack = threshold
+	}
+
+	if repackBudget != "" {
+		if repack == "" {
+			return fmt.Errorf("-repack-budget requires -repack")
+		}
+		budget, err := humanize.ParseBytes(repackBudget)
+		if err != nil {
+			return fmt.Errorf("invalid repack budget %q: %w", repackBudget, err)
+		}
+		cmd.RepackBudget = budget
+	}
+
 	cmd.RepositorySecret = ctx.GetSecret()
 
 	return nil
@@ -55,9 +121,28 @@ func (cmd *Maintenance) Parse(ctx *appcontext.AppContext, args []string) error {
 type Maintenance struct {
 	subcommands.SubcommandBase
 
-	repository    *repository.Repository
-	maintenanceID objects.MAC
-	cutoff        time.Time
+	// Grace overrides the delay between the colouring of a packfile and
+	// its removal when set.
+	Grace time.Duration
+
+	// Recovery is the period during which deleted snapshots can be
+	// restored with undelete, their data is not reclaimed before.
+	Recovery time.Duration
+
+	// Repack is the ratio of live data under which packfiles are
+	// rewritten, zero disables repacking.
+	Repack float64
+
+	// RepackBudget bounds the live data rewritten by a run, zero means
+	// unlimited.
+	RepackBudget uint64
+
+	DryRun bool
+
+	repository     *repository.Repository
+	maintenanceID  objects.MAC
+	cutoff         time.Time
+	recoveryCutoff time.Time
 }
 
 // Builds the local cache of snapshot -> packfiles
@@ -70,69 +155,163 @@ func (cmd *Maintenance) updateCache(ctx *appcontext.AppContext, cache *caching.M
 			return err
 		}
 		wg.Go(func() error {
-			snapshot, err := snapshot.Load(cmd.repository, snapshotID)
-			if err != nil {
+			return cmd.cacheSnapshot(ctx, cache, snapshotID)
+		})
+	}
+
+	if err := wg.Wait(); err != nil {
+		return err
+	}
+
+	for snapshotID, err := range cmd.repository.ListSnapshots() {
+		if err != nil {
+			return err
+		}
+		if err := cmd.cacheHold(cache, snapshotID); err != nil {
+			return err
+		}
+	}
+
+	// While ListSnapshots doesn't return deleted snapshots, we still need to
+	// go over them to remove previously added one to our local cache.
+	now := time.Now()
+	for snapshotID, deletedAt := range cmd.repository.ListDeletedSnapShots() {
+		// Snapshots deleted within the recovery period, and held snapshots
+		// removed by a client unaware of the hold, must remain restorable:
+		// keep their packfiles around.
+		keep, err := cmd.keepDeleted(snapshotID, deletedAt, now)
+		if err != nil {
+			return err
+		}
+		if keep {
+			if err := cmd.cacheSnapshot(ctx, cache, snapshotID); err != nil {
 				return err
 			}
-			defer snapshot.Close()
-
-			ok, err := cache.HasSnapshot(snapshotID)
-			if err != nil {
+			if err := cmd.cacheHold(cache, snapshotID); err != nil {
 				return err
 			}
+			continue
+		}
 
-			if ok {
-				return nil
-			}
+		if err := cmd.uncache(cache, utils.HoldMAC(cmd.repository, snapshotID)); err != nil {
+			return err
+		}
+		if err := cmd.uncache(cache, snapshotID); err != nil {
+			return err
+		}
+	}
 
-			iter, err := snapshot.ListPackfiles()
-			if err != nil {
-				return err
-			}
+	return nil
+}
 
-			for packfile, err := range iter {
-				if err != nil {
-					return err
-				}
+// keepDeleted reports whether the data of a deleted snapshot must be kept,
+// because it is still within the recovery period or it is held.
+func (cmd *Maintenance) keepDeleted(snapshotID objects.MAC, deletedAt, now time.Time) (bool, error) {
+	if deletedAt.After(cmd.recoveryCutoff) {
+		return true, nil
+	}
+	hold, err := utils.ActiveHold(cmd.repository, snapshotID, now)
+	if err != nil {
+		return false, err
+	}
+	return hold != nil, nil
+}
 
-				if err := ctx.Err(); err != nil {
-					return err
-				}
+func (cmd *Maintenance) cacheSnapshot(ctx *appcontext.AppContext, cache *caching.MaintenanceCache, snapshotID objects.MAC) error {
+	ok, err := cache.HasSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
 
-				if err := cache.PutPackfile(snapshotID, packfile); err != nil {
-					return err
-				}
-			}
+	if ok {
+		return nil
+	}
 
-			cache.PutSnapshot(snapshotID, nil)
-			return nil
-		})
+	snapshot, err := snapshot.Load(cmd.repository, snapshotID)
+	if err != nil {
+		return err
 	}
+	defer snapshot.Close()
 
-	if err := wg.Wait(); err != nil {
+	iter, err := snapshot.ListPackfiles()
+	if err != nil {
 		return err
 	}
 
-	// While ListSnapshots doesn't return deleted snapshots, we still need to
-	// go over them to remove previously added one to our local cache.
-	for snapshotID := range cmd.repository.ListDeletedSnapShots() {
-		ok, err := cache.HasSnapshot(snapshotID)
+	for packfile, err := range iter {
 		if err != nil {
 			return err
 		}
 
-		if !ok {
-			continue
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		cache.DeleletePackfiles(snapshotID)
-		cache.DeleteSnapshot(snapshotID)
+		if err := cache.PutPackfile(snapshotID, packfile); err != nil {
+			return err
+		}
+	}
+
+	return cache.PutSnapshot(snapshotID, nil)
+}
+
+// The hold records of snapshots live in their own packfiles, they are
+// tracked in the cache under the MAC of their blob as if they were
+// snapshots.  A hold may have been replaced since the last run, so its
+// entry is always rebuilt.
+func (cmd *Maintenance) cacheHold(cache *caching.MaintenanceCache, snapshotID objects.MAC) error {
+	holdMAC := utils.HoldMAC(cmd.repository, snapshotID)
+	if err := cmd.uncache(cache, holdMAC); err != nil {
+		return err
+	}
+
+	packfileMAC, exists, err := cmd.repository.GetPackfileForBlob(utils.RT_HOLD, holdMAC)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
 	}
 
+	if err := cache.PutPackfile(holdMAC, packfileMAC); err != nil {
+		return err
+	}
+	return cache.PutSnapshot(holdMAC, nil)
+}
+
+func (cmd *Maintenance) uncache(cache *caching.MaintenanceCache, snapshotID objects.MAC) error {
+	ok, err := cache.HasSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return nil
+	}
+
+	cache.DeleletePackfiles(snapshotID)
+	cache.DeleteSnapshot(snapshotID)
 	return nil
 }
 
-func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.MaintenanceCache) error {
+// gracePeriod returns the delay between the colouring of a packfile and
+// its removal.
+func (cmd *Maintenance) gracePeriod() time.Duration {
+	if cmd.Grace != 0 {
+		return cmd.Grace
+	}
+
+	// This need to be configurable per repo, but we don't have a mechanism yet (comes in a PR soon!)
+	duration, err := time.ParseDuration(os.Getenv("PLAKAR_GRACEPERIOD"))
+	if err != nil {
+		duration = defaultDuration
+	}
+	return duration
+}
+
+// colourCandidates returns the packfiles the colouring pass flags for
+// deletion, the orphaned ones among them and the size of their blobs.
+func (cmd *Maintenance) colourCandidates(cache *caching.MaintenanceCache) ([]objects.MAC, []objects.MAC, uint64, error) {
 	var packfiles = make(map[objects.MAC]struct{})
 	for packfileMAC := range cmd.repository.ListPackfiles() {
 		packfiles[packfileMAC] = struct{}{}
@@ -142,10 +321,11 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 	// identify orphaned packfiles (eg. from an aborted backup)
 	repoPackfiles, err := cmd.repository.GetPackfiles()
 	if err != nil {
-		return err
+		return nil, nil, 0, err
 	}
 
-	orphanedPackfiles := 0
+	orphaned := make([]objects.MAC, 0)
+	var orphanedSize uint64
 	for _, packfileMAC := range repoPackfiles {
 		_, ok := packfiles[packfileMAC]
 		if ok {
@@ -156,7 +336,7 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 		// packfile once again
 		has, err := cmd.repository.HasDeletedPackfile(packfileMAC)
 		if err != nil {
-			return err
+			return nil, nil, 0, err
 		}
 
 		if has {
@@ -170,29 +350,20 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 		// hopefully those are rare enough that it's not a problem in practice.
 		packfile, err := cmd.repository.GetPackfile(packfileMAC)
 		if err != nil {
-			return err
+			return nil, nil, 0, err
 		}
 
 		packfileDate := time.Unix(0, packfile.Footer.Timestamp)
 		if packfileDate.Before(cmd.cutoff) {
-			orphanedPackfiles++
+			orphaned = append(orphaned, packfileMAC)
 			packfiles[packfileMAC] = struct{}{}
+			for _, blob := range packfile.Index {
+				orphanedSize += uint64(blob.Length)
+			}
 		}
 	}
 
-	stateID := objects.RandomMAC()
-	sc, err := cmd.repository.AppContext().GetCache().Scan(stateID)
-	if err != nil {
-		return err
-	}
-
-	// First pass, coloring, we just flag those packfiles as being selected for deletion.
-	// For now we keep the same serial so that those delete gets merged in.
-	// Once we do the real deletion we will rebuild the aggregated view
-	// excluding those resources alltogether.
-	repoWriter := cmd.repository.NewRepositoryWriter(sc, stateID, repository.DefaultType, "")
-
-	coloredPackfiles := 0
+	candidates := make([]objects.MAC, 0)
 	for packfile := range packfiles {
 		if cache.HasPackfile(packfile) {
 			continue
@@ -200,38 +371,45 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 
 		has, err := cmd.repository.HasDeletedPackfile(packfile)
 		if err != nil {
-			return err
+			return nil, nil, 0, err
 		}
 
 		if !has {
-			coloredPackfiles++
-			if err := repoWriter.DeleteStateResource(resources.RT_PACKFILE, packfile); err != nil {
-				return err
-			}
+			candidates = append(candidates, packfile)
 		}
 	}
 
-	fmt.Fprintf(ctx.Stdout, "maintenance: Coloured %d packfiles (%d orphaned) for deletion\n", coloredPackfiles, orphanedPackfiles)
+	return candidates, orphaned, orphanedSize, nil
+}
 
-	if coloredPackfiles > 0 {
-		duration, err := time.ParseDuration(os.Getenv("PLAKAR_GRACEPERIOD"))
-		if err != nil {
-			duration = defaultDuration
-		}
+func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.MaintenanceCache) error {
+	candidates, orphaned, _, err := cmd.colourCandidates(cache)
+	if err != nil {
+		return err
+	}
 
-		humanDuration := duration.String()
-		if duration.Hours() > 24 {
-			days := duration / (24 * time.Hour)
-			left := duration - (days * 24 * time.Hour)
+	stateID := objects.RandomMAC()
+	sc, err := cmd.repository.AppContext().GetCache().Scan(stateID)
+	if err != nil {
+		return err
+	}
 
-			humanDuration = fmt.Sprintf("%dd", days)
+	// First pass, coloring, we just flag those packfiles as being selected for deletion.
+	// For now we keep the same serial so that those delete gets merged in.
+	// Once we do the real deletion we will rebuild the aggregated view
+	// excluding those resources alltogether.
+	repoWriter := cmd.repository.NewRepositoryWriter(sc, stateID, repository.DefaultType, "")
 
-			if left != 0 {
-				humanDuration += left.String()
-			}
+	for _, packfile := range candidates {
+		if err := repoWriter.DeleteStateResource(resources.RT_PACKFILE, packfile); err != nil {
+			return err
 		}
+	}
 
-		fmt.Fprintf(ctx.Stdout, "Coloured packfiles are scheduled to be removed in %s\n", humanDuration)
+	fmt.Fprintf(ctx.Stdout, "maintenance: Coloured %d packfiles (%d orphaned) for deletion\n", len(candidates), len(orphaned))
+
+	if len(candidates) > 0 {
+		fmt.Fprintf(ctx.Stdout, "Coloured packfiles are scheduled to be removed in %s\n", humanDuration(cmd.gracePeriod()))
 
 		if err := repoWriter.CommitTransaction(stateID); err != nil {
 			return err
@@ -241,6 +419,21 @@ func (cmd *Maintenance) colourPass(ctx *appcontext.AppContext, cache *caching.Ma
 	return nil
 }
 
+func humanDuration(duration time.Duration) string {
+	humanDuration := duration.String()
+	if duration.Hours() > 24 {
+		days := duration / (24 * time.Hour)
+		left := duration - (days * 24 * time.Hour)
+
+		humanDuration = fmt.Sprintf("%dd", days)
+
+		if left != 0 {
+			humanDuration += left.String()
+		}
+	}
+	return humanDuration
+}
+
 func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.MaintenanceCache) error {
 	noDeletion, _ := strconv.ParseBool(os.Getenv("PLAKAR_NODELETION"))
 
@@ -318,6 +511,169 @@ func (cmd *Maintenance) sweepPass(ctx *appcontext.AppContext, cache *caching.Mai
 	return nil
 }
 
+// packfileSizes returns the size of the blobs of each packfile as
+// recorded in the states of the repository, so that the packfiles
+// themselves don't have to be fetched. The states are merged into the
+// local repository cache, only those not merged by a previous run are
+// fetched.
+func (cmd *Maintenance) packfileSizes(ctx *appcontext.AppContext) (map[objects.MAC]uint64, error) {
+	cache, err := ctx.GetCache().Repository(cmd.repository.Configuration().RepositoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	ls, err := state.NewLocalState(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	stateIDs, err := cmd.repository.GetStates()
+	if err != nil {
+		return nil, err
+	}
+	for _, stateID := range stateIDs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		has, err := ls.HasState(stateID)
+		if err != nil {
+			return nil, err
+		}
+		if has {
+			continue
+		}
+
+		rd, version, err := cmd.repository.GetState(stateID)
+		if err != nil {
+			return nil, fmt.Errorf("state %x: %w", stateID, err)
+		}
+		err = ls.MergeState(stateID, rd, version)
+		rd.Close()
+		if err != nil {
+			return nil, fmt.Errorf("state %x: %w", stateID, err)
+		}
+	}
+
+	sizes := make(map[objects.MAC]uint64)
+	for _, buf := range cache.GetDeltas() {
+		de, err := state.DeltaEntryFromBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		sizes[de.Location.Packfile] += uint64(de.Location.Length)
+	}
+	return sizes, nil
+}
+
+func packfilesSize(sizes map[objects.MAC]uint64, packfiles []objects.MAC) uint64 {
+	var size uint64
+	for _, packfileMAC := range packfiles {
+		size += sizes[packfileMAC]
+	}
+	return size
+}
+
+// dryRun reports what the colouring, sweeping and repacking passes would
+// do, and the snapshots pinning the most data, without modifying the
+// repository.
+func (cmd *Maintenance) dryRun(ctx *appcontext.AppContext, cache *caching.MaintenanceCache) error {
+	candidates, orphaned, orphanedSize, err := cmd.colourCandidates(cache)
+	if err != nil {
+		return err
+	}
+
+	sizes, err := cmd.packfileSizes(ctx)
+	if err != nil {
+		return err
+	}
+	// orphaned packfiles are not in the states
+	candidatesSize := packfilesSize(sizes, candidates) + orphanedSize
+
+	fmt.Fprintf(ctx.Stdout, "maintenance: Would colour %d packfiles (%s) for deletion, %d orphaned (%s)\n",
+		len(candidates), humanize.IBytes(candidatesSize), len(orphaned), humanize.IBytes(orphanedSize))
+
+	swept := make([]objects.MAC, 0)
+	for packfileMAC, deletionTime := range cmd.repository.ListColouredPackfiles() {
+		if deletionTime.After(cmd.cutoff) || cache.HasPackfile(packfileMAC) {
+			continue
+		}
+		swept = append(swept, packfileMAC)
+	}
+
+	sweptSize := packfilesSize(sizes, swept)
+
+	fmt.Fprintf(ctx.Stdout, "maintenance: Would delete %d packfiles (%s) coloured more than %s ago\n",
+		len(swept), humanize.IBytes(sweptSize), humanDuration(cmd.gracePeriod()))
+
+	if cmd.Repack > 0 {
+		candidates, deferred, err := cmd.repackCandidates(ctx, cache)
+		if err != nil {
+			return err
+		}
+		live, reclaimed := repackTotals(candidates)
+		fmt.Fprintf(ctx.Stdout, "maintenance: Would repack %d packfiles, rewriting %s of live data to reclaim %s\n",
+			len(candidates), humanize.IBytes(live), humanize.IBytes(reclaimed))
+		if len(deferred) > 0 {
+			live, _ := repackTotals(deferred)
+			fmt.Fprintf(ctx.Stdout, "maintenance: %d packfiles (%s of live data) left to repack in a later run\n",
+				len(deferred), humanize.IBytes(live))
+		}
+	}
+
+	return cmd.reportPinned(ctx)
+}
+
+// reportPinned lists the snapshots whose removal would reclaim the most
+// data.
+func (cmd *Maintenance) reportPinned(ctx *appcontext.AppContext) error {
+	footprint, err := utils.NewFootprint(ctx, cmd.repository)
+	if err != nil {
+		return err
+	}
+
+	type pinned struct {
+		id    objects.MAC
+		bytes uint64
+	}
+
+	snapshots := make([]pinned, 0)
+	for snapshotID, err := range cmd.repository.ListSnapshots() {
+		if err != nil {
+			return err
+		}
+		if n := footprint.Exclusive(snapshotID); n != 0 {
+			snapshots = append(snapshots, pinned{id: snapshotID, bytes: n})
+		}
+	}
+	if len(snapshots) == 0 {
+		return nil
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].bytes != snapshots[j].bytes {
+			return snapshots[i].bytes > snapshots[j].bytes
+		}
+		return bytes.Compare(snapshots[i].id[:], snapshots[j].id[:]) < 0
+	})
+	snapshots = snapshots[:min(len(snapshots), maxPinnedReport)]
+
+	fmt.Fprintf(ctx.Stdout, "maintenance: Snapshots pinning the most data:\n")
+	for _, p := range snapshots {
+		snap, err := snapshot.Load(cmd.repository, p.id)
+		if err != nil {
+			return fmt.Errorf("%x: %w", p.id[:4], err)
+		}
+		fmt.Fprintf(ctx.Stdout, "%s %x %10s %s\n",
+			snap.Header.Timestamp.UTC().Format(time.RFC3339),
+			snap.Header.GetIndexShortID(),
+			humanize.IBytes(p.bytes),
+			utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory))
+		snap.Close()
+	}
+	return nil
+}
+
 func (cmd *Maintenance) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
 	// the maintenance algorithm is a bit tricky and needs to be done in the correct sequence,
 	// here's what it has to do:
@@ -332,20 +688,19 @@ func (cmd *Maintenance) Execute(ctx *appcontext.AppContext, repo *repository.Rep
 
 	cmd.repository = repo
 
-	// This need to be configurable per repo, but we don't have a mechanism yet (comes in a PR soon!)
-	duration, err := time.ParseDuration(os.Getenv("PLAKAR_GRACEPERIOD"))
-	if err != nil {
-		duration = defaultDuration
-	}
-
-	cmd.cutoff = time.Now().Add(-duration)
+	cmd.cutoff = time.Now().Add(-cmd.gracePeriod())
+	cmd.recoveryCutoff = time.Now().Add(-cmd.Recovery)
 
-	cmd.maintenanceID = objects.RandomMAC()
-	done, err := cmd.Lock()
-	if err != nil {
-		return 1, err
+	// a dry run doesn't modify the repository, it must not prevent backups
+	// from running meanwhile.
+	if !cmd.DryRun {
+		cmd.maintenanceID = objects.RandomMAC()
+		done, err := cmd.Lock()
+		if err != nil {
+			return 1, err
+		}
+		defer cmd.Unlock(done)
 	}
-	defer cmd.Unlock(done)
 
 	cache, err := repo.AppContext().GetCache().Maintenance(repo.Configuration().RepositoryID)
 	if err != nil {
@@ -358,6 +713,14 @@ func (cmd *Maintenance) Execute(ctx *appcontext.AppContext, repo *repository.Rep
 		return 1, err
 	}
 
+	if cmd.DryRun {
+		if err := cmd.dryRun(ctx, cache); err != nil {
+			fmt.Fprintf(ctx.Stderr, "maintenance: Dry run failed %s\n", err)
+			return 1, err
+		}
+		return 0, nil
+	}
+
 	if err := cmd.colourPass(ctx, cache); err != nil {
 		fmt.Fprintf(ctx.Stderr, "maintenance: Colouring pass failed %s\n", err)
 		return 1, err
@@ -368,6 +731,13 @@ func (cmd *Maintenance) Execute(ctx *appcontext.AppContext, repo *repository.Rep
 		return 1, err
 	}
 
+	if cmd.Repack > 0 {
+		if err := cmd.repackPass(ctx, cache); err != nil {
+			fmt.Fprintf(ctx.Stderr, "maintenance: Repack pass failed %s\n", err)
+			return 1, err
+		}
+	}
+
 	return 0, nil
 }
 
@@ -2,18 +2,23 @@ package maintenance
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"path"
 	"strings"
 	"testing"
 	"time"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
+	"github.com/PlakarKorp/kloset/connectors"
 	"github.com/PlakarKorp/kloset/connectors/storage"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
 	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -30,6 +35,8 @@ func resetEnv(t *testing.T) {
 	t.Setenv("PLAKAR_GRACEPERIOD", "")
 	t.Setenv("PLAKAR_NODELETION", "")
 	t.Setenv("PLAKAR_LOCKLESS", "")
+	// reclaim deleted snapshots right away unless a test opts in
+	t.Setenv("PLAKAR_RECOVERYPERIOD", "0s")
 }
 
 // freshRepo builds a fresh fs-backed repository with cleared env. Returns
@@ -292,6 +299,11 @@ func TestExecuteIdempotent(t *testing.T) {
 func primeAndDelete(t *testing.T, ctx *appcontext.AppContext, repo *repository.Repository,
 	bufOut, bufErr *bytes.Buffer, snapID objects.MAC) {
 	t.Helper()
+	// the backups release their lock asynchronously as well
+	require.Eventually(t, func() bool {
+		locks, lerr := repo.GetLocks()
+		return lerr == nil && len(locks) == 0
+	}, 2*time.Second, 10*time.Millisecond)
 	status, err, _, _ := runMaintenance(t, ctx, repo, bufOut, bufErr)
 	require.NoError(t, err, "priming maintenance run must succeed")
 	require.Equal(t, 0, status)
@@ -878,3 +890,334 @@ func TestNoStrayMessagesWhenNothingToDo(t *testing.T) {
 	require.NotContains(t, errOut, "Concurrent backup",
 		"no concurrent-backup warning when nothing was coloured")
 }
+
+// --- Holds -----------------------------------------------------------------
+
+func TestHoldRecordSurvivesMaintenance(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap1"))
+	require.NoError(t, utils.PutHold(repo, &utils.Hold{
+		Snapshot:  snap.Header.Identifier,
+		CreatedAt: time.Now(),
+	}))
+
+	t.Setenv("PLAKAR_GRACEPERIOD", "1ns")
+	out := colourRunAndRebuild(t, ctx, repo, bufOut, bufErr)
+	require.Contains(t, out, "Coloured 0 packfiles (0 orphaned)")
+	colourRunAndRebuild(t, ctx, repo, bufOut, bufErr)
+
+	hold, err := utils.GetHold(repo, snap.Header.Identifier)
+	require.NoError(t, err)
+	require.NotNil(t, hold)
+
+	// once released, the packfile of the record is collected
+	require.NoError(t, utils.RemoveHold(repo, snap.Header.Identifier))
+	out = colourRunAndRebuild(t, ctx, repo, bufOut, bufErr)
+	require.Contains(t, out, "Coloured 1 packfiles (0 orphaned)")
+}
+
+func TestHeldDeletedSnapshotKeepsPackfiles(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap1"))
+	ptesting.GenerateSnapshot(t, repo, extraFiles("keep"), ptesting.WithName("snap2"))
+	require.NoError(t, utils.PutHold(repo, &utils.Hold{
+		Snapshot:  snap1.Header.Identifier,
+		CreatedAt: time.Now(),
+	}))
+
+	// a client unaware of the hold removes the snapshot anyway
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, snap1.Header.GetIndexID())
+
+	t.Setenv("PLAKAR_GRACEPERIOD", "1ns")
+	out := colourRunAndRebuild(t, ctx, repo, bufOut, bufErr)
+	require.Contains(t, out, "Coloured 0 packfiles (0 orphaned)")
+}
+
+func TestRecoveryPeriodKeepsDeletedSnapshotPackfiles(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap1"))
+	ptesting.GenerateSnapshot(t, repo, extraFiles("keep"), ptesting.WithName("snap2"))
+
+	t.Setenv("PLAKAR_RECOVERYPERIOD", "1h")
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, snap1.Header.GetIndexID())
+
+	t.Setenv("PLAKAR_GRACEPERIOD", "1ns")
+	out := colourRunAndRebuild(t, ctx, repo, bufOut, bufErr)
+	require.Contains(t, out, "Coloured 0 packfiles (0 orphaned)")
+
+	// once the recovery period is over, the data is reclaimed
+	t.Setenv("PLAKAR_RECOVERYPERIOD", "0s")
+	out = colourRunAndRebuild(t, ctx, repo, bufOut, bufErr)
+	require.NotContains(t, out, "Coloured 0 packfiles")
+}
+
+func TestParseRecoveryPeriod(t *testing.T) {
+	resetEnv(t)
+	ctx := appcontext.NewAppContext()
+
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-recovery", "2w"}))
+	require.Equal(t, 14*24*time.Hour, cmd.Recovery)
+
+	t.Setenv("PLAKAR_RECOVERYPERIOD", "")
+	cmd = &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, nil))
+	require.Equal(t, defaultDuration, cmd.Recovery)
+
+	cmd = &Maintenance{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-recovery", "soon"}), "invalid recovery period")
+}
+
+func TestDryRunReportsWithoutColouring(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap1"))
+	snap2 := ptesting.GenerateSnapshot(t, repo, extraFiles("keep"), ptesting.WithName("snap2"))
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, snap1.Header.GetIndexID())
+
+	bufOut.Reset()
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-dry-run"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	out := bufOut.String()
+	// sized from the states, without fetching the packfiles
+	require.Regexp(t, `Would colour [1-9]\d* packfiles \([1-9]\d*(\.\d+)? [KMG]?i?B\) for deletion, 0 orphaned`, out)
+	require.Contains(t, out, "Would delete 0 packfiles (0 B) coloured more than 7d ago")
+	require.Contains(t, out, "Snapshots pinning the most data:")
+	require.Contains(t, out, fmt.Sprintf("%x", snap2.Header.GetIndexShortID()))
+
+	// nothing was written to the repository
+	locks, err := repo.GetLocks()
+	require.NoError(t, err)
+	require.Empty(t, locks)
+	require.NoError(t, repo.RebuildState())
+	require.Empty(t, colouredPackfiles(t, repo))
+}
+
+func TestParseGracePeriod(t *testing.T) {
+	resetEnv(t)
+	ctx := appcontext.NewAppContext()
+
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-grace", "3d"}))
+	require.Equal(t, 3*24*time.Hour, cmd.Grace)
+	require.Equal(t, 3*24*time.Hour, cmd.gracePeriod())
+
+	// the flag takes precedence over the environment
+	t.Setenv("PLAKAR_GRACEPERIOD", "1h")
+	require.Equal(t, 3*24*time.Hour, cmd.gracePeriod())
+	cmd = &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, nil))
+	require.Equal(t, time.Hour, cmd.gracePeriod())
+
+	cmd = &Maintenance{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-grace", "0s"}), "must be positive")
+	cmd = &Maintenance{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-grace", "later"}), "invalid grace period")
+}
+
+func TestParseRepack(t *testing.T) {
+	resetEnv(t)
+	ctx := appcontext.NewAppContext()
+
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, nil))
+	require.Zero(t, cmd.Repack)
+	require.Zero(t, cmd.RepackBudget)
+
+	cmd = &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repack", "0.3"}))
+	require.Equal(t, 0.3, cmd.Repack)
+
+	cmd = &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repack", "50%", "-repack-budget", "2GB"}))
+	require.Equal(t, 0.5, cmd.Repack)
+	require.Equal(t, uint64(2_000_000_000), cmd.RepackBudget)
+
+	for _, threshold := range []string{"0", "150%", "-0.5", "half"} {
+		cmd = &Maintenance{}
+		require.ErrorContains(t, cmd.Parse(ctx, []string{"-repack", threshold}), "invalid repack threshold", threshold)
+	}
+
+	cmd = &Maintenance{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-repack", "0.3", "-repack-budget", "lots"}), "invalid repack budget")
+	cmd = &Maintenance{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-repack-budget", "1GB"}), "requires -repack")
+}
+
+// repackFixture leaves the packfiles of a deleted snapshot partially
+// referenced by a second snapshot sharing some of its files.
+func repackFixture(t *testing.T) (*repository.Repository, *appcontext.AppContext, *bytes.Buffer, *bytes.Buffer, *snapshot.Snapshot) {
+	t.Helper()
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, append(simpleFiles(), extraFiles("gone")...), ptesting.WithName("snap1"))
+	snap2 := ptesting.GenerateSnapshot(t, repo, simpleFiles(), ptesting.WithName("snap2"))
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, snap1.Header.GetIndexID())
+	return repo, ctx, bufOut, bufErr, snap2
+}
+
+func requireSnapshotReadable(t *testing.T, repo *repository.Repository, snapshotID objects.MAC) {
+	t.Helper()
+	snap, err := snapshot.Load(repo, snapshotID)
+	require.NoError(t, err)
+	defer snap.Close()
+	fs, err := snap.Filesystem()
+	require.NoError(t, err)
+
+	root := snap.Header.GetSource(0).Importer.Directory
+	for name, content := range map[string]string{"subdir/a.txt": "alpha", "subdir/b.txt": "bravo"} {
+		fp, err := fs.Open(path.Join(root, name))
+		require.NoError(t, err, name)
+		data, err := io.ReadAll(fp)
+		fp.Close()
+		require.NoError(t, err, name)
+		require.Equal(t, content, string(data))
+	}
+}
+
+func TestRepackRewritesLiveBlobs(t *testing.T) {
+	repo, ctx, bufOut, bufErr, snap2 := repackFixture(t)
+
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repack", "100%"}))
+	bufOut.Reset()
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Regexp(t, `Repacked [1-9]\d* packfiles, .* of live data rewritten`, bufOut.String())
+	require.Contains(t, bufOut.String(), "Repacked packfiles are scheduled to be removed in 7d")
+
+	require.Eventually(t, func() bool {
+		locks, lerr := repo.GetLocks()
+		return lerr == nil && len(locks) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, repo.RebuildState())
+
+	// the snapshot reads from the new packfiles, the old ones are coloured
+	snap, err := snapshot.Load(repo, snap2.Header.GetIndexID())
+	require.NoError(t, err)
+	iter, err := snap.ListPackfiles()
+	require.NoError(t, err)
+	coloured := colouredPackfiles(t, repo)
+	require.NotEmpty(t, coloured)
+	for packfileMAC, err := range iter {
+		require.NoError(t, err)
+		require.NotContains(t, coloured, packfileMAC)
+	}
+	snap.Close()
+	requireSnapshotReadable(t, repo, snap2.Header.GetIndexID())
+
+	// once the grace period is over, the old packfiles are removed
+	t.Setenv("PLAKAR_GRACEPERIOD", "1ns")
+	cmd = &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, nil))
+	bufOut.Reset()
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NotContains(t, bufErr.String(), "Concurrent backup")
+
+	require.NoError(t, repo.RebuildState())
+	store := storePackfiles(t, repo)
+	for packfileMAC := range coloured {
+		require.NotContains(t, store, packfileMAC)
+	}
+	requireSnapshotReadable(t, repo, snap2.Header.GetIndexID())
+}
+
+func TestRepackBudget(t *testing.T) {
+	repo, ctx, bufOut, _, _ := repackFixture(t)
+
+	// a packfile exceeding the budget on its own is still repacked
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repack", "100%", "-repack-budget", "1B", "-dry-run"}))
+	bufOut.Reset()
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "Would repack 1 packfiles")
+
+	cmd = &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repack", "100%", "-repack-budget", "1B"}))
+	bufOut.Reset()
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "Repacked 1 packfiles")
+
+	require.Eventually(t, func() bool {
+		locks, lerr := repo.GetLocks()
+		return lerr == nil && len(locks) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, repo.RebuildState())
+	require.Len(t, colouredPackfiles(t, repo), 1)
+}
+
+// xattrFiles generates a file with an extended attribute and, if gone
+// is set, a directory the next snapshot does not have.
+func xattrFiles(gone bool) func(chan<- *connectors.Record) {
+	mtime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	file := func(pathname, content string, xattrs []string) *connectors.Record {
+		return connectors.NewRecord(pathname, "",
+			objects.NewFileInfo(path.Base(pathname), int64(len(content)), 0644, mtime, 0, 0, 0, 0, 1),
+			xattrs,
+			func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(content)), nil })
+	}
+
+	return func(ch chan<- *connectors.Record) {
+		ch <- &connectors.Record{
+			Pathname: "/",
+			FileInfo: objects.NewFileInfo("/", 0, 0755|os.ModeDir, mtime, 0, 0, 0, 0, 1),
+		}
+		ch <- file("/tagged.txt", "tagged", []string{"user.label"})
+		ch <- connectors.NewXattr("/tagged.txt", "user.label", objects.AttributeExtended,
+			func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("precious")), nil })
+		if gone {
+			ch <- &connectors.Record{
+				Pathname: "/gone",
+				FileInfo: objects.NewFileInfo("gone", 0, 0755|os.ModeDir, mtime, 0, 0, 0, 0, 1),
+			}
+			ch <- file("/gone/y.txt", strings.Repeat("gone", 1024), nil)
+		}
+	}
+}
+
+func TestRepackKeepsXattrs(t *testing.T) {
+	repo, ctx, bufOut, bufErr := freshRepo(t)
+	snap1 := ptesting.GenerateSnapshot(t, repo, nil, ptesting.WithName("snap1"), ptesting.WithGenerator(xattrFiles(true)))
+	snap2 := ptesting.GenerateSnapshot(t, repo, nil, ptesting.WithName("snap2"), ptesting.WithGenerator(xattrFiles(false)))
+	primeAndDelete(t, ctx, repo, bufOut, bufErr, snap1.Header.GetIndexID())
+
+	cmd := &Maintenance{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-repack", "100%"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Regexp(t, `Repacked [1-9]\d* packfiles`, bufOut.String())
+	require.Eventually(t, func() bool {
+		locks, lerr := repo.GetLocks()
+		return lerr == nil && len(locks) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+	require.NoError(t, repo.RebuildState())
+	require.NotEmpty(t, colouredPackfiles(t, repo))
+
+	// sweep the repacked packfiles
+	t.Setenv("PLAKAR_GRACEPERIOD", "1ns")
+	runMaintenance(t, ctx, repo, bufOut, bufErr)
+	require.NoError(t, repo.RebuildState())
+
+	snap, err := snapshot.Load(repo, snap2.Header.GetIndexID())
+	require.NoError(t, err)
+	defer snap.Close()
+	fs, err := snap.Filesystem()
+	require.NoError(t, err)
+	entry, err := fs.GetEntry("/tagged.txt")
+	require.NoError(t, err)
+	rd, err := entry.Xattr(fs, "user.label")
+	require.NoError(t, err)
+	value, err := io.ReadAll(rd)
+	require.NoError(t, err)
+	require.Equal(t, "precious", string(value))
+}
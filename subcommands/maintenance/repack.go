@@ -0,0 +1,438 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package maintenance
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/btree"
+	"github.com/PlakarKorp/kloset/caching"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/packfile"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/repository/state"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/dustin/go-humanize"
+)
+
+// parseRepackThreshold parses a live-data ratio given either as a fraction
+// (0.3) or as a percentage (30%).
+func parseRepackThreshold(value string) (float64, error) {
+	percent := strings.HasSuffix(value, "%")
+	ratio, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if percent {
+		ratio /= 100
+	}
+	if ratio <= 0 || ratio > 1 {
+		return 0, fmt.Errorf("must be between 0 and 1, or 0%% and 100%%")
+	}
+	return ratio, nil
+}
+
+// liveBlobs are the chunks and objects referenced by the snapshots whose
+// data maintenance keeps, be they file contents, directory packs or
+// extended attribute values.
+type liveBlobs struct {
+	chunks  map[objects.MAC]struct{}
+	objects map[objects.MAC]struct{}
+}
+
+// repackCandidate is a packfile holding mostly unreferenced data, and the
+// blobs to rewrite before it can be removed.
+type repackCandidate struct {
+	packfile  objects.MAC
+	live      []packfile.Blob
+	liveBytes uint64
+	size      uint64
+}
+
+func (c *repackCandidate) ratio() float64 {
+	return float64(c.liveBytes) / float64(c.size)
+}
+
+// retainedSnapshots returns the snapshots whose data maintenance keeps:
+// the live ones and the deleted ones that must remain restorable.
+func (cmd *Maintenance) retainedSnapshots() ([]objects.MAC, error) {
+	retained := make([]objects.MAC, 0)
+	for snapshotID, err := range cmd.repository.ListSnapshots() {
+		if err != nil {
+			return nil, err
+		}
+		retained = append(retained, snapshotID)
+	}
+
+	now := time.Now()
+	for snapshotID, deletedAt := range cmd.repository.ListDeletedSnapShots() {
+		keep, err := cmd.keepDeleted(snapshotID, deletedAt, now)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			retained = append(retained, snapshotID)
+		}
+	}
+	return retained, nil
+}
+
+// liveBlobs walks the retained snapshots to collect the chunks and objects
+// they reference.
+func (cmd *Maintenance) liveBlobs(ctx *appcontext.AppContext, retained []objects.MAC) (*liveBlobs, error) {
+	live := &liveBlobs{
+		chunks:  make(map[objects.MAC]struct{}),
+		objects: make(map[objects.MAC]struct{}),
+	}
+	for _, snapshotID := range retained {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := cmd.markLive(live, snapshotID); err != nil {
+			return nil, fmt.Errorf("%x: %w", snapshotID[:4], err)
+		}
+	}
+	return live, nil
+}
+
+func (cmd *Maintenance) markLive(live *liveBlobs, snapshotID objects.MAC) error {
+	snap, err := snapshot.Load(cmd.repository, snapshotID)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return err
+	}
+
+	fsIter := fs.IterNodes()
+	for fsIter.Next() {
+		_, node := fsIter.Current()
+		for _, entryMAC := range node.Values {
+			entry, err := fs.ResolveEntry(entryMAC)
+			if err != nil {
+				return err
+			}
+			if !entry.HasObject() {
+				continue
+			}
+			live.objects[entry.Object] = struct{}{}
+			for _, chunk := range entry.ResolvedObject.Chunks {
+				live.chunks[chunk.ContentMAC] = struct{}{}
+			}
+		}
+	}
+	if err := fsIter.Err(); err != nil {
+		return err
+	}
+
+	// extended attribute values are stored as objects and chunks too
+	rd, err := cmd.repository.GetBlob(resources.RT_XATTR_BTREE, snap.Header.GetSource(0).VFS.Xattrs)
+	if err != nil {
+		return err
+	}
+	store := repository.NewRepositoryStore[string, objects.MAC](cmd.repository, resources.RT_XATTR_NODE)
+	xattrs, err := btree.Deserialize(rd, store, vfs.PathCmp)
+	if err != nil {
+		return err
+	}
+	xattrIter, err := xattrs.ScanFrom("/")
+	if err != nil {
+		return err
+	}
+	for xattrIter.Next() {
+		_, xattrMAC := xattrIter.Current()
+		xattr, err := fs.ResolveXattr(xattrMAC)
+		if err != nil {
+			return err
+		}
+		live.objects[xattr.Object] = struct{}{}
+		for _, chunk := range xattr.ResolvedObject.Chunks {
+			live.chunks[chunk.ContentMAC] = struct{}{}
+		}
+	}
+	if err := xattrIter.Err(); err != nil {
+		return err
+	}
+
+	dirpack, err := snap.DirPack()
+	if err != nil {
+		return err
+	}
+	if dirpack == nil {
+		return nil
+	}
+
+	dirpackIter := dirpack.IterDFS()
+	for dirpackIter.Next() {
+		_, node := dirpackIter.Current()
+		for _, objectMAC := range node.Values {
+			object, err := snap.LookupObject(objectMAC)
+			if err != nil {
+				return err
+			}
+			live.objects[objectMAC] = struct{}{}
+			for _, chunk := range object.Chunks {
+				live.chunks[chunk.ContentMAC] = struct{}{}
+			}
+		}
+	}
+	return dirpackIter.Err()
+}
+
+// isLive reports whether a blob stored in a packfile is still needed: the
+// state must resolve it to this packfile and, for chunks and objects, a
+// retained snapshot must reference it.  Other blobs are small and their
+// references are not tracked, they are always kept.
+func (cmd *Maintenance) isLive(live *liveBlobs, packfileMAC objects.MAC, blob packfile.Blob) (bool, error) {
+	location, exists, err := cmd.repository.GetPackfileForBlob(blob.Type, blob.MAC)
+	if err != nil {
+		return false, err
+	}
+	if !exists || location != packfileMAC {
+		return false, nil
+	}
+
+	switch blob.Type {
+	case resources.RT_CHUNK:
+		_, ok := live.chunks[blob.MAC]
+		return ok, nil
+	case resources.RT_OBJECT:
+		_, ok := live.objects[blob.MAC]
+		return ok, nil
+	default:
+		return true, nil
+	}
+}
+
+// repackCandidates returns the packfiles whose ratio of live data is below
+// the threshold and whose live data fits in the budget, the emptiest
+// first, along with those left for a later run.
+func (cmd *Maintenance) repackCandidates(ctx *appcontext.AppContext, cache *caching.MaintenanceCache) ([]repackCandidate, []repackCandidate, error) {
+	retained, err := cmd.retainedSnapshots()
+	if err != nil {
+		return nil, nil, err
+	}
+	live, err := cmd.liveBlobs(ctx, retained)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Packfiles no snapshot references are left to the colouring pass.
+	packfiles := make([]objects.MAC, 0)
+	for packfileMAC := range cmd.repository.ListPackfiles() {
+		if !cache.HasPackfile(packfileMAC) {
+			continue
+		}
+		coloured, err := cmd.repository.HasDeletedPackfile(packfileMAC)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !coloured {
+			packfiles = append(packfiles, packfileMAC)
+		}
+	}
+
+	candidates := make([]repackCandidate, 0)
+	for _, packfileMAC := range packfiles {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		p, err := cmd.repository.GetPackfile(packfileMAC)
+		if err != nil {
+			return nil, nil, fmt.Errorf("packfile %x: %w", packfileMAC, err)
+		}
+
+		candidate := repackCandidate{packfile: packfileMAC}
+		for _, blob := range p.Index {
+			// the padding is not data, it doesn't weigh in the ratio
+			if blob.Type == resources.RT_RANDOM {
+				continue
+			}
+			candidate.size += uint64(blob.Length)
+
+			ok, err := cmd.isLive(live, packfileMAC, blob)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				candidate.live = append(candidate.live, blob)
+				candidate.liveBytes += uint64(blob.Length)
+			}
+		}
+
+		if candidate.size != 0 && candidate.ratio() < cmd.Repack {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ratio() < candidates[j].ratio()
+	})
+
+	if cmd.RepackBudget == 0 {
+		return candidates, nil, nil
+	}
+
+	// Packfiles that do not fit are left for the next runs, so that the
+	// work can be spread over time.  The first one is always admitted,
+	// or a packfile larger than the budget would never be repacked.
+	var used uint64
+	selected := make([]repackCandidate, 0)
+	deferred := make([]repackCandidate, 0)
+	for _, candidate := range candidates {
+		if len(selected) != 0 && used+candidate.liveBytes > cmd.RepackBudget {
+			deferred = append(deferred, candidate)
+			continue
+		}
+		used += candidate.liveBytes
+		selected = append(selected, candidate)
+	}
+	return selected, deferred, nil
+}
+
+// repackTotals returns the live bytes to rewrite and the bytes reclaimed
+// once the packfiles are removed.
+func repackTotals(candidates []repackCandidate) (uint64, uint64) {
+	var live, reclaimed uint64
+	for _, candidate := range candidates {
+		live += candidate.liveBytes
+		reclaimed += candidate.size - candidate.liveBytes
+	}
+	return live, reclaimed
+}
+
+// repackPass rewrites the live blobs of mostly unreferenced packfiles into
+// new packfiles and colours the old ones for deletion in the same state,
+// so that the blobs are always reachable.  The snapshots referencing the
+// old packfiles are evicted from the local cache for the next run to
+// resolve them to the new ones before sweeping.  If the pass fails
+// midway, the packfiles fully rewritten so far are still committed.
+func (cmd *Maintenance) repackPass(ctx *appcontext.AppContext, cache *caching.MaintenanceCache) error {
+	candidates, deferred, err := cmd.repackCandidates(ctx, cache)
+	if err != nil {
+		return err
+	}
+
+	if len(deferred) > 0 {
+		live, _ := repackTotals(deferred)
+		fmt.Fprintf(ctx.Stdout, "maintenance: %d packfiles (%s of live data) left to repack in a later run\n",
+			len(deferred), humanize.IBytes(live))
+	}
+
+	if len(candidates) == 0 {
+		fmt.Fprintf(ctx.Stdout, "maintenance: Repacked 0 packfiles, 0 B of live data rewritten, 0 B to reclaim\n")
+		return nil
+	}
+
+	stateID := objects.RandomMAC()
+	sc, err := cmd.repository.AppContext().GetCache().Scan(stateID)
+	if err != nil {
+		return err
+	}
+	repoWriter := cmd.repository.NewRepositoryWriter(sc, stateID, repository.DefaultType, "")
+
+	rewrite := func(candidate repackCandidate) error {
+		for _, blob := range candidate.live {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			rd, err := cmd.repository.GetPackfileBlob(state.Location{
+				Packfile: candidate.packfile,
+				Offset:   blob.Offset,
+				Length:   blob.Length,
+			})
+			if err != nil {
+				return fmt.Errorf("packfile %x: blob %x: %w", candidate.packfile, blob.MAC, err)
+			}
+			data, err := io.ReadAll(rd)
+			if err != nil {
+				return fmt.Errorf("packfile %x: blob %x: %w", candidate.packfile, blob.MAC, err)
+			}
+
+			if err := repoWriter.PutBlob(blob.Type, blob.MAC, data, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// only the packfiles whose live blobs were all rewritten are coloured
+	repacked := make(map[objects.MAC]struct{})
+	done := make([]repackCandidate, 0, len(candidates))
+	var rewriteErr error
+	for _, candidate := range candidates {
+		if rewriteErr = rewrite(candidate); rewriteErr != nil {
+			break
+		}
+		repacked[candidate.packfile] = struct{}{}
+		done = append(done, candidate)
+	}
+
+	// the packfiles flushed so far must be recorded in the state
+	repoWriter.PackerManager.Wait()
+
+	for packfileMAC := range repacked {
+		if err := repoWriter.DeleteStateResource(resources.RT_PACKFILE, packfileMAC); err != nil {
+			return err
+		}
+	}
+
+	if err := repoWriter.CommitTransaction(stateID); err != nil {
+		return err
+	}
+
+	live, reclaimed := repackTotals(done)
+	fmt.Fprintf(ctx.Stdout, "maintenance: Repacked %d packfiles, %s of live data rewritten, %s to reclaim\n",
+		len(done), humanize.IBytes(live), humanize.IBytes(reclaimed))
+	if len(done) != 0 {
+		fmt.Fprintf(ctx.Stdout, "Repacked packfiles are scheduled to be removed in %s\n", humanDuration(cmd.gracePeriod()))
+	}
+
+	retained, err := cmd.retainedSnapshots()
+	if err != nil {
+		return err
+	}
+	for _, snapshotID := range retained {
+		stale := false
+		for packfileMAC := range cache.GetPackfiles(snapshotID) {
+			if _, ok := repacked[packfileMAC]; ok {
+				stale = true
+				break
+			}
+		}
+		if stale {
+			if err := cmd.uncache(cache, snapshotID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return rewriteErr
+}
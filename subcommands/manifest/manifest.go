@@ -0,0 +1,341 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package manifest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/subcommands/digest"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+	FormatSum   = "sum"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Manifest{} }, 0, "manifest")
+}
+
+type Manifest struct {
+	subcommands.SubcommandBase
+
+	Format          string
+	HashingFunction string
+	Target          string
+}
+
+// Record is the manifest line of an entry.  The content MAC and the
+// digest are only set for regular files, the digest unless hashing was
+// disabled.
+type Record struct {
+	Path       string    `json:"path"`
+	Type       string    `json:"type"`
+	Size       int64     `json:"size"`
+	Mode       string    `json:"mode"`
+	Uid        uint64    `json:"uid"`
+	Gid        uint64    `json:"gid"`
+	Owner      string    `json:"owner"`
+	Group      string    `json:"group"`
+	ModTime    time.Time `json:"mtime"`
+	Target     string    `json:"target,omitempty"`
+	ContentMAC string    `json:"content_mac,omitempty"`
+	Digest     string    `json:"digest,omitempty"`
+}
+
+func (cmd *Manifest) Parse(ctx *appcontext.AppContext, args []string) error {
+	var opt_hashing string
+
+	flags := flag.NewFlagSet("manifest", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] SNAPSHOT[:PATH]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+
+	flags.StringVar(&cmd.Format, "format", FormatCSV, "output format: csv, jsonl or sum")
+	flags.StringVar(&opt_hashing, "hashing", "SHA256", "hashing algorithm to use, or none")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("exactly one snapshot must be specified")
+	}
+
+	switch cmd.Format {
+	case FormatCSV, FormatJSONL, FormatSum:
+	default:
+		return fmt.Errorf("unsupported format: %s", cmd.Format)
+	}
+
+	if !strings.EqualFold(opt_hashing, "none") {
+		hashingFunction, err := digest.ParseHashing(opt_hashing)
+		if err != nil {
+			return err
+		}
+		cmd.HashingFunction = hashingFunction
+	} else if cmd.Format == FormatSum {
+		return fmt.Errorf("the sum format requires a hashing algorithm")
+	}
+
+	cmd.RepositorySecret = ctx.GetSecret()
+	cmd.Target = flags.Arg(0)
+
+	return nil
+}
+
+func (cmd *Manifest) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	snap, pathname, err := locate.OpenSnapshotByPath(repo, cmd.Target)
+	if err != nil {
+		return 1, fmt.Errorf("manifest: %s: %w", utils.SanitizeText(cmd.Target), err)
+	}
+	defer snap.Close()
+
+	pvfs, err := snap.Filesystem()
+	if err != nil {
+		return 1, fmt.Errorf("manifest: %x: %w", snap.Header.Identifier[:4], err)
+	}
+
+	root, err := pvfs.GetEntry(pathname)
+	if err != nil {
+		return 1, fmt.Errorf("manifest: %x:%s: %w", snap.Header.Identifier[:4], utils.SanitizeText(pathname), err)
+	}
+
+	entries := pvfs.Files(root.Path())
+	if !root.IsDir() {
+		entries = func(yield func(*vfs.Entry, error) bool) {
+			yield(root, nil)
+		}
+	}
+
+	out := newWriter(cmd.Format, ctx.Stdout, root.Path())
+	errors := 0
+	for entry, err := range entries {
+		if err != nil {
+			return 1, fmt.Errorf("manifest: %x:%s: %w", snap.Header.Identifier[:4], utils.SanitizeText(pathname), err)
+		}
+		if err := ctx.Err(); err != nil {
+			return 1, err
+		}
+
+		record, err := cmd.record(pvfs, snap, entry)
+		if err != nil {
+			ctx.GetLogger().Error("manifest: %x:%s: %s", snap.Header.Identifier[:4],
+				utils.SanitizeText(entry.Path()), err)
+			errors++
+		}
+		if err := out.write(record); err != nil {
+			return 1, fmt.Errorf("manifest: %w", err)
+		}
+	}
+	if err := out.flush(); err != nil {
+		return 1, fmt.Errorf("manifest: %w", err)
+	}
+
+	if errors != 0 {
+		return 1, fmt.Errorf("errors occurred")
+	}
+	return 0, nil
+}
+
+// record returns the record of the entry, along with an error if its
+// content could not be read, in which case it has no digest.
+func (cmd *Manifest) record(pvfs *vfs.Filesystem, snap *snapshot.Snapshot, entry *vfs.Entry) (*Record, error) {
+	info := entry.Stat()
+	record := &Record{
+		Path:    entry.Path(),
+		Type:    fileType(info.Mode()),
+		Size:    info.Size(),
+		Mode:    info.Mode().String(),
+		Uid:     info.Uid(),
+		Gid:     info.Gid(),
+		Owner:   info.Username(),
+		Group:   info.Groupname(),
+		ModTime: info.ModTime().UTC(),
+		Target:  entry.SymlinkTarget,
+	}
+	if record.Owner == "" {
+		record.Owner = strconv.FormatUint(record.Uid, 10)
+	}
+	if record.Group == "" {
+		record.Group = strconv.FormatUint(record.Gid, 10)
+	}
+
+	if !info.Mode().IsRegular() || !entry.HasObject() {
+		return record, nil
+	}
+
+	object, err := snap.LookupObject(entry.Object)
+	if err != nil {
+		return record, err
+	}
+	record.ContentMAC = fmt.Sprintf("%x", object.ContentMAC)
+
+	if cmd.HashingFunction == "" {
+		return record, nil
+	}
+
+	// already resolved, opening the entry does not look it up again
+	entry.ResolvedObject = object
+	rd, err := entry.Open(pvfs)
+	if err != nil {
+		return record, err
+	}
+	defer rd.Close()
+
+	sum, err := digest.Sum(cmd.HashingFunction, rd)
+	if err != nil {
+		return record, err
+	}
+	record.Digest = fmt.Sprintf("%x", sum)
+	return record, nil
+}
+
+func fileType(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	case mode&fs.ModeNamedPipe != 0:
+		return "pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	default:
+		return "other"
+	}
+}
+
+// writer writes records as they come, so that the manifest of a large
+// snapshot is streamed.
+type writer interface {
+	write(*Record) error
+	flush() error
+}
+
+func newWriter(format string, w io.Writer, root string) writer {
+	switch format {
+	case FormatJSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w)}
+	case FormatSum:
+		return &sumWriter{w: w, root: root}
+	default:
+		return &csvWriter{w: csv.NewWriter(w)}
+	}
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvWriter) write(record *Record) error {
+	if !c.header {
+		c.header = true
+		err := c.w.Write([]string{"path", "type", "size", "mode", "uid", "gid",
+			"owner", "group", "mtime", "target", "content_mac", "digest"})
+		if err != nil {
+			return err
+		}
+	}
+	return c.w.Write([]string{
+		record.Path,
+		record.Type,
+		strconv.FormatInt(record.Size, 10),
+		record.Mode,
+		strconv.FormatUint(record.Uid, 10),
+		strconv.FormatUint(record.Gid, 10),
+		record.Owner,
+		record.Group,
+		record.ModTime.Format(time.RFC3339Nano),
+		record.Target,
+		record.ContentMAC,
+		record.Digest,
+	})
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) write(record *Record) error {
+	return j.enc.Encode(record)
+}
+
+func (j *jsonlWriter) flush() error {
+	return nil
+}
+
+// sumWriter writes the digests of regular files in the format read by
+// sha256sum -c and its siblings, with paths relative to the root of the
+// manifest so that it can be checked from a restored copy of it.
+type sumWriter struct {
+	w    io.Writer
+	root string
+}
+
+var sumEscaper = strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\r", "\\r")
+
+func (s *sumWriter) write(record *Record) error {
+	if record.Digest == "" {
+		return nil
+	}
+
+	relpath := path.Base(record.Path)
+	if record.Path != s.root {
+		relpath = strings.TrimPrefix(record.Path, strings.TrimSuffix(s.root, "/")+"/")
+	}
+
+	// like coreutils, a line whose name needed escaping starts with a
+	// backslash
+	name := sumEscaper.Replace(relpath)
+	prefix := ""
+	if name != relpath {
+		prefix = "\\"
+	}
+	_, err := fmt.Fprintf(s.w, "%s%s  %s\n", prefix, record.Digest, name)
+	return err
+}
+
+func (s *sumWriter) flush() error {
+	return nil
+}
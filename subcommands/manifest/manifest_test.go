@@ -0,0 +1,105 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func generateSnapshot(t *testing.T) (*repository.Repository, *appcontext.AppContext, *snapshot.Snapshot) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("docs"),
+		ptesting.NewMockFile("docs/a.txt", 0644, "hello"),
+		ptesting.NewMockFile("docs/we\\ird.txt", 0600, "weird"),
+	})
+	t.Cleanup(func() { snap.Close() })
+	return repo, ctx, snap
+}
+
+func run(t *testing.T, repo *repository.Repository, ctx *appcontext.AppContext, args ...string) string {
+	bufOut := bytes.NewBuffer(nil)
+	ctx.Stdout = bufOut
+
+	cmd := &Manifest{}
+	require.NoError(t, cmd.Parse(ctx, args))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	return bufOut.String()
+}
+
+func TestExecuteCmdManifestSum(t *testing.T) {
+	repo, ctx, snap := generateSnapshot(t)
+
+	out := run(t, repo, ctx, "-format", "sum", fmt.Sprintf("%x:/docs", snap.Header.Identifier))
+	require.Equal(t, fmt.Sprintf("%x  a.txt\n\\%x  we\\\\ird.txt\n",
+		sha256.Sum256([]byte("hello")), sha256.Sum256([]byte("weird"))), out)
+
+	// paths are relative to the requested path
+	out = run(t, repo, ctx, "-format", "sum", fmt.Sprintf("%x:/", snap.Header.Identifier))
+	require.Contains(t, out, fmt.Sprintf("%x  docs/a.txt\n", sha256.Sum256([]byte("hello"))))
+
+	out = run(t, repo, ctx, "-format", "sum", fmt.Sprintf("%x:/docs/a.txt", snap.Header.Identifier))
+	require.Equal(t, fmt.Sprintf("%x  a.txt\n", sha256.Sum256([]byte("hello"))), out)
+}
+
+func TestExecuteCmdManifestCSV(t *testing.T) {
+	repo, ctx, snap := generateSnapshot(t)
+
+	out := run(t, repo, ctx, fmt.Sprintf("%x:/docs", snap.Header.Identifier))
+	rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+	require.Equal(t, "path", rows[0][0])
+	require.Equal(t, []string{"/docs", "directory"}, rows[1][:2])
+	require.Empty(t, rows[1][10])
+
+	require.Equal(t, []string{"/docs/a.txt", "file", "5", "-rw-r--r--"}, rows[2][:4])
+	require.Len(t, rows[2][10], 64)
+	require.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("hello"))), rows[2][11])
+	require.Equal(t, "-rw-------", rows[3][3])
+}
+
+func TestExecuteCmdManifestJSONL(t *testing.T) {
+	repo, ctx, snap := generateSnapshot(t)
+
+	out := run(t, repo, ctx, "-format", "jsonl", "-hashing", "none", fmt.Sprintf("%x:/docs/a.txt", snap.Header.Identifier))
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 1)
+
+	var record Record
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	require.Equal(t, "/docs/a.txt", record.Path)
+	require.Equal(t, "file", record.Type)
+	require.Equal(t, int64(5), record.Size)
+	require.NotEmpty(t, record.Owner)
+	require.NotEmpty(t, record.ContentMAC)
+	require.Empty(t, record.Digest)
+}
+
+func TestParseManifest(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	require.Error(t, (&Manifest{}).Parse(ctx, []string{}))
+	require.Error(t, (&Manifest{}).Parse(ctx, []string{"a", "b"}))
+	require.Error(t, (&Manifest{}).Parse(ctx, []string{"-format", "xml", "a"}))
+	require.Error(t, (&Manifest{}).Parse(ctx, []string{"-hashing", "md4", "a"}))
+	require.Error(t, (&Manifest{}).Parse(ctx, []string{"-format", "sum", "-hashing", "none", "a"}))
+
+	cmd := &Manifest{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-hashing", "blake3", "-format", "jsonl", "a:/etc"}))
+	require.Equal(t, "BLAKE3", cmd.HashingFunction)
+	require.Equal(t, FormatJSONL, cmd.Format)
+	require.Equal(t, "a:/etc", cmd.Target)
+}
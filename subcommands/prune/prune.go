@@ -26,11 +26,13 @@ import (
 	"sync"
 	"time"
 
+	"github.com/PlakarKorp/go-human2duration"
 	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exitcodes"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/dustin/go-humanize"
@@ -41,7 +43,9 @@ type Prune struct {
 
 	LocateOptions *locate.LocateOptions
 
-	Apply bool
+	Apply   bool
+	MaxSize uint64
+	MinAge  time.Duration
 }
 
 func init() {
@@ -50,6 +54,8 @@ func init() {
 
 func (cmd *Prune) Parse(ctx *appcontext.AppContext, args []string) error {
 	policyName := ""
+	maxSize := ""
+	minAge := ""
 	cmd.LocateOptions = locate.NewDefaultLocateOptions()
 	policyOverride := locate.NewDefaultLocateOptions()
 
@@ -61,6 +67,8 @@ func (cmd *Prune) Parse(ctx *appcontext.AppContext, args []string) error {
 	}
 	flags.BoolVar(&cmd.Apply, "apply", false, "do the actual removal")
 	flags.StringVar(&policyName, "policy", "", "policy to use")
+	flags.StringVar(&maxSize, "max-size", "", "remove the oldest snapshots until the repository footprint is below the given size")
+	flags.StringVar(&minAge, "min-age", "", "never remove snapshots younger than the given duration")
 	policyOverride.InstallLocateFlags(flags)
 	flags.Parse(args)
 
@@ -74,10 +82,30 @@ func (cmd *Prune) Parse(ctx *appcontext.AppContext, args []string) error {
 			return fmt.Errorf("policy %q not found", policyName)
 		}
 		cfg.ApplyConfig(policyName, cmd.LocateOptions)
+		cmd.MaxSize = cfg.Policies[policyName].MaxSize
+		cmd.MinAge = time.Duration(cfg.Policies[policyName].MinAge)
 	}
 	mergePolicyOptions(cmd.LocateOptions, policyOverride)
 
-	if flags.NArg() == 0 && cmd.LocateOptions.Empty() {
+	if maxSize != "" {
+		size, err := humanize.ParseBytes(maxSize)
+		if err != nil {
+			return fmt.Errorf("invalid maximum size %q: %w", maxSize, err)
+		}
+		cmd.MaxSize = size
+	}
+	if minAge != "" {
+		age, err := human2duration.ParseDuration(minAge)
+		if err != nil {
+			return fmt.Errorf("invalid minimum age %q: %w", minAge, err)
+		}
+		if age < 0 {
+			return fmt.Errorf("invalid minimum age %q: negative duration", minAge)
+		}
+		cmd.MinAge = age
+	}
+
+	if flags.NArg() == 0 && cmd.LocateOptions.Empty() && cmd.MaxSize == 0 {
 		return fmt.Errorf("no filter specified, not going to prune everything")
 	}
 
@@ -175,8 +203,10 @@ type planEntry struct {
 	key    string
 	ts     time.Time
 
-	reason locate.Reason
-	action string // "keep" or "delete"
+	reason  locate.Reason
+	action  string // "keep" or "delete"
+	reclaim uint64
+	hold    *utils.Hold
 }
 
 func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
@@ -185,14 +215,10 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		return 1, err
 	}
 
-	toDelete := make([]objects.MAC, 0, len(reasons))
 	entries := make([]planEntry, 0, len(reasons))
 
+	now := time.Now()
 	for id, r := range reasons {
-		if r.Action == "delete" {
-			toDelete = append(toDelete, id)
-		}
-
 		snap, err := snapshot.Load(repo, id)
 		if err != nil {
 			ctx.GetLogger().Warn("prune: skipping %x for timestamp lookup: %v", id[:4], err)
@@ -221,9 +247,47 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 			entry.reason = locate.Reason{Action: "delete", Note: "not evaluated by policy"}
 			entry.action = "skip"
 		}
+
+		entry.hold, err = utils.ActiveHold(repo, id, now)
+		if err != nil {
+			return 1, fmt.Errorf("prune: %w", err)
+		}
 		entries = append(entries, entry)
 	}
 
+	// the footprint is only needed to enforce the size bound, or to
+	// show what each removal would reclaim: the dry run goes without
+	// the latter if it cannot be estimated
+	var footprint *utils.Footprint
+	var before uint64
+	if !cmd.Apply || cmd.MaxSize != 0 {
+		footprint, err = utils.NewFootprint(ctx, repo)
+		if err != nil && cmd.MaxSize != 0 {
+			return 1, fmt.Errorf("prune: failed to estimate the repository footprint: %w", err)
+		} else if err != nil {
+			ctx.GetLogger().Warn("prune: failed to estimate the repository footprint: %v", err)
+			footprint = nil
+		} else {
+			before = footprint.Size()
+		}
+	}
+
+	held := cmd.retain(entries, footprint, now)
+	for _, e := range entries {
+		reasons[e.id] = e.reason
+	}
+	if cmd.MaxSize != 0 && footprint.Size() > cmd.MaxSize {
+		ctx.GetLogger().Warn("prune: repository footprint of %s remains above the maximum size of %s",
+			humanize.IBytes(footprint.Size()), humanize.IBytes(cmd.MaxSize))
+	}
+
+	toDelete := make([]objects.MAC, 0, len(reasons))
+	for id, r := range reasons {
+		if r.Action == "delete" {
+			toDelete = append(toDelete, id)
+		}
+	}
+
 	if !cmd.Apply {
 		// Sort newest-first; unknown timestamps (IsZero) go last
 		sort.SliceStable(entries, func(i, j int) bool {
@@ -240,6 +304,13 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 			return ti.After(tj)
 		})
 		fmt.Fprintf(ctx.Stdout, "prune: would keep %d and delete %d snapshot(s), run with -apply to proceed\n", len(reasons)-len(toDelete), len(toDelete))
+		if footprint != nil {
+			fmt.Fprintf(ctx.Stdout, "prune: would reclaim %s, estimated repository footprint %s -> %s\n",
+				humanize.IBytes(before-footprint.Size()), humanize.IBytes(before), humanize.IBytes(footprint.Size()))
+		}
+		if len(held) != 0 {
+			fmt.Fprintf(ctx.Stdout, "prune: %d held snapshot(s) would be refused\n", len(held))
+		}
 		l := 0
 		for _, e := range entries {
 			l = max(l, len(e.prefix))
@@ -249,18 +320,22 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 				e.prefix += " "
 			}
 			r := e.reason
+			reclaim := ""
+			if e.action == "delete" && footprint != nil {
+				reclaim = " reclaim=" + humanize.IBytes(e.reclaim)
+			}
 			if r.Rule == "" {
-				fmt.Fprintf(ctx.Stdout, "%-8s %s  reason=%s\n", e.action, e.prefix, e.reason.Note)
+				fmt.Fprintf(ctx.Stdout, "%-8s %s  reason=%s%s\n", e.action, e.prefix, e.reason.Note, reclaim)
 			} else {
-				fmt.Fprintf(ctx.Stdout, "%-8s %s  match=%s:%s rank=%d cap=%d\n",
-					e.action, e.prefix, r.Rule, r.Bucket, r.Rank, r.Cap)
+				fmt.Fprintf(ctx.Stdout, "%-8s %s  match=%s:%s rank=%d cap=%d%s\n",
+					e.action, e.prefix, r.Rule, r.Bucket, r.Rank, r.Cap, reclaim)
 			}
 		}
 		return 0, nil
 	}
 
-	if len(toDelete) == 0 {
-		return 0, nil
+	for _, e := range held {
+		ctx.GetLogger().Error("prune: refusing to remove %x: %s", e.id[:4], e.hold)
 	}
 
 	errors := 0
@@ -283,5 +358,65 @@ func (cmd *Prune) Execute(ctx *appcontext.AppContext, repo *repository.Repositor
 		return 1, fmt.Errorf("failed to remove %d snapshots", errors)
 	}
 
+	if len(held) != 0 {
+		return exitcodes.SnapshotHeld, fmt.Errorf("prune: %d snapshot(s) held: %w", len(held), utils.ErrSnapshotHeld)
+	}
+
 	return 0, nil
 }
+
+// retain applies the holds, the minimum age and the maximum size to the
+// plan: held and young snapshots are never removed, and the oldest
+// snapshots not kept by a period rule are removed until the footprint fits
+// the maximum size.
+// Removals are accounted oldest first in the footprint, if any, so the
+// space reclaimed by a removal assumes the older ones were done.
+// It returns the held snapshots a period rule would have removed.
+func (cmd *Prune) retain(entries []planEntry, footprint *utils.Footprint, now time.Time) []planEntry {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ts.Before(entries[j].ts)
+	})
+
+	young := func(e *planEntry) bool {
+		return cmd.MinAge != 0 && now.Sub(e.ts) < cmd.MinAge
+	}
+
+	held := make([]planEntry, 0)
+	for i := range entries {
+		e := &entries[i]
+		if e.action != "delete" {
+			continue
+		}
+		if e.hold != nil {
+			e.action = "keep"
+			e.reason = locate.Reason{Action: "keep", Note: e.hold.String()}
+			held = append(held, *e)
+			continue
+		}
+		if young(e) {
+			e.action = "keep"
+			e.reason = locate.Reason{Action: "keep", Note: "younger than min-age " + cmd.MinAge.String()}
+			continue
+		}
+		if footprint != nil {
+			e.reclaim = footprint.Remove(e.id)
+		}
+	}
+
+	if cmd.MaxSize == 0 {
+		return held
+	}
+	for i := range entries {
+		if footprint.Size() <= cmd.MaxSize {
+			break
+		}
+		e := &entries[i]
+		if e.action != "keep" || e.reason.Rule != "" || e.hold != nil || young(e) {
+			continue
+		}
+		e.action = "delete"
+		e.reason = locate.Reason{Action: "delete", Note: "above max-size " + humanize.IBytes(cmd.MaxSize)}
+		e.reclaim = footprint.Remove(e.id)
+	}
+	return held
+}
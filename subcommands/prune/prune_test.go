@@ -4,18 +4,23 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
 	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exitcodes"
 	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -195,6 +200,163 @@ func TestPrune_ApplyNothingToDelete(t *testing.T) {
 	require.NotContains(t, bufOut.String(), "prune: removal of")
 }
 
+// generateRepoAndThreeSnaps creates three snapshots of 1000 B files with
+// random contents: the second one shares the file of the first one.
+func generateRepoAndThreeSnaps(t *testing.T, bufOut *bytes.Buffer, bufErr *bytes.Buffer) (*repository.Repository, []*snapshot.Snapshot, *appcontext.AppContext) {
+	// the lock of the previous snapshot is released asynchronously and
+	// may vanish while the next one lists the repository locks
+	t.Setenv("PLAKAR_LOCKLESS", "true")
+
+	rnd := rand.New(rand.NewSource(1))
+	content := func() string {
+		buf := make([]byte, 1000)
+		rnd.Read(buf)
+		return string(buf)
+	}
+	a, b, c := content(), content(), content()
+
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	var snaps []*snapshot.Snapshot
+	for _, files := range [][]ptesting.MockFile{
+		{ptesting.NewMockFile("a.bin", 0644, a)},
+		{ptesting.NewMockFile("a.bin", 0644, a), ptesting.NewMockFile("b.bin", 0644, b)},
+		{ptesting.NewMockFile("c.bin", 0644, c)},
+	} {
+		snap := ptesting.GenerateSnapshot(t, repo, files)
+		t.Cleanup(func() { snap.Close() })
+		snaps = append(snaps, snap)
+		time.Sleep(10 * time.Millisecond)
+	}
+	return repo, snaps, ctx
+}
+
+func TestFootprint(t *testing.T) {
+	repo, snaps, ctx := generateRepoAndThreeSnaps(t, nil, nil)
+
+	footprint, err := utils.NewFootprint(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3000), footprint.Size())
+
+	// the file of the first snapshot is still used by the second one
+	require.Zero(t, footprint.Remove(snaps[0].Header.Identifier))
+	require.Equal(t, uint64(2000), footprint.Remove(snaps[1].Header.Identifier))
+	require.Equal(t, uint64(1000), footprint.Size())
+}
+
+func TestPrune_MaxSize(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, snaps, ctx := generateRepoAndThreeSnaps(t, bufOut, bufErr)
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-max-size", "2500B"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	// removing the first snapshot alone does not reclaim anything
+	out := bufOut.String()
+	require.Contains(t, out, "prune: would keep 1 and delete 2 snapshot(s)")
+	require.Contains(t, out, "prune: would reclaim 2.0 KiB, estimated repository footprint 2.9 KiB -> 1000 B")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 5)
+	require.True(t, strings.HasPrefix(lines[2], "keep "))
+	require.Contains(t, lines[2], hex.EncodeToString(snaps[2].Header.GetIndexShortID()))
+	require.Contains(t, lines[3], hex.EncodeToString(snaps[1].Header.GetIndexShortID()))
+	require.Contains(t, lines[3], "reason=above max-size 2.4 KiB reclaim=2.0 KiB")
+	require.Contains(t, lines[4], "reclaim=0 B")
+
+	// young snapshots are never removed
+	bufOut.Reset()
+	cmd = &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-apply", "-max-size", "1B", "-min-age", "1h"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.NotContains(t, bufOut.String(), "prune: removal of")
+	require.Contains(t, bufErr.String(), "remains above the maximum size of 1 B")
+
+	bufOut.Reset()
+	cmd = &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-apply", "-max-size", "1500B"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), fmt.Sprintf("prune: removal of %x completed", snaps[0].Header.Identifier[:4]))
+	require.Contains(t, bufOut.String(), fmt.Sprintf("prune: removal of %x completed", snaps[1].Header.Identifier[:4]))
+	require.NotContains(t, bufOut.String(), fmt.Sprintf("prune: removal of %x completed", snaps[2].Header.Identifier[:4]))
+}
+
+func TestPrune_MinAgeProtectsPeriodDeletions(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snap1, snap2, ctx := generateRepoAndTwoSnaps(t, bufOut, bytes.NewBuffer(nil))
+	defer snap1.Close()
+	defer snap2.Close()
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"--per-minute=1", "-min-age", "1d"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 2 and delete 0 snapshot(s)")
+	require.Contains(t, bufOut.String(), "reason=younger than min-age 24h0m0s")
+}
+
+func TestPrune_HeldSnapshotsKept(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	repo, snaps, ctx := generateRepoAndThreeSnaps(t, bufOut, bytes.NewBuffer(nil))
+
+	require.NoError(t, utils.PutHold(repo, &utils.Hold{
+		Snapshot:  snaps[0].Header.Identifier,
+		CreatedAt: time.Now(),
+	}))
+
+	// the held snapshot is neither removed by a period rule nor to fit the
+	// maximum size
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"--per-minute=1", "-max-size", "1000"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "prune: would keep 2 and delete 1 snapshot(s)")
+	require.Contains(t, bufOut.String(), "reason=held indefinitely")
+	require.Contains(t, bufOut.String(), "prune: 1 held snapshot(s) would be refused")
+
+	cmd = &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"--per-minute=1", "-apply"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.ErrorIs(t, err, utils.ErrSnapshotHeld)
+	require.Equal(t, exitcodes.SnapshotHeld, status)
+
+	require.NoError(t, repo.RebuildState())
+	ids, err := locate.LocateSnapshotIDs(repo, locate.NewDefaultLocateOptions())
+	require.NoError(t, err)
+	require.ElementsMatch(t, ids, []objects.MAC{snaps[0].Header.Identifier, snaps[2].Header.Identifier})
+}
+
+func TestPrune_RetentionFromPolicy(t *testing.T) {
+	_, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	dir := t.TempDir()
+	ctx.ConfigDir = dir
+	policyYAML := "version: v1.0.0\npolicies:\n  bounded:\n    max_size: 1048576\n    min_age: 48h\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "policies.yml"), []byte(policyYAML), 0644))
+
+	cmd := &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-policy", "bounded"}))
+	require.Equal(t, uint64(1<<20), cmd.MaxSize)
+	require.Equal(t, 48*time.Hour, cmd.MinAge)
+
+	// the command line wins over the policy
+	cmd = &Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-policy", "bounded", "-max-size", "1GiB", "-min-age", "1w"}))
+	require.Equal(t, uint64(1<<30), cmd.MaxSize)
+	require.Equal(t, 7*24*time.Hour, cmd.MinAge)
+
+	require.Error(t, (&Prune{}).Parse(ctx, []string{"-max-size", "big"}))
+	require.Error(t, (&Prune{}).Parse(ctx, []string{"-max-size", "1GiB", "-min-age", "soon"}))
+}
+
 // TestMergePolicyOptions_PreservesPolicyFiltersWhenCLIEmpty is the regression
 // test for https://github.com/PlakarKorp/plakar/issues/1758
 //
@@ -0,0 +1,399 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package restore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/location"
+)
+
+// ConflictPolicy tells what to do with entries already present at the
+// restore target.
+type ConflictPolicy string
+
+const (
+	ConflictOverwrite        ConflictPolicy = "overwrite"
+	ConflictOverwriteIfNewer ConflictPolicy = "overwrite-if-newer"
+	ConflictSkip             ConflictPolicy = "skip"
+	ConflictRename           ConflictPolicy = "rename"
+	ConflictFail             ConflictPolicy = "fail"
+)
+
+func ParseConflictPolicy(value string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(value); policy {
+	case ConflictOverwrite, ConflictOverwriteIfNewer, ConflictSkip, ConflictRename, ConflictFail:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid conflict policy %q: must be one of overwrite, overwrite-if-newer, skip, rename or fail", value)
+}
+
+type outcome int
+
+const (
+	outcomeRestored outcome = iota
+	outcomeOverwritten
+	outcomeSkipped
+	outcomeRenamed
+	outcomeErrors
+)
+
+// Summary counts the outcome of each restored entry.  Directories are
+// only accounted for when they conflict with an existing entry.
+type Summary struct {
+	Restored    uint64
+	Overwritten uint64
+	Skipped     uint64
+	Renamed     uint64
+	Errors      uint64
+}
+
+func (s *Summary) add(o outcome) {
+	switch o {
+	case outcomeRestored:
+		s.Restored++
+	case outcomeOverwritten:
+		s.Overwritten++
+	case outcomeSkipped:
+		s.Skipped++
+	case outcomeRenamed:
+		s.Renamed++
+	case outcomeErrors:
+		s.Errors++
+	}
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d restored, %d overwritten, %d skipped, %d renamed, %d errors",
+		s.Restored, s.Overwritten, s.Skipped, s.Renamed, s.Errors)
+}
+
+// conflictExporter sits in front of the actual exporter and applies
+// the conflict policy to the records that would replace an existing
+// entry.  Conflicts can only be detected on local filesystems, other
+// exporters get every record.
+type conflictExporter struct {
+	exporter.Exporter
+
+	policy ConflictPolicy
+	suffix string
+	local  bool
+
+	// directories skipped or renamed, their content follows them
+	skippedDirs []string
+	renamedDirs map[string]string
+	conflict    error
+
+	// started, if set, is told where each entry is about to be
+	// restored, before it is
+	started func(original, target string) error
+
+	mu        sync.Mutex
+	pending   map[string]outcome
+	origins   map[string]string
+	reclaimed map[string]string
+	summary   Summary
+}
+
+func newConflictExporter(exp exporter.Exporter, policy ConflictPolicy, suffix string) *conflictExporter {
+	return &conflictExporter{
+		Exporter:    exp,
+		policy:      policy,
+		suffix:      suffix,
+		local:       exp.Flags()&location.FLAG_LOCALFS != 0,
+		renamedDirs: make(map[string]string),
+		pending:     make(map[string]outcome),
+		origins:     make(map[string]string),
+		reclaimed:   make(map[string]string),
+	}
+}
+
+// reclaim has the entry at pathname restored at target whatever is
+// there, as an interrupted restore left it.
+func (e *conflictExporter) reclaim(pathname, target string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reclaimed[pathname] = target
+}
+
+func (e *conflictExporter) Summary() Summary {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.summary
+}
+
+// OriginalPath returns the pathname a record had before being renamed.
+func (e *conflictExporter) OriginalPath(pathname string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if origin, ok := e.origins[pathname]; ok {
+		return origin
+	}
+	return pathname
+}
+
+func (e *conflictExporter) rename(record *connectors.Record, to string) {
+	e.mu.Lock()
+	e.origins[to] = record.Pathname
+	e.mu.Unlock()
+	record.Pathname = to
+}
+
+func (e *conflictExporter) record(pathname string, o outcome) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending[pathname] = o
+}
+
+func (e *conflictExporter) done(res *connectors.Result) {
+	if res.Record.IsXattr {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	o, ok := e.pending[res.Record.Pathname]
+	delete(e.pending, res.Record.Pathname)
+
+	if res.Err != nil {
+		e.summary.add(outcomeErrors)
+	} else if ok {
+		e.summary.add(o)
+	} else if !res.Record.FileInfo.IsDir() {
+		e.summary.add(outcomeRestored)
+	}
+}
+
+func (e *conflictExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+	defer close(results)
+
+	innerRecords := make(chan *connectors.Record, cap(records))
+	innerResults := make(chan *connectors.Result, cap(results))
+
+	forwarded := make(chan struct{})
+	go func() {
+		for res := range innerResults {
+			e.done(res)
+			results <- res
+		}
+		close(forwarded)
+	}()
+
+	exported := make(chan error, 1)
+	go func() {
+		exported <- e.Exporter.Export(ctx, innerRecords, innerResults)
+	}()
+
+	for record := range records {
+		if e.conflict != nil {
+			// drain so that the producer terminates
+			record.Close()
+			continue
+		}
+
+		if res := e.filter(record); res != nil {
+			e.done(res)
+			results <- res
+			continue
+		}
+		innerRecords <- record
+	}
+	close(innerRecords)
+
+	err := <-exported
+	<-forwarded
+
+	if e.conflict != nil {
+		return e.conflict
+	}
+	return err
+}
+
+// filter applies the policy to a record, possibly rewriting its path.
+// It returns a result if the record must not reach the exporter.
+func (e *conflictExporter) filter(record *connectors.Record) *connectors.Result {
+	if record.Err != nil || !e.local {
+		return nil
+	}
+
+	original := record.Pathname
+	res := e.applyPolicy(record)
+	if res == nil && !record.IsXattr && e.started != nil {
+		if err := e.started(original, record.Pathname); err != nil {
+			return record.Error(err)
+		}
+	}
+	return res
+}
+
+func (e *conflictExporter) applyPolicy(record *connectors.Record) *connectors.Result {
+	isDir := record.FileInfo.IsDir()
+
+	e.mu.Lock()
+	to, reclaimed := e.reclaimed[record.Pathname]
+	e.mu.Unlock()
+	if reclaimed {
+		return e.restoreAt(record, to)
+	}
+
+	for _, dir := range e.skippedDirs {
+		if isBelow(dir, record.Pathname) {
+			if !isDir {
+				e.record(record.Pathname, outcomeSkipped)
+			}
+			return record.Ok()
+		}
+	}
+
+	renamed := false
+	for dir, to := range e.renamedDirs {
+		if isBelow(dir, record.Pathname) {
+			e.rename(record, to+strings.TrimPrefix(record.Pathname, dir))
+			renamed = true
+			break
+		}
+	}
+
+	if record.IsXattr {
+		return nil
+	}
+
+	if renamed {
+		if !isDir {
+			e.record(record.Pathname, outcomeRenamed)
+		}
+		return nil
+	}
+
+	target := filepath.Join(e.Root(), filepath.FromSlash(record.Pathname))
+	existing, err := os.Lstat(target)
+	if err != nil {
+		return nil
+	}
+
+	// restoring a directory over an existing one merges them
+	if isDir && existing.IsDir() {
+		return nil
+	}
+
+	policy := e.policy
+	if policy == ConflictOverwriteIfNewer {
+		if record.FileInfo.ModTime().After(existing.ModTime()) {
+			policy = ConflictOverwrite
+		} else {
+			policy = ConflictSkip
+		}
+	}
+
+	switch policy {
+	case ConflictFail:
+		e.conflict = fmt.Errorf("%s: already exists", target)
+		return record.Error(e.conflict)
+
+	case ConflictSkip:
+		if isDir {
+			e.skippedDirs = append(e.skippedDirs, record.Pathname)
+		}
+		e.record(record.Pathname, outcomeSkipped)
+		return record.Ok()
+
+	case ConflictRename:
+		to, err := e.renameTarget(record.Pathname)
+		if err != nil {
+			return record.Error(err)
+		}
+		if isDir {
+			e.renamedDirs[record.Pathname] = to
+		}
+		e.rename(record, to)
+		e.record(record.Pathname, outcomeRenamed)
+		return nil
+
+	default:
+		// the exporter replaces regular files in place, anything
+		// else has to be removed first.
+		if isDir || !existing.Mode().IsRegular() || !record.FileInfo.Mode().IsRegular() {
+			if err := os.Remove(target); err != nil {
+				return record.Error(err)
+			}
+		}
+		e.record(record.Pathname, outcomeOverwritten)
+		return nil
+	}
+}
+
+// renameTarget returns the first of <pathname>.<suffix>,
+// <pathname>.<suffix>.1, ... not present at the target, so that a
+// renamed record never replaces an earlier copy.
+func (e *conflictExporter) renameTarget(pathname string) (string, error) {
+	base := pathname + "." + e.suffix
+	to := base
+	for i := 1; ; i++ {
+		_, err := os.Lstat(filepath.Join(e.Root(), filepath.FromSlash(to)))
+		if errors.Is(err, fs.ErrNotExist) {
+			return to, nil
+		} else if err != nil {
+			return "", err
+		}
+		to = fmt.Sprintf("%s.%d", base, i)
+	}
+}
+
+// restoreAt restores the record at to, replacing what is there.
+func (e *conflictExporter) restoreAt(record *connectors.Record, to string) *connectors.Result {
+	if to != record.Pathname {
+		if record.FileInfo.IsDir() {
+			e.renamedDirs[record.Pathname] = to
+		}
+		e.rename(record, to)
+		if !record.IsXattr && !record.FileInfo.IsDir() {
+			e.record(record.Pathname, outcomeRenamed)
+		}
+	}
+	if record.IsXattr {
+		return nil
+	}
+
+	target := filepath.Join(e.Root(), filepath.FromSlash(record.Pathname))
+	existing, err := os.Lstat(target)
+	if err != nil {
+		return nil
+	}
+	if record.FileInfo.IsDir() && existing.IsDir() {
+		return nil
+	}
+	if existing.IsDir() || !existing.Mode().IsRegular() || !record.FileInfo.Mode().IsRegular() {
+		if err := os.Remove(target); err != nil {
+			return record.Error(err)
+		}
+	}
+	return nil
+}
+
+func isBelow(dir, pathname string) bool {
+	return strings.HasPrefix(pathname, strings.TrimSuffix(dir, "/")+"/")
+}
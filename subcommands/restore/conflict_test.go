@@ -0,0 +1,180 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, value := range []string{"overwrite", "overwrite-if-newer", "skip", "rename", "fail"} {
+		policy, err := ParseConflictPolicy(value)
+		require.NoError(t, err)
+		require.Equal(t, ConflictPolicy(value), policy)
+	}
+
+	_, err := ParseConflictPolicy("clobber")
+	require.Error(t, err)
+
+	_, _, ctx := generateSnapshot(t)
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{}))
+	require.Equal(t, ConflictOverwrite, cmd.OptConflict)
+
+	cmd = &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-conflict", "clobber"}))
+}
+
+func restoreWithPolicy(t *testing.T, policy ConflictPolicy, setup func(dir string)) (string, Summary, error, *snapshot.Snapshot) {
+	_, snap, ctx := generateSnapshot(t)
+	t.Cleanup(func() { snap.Close() })
+
+	dir := mkRestoreDir(t)
+	setup(dir)
+
+	exp, err := exporter.NewExporter(ctx.GetInner(), ctx.ExporterOpts(), map[string]string{"location": dir})
+	require.NoError(t, err)
+	defer exp.Close(ctx)
+
+	conflictExp := newConflictExporter(exp, policy, fmt.Sprintf("%x", snap.Header.Identifier[:4]))
+	err = snap.Export(conflictExp, "/", &snapshot.ExportOptions{})
+	return dir, conflictExp.Summary(), err, snap
+}
+
+func writeExisting(t *testing.T, dir, name string, mtime time.Time) {
+	pathname := filepath.Join(dir, filepath.FromSlash(name))
+	require.NoError(t, os.MkdirAll(filepath.Dir(pathname), 0755))
+	require.NoError(t, os.WriteFile(pathname, []byte("mine"), 0644))
+	require.NoError(t, os.Chtimes(pathname, mtime, mtime))
+}
+
+func readRestored(t *testing.T, dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestConflictOverwrite(t *testing.T) {
+	dir, summary, err, _ := restoreWithPolicy(t, ConflictOverwrite, func(dir string) {
+		writeExisting(t, dir, "subdir/foo.txt", time.Now())
+	})
+	require.NoError(t, err)
+	require.Equal(t, "hello foo", readRestored(t, dir, "subdir/foo.txt"))
+	require.Equal(t, Summary{Restored: 2, Overwritten: 1}, summary)
+}
+
+func TestConflictSkip(t *testing.T) {
+	dir, summary, err, _ := restoreWithPolicy(t, ConflictSkip, func(dir string) {
+		writeExisting(t, dir, "subdir/foo.txt", time.Now())
+	})
+	require.NoError(t, err)
+	require.Equal(t, "mine", readRestored(t, dir, "subdir/foo.txt"))
+	require.Equal(t, "hello dummy", readRestored(t, dir, "subdir/dummy.txt"))
+	require.Equal(t, Summary{Restored: 2, Skipped: 1}, summary)
+}
+
+func TestConflictSkipDirectory(t *testing.T) {
+	dir, summary, err, _ := restoreWithPolicy(t, ConflictSkip, func(dir string) {
+		writeExisting(t, dir, "subdir", time.Now())
+	})
+	require.NoError(t, err)
+	require.Equal(t, "mine", readRestored(t, dir, "subdir"))
+	require.Equal(t, "hello bar", readRestored(t, dir, "another_subdir/bar.txt"))
+	require.Equal(t, Summary{Restored: 1, Skipped: 3}, summary)
+}
+
+func TestConflictOverwriteIfNewer(t *testing.T) {
+	// the mock snapshot entries carry no modification time, so any
+	// existing file is newer
+	dir, summary, err, _ := restoreWithPolicy(t, ConflictOverwriteIfNewer, func(dir string) {
+		writeExisting(t, dir, "subdir/foo.txt", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	})
+	require.NoError(t, err)
+	require.Equal(t, "mine", readRestored(t, dir, "subdir/foo.txt"))
+	require.Equal(t, Summary{Restored: 2, Skipped: 1}, summary)
+
+	_, _, ctx := generateSnapshot(t)
+	exp, err := exporter.NewExporter(ctx.GetInner(), ctx.ExporterOpts(), map[string]string{"location": dir})
+	require.NoError(t, err)
+	defer exp.Close(ctx)
+
+	conflictExp := newConflictExporter(exp, ConflictOverwriteIfNewer, "suffix")
+	record := connectors.NewRecord("/subdir/foo.txt", "", objects.FileInfo{
+		Lname:    "foo.txt",
+		Lmode:    0644,
+		LmodTime: time.Now(),
+	}, nil, nil)
+	require.Nil(t, conflictExp.filter(record))
+	require.Equal(t, outcomeOverwritten, conflictExp.pending["/subdir/foo.txt"])
+}
+
+func TestConflictRename(t *testing.T) {
+	dir, summary, err, snap := restoreWithPolicy(t, ConflictRename, func(dir string) {
+		writeExisting(t, dir, "subdir/foo.txt", time.Now())
+		writeExisting(t, dir, "another_subdir", time.Now())
+	})
+	require.NoError(t, err)
+
+	suffix := fmt.Sprintf("%x", snap.Header.Identifier[:4])
+	require.Equal(t, "mine", readRestored(t, dir, "subdir/foo.txt"))
+	require.Equal(t, "hello foo", readRestored(t, dir, "subdir/foo.txt."+suffix))
+	require.Equal(t, "mine", readRestored(t, dir, "another_subdir"))
+	require.Equal(t, "hello bar", readRestored(t, dir, "another_subdir."+suffix+"/bar.txt"))
+	require.Equal(t, Summary{Restored: 1, Renamed: 3}, summary)
+}
+
+func TestConflictRenameTwice(t *testing.T) {
+	_, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+	writeExisting(t, dir, "subdir/foo.txt", time.Now())
+
+	suffix := fmt.Sprintf("%x", snap.Header.Identifier[:4])
+	for range 2 {
+		exp, err := exporter.NewExporter(ctx.GetInner(), ctx.ExporterOpts(), map[string]string{"location": dir})
+		require.NoError(t, err)
+
+		conflictExp := newConflictExporter(exp, ConflictRename, suffix)
+		require.NoError(t, snap.Export(conflictExp, "/", &snapshot.ExportOptions{}))
+		require.NoError(t, exp.Close(ctx))
+	}
+
+	require.Equal(t, "mine", readRestored(t, dir, "subdir/foo.txt"))
+	require.Equal(t, "hello foo", readRestored(t, dir, "subdir/foo.txt."+suffix))
+	require.Equal(t, "hello foo", readRestored(t, dir, "subdir/foo.txt."+suffix+".1"))
+
+	// the second restore renamed the files of the first one
+	require.Equal(t, "hello bar", readRestored(t, dir, "another_subdir/bar.txt"))
+	require.Equal(t, "hello bar", readRestored(t, dir, "another_subdir/bar.txt."+suffix))
+}
+
+func TestConflictFail(t *testing.T) {
+	_, _, err, _ := restoreWithPolicy(t, ConflictFail, func(dir string) {
+		writeExisting(t, dir, "subdir/foo.txt", time.Now())
+	})
+	require.ErrorContains(t, err, "already exists")
+}
+
+func TestExecuteCmdRestoreConflictFail(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+	writeExisting(t, dir, "another_subdir/bar.txt", time.Now())
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-conflict", "fail", "-to", dir}))
+	status, err := cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, 1, status)
+	require.Equal(t, "mine", readRestored(t, dir, "another_subdir/bar.txt"))
+}
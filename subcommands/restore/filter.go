@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package restore
+
+import (
+	"context"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// filterExporter only passes to the actual exporter the records
+// selected by the include and exclude patterns.  Pathnames are matched
+// relative to the restored directory.
+type filterExporter struct {
+	exporter.Exporter
+
+	filter *utils.PathFilter
+
+	// directories not selected by themselves, only exported once an
+	// entry below them is.
+	pending []*connectors.Record
+}
+
+func newFilterExporter(exp exporter.Exporter, filter *utils.PathFilter) *filterExporter {
+	return &filterExporter{
+		Exporter: exp,
+		filter:   filter,
+	}
+}
+
+func (e *filterExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+	innerRecords := make(chan *connectors.Record, cap(records))
+
+	go func() {
+		for record := range records {
+			for _, selected := range e.selectRecord(record) {
+				innerRecords <- selected
+			}
+		}
+		for _, dir := range e.pending {
+			dir.Close()
+		}
+		e.pending = nil
+		close(innerRecords)
+	}()
+
+	return e.Exporter.Export(ctx, innerRecords, results)
+}
+
+// selectRecord returns the records to export for this one: none, the
+// record itself, or the record preceded by its pending parents.
+// Records come in walk order, a directory before its content.
+func (e *filterExporter) selectRecord(record *connectors.Record) []*connectors.Record {
+	if record.Err != nil || record.Pathname == "/" {
+		return []*connectors.Record{record}
+	}
+
+	for len(e.pending) != 0 && !isBelow(e.pending[len(e.pending)-1].Pathname, record.Pathname) {
+		e.pending[len(e.pending)-1].Close()
+		e.pending = e.pending[:len(e.pending)-1]
+	}
+
+	isDir := !record.IsXattr && record.FileInfo.IsDir()
+	if e.filter.Excludes(record.Pathname, isDir) {
+		record.Close()
+		return nil
+	}
+
+	if !e.filter.Includes(record.Pathname, isDir) {
+		if isDir {
+			e.pending = append(e.pending, record)
+		} else {
+			record.Close()
+		}
+		return nil
+	}
+
+	selected := append(e.pending, record)
+	e.pending = nil
+	return selected
+}
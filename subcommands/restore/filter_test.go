@@ -0,0 +1,53 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecuteCmdRestoreInclude(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-include", "foo.txt", "-include", "bar.txt", "-exclude", "another_subdir/", "-to", dir}))
+	require.Equal(t, []string{"foo.txt", "bar.txt"}, cmd.OptIncludes)
+
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.Equal(t, "hello foo", readRestored(t, dir, "subdir/foo.txt"))
+	_, err = os.Stat(filepath.Join(dir, "subdir", "dummy.txt"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "another_subdir"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestExecuteCmdRestoreExcludeFile(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+	excludeFile := filepath.Join(t.TempDir(), "excludes")
+	require.NoError(t, os.WriteFile(excludeFile, []byte("# restore everything but\ndummy.txt\n"), 0644))
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-exclude-file", excludeFile, "-to", dir}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.Equal(t, "hello foo", readRestored(t, dir, "subdir/foo.txt"))
+	require.Equal(t, "hello bar", readRestored(t, dir, "another_subdir/bar.txt"))
+	_, err = os.Stat(filepath.Join(dir, "subdir", "dummy.txt"))
+	require.True(t, os.IsNotExist(err))
+
+	cmd = &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-include-file", filepath.Join(t.TempDir(), "missing")}))
+}
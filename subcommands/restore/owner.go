@@ -0,0 +1,403 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/logging"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/pkg/xattr"
+)
+
+// IDMap maps the user or group IDs found in a snapshot to the IDs to
+// restore, it is set from repeated old:new flags.
+type IDMap map[uint64]uint64
+
+func (m IDMap) String() string {
+	keys := make([]uint64, 0, len(m))
+	for id := range m {
+		keys = append(keys, id)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	pairs := make([]string, 0, len(keys))
+	for _, id := range keys {
+		pairs = append(pairs, fmt.Sprintf("%d:%d", id, m[id]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m IDMap) Set(value string) error {
+	from, to, ok := strings.Cut(value, ":")
+	if !ok {
+		return fmt.Errorf("invalid mapping %q, expected old:new", value)
+	}
+	oldID, err := strconv.ParseUint(from, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mapping %q: %w", value, err)
+	}
+	newID, err := strconv.ParseUint(to, 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mapping %q: %w", value, err)
+	}
+	m[oldID] = newID
+	return nil
+}
+
+// Ownership describes how the owner of the restored entries is set.
+type Ownership struct {
+	// use the IDs recorded in the snapshot rather than looking up
+	// the user and group names on this host.
+	Numeric bool
+	UIDMap  IDMap
+	GIDMap  IDMap
+
+	// override everything else when set
+	UID *uint64
+	GID *uint64
+
+	mu     sync.Mutex
+	users  map[string]*uint64
+	groups map[string]*uint64
+}
+
+func NewOwnership() *Ownership {
+	return &Ownership{
+		UIDMap: make(IDMap),
+		GIDMap: make(IDMap),
+		users:  make(map[string]*uint64),
+		groups: make(map[string]*uint64),
+	}
+}
+
+// IsDefault returns true if no option alters the ownership recorded in
+// the snapshot.
+func (o *Ownership) IsDefault() bool {
+	return !o.Numeric && len(o.UIDMap) == 0 && len(o.GIDMap) == 0 && o.UID == nil && o.GID == nil
+}
+
+// SetOwner parses an owner given as user, user:group or :group, where
+// each part is either a name or a numeric ID.
+func (o *Ownership) SetOwner(value string) error {
+	owner, group, _ := strings.Cut(value, ":")
+	if owner == "" && group == "" {
+		return fmt.Errorf("invalid owner %q, expected user:group", value)
+	}
+
+	if owner != "" {
+		uid, err := lookupID(owner, func(name string) (string, error) {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", err
+			}
+			return u.Uid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("invalid owner %q: %w", owner, err)
+		}
+		o.UID = &uid
+	}
+
+	if group != "" {
+		gid, err := lookupID(group, func(name string) (string, error) {
+			g, err := user.LookupGroup(name)
+			if err != nil {
+				return "", err
+			}
+			return g.Gid, nil
+		})
+		if err != nil {
+			return fmt.Errorf("invalid group %q: %w", group, err)
+		}
+		o.GID = &gid
+	}
+	return nil
+}
+
+func lookupID(value string, lookup func(string) (string, error)) (uint64, error) {
+	if id, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return id, nil
+	}
+	id, err := lookup(value)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(id, 10, 32)
+}
+
+// Resolve returns the user and group IDs to restore an entry with.
+func (o *Ownership) Resolve(fileinfo objects.FileInfo) (uint64, uint64) {
+	uid, gid := fileinfo.Uid(), fileinfo.Gid()
+
+	if !o.Numeric {
+		if id := o.lookupUser(fileinfo.Username()); id != nil {
+			uid = *id
+		}
+		if id := o.lookupGroup(fileinfo.Groupname()); id != nil {
+			gid = *id
+		}
+	}
+
+	if id, ok := o.UIDMap[fileinfo.Uid()]; ok {
+		uid = id
+	}
+	if id, ok := o.GIDMap[fileinfo.Gid()]; ok {
+		gid = id
+	}
+
+	if o.UID != nil {
+		uid = *o.UID
+	}
+	if o.GID != nil {
+		gid = *o.GID
+	}
+	return uid, gid
+}
+
+func (o *Ownership) lookupUser(name string) *uint64 {
+	if name == "" {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if id, ok := o.users[name]; ok {
+		return id
+	}
+
+	var id *uint64
+	if u, err := user.Lookup(name); err == nil {
+		if uid, err := strconv.ParseUint(u.Uid, 10, 32); err == nil {
+			id = &uid
+		}
+	}
+	o.users[name] = id
+	return id
+}
+
+func (o *Ownership) lookupGroup(name string) *uint64 {
+	if name == "" {
+		return nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if id, ok := o.groups[name]; ok {
+		return id
+	}
+
+	var id *uint64
+	if g, err := user.LookupGroup(name); err == nil {
+		if gid, err := strconv.ParseUint(g.Gid, 10, 32); err == nil {
+			id = &gid
+		}
+	}
+	o.groups[name] = id
+	return id
+}
+
+type pendingOwner struct {
+	source string
+	uid    uint64
+	gid    uint64
+	xattrs []string
+}
+
+// ownerExporter sets the owner of the records passed to the actual
+// exporter.  On a local destination, it also applies the ownership and
+// the extended attributes recorded in the snapshot to the restored
+// entries, reporting those it could not apply.
+type ownerExporter struct {
+	exporter.Exporter
+
+	fs      *vfs.Filesystem
+	tostrip string
+	origin  func(string) string
+
+	owner   *Ownership
+	rewrite bool
+	chown   bool
+	xattrs  bool
+	logger  *logging.Logger
+
+	mu      sync.Mutex
+	pending map[string]pendingOwner
+	failed  uint64
+}
+
+// newOwnerExporter wraps exp for an export of the entries below
+// tostrip in fs, origin maps the pathname of a record to the one it had
+// in the export.  Ownership is only applied if chown is set, extended
+// attributes if xattrs is.
+func newOwnerExporter(exp exporter.Exporter, fs *vfs.Filesystem, tostrip string, origin func(string) string, owner *Ownership, chown bool, xattrs bool, logger *logging.Logger) *ownerExporter {
+	local := exp.Flags()&location.FLAG_LOCALFS != 0
+	return &ownerExporter{
+		Exporter: exp,
+		fs:       fs,
+		tostrip:  tostrip,
+		origin:   origin,
+		owner:    owner,
+		rewrite:  chown || !owner.IsDefault(),
+		chown:    chown && local,
+		xattrs:   xattrs && local,
+		logger:   logger,
+		pending:  make(map[string]pendingOwner),
+	}
+}
+
+// Failed returns the number of entries whose ownership or extended
+// attributes could not be applied.
+func (e *ownerExporter) Failed() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.failed
+}
+
+func (e *ownerExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+	defer close(results)
+
+	innerRecords := make(chan *connectors.Record, cap(records))
+	innerResults := make(chan *connectors.Result, cap(results))
+
+	forwarded := make(chan struct{})
+	go func() {
+		for res := range innerResults {
+			e.done(res)
+			results <- res
+		}
+		close(forwarded)
+	}()
+
+	exported := make(chan error, 1)
+	go func() {
+		exported <- e.Exporter.Export(ctx, innerRecords, innerResults)
+	}()
+
+	for record := range records {
+		e.prepare(record)
+		innerRecords <- record
+	}
+	close(innerRecords)
+
+	err := <-exported
+	<-forwarded
+	return err
+}
+
+// prepare sets the owner of the record, the destination directory
+// itself is left as is.
+func (e *ownerExporter) prepare(record *connectors.Record) {
+	if record.Err != nil || record.IsXattr || record.Pathname == "/" {
+		return
+	}
+
+	uid, gid := record.FileInfo.Uid(), record.FileInfo.Gid()
+	if e.rewrite {
+		uid, gid = e.owner.Resolve(record.FileInfo)
+		record.FileInfo.Luid = uid
+		record.FileInfo.Lgid = gid
+	}
+
+	if !e.chown && (!e.xattrs || len(record.ExtendedAttributes) == 0) {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pending[record.Pathname] = pendingOwner{
+		source: path.Join(e.tostrip, e.origin(record.Pathname)),
+		uid:    uid,
+		gid:    gid,
+		xattrs: record.ExtendedAttributes,
+	}
+}
+
+func (e *ownerExporter) done(res *connectors.Result) {
+	e.mu.Lock()
+	p, ok := e.pending[res.Record.Pathname]
+	if ok && !res.Record.IsXattr {
+		delete(e.pending, res.Record.Pathname)
+	}
+	e.mu.Unlock()
+
+	if !ok || res.Record.IsXattr || res.Err != nil {
+		return
+	}
+
+	target := filepath.Join(e.Root(), filepath.FromSlash(res.Record.Pathname))
+
+	failed := false
+	if e.chown {
+		if err := os.Lchown(target, int(p.uid), int(p.gid)); err != nil {
+			e.logger.Warn("restore: %s: could not set owner %d:%d: %s",
+				utils.SanitizeText(res.Record.Pathname), p.uid, p.gid, err)
+			failed = true
+		}
+	}
+
+	if e.xattrs {
+		for _, name := range p.xattrs {
+			if err := e.setXattr(target, p.source, name); err != nil {
+				e.logger.Warn("restore: %s: could not set extended attribute %s: %s",
+					utils.SanitizeText(res.Record.Pathname), utils.SanitizeText(name), err)
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		e.mu.Lock()
+		e.failed++
+		e.mu.Unlock()
+	}
+}
+
+func (e *ownerExporter) setXattr(target string, source string, name string) error {
+	entry, err := e.fs.GetEntry(source)
+	if err != nil {
+		return err
+	}
+
+	rd, err := entry.Xattr(e.fs, name)
+	if err != nil {
+		return err
+	}
+
+	value, err := io.ReadAll(rd)
+	if err != nil {
+		return err
+	}
+	return xattr.LSet(target, name, value)
+}
@@ -0,0 +1,221 @@
+//go:build unix
+
+package restore
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/pkg/xattr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIDMap(t *testing.T) {
+	m := make(IDMap)
+	require.NoError(t, m.Set("1000:2000"))
+	require.NoError(t, m.Set("0:65534"))
+	require.Equal(t, IDMap{1000: 2000, 0: 65534}, m)
+	require.Equal(t, "0:65534,1000:2000", m.String())
+
+	require.Error(t, m.Set("1000"))
+	require.Error(t, m.Set("alice:2000"))
+	require.Error(t, m.Set("1000:-1"))
+}
+
+func TestOwnershipResolve(t *testing.T) {
+	fileinfo := objects.FileInfo{
+		Luid:       1000,
+		Lgid:       1000,
+		Lusername:  "root",
+		Lgroupname: "no-such-group-here",
+	}
+
+	owner := NewOwnership()
+	require.True(t, owner.IsDefault())
+	uid, gid := owner.Resolve(fileinfo)
+	require.Equal(t, uint64(0), uid, "user name looked up on this host")
+	require.Equal(t, uint64(1000), gid, "unknown group name falls back to its ID")
+
+	owner.Numeric = true
+	uid, gid = owner.Resolve(fileinfo)
+	require.Equal(t, uint64(1000), uid)
+	require.Equal(t, uint64(1000), gid)
+
+	require.NoError(t, owner.UIDMap.Set("1000:2000"))
+	require.NoError(t, owner.GIDMap.Set("1000:3000"))
+	uid, gid = owner.Resolve(fileinfo)
+	require.Equal(t, uint64(2000), uid)
+	require.Equal(t, uint64(3000), gid)
+
+	require.NoError(t, owner.SetOwner(":42"))
+	uid, gid = owner.Resolve(fileinfo)
+	require.Equal(t, uint64(2000), uid)
+	require.Equal(t, uint64(42), gid)
+	require.False(t, owner.IsDefault())
+}
+
+func TestOwnershipSetOwner(t *testing.T) {
+	owner := NewOwnership()
+	require.NoError(t, owner.SetOwner("root:0"))
+	require.Equal(t, uint64(0), *owner.UID)
+	require.Equal(t, uint64(0), *owner.GID)
+
+	owner = NewOwnership()
+	require.NoError(t, owner.SetOwner("12"))
+	require.Equal(t, uint64(12), *owner.UID)
+	require.Nil(t, owner.GID)
+
+	require.Error(t, NewOwnership().SetOwner(":"))
+	require.Error(t, NewOwnership().SetOwner("no-such-user-here"))
+	require.Error(t, NewOwnership().SetOwner(":no-such-group-here"))
+}
+
+func TestParseRestoreOwnership(t *testing.T) {
+	_, _, ctx := generateSnapshot(t)
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-numeric-owner", "-map-uid", "1:2", "-map-gid", "3:4", "-owner", "5:6"}))
+	require.True(t, cmd.Ownership.Numeric)
+	require.Equal(t, IDMap{1: 2}, cmd.Ownership.UIDMap)
+	require.Equal(t, IDMap{3: 4}, cmd.Ownership.GIDMap)
+	require.Equal(t, uint64(5), *cmd.Ownership.UID)
+	require.Equal(t, uint64(6), *cmd.Ownership.GID)
+	require.True(t, cmd.OptXattrs)
+
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-no-xattrs"}))
+	require.False(t, cmd.OptXattrs)
+
+	cmd = &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-xattrs", "-no-xattrs"}))
+
+	cmd = &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-skip-permissions", "-owner", "0"}))
+}
+
+func statOwner(t *testing.T, pathname string) (uint32, uint32) {
+	info, err := os.Lstat(pathname)
+	require.NoError(t, err)
+	st := info.Sys().(*syscall.Stat_t)
+	return st.Uid, st.Gid
+}
+
+func TestExecuteCmdRestoreOwner(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	uid, gid := os.Getuid(), os.Getgid()
+
+	dir := mkRestoreDir(t)
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-owner", fmt.Sprintf("%d:%d", uid, gid)}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	for _, name := range []string{"subdir", "subdir/foo.txt", "another_subdir/bar.txt"} {
+		fuid, fgid := statOwner(t, filepath.Join(dir, filepath.FromSlash(name)))
+		require.Equal(t, uint32(uid), fuid, name)
+		require.Equal(t, uint32(gid), fgid, name)
+	}
+}
+
+// generateXattrSnapshot creates a snapshot of a file carrying a
+// user.comment extended attribute.
+func generateXattrSnapshot(t *testing.T) (*repository.Repository, *snapshot.Snapshot, *appcontext.AppContext) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	mtime := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	gen := func(ch chan<- *connectors.Record) {
+		ch <- &connectors.Record{
+			Pathname: "/",
+			FileInfo: objects.NewFileInfo("/", 0, 0755|os.ModeDir, mtime, 0, 0, 0, 0, 1),
+		}
+		ch <- connectors.NewRecord("/file.txt", "",
+			objects.NewFileInfo("file.txt", 5, 0644, mtime, 0, 0, 0, 0, 1),
+			[]string{"user.comment"},
+			func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("hello")), nil })
+		ch <- connectors.NewXattr("/file.txt", "user.comment", objects.AttributeExtended,
+			func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("precious")), nil })
+	}
+
+	snap := ptesting.GenerateSnapshot(t, repo, nil, ptesting.WithGenerator(gen))
+	t.Cleanup(func() { snap.Close() })
+	return repo, snap, ctx
+}
+
+func TestExecuteCmdRestoreXattrs(t *testing.T) {
+	dir := mkRestoreDir(t)
+	probe := filepath.Join(dir, "probe")
+	require.NoError(t, os.WriteFile(probe, nil, 0644))
+	if err := xattr.LSet(probe, "user.probe", []byte("x")); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+	require.NoError(t, os.Remove(probe))
+
+	repo, _, ctx := generateXattrSnapshot(t)
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	value, err := xattr.LGet(filepath.Join(dir, "file.txt"), "user.comment")
+	require.NoError(t, err)
+	require.Equal(t, "precious", string(value))
+
+	dir = mkRestoreDir(t)
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-no-xattrs"}))
+	_, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+
+	_, err = xattr.LGet(filepath.Join(dir, "file.txt"), "user.comment")
+	require.Error(t, err)
+}
+
+func TestOwnerExporterReportsFailures(t *testing.T) {
+	_, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	pvfs, err := snap.Filesystem()
+	require.NoError(t, err)
+
+	dir := mkRestoreDir(t)
+	exp, err := exporter.NewExporter(ctx.GetInner(), ctx.ExporterOpts(), map[string]string{"location": dir})
+	require.NoError(t, err)
+	defer exp.Close(ctx)
+
+	ownerExp := newOwnerExporter(exp, pvfs, "", func(pathname string) string { return pathname },
+		NewOwnership(), false, true, ctx.GetLogger())
+
+	records := make(chan *connectors.Record, 1)
+	results := make(chan *connectors.Result, 1)
+	records <- connectors.NewRecord("/file.txt", "",
+		objects.NewFileInfo("file.txt", 2, 0644, time.Now(), 0, 0, 0, 0, 1),
+		[]string{"user.missing"},
+		func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("hi")), nil })
+	close(records)
+
+	go func() {
+		for range results {
+		}
+	}()
+	require.NoError(t, ownerExp.Export(ctx, records, results))
+	require.Equal(t, uint64(1), ownerExp.Failed())
+	require.Equal(t, "hi", readRestored(t, dir, "file.txt"))
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package restore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// locateAt returns the newest snapshot taken before -at that contains
+// the pathname, followed by the older ones -merge can draw from.
+func (cmd *Restore) locateAt(repo *repository.Repository) (objects.MAC, []objects.MAC, error) {
+	locateOptions := locate.NewDefaultLocateOptions()
+	locateOptions.Filters.Before = cmd.OptAt
+	locateOptions.Filters.Since = cmd.OptSince
+	locateOptions.Filters.Name = cmd.OptName
+	locateOptions.Filters.Category = cmd.OptCategory
+	locateOptions.Filters.Environment = cmd.OptEnvironment
+	locateOptions.Filters.Perimeter = cmd.OptPerimeter
+	locateOptions.Filters.Job = cmd.OptJob
+	locateOptions.Filters.Tags = []string{cmd.OptTag}
+
+	snapshotIDs, err := locate.LocateSnapshotIDs(repo, locateOptions)
+	if err != nil {
+		return objects.MAC{}, nil, fmt.Errorf("could not fetch snapshots list: %w", err)
+	}
+
+	// newest first
+	for i, snapshotID := range snapshotIDs {
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			return objects.MAC{}, nil, err
+		}
+		found := hasEntry(snap, cmd.Pathname)
+		snap.Close()
+
+		if found {
+			return snapshotID, snapshotIDs[i+1:], nil
+		}
+	}
+
+	if len(snapshotIDs) == 0 {
+		return objects.MAC{}, nil, fmt.Errorf("no snapshots found")
+	}
+	return objects.MAC{}, nil, fmt.Errorf("no snapshot found containing %s", cmd.Pathname)
+}
+
+func hasEntry(snap *snapshot.Snapshot, pathname string) bool {
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return false
+	}
+	_, err = fs.GetEntry(pathname)
+	return err == nil
+}
+
+// merge restores the entries below pathname that snap failed to back
+// up, taking for each one its newest version among the older snapshots.
+// It fails if some of them are found in none.
+func (cmd *Restore) merge(ctx *appcontext.AppContext, repo *repository.Repository, snap *snapshot.Snapshot, pathname string, older []objects.MAC, exp exporter.Exporter, filter *utils.PathFilter, chown bool, opts *snapshot.ExportOptions) error {
+	pvfs, err := snap.Filesystem()
+	if err != nil {
+		return err
+	}
+
+	entry, err := pvfs.GetEntry(pathname)
+	if err != nil {
+		return err
+	}
+	if !entry.IsDir() {
+		return nil
+	}
+	pathname = entry.Path()
+
+	// errors are listed in order, a directory before what is below it
+	var failed []string
+	for item, err := range pvfs.Errors(pathname) {
+		if err != nil {
+			return fmt.Errorf("failed to scan errors: %w", err)
+		}
+		if len(failed) != 0 && isBelow(failed[len(failed)-1], item.Name) {
+			continue
+		}
+		if _, err := pvfs.GetEntry(item.Name); err == nil {
+			continue
+		}
+		failed = append(failed, item.Name)
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	sources := make(map[objects.MAC]*snapshot.Snapshot)
+	defer func() {
+		for _, src := range sources {
+			src.Close()
+		}
+	}()
+
+	var missing int
+	for _, name := range failed {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		relpath := "/" + strings.TrimLeft(strings.TrimPrefix(name, pathname), "/")
+
+		var src *snapshot.Snapshot
+		for _, snapshotID := range older {
+			candidate, ok := sources[snapshotID]
+			if !ok {
+				candidate, err = snapshot.Load(repo, snapshotID)
+				if err != nil {
+					return err
+				}
+				sources[snapshotID] = candidate
+			}
+			if hasEntry(candidate, name) {
+				src = candidate
+				break
+			}
+		}
+
+		if src == nil {
+			ctx.GetLogger().Warn("restore: %s: no older version found", utils.SanitizeText(relpath))
+			missing++
+			continue
+		}
+
+		srcfs, err := src.Filesystem()
+		if err != nil {
+			return err
+		}
+		e, err := srcfs.GetEntry(name)
+		if err != nil {
+			return err
+		}
+
+		// a directory is exported as the root of its records, a file
+		// below the root of its parent.
+		prefix := relpath
+		if !e.IsDir() {
+			prefix = path.Dir(relpath)
+		}
+
+		parents, err := parentRecords(pvfs, pathname, relpath)
+		if err != nil {
+			return err
+		}
+
+		// rebased records are relative to the restored directory,
+		// which has the same path in both snapshots.
+		var conflictExp *conflictExporter
+		ownerExp := newOwnerExporter(exp, srcfs, pathname,
+			func(pathname string) string { return conflictExp.OriginalPath(pathname) },
+			cmd.Ownership, chown, cmd.OptXattrs, ctx.GetLogger())
+		conflictExp = newConflictExporter(ownerExp, cmd.OptConflict, fmt.Sprintf("%x", src.Header.Identifier[:4]))
+
+		var mergeExp exporter.Exporter = conflictExp
+		if !filter.IsEmpty() {
+			mergeExp = newFilterExporter(mergeExp, filter)
+		}
+		mergeExp = newRebaseExporter(mergeExp, prefix, parents)
+
+		err = src.Export(mergeExp, name, opts)
+		ctx.GetLogger().Info("restore: %x: merged %s: %s", src.Header.Identifier[:4],
+			utils.SanitizeText(relpath), conflictExp.Summary())
+		if n := ownerExp.Failed(); n != 0 {
+			ctx.GetLogger().Warn("restore: %x: ownership or extended attributes could not be applied to %d entries",
+				src.Header.Identifier[:4], n)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if missing != 0 {
+		return fmt.Errorf("restore: %d entries could not be merged from older snapshots", missing)
+	}
+	return nil
+}
+
+// parentRecords returns the records for the directories from the
+// restored root down to the parent of relpath, as found in the snapshot.
+func parentRecords(pvfs *vfs.Filesystem, root string, relpath string) ([]*connectors.Record, error) {
+	var records []*connectors.Record
+
+	dir := path.Dir(relpath)
+	for {
+		e, err := pvfs.GetEntry(path.Join(root, dir))
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, connectors.NewRecord(dir, "", e.FileInfo, nil,
+			func() (io.ReadCloser, error) {
+				return nil, nil
+			}))
+		if dir == "/" {
+			break
+		}
+		dir = path.Dir(dir)
+	}
+
+	slices.Reverse(records)
+	return records, nil
+}
+
+// rebaseExporter moves the records of an export below prefix, after
+// the records of its parent directories.  Records for these parents
+// coming from the export are dropped.
+type rebaseExporter struct {
+	exporter.Exporter
+
+	prefix  string
+	parents []*connectors.Record
+}
+
+func newRebaseExporter(exp exporter.Exporter, prefix string, parents []*connectors.Record) *rebaseExporter {
+	return &rebaseExporter{
+		Exporter: exp,
+		prefix:   prefix,
+		parents:  parents,
+	}
+}
+
+func (e *rebaseExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+	innerRecords := make(chan *connectors.Record, cap(records))
+
+	go func() {
+		sent := make(map[string]struct{})
+		for _, parent := range e.parents {
+			sent[parent.Pathname] = struct{}{}
+			innerRecords <- parent
+		}
+
+		for record := range records {
+			record.Pathname = path.Join(e.prefix, record.Pathname)
+			if _, ok := sent[record.Pathname]; ok && !record.IsXattr {
+				record.Close()
+				continue
+			}
+			innerRecords <- record
+		}
+		close(innerRecords)
+	}()
+
+	return e.Exporter.Export(ctx, innerRecords, results)
+}
@@ -0,0 +1,133 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+// generateHistory creates two snapshots of the same tree, the second
+// one failing to back up subdir/foo.txt, and returns a time between
+// them.
+func generateHistory(t *testing.T) (*repository.Repository, *appcontext.AppContext, time.Time) {
+	repo, ctx := ptesting.GenerateRepository(t, nil, nil, nil)
+
+	first := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "old dummy"),
+		ptesting.NewMockFile("subdir/foo.txt", 0644, "old foo"),
+	})
+	first.Close()
+
+	time.Sleep(10 * time.Millisecond)
+	between := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	second := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "new dummy"),
+		ptesting.NewMockFile("subdir/foo.txt", 0000, "new foo"),
+		ptesting.NewMockFile("another_subdir/bar.txt", 0644, "new bar"),
+	})
+	second.Close()
+
+	return repo, ctx, between
+}
+
+func TestParseRestoreAt(t *testing.T) {
+	_, _, ctx := generateSnapshot(t)
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-at", "2026-03-01", "subdir/"}))
+	require.Equal(t, "/subdir", cmd.Pathname)
+	require.Equal(t, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), cmd.OptAt.UTC())
+
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-merge"}))
+	require.Equal(t, "/", cmd.Pathname)
+
+	cmd = &Restore{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{"-since", "2026-03-01"}), "-since requires -merge")
+
+	cmd = &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-merge", "-verify"}))
+
+	cmd = &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-at", "2026-03-01", "/subdir", "/another_subdir"}))
+}
+
+func TestExecuteCmdRestoreAt(t *testing.T) {
+	repo, ctx, between := generateHistory(t)
+
+	dir := mkRestoreDir(t)
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-at", between.Format(time.RFC3339Nano), "/subdir"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.Equal(t, "old dummy", readRestored(t, dir, "dummy.txt"))
+	require.Equal(t, "old foo", readRestored(t, dir, "foo.txt"))
+}
+
+func TestExecuteCmdRestoreAtNotFound(t *testing.T) {
+	repo, ctx, between := generateHistory(t)
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", mkRestoreDir(t), "-at", between.Format(time.RFC3339Nano), "/another_subdir"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "no snapshot found containing /another_subdir")
+	require.Equal(t, 1, status)
+
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", mkRestoreDir(t), "-at", "2000-01-01", "/subdir"}))
+	status, err = cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "no snapshots found")
+	require.Equal(t, 1, status)
+}
+
+func TestExecuteCmdRestoreMerge(t *testing.T) {
+	repo, ctx, _ := generateHistory(t)
+
+	// without merging, the latest snapshot lacks foo.txt
+	dir := mkRestoreDir(t)
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-at", time.Now().Format(time.RFC3339Nano), "/"}))
+	_, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "subdir", "foo.txt"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	dir = mkRestoreDir(t)
+	cmd = &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-merge", "/"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.Equal(t, "new dummy", readRestored(t, dir, "subdir/dummy.txt"))
+	require.Equal(t, "old foo", readRestored(t, dir, "subdir/foo.txt"))
+	require.Equal(t, "new bar", readRestored(t, dir, "another_subdir/bar.txt"))
+
+	info, err := os.Stat(filepath.Join(dir, "subdir"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
+
+func TestExecuteCmdRestoreMergeWindow(t *testing.T) {
+	repo, ctx, between := generateHistory(t)
+
+	dir := mkRestoreDir(t)
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir, "-merge", "-since", between.Format(time.RFC3339Nano), "/subdir"}))
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "1 entries could not be merged")
+	require.Equal(t, 1, status)
+	require.Equal(t, "new dummy", readRestored(t, dir, "dummy.txt"))
+}
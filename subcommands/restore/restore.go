@@ -20,15 +20,20 @@ import (
 	"flag"
 	"fmt"
 	"maps"
+	"os"
 	"path"
 	"strings"
 	"time"
 
 	"github.com/PlakarKorp/kloset/connectors/exporter"
 	"github.com/PlakarKorp/kloset/locate"
+	"github.com/PlakarKorp/kloset/location"
+	"github.com/PlakarKorp/kloset/objects"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exitcodes"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 )
@@ -43,11 +48,22 @@ type Restore struct {
 	OptJob             string
 	OptTag             string
 	OptSkipPermissions bool
+	OptConflict        ConflictPolicy
+	OptIncludes        []string
+	OptExcludes        []string
+	OptVerify          bool
+	OptResume          bool
+	OptAt              time.Time
+	OptSince           time.Time
+	OptMerge           bool
+	OptXattrs          bool
+	Ownership          *Ownership
 	Opts               map[string]string
 
 	Target    string
 	Strip     string
 	Snapshots []string
+	Pathname  string
 }
 
 func init() {
@@ -56,12 +72,18 @@ func init() {
 
 func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 	var pullPath string
+	var optConflict string
+	var optIncludes, optExcludes utils.PatternsFlag
+	var optIncludeFiles, optExcludeFiles utils.PatternsFlag
+	var optXattrs, optNoXattrs bool
 
 	cmd.Opts = make(map[string]string)
+	cmd.Ownership = NewOwnership()
 
 	flags := flag.NewFlagSet("restore", flag.ExitOnError)
 	flags.Usage = func() {
 		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS] [SNAPSHOT[:PATH]]...\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s [OPTIONS] -at TIME [-merge] [PATH]\n", flags.Name())
 		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
 		flags.PrintDefaults()
 	}
@@ -73,12 +95,76 @@ func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 	flags.StringVar(&cmd.OptJob, "job", "", "filter by job")
 	flags.StringVar(&cmd.OptTag, "tag", "", "filter by tag")
 	flags.Var(utils.NewOptsFlag(cmd.Opts), "o", "specify extra exporter options")
+	flags.Var(locate.NewTimeFlag(&cmd.OptAt), "at", "restore PATH from the latest snapshot taken before this time")
+	flags.BoolVar(&cmd.OptMerge, "merge", false, "restore the entries the snapshot failed to back up from older snapshots")
+	flags.Var(locate.NewTimeFlag(&cmd.OptSince), "since", "only merge from snapshots taken after this time")
 
 	flags.StringVar(&pullPath, "to", "", "base directory where pull will restore")
 	flags.BoolVar(&cmd.OptSkipPermissions, "skip-permissions", false, "do not restore file permissions")
+	flags.BoolVar(&cmd.Ownership.Numeric, "numeric-owner", false, "restore the user and group IDs recorded in the snapshot, without looking up their names")
+	flags.Var(cmd.Ownership.UIDMap, "map-uid", "restore the files owned by user ID old as owned by new, as old:new (can be specified multiple times)")
+	flags.Var(cmd.Ownership.GIDMap, "map-gid", "restore the files owned by group ID old as owned by new, as old:new (can be specified multiple times)")
+	flags.Func("owner", "restore all files with this owner, as user, user:group or :group", cmd.Ownership.SetOwner)
+	flags.BoolVar(&optXattrs, "xattrs", false, "restore the extended attributes recorded in the snapshot (default)")
+	flags.BoolVar(&optNoXattrs, "no-xattrs", false, "do not restore extended attributes")
+	flags.StringVar(&optConflict, "conflict", string(ConflictOverwrite), "what to do with existing files: overwrite, overwrite-if-newer, skip, rename or fail")
+	flags.BoolVar(&cmd.OptResume, "resume", false, "resume an interrupted restore, skipping the files already restored")
+	flags.BoolVar(&cmd.OptVerify, "verify", false, "verify the restored files against the snapshot")
+	flags.Var(&optIncludes, "include", "only restore pathnames matching this pattern (can be specified multiple times)")
+	flags.Var(&optExcludes, "exclude", "do not restore pathnames matching this pattern (can be specified multiple times)")
+	flags.Var(&optIncludeFiles, "include-file", "file containing include patterns, one per line (can be specified multiple times)")
+	flags.Var(&optExcludeFiles, "exclude-file", "file containing exclude patterns, one per line (can be specified multiple times)")
 	flags.Parse(args)
 
-	if flags.NArg() != 0 {
+	conflict, err := ParseConflictPolicy(optConflict)
+	if err != nil {
+		return err
+	}
+
+	if cmd.OptVerify && conflict != ConflictOverwrite && conflict != ConflictFail {
+		return fmt.Errorf("-verify requires -conflict overwrite or fail")
+	}
+
+	for _, includeFile := range optIncludeFiles {
+		lines, err := utils.LoadIgnoreFile(includeFile)
+		if err != nil {
+			return err
+		}
+		optIncludes = append(optIncludes, lines...)
+	}
+
+	for _, excludeFile := range optExcludeFiles {
+		lines, err := utils.LoadIgnoreFile(excludeFile)
+		if err != nil {
+			return err
+		}
+		optExcludes = append(optExcludes, lines...)
+	}
+
+	if optXattrs && optNoXattrs {
+		return fmt.Errorf("-xattrs and -no-xattrs are mutually exclusive")
+	}
+	if cmd.OptSkipPermissions && !cmd.Ownership.IsDefault() {
+		return fmt.Errorf("-skip-permissions cannot be used with ownership options")
+	}
+
+	if !cmd.OptSince.IsZero() && !cmd.OptMerge {
+		return fmt.Errorf("-since requires -merge")
+	}
+	if cmd.OptMerge && cmd.OptVerify {
+		return fmt.Errorf("-verify cannot be used with -merge")
+	}
+
+	if !cmd.OptAt.IsZero() || cmd.OptMerge {
+		// arguments are pathnames, the snapshot is located
+		if flags.NArg() > 1 {
+			return fmt.Errorf("multiple restore paths specified, please specify only one")
+		}
+		cmd.Pathname = "/"
+		if flags.NArg() == 1 {
+			cmd.Pathname = path.Clean("/" + flags.Arg(0))
+		}
+	} else if flags.NArg() != 0 {
 		if cmd.OptName != "" || cmd.OptCategory != "" || cmd.OptEnvironment != "" || cmd.OptPerimeter != "" || cmd.OptJob != "" || cmd.OptTag != "" {
 			ctx.GetLogger().Warn("snapshot specified, filters will be ignored")
 		}
@@ -92,6 +178,10 @@ func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 
 	cmd.RepositorySecret = ctx.GetSecret()
 	cmd.Target = pullPath
+	cmd.OptConflict = conflict
+	cmd.OptXattrs = !optNoXattrs
+	cmd.OptIncludes = optIncludes
+	cmd.OptExcludes = optExcludes
 	cmd.Snapshots = flags.Args()
 
 	return nil
@@ -99,7 +189,15 @@ func (cmd *Restore) Parse(ctx *appcontext.AppContext, args []string) error {
 
 func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
 	var snapshots []string
-	if len(cmd.Snapshots) == 0 {
+	var older []objects.MAC
+	if !cmd.OptAt.IsZero() || cmd.OptMerge {
+		snapshotID, rest, err := cmd.locateAt(repo)
+		if err != nil {
+			return 1, err
+		}
+		snapshots = append(snapshots, fmt.Sprintf("%x:%s", snapshotID, cmd.Pathname))
+		older = rest
+	} else if len(cmd.Snapshots) == 0 {
 		locateOptions := locate.NewDefaultLocateOptions()
 		locateOptions.Filters.Latest = true
 
@@ -174,11 +272,30 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 	}
 	defer exporterInstance.Close(ctx)
 
+	filter, err := utils.NewPathFilter(cmd.OptIncludes, cmd.OptExcludes)
+	if err != nil {
+		return 1, err
+	}
+
+	// progress can only be recorded for local destinations, where the
+	// restored files can be checked when resuming.
+	local := exporterInstance.Flags()&location.FLAG_LOCALFS != 0
+	if cmd.OptVerify && !local {
+		return 1, fmt.Errorf("-verify requires a local destination")
+	}
+	if cmd.OptResume && !local {
+		return 1, fmt.Errorf("-resume requires a local destination")
+	}
+
 	opts := &snapshot.ExportOptions{}
 	if cmd.OptSkipPermissions {
 		opts.SkipPermissions = true
 	}
 
+	// as with tar, only root restores the recorded ownership unless
+	// told otherwise.
+	chown := !cmd.OptSkipPermissions && (os.Geteuid() == 0 || !cmd.Ownership.IsDefault())
+
 	for _, snapPath := range snapshots {
 		snap, pathname, relative, err := locate.OpenSnapshotByPathRelative(repo, snapPath)
 		if err != nil {
@@ -193,12 +310,96 @@ func (cmd *Restore) Execute(ctx *appcontext.AppContext, repo *repository.Reposit
 			}
 		}
 
-		err = snap.Export(exporterInstance, pathname, opts)
+		pvfs, err := snap.Filesystem()
+		if err != nil {
+			snap.Close()
+			return 1, err
+		}
+		entry, err := pvfs.GetEntry(pathname)
 		if err != nil {
+			snap.Close()
 			return 1, err
 		}
 
+		// ownership is applied where the conflict policy restored
+		// the entries
+		var conflictExp *conflictExporter
+		ownerExp := newOwnerExporter(exporterInstance, pvfs, exportRoot(entry),
+			func(pathname string) string { return conflictExp.OriginalPath(pathname) },
+			cmd.Ownership, chown, cmd.OptXattrs, ctx.GetLogger())
+		conflictExp = newConflictExporter(ownerExp, cmd.OptConflict, fmt.Sprintf("%x", snap.Header.Identifier[:4]))
+
+		var exp exporter.Exporter = conflictExp
+		var resumeExp *resumeExporter
+		if cmd.OptResume {
+			journal, err := openJournal(JournalPath(ctx.CacheDir, snap.Header.Identifier, exporterInstance.Root(), pathname), cmd.OptResume)
+			if err != nil {
+				snap.Close()
+				return 1, fmt.Errorf("failed to open restore journal: %w", err)
+			}
+			resumeExp = newResumeExporter(conflictExp, repo, journal)
+			exp = resumeExp
+		}
+		if !filter.IsEmpty() {
+			exp = newFilterExporter(exp, filter)
+		}
+
+		err = snap.Export(exp, pathname, opts)
+		if resumeExp != nil {
+			if n := resumeExp.Resumed(); n != 0 {
+				ctx.GetLogger().Info("restore: %x: %d files already restored", snap.Header.Identifier[:4], n)
+			}
+			if err == nil && resumeExp.Complete() {
+				resumeExp.journal.Remove()
+			} else {
+				resumeExp.journal.Close()
+			}
+		}
+		ctx.GetLogger().Info("restore: %x: %s", snap.Header.Identifier[:4], conflictExp.Summary())
+		if n := ownerExp.Failed(); n != 0 {
+			ctx.GetLogger().Warn("restore: %x: ownership or extended attributes could not be applied to %d entries",
+				snap.Header.Identifier[:4], n)
+		}
+		if err != nil {
+			snap.Close()
+			return 1, err
+		}
+
+		if cmd.OptMerge {
+			if err := cmd.merge(ctx, repo, snap, pathname, older, exporterInstance, filter, chown, opts); err != nil {
+				snap.Close()
+				return 1, err
+			}
+		}
+
+		if cmd.OptVerify {
+			report, err := verifyRestore(ctx, repo, snap, pathname, exporterInstance.Root(), filter)
+			if err != nil {
+				snap.Close()
+				return 1, err
+			}
+			ctx.GetLogger().Info("restore: %x: verify: %s", snap.Header.Identifier[:4], report)
+			if report.Failed() {
+				snap.Close()
+				return exitcodes.IntegrityFailure, fmt.Errorf("restore: verification failed for %d entries",
+					report.Missing+report.Mismatched)
+			}
+		}
+
 		snap.Close()
 	}
 	return 0, nil
 }
+
+// exportRoot returns the snapshot directory that the exported records
+// are relative to when exporting entry.
+func exportRoot(entry *vfs.Entry) string {
+	if entry.IsDir() {
+		return entry.Path()
+	}
+	root := path.Dir(entry.Path())
+	if root == "/" {
+		return ""
+	}
+	return root
+}
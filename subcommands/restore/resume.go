@@ -0,0 +1,380 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package restore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PlakarKorp/kloset/connectors"
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+)
+
+// JournalPath returns the file recording the progress of restoring
+// pathname from a snapshot to a local destination.
+func JournalPath(cacheDir string, snapshotID objects.MAC, destination string, pathname string) string {
+	key := sha256.New()
+	key.Write(snapshotID[:])
+	key.Write([]byte(destination))
+	key.Write([]byte{0})
+	key.Write([]byte(pathname))
+	return filepath.Join(cacheDir, "restore", fmt.Sprintf("%x.journal", key.Sum(nil)))
+}
+
+// journalEntry is the progress of restoring a pathname: where it is
+// restored, which the conflict policy may have changed, and whether it
+// was completely restored with the given content MAC.
+type journalEntry struct {
+	target string
+	done   bool
+	mac    objects.MAC
+}
+
+// journal is an append-only log of the entries restored.  A start line
+// holding the original and target pathnames is written before an entry
+// is restored, and a done line with the MAC of its content once a file
+// is completely restored.  Pathnames are quoted.
+type journal struct {
+	filename string
+	fp       *os.File
+	entries  map[string]journalEntry
+
+	mu sync.Mutex
+}
+
+// openJournal opens the journal at filename, loading the previous
+// progress if resume is set or starting over otherwise.
+func openJournal(filename string, resume bool) (*journal, error) {
+	j := &journal{
+		filename: filename,
+		entries:  make(map[string]journalEntry),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return nil, err
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resume {
+		if err := j.load(); err != nil {
+			return nil, err
+		}
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	fp, err := os.OpenFile(filename, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.fp = fp
+	return j, nil
+}
+
+// unquotePaths parses the original and target pathnames of a line.
+func unquotePaths(line string) (string, string, bool) {
+	quoted, err := strconv.QuotedPrefix(line)
+	if err != nil {
+		return "", "", false
+	}
+	original, _ := strconv.Unquote(quoted)
+
+	line, ok := strings.CutPrefix(line[len(quoted):], " ")
+	if !ok {
+		return "", "", false
+	}
+	quoted, err = strconv.QuotedPrefix(line)
+	if err != nil || len(quoted) != len(line) {
+		return "", "", false
+	}
+	target, _ := strconv.Unquote(quoted)
+	return original, target, true
+}
+
+func (j *journal) load() error {
+	fp, err := os.Open(j.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer fp.Close()
+
+	// a line cut short by an interruption fails to parse, the entry
+	// is simply restored again.
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		kind, line, _ := strings.Cut(scanner.Text(), " ")
+		switch kind {
+		case "start":
+			original, target, ok := unquotePaths(line)
+			if !ok {
+				continue
+			}
+			j.entries[original] = journalEntry{target: target}
+
+		case "done":
+			macHex, line, _ := strings.Cut(line, " ")
+			buf, err := hex.DecodeString(macHex)
+			if err != nil || len(buf) != len(objects.MAC{}) {
+				continue
+			}
+			original, target, ok := unquotePaths(line)
+			if !ok {
+				continue
+			}
+			j.entries[original] = journalEntry{target: target, done: true, mac: objects.MAC(buf)}
+		}
+	}
+	return scanner.Err()
+}
+
+func (j *journal) lookup(original string) (journalEntry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.entries[original]
+	return entry, ok
+}
+
+// start records that original is about to be restored at target.
+func (j *journal) start(original, target string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries[original] = journalEntry{target: target}
+	_, err := fmt.Fprintf(j.fp, "start %s %s\n", strconv.Quote(original), strconv.Quote(target))
+	return err
+}
+
+// add records that original was completely restored at target.
+func (j *journal) add(original, target string, mac objects.MAC) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries[original] = journalEntry{target: target, done: true, mac: mac}
+	_, err := fmt.Fprintf(j.fp, "done %x %s %s\n", mac, strconv.Quote(original), strconv.Quote(target))
+	return err
+}
+
+func (j *journal) Close() error {
+	return j.fp.Close()
+}
+
+// Remove deletes the journal once the restore completed.
+func (j *journal) Remove() error {
+	j.fp.Close()
+	return os.Remove(j.filename)
+}
+
+// hashingReader computes the MAC of the content read through it.
+type hashingReader struct {
+	io.ReadCloser
+	hasher hash.Hash
+	size   int64
+	eof    bool
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hasher.Write(p[:n])
+	r.size += int64(n)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// resumeExporter records in the journal the files restored through
+// the conflict policy, and skips those the journal lists as restored if
+// they are still intact at the destination.  The entries a previous
+// attempt started but did not finish restoring are restored again where
+// it did, regardless of the policy: what is there is what it left.
+type resumeExporter struct {
+	exporter.Exporter
+
+	repo     *repository.Repository
+	journal  *journal
+	conflict *conflictExporter
+
+	mu       sync.Mutex
+	inflight map[string]*hashingReader
+	resumed  uint64
+	errors   uint64
+}
+
+func newResumeExporter(exp *conflictExporter, repo *repository.Repository, journal *journal) *resumeExporter {
+	exp.started = journal.start
+	return &resumeExporter{
+		Exporter: exp,
+		repo:     repo,
+		journal:  journal,
+		conflict: exp,
+		inflight: make(map[string]*hashingReader),
+	}
+}
+
+// Resumed returns the number of files found already restored.
+func (e *resumeExporter) Resumed() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.resumed
+}
+
+// Complete returns true if every record was successfully exported.
+func (e *resumeExporter) Complete() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.errors == 0
+}
+
+func (e *resumeExporter) Export(ctx context.Context, records <-chan *connectors.Record, results chan<- *connectors.Result) error {
+	defer close(results)
+
+	innerRecords := make(chan *connectors.Record, cap(records))
+	innerResults := make(chan *connectors.Result, cap(results))
+
+	forwarded := make(chan struct{})
+	go func() {
+		for res := range innerResults {
+			e.done(res)
+			results <- res
+		}
+		close(forwarded)
+	}()
+
+	exported := make(chan error, 1)
+	go func() {
+		exported <- e.Exporter.Export(ctx, innerRecords, innerResults)
+	}()
+
+	for record := range records {
+		if res := e.resume(record); res != nil {
+			results <- res
+			continue
+		}
+		innerRecords <- record
+	}
+	close(innerRecords)
+
+	err := <-exported
+	<-forwarded
+	return err
+}
+
+// resume returns a result if the record was already restored,
+// otherwise it sets up the computation of its MAC.
+func (e *resumeExporter) resume(record *connectors.Record) *connectors.Result {
+	if record.Err != nil || record.IsXattr {
+		return nil
+	}
+
+	regular := record.FileInfo.Mode().IsRegular()
+	if entry, ok := e.journal.lookup(record.Pathname); ok {
+		if regular && entry.done && e.intact(record, entry) {
+			e.mu.Lock()
+			e.resumed++
+			e.mu.Unlock()
+			return record.Ok()
+		}
+		if !entry.done {
+			e.conflict.reclaim(record.Pathname, entry.target)
+		}
+	}
+
+	if !regular {
+		return nil
+	}
+
+	reader := &hashingReader{
+		ReadCloser: record.Reader,
+		hasher:     e.repo.GetMACHasher(),
+	}
+	record.Reader = reader
+
+	e.mu.Lock()
+	e.inflight[record.Pathname] = reader
+	e.mu.Unlock()
+	return nil
+}
+
+// intact returns true if the file restored at the destination still
+// has the expected size and content.
+func (e *resumeExporter) intact(record *connectors.Record, entry journalEntry) bool {
+	target := filepath.Join(e.Root(), filepath.FromSlash(entry.target))
+
+	fp, err := os.Open(target)
+	if err != nil {
+		return false
+	}
+	defer fp.Close()
+
+	info, err := fp.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() != record.FileInfo.Size() {
+		return false
+	}
+
+	hasher := e.repo.GetMACHasher()
+	if _, err := io.Copy(hasher, fp); err != nil {
+		return false
+	}
+	return bytes.Equal(hasher.Sum(nil), entry.mac[:])
+}
+
+func (e *resumeExporter) done(res *connectors.Result) {
+	// files are in flight under the pathname they had before the
+	// conflict policy possibly renamed them
+	original := e.conflict.OriginalPath(res.Record.Pathname)
+
+	e.mu.Lock()
+	reader, ok := e.inflight[original]
+	if ok && !res.Record.IsXattr {
+		delete(e.inflight, original)
+	}
+	if res.Err != nil {
+		e.errors++
+	}
+	e.mu.Unlock()
+
+	if !ok || res.Record.IsXattr || res.Err != nil {
+		return
+	}
+
+	// only a file read up to its end had its whole content hashed
+	if !reader.eof || reader.size != res.Record.FileInfo.Size() {
+		return
+	}
+
+	var mac objects.MAC
+	copy(mac[:], reader.hasher.Sum(nil))
+	if err := e.journal.add(original, res.Record.Pathname, mac); err != nil {
+		e.mu.Lock()
+		e.errors++
+		e.mu.Unlock()
+	}
+}
@@ -0,0 +1,174 @@
+package restore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors/exporter"
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "restore", "test.journal")
+
+	j, err := openJournal(filename, true)
+	require.NoError(t, err)
+	require.NoError(t, j.start("/a", "/a"))
+	require.NoError(t, j.add("/a", "/a", objects.MAC{1}))
+	require.NoError(t, j.add("/dir/with space", "/dir/with space.sfx", objects.MAC{2}))
+	require.NoError(t, j.add("/new\nline", "/new\nline", objects.MAC{3}))
+	require.NoError(t, j.start("/partial", "/partial"))
+	require.NoError(t, j.Close())
+
+	// a line cut short by an interruption
+	fp, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = fp.WriteString("done 0303")
+	require.NoError(t, err)
+	require.NoError(t, fp.Close())
+
+	j, err = openJournal(filename, true)
+	require.NoError(t, err)
+	entry, ok := j.lookup("/dir/with space")
+	require.True(t, ok)
+	require.Equal(t, journalEntry{target: "/dir/with space.sfx", done: true, mac: objects.MAC{2}}, entry)
+	entry, ok = j.lookup("/new\nline")
+	require.True(t, ok)
+	require.Equal(t, "/new\nline", entry.target)
+	entry, ok = j.lookup("/partial")
+	require.True(t, ok)
+	require.False(t, entry.done)
+	require.Len(t, j.entries, 4)
+	require.NoError(t, j.Close())
+
+	j, err = openJournal(filename, false)
+	require.NoError(t, err)
+	_, ok = j.lookup("/a")
+	require.False(t, ok)
+	require.NoError(t, j.Remove())
+
+	_, err = os.Stat(filename)
+	require.True(t, os.IsNotExist(err))
+}
+
+func macOf(repo *repository.Repository, data string) objects.MAC {
+	hasher := repo.GetMACHasher()
+	hasher.Write([]byte(data))
+	var mac objects.MAC
+	copy(mac[:], hasher.Sum(nil))
+	return mac
+}
+
+// resumeWithPolicy restores the snapshot to dir through a journal, as
+// restore -resume does.
+func resumeWithPolicy(t *testing.T, snap *snapshot.Snapshot, repo *repository.Repository, ctx *appcontext.AppContext,
+	dir string, policy ConflictPolicy) *resumeExporter {
+	exp, err := exporter.NewExporter(ctx.GetInner(), ctx.ExporterOpts(), map[string]string{"location": dir})
+	require.NoError(t, err)
+	defer exp.Close(ctx)
+
+	j, err := openJournal(JournalPath(ctx.CacheDir, snap.Header.Identifier, dir, "/"), true)
+	require.NoError(t, err)
+	defer j.Close()
+
+	conflictExp := newConflictExporter(exp, policy, fmt.Sprintf("%x", snap.Header.Identifier[:4]))
+	resumeExp := newResumeExporter(conflictExp, repo, j)
+	require.NoError(t, snap.Export(resumeExp, "/", &snapshot.ExportOptions{}))
+	return resumeExp
+}
+
+func TestResumeExporter(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+
+	// foo.txt was restored before the interruption, dummy.txt was
+	// then damaged and bar.txt was being restored.
+	writeExisting := func(name, content string) {
+		pathname := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(pathname), 0755))
+		require.NoError(t, os.WriteFile(pathname, []byte(content), 0644))
+	}
+	writeExisting("subdir/foo.txt", "hello foo")
+	writeExisting("subdir/dummy.txt", "hello DUMMY")
+	writeExisting("another_subdir/bar.txt", "hel")
+
+	filename := JournalPath(ctx.CacheDir, snap.Header.Identifier, dir, "/")
+	j, err := openJournal(filename, false)
+	require.NoError(t, err)
+	require.NoError(t, j.add("/subdir/foo.txt", "/subdir/foo.txt", macOf(repo, "hello foo")))
+	require.NoError(t, j.add("/subdir/dummy.txt", "/subdir/dummy.txt", macOf(repo, "hello dummy")))
+	require.NoError(t, j.start("/another_subdir/bar.txt", "/another_subdir/bar.txt"))
+	require.NoError(t, j.Close())
+
+	// the partial file is restored again, whatever the policy
+	resumeExp := resumeWithPolicy(t, snap, repo, ctx, dir, ConflictSkip)
+	require.Equal(t, uint64(1), resumeExp.Resumed())
+	require.True(t, resumeExp.Complete())
+	require.Equal(t, "hello DUMMY", readRestored(t, dir, "subdir/dummy.txt"))
+	require.Equal(t, "hello bar", readRestored(t, dir, "another_subdir/bar.txt"))
+
+	// the files restored this time were recorded
+	j, err = openJournal(filename, true)
+	require.NoError(t, err)
+	defer j.Close()
+	entry, ok := j.lookup("/another_subdir/bar.txt")
+	require.True(t, ok)
+	require.Equal(t, journalEntry{target: "/another_subdir/bar.txt", done: true, mac: macOf(repo, "hello bar")}, entry)
+}
+
+func TestResumeExporterRename(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+	writeExisting(t, dir, "subdir/foo.txt", time.Now())
+
+	resumeExp := resumeWithPolicy(t, snap, repo, ctx, dir, ConflictRename)
+	require.True(t, resumeExp.Complete())
+	require.Empty(t, resumeExp.inflight)
+
+	suffix := fmt.Sprintf("%x", snap.Header.Identifier[:4])
+	j, err := openJournal(JournalPath(ctx.CacheDir, snap.Header.Identifier, dir, "/"), true)
+	require.NoError(t, err)
+	entry, ok := j.lookup("/subdir/foo.txt")
+	require.NoError(t, j.Close())
+	require.True(t, ok)
+	require.Equal(t, journalEntry{target: "/subdir/foo.txt." + suffix, done: true, mac: macOf(repo, "hello foo")}, entry)
+
+	// resuming does not rename the restored files again
+	resumeExp = resumeWithPolicy(t, snap, repo, ctx, dir, ConflictRename)
+	require.True(t, resumeExp.Complete())
+	require.Equal(t, "mine", readRestored(t, dir, "subdir/foo.txt"))
+	require.Equal(t, "hello foo", readRestored(t, dir, "subdir/foo.txt."+suffix))
+	_, err = os.Stat(filepath.Join(dir, "subdir", "foo.txt."+suffix+".1"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestExecuteCmdRestoreResume(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-resume", "-to", dir}))
+	require.True(t, cmd.OptResume)
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	checkRestored(t, dir)
+
+	// the journal of a completed restore is removed
+	entries, err := os.ReadDir(filepath.Join(ctx.CacheDir, "restore"))
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
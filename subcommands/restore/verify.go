@@ -0,0 +1,259 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package restore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// VerifyReport counts the outcome of comparing the restored entries
+// with the snapshot.
+type VerifyReport struct {
+	Verified   uint64
+	Missing    uint64
+	Mismatched uint64
+	Extra      uint64
+}
+
+// Failed returns true if a restored entry is missing or differs from
+// the snapshot.  Extra entries are reported but are not a failure.
+func (r VerifyReport) Failed() bool {
+	return r.Missing != 0 || r.Mismatched != 0
+}
+
+func (r VerifyReport) String() string {
+	return fmt.Sprintf("%d verified, %d missing, %d mismatched, %d extra",
+		r.Verified, r.Missing, r.Mismatched, r.Extra)
+}
+
+// verifier walks the restored part of a snapshot and compares each
+// entry with its restored copy below root, rehashing regular files to
+// compare them with the MAC of their content.
+type verifier struct {
+	ctx    *appcontext.AppContext
+	repo   *repository.Repository
+	snap   *snapshot.Snapshot
+	fs     *vfs.Filesystem
+	root   string
+	filter *utils.PathFilter
+	report VerifyReport
+}
+
+func verifyRestore(ctx *appcontext.AppContext, repo *repository.Repository, snap *snapshot.Snapshot, pathname string, root string, filter *utils.PathFilter) (VerifyReport, error) {
+	pvfs, err := snap.Filesystem()
+	if err != nil {
+		return VerifyReport{}, err
+	}
+
+	entry, err := pvfs.GetEntry(pathname)
+	if err != nil {
+		return VerifyReport{}, err
+	}
+	pathname = entry.Path()
+
+	// same layout as the export
+	tostrip := exportRoot(entry)
+
+	v := &verifier{
+		ctx:    ctx,
+		repo:   repo,
+		snap:   snap,
+		fs:     pvfs,
+		root:   root,
+		filter: filter,
+	}
+
+	err = pvfs.WalkDir(pathname, func(entrypath string, e *vfs.Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		relpath := "/" + strings.TrimLeft(strings.TrimPrefix(path.Clean(entrypath), tostrip), "/")
+		if e.IsDir() {
+			if relpath != "/" && filter.Excludes(relpath, true) {
+				return iofs.SkipDir
+			}
+			return v.verifyDirectory(relpath, e)
+		}
+
+		if !filter.Match(relpath, false) {
+			return nil
+		}
+		v.verifyEntry(relpath, e)
+		return nil
+	})
+	return v.report, err
+}
+
+func (v *verifier) missing(relpath string) {
+	v.report.Missing++
+	fmt.Fprintf(v.ctx.Stdout, "missing %s\n", utils.SanitizeText(relpath))
+}
+
+func (v *verifier) mismatched(relpath string, reason string) {
+	v.report.Mismatched++
+	fmt.Fprintf(v.ctx.Stdout, "mismatch %s: %s\n", utils.SanitizeText(relpath), reason)
+}
+
+func (v *verifier) extra(relpath string) {
+	v.report.Extra++
+	fmt.Fprintf(v.ctx.Stdout, "extra %s\n", utils.SanitizeText(relpath))
+}
+
+func (v *verifier) verifyDirectory(relpath string, e *vfs.Entry) error {
+	target := filepath.Join(v.root, filepath.FromSlash(relpath))
+
+	info, err := os.Lstat(target)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			v.mismatched(relpath, err.Error())
+			return iofs.SkipDir
+		}
+		// directories without selected content are not restored,
+		// the selected entries below them will be reported missing.
+		if relpath == "/" || v.filter.Includes(relpath, true) {
+			v.missing(relpath)
+			return iofs.SkipDir
+		}
+		return nil
+	}
+	if !info.IsDir() {
+		v.mismatched(relpath, "not a directory")
+		return iofs.SkipDir
+	}
+
+	children, err := e.Getdents(v.fs)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]struct{})
+	for child, err := range children {
+		if err != nil {
+			return err
+		}
+		known[child.Name()] = struct{}{}
+	}
+
+	local, err := os.ReadDir(target)
+	if err != nil {
+		v.mismatched(relpath, err.Error())
+		return iofs.SkipDir
+	}
+	for _, dirent := range local {
+		if _, ok := known[dirent.Name()]; ok {
+			continue
+		}
+		childpath := path.Join(relpath, dirent.Name())
+		if v.filter.Match(childpath, dirent.IsDir()) {
+			v.extra(childpath)
+		}
+	}
+	return nil
+}
+
+func (v *verifier) verifyEntry(relpath string, e *vfs.Entry) {
+	target := filepath.Join(v.root, filepath.FromSlash(relpath))
+
+	info, err := os.Lstat(target)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			v.missing(relpath)
+		} else {
+			v.mismatched(relpath, err.Error())
+		}
+		return
+	}
+
+	expected := e.FileInfo.Mode().Type()
+	if info.Mode().Type() != expected {
+		v.mismatched(relpath, fmt.Sprintf("type is %s, expected %s", info.Mode().Type(), expected))
+		return
+	}
+
+	switch {
+	case expected&os.ModeSymlink != 0:
+		linkTarget, err := os.Readlink(target)
+		if err != nil {
+			v.mismatched(relpath, err.Error())
+			return
+		}
+		if linkTarget != e.SymlinkTarget {
+			v.mismatched(relpath, "symlink target differs")
+			return
+		}
+
+	case expected.IsRegular():
+		if info.Size() != e.FileInfo.Size() {
+			v.mismatched(relpath, fmt.Sprintf("size is %d, expected %d", info.Size(), e.FileInfo.Size()))
+			return
+		}
+		if err := v.verifyContent(target, e); err != nil {
+			v.mismatched(relpath, err.Error())
+			return
+		}
+	}
+
+	v.report.Verified++
+}
+
+func (v *verifier) verifyContent(target string, e *vfs.Entry) error {
+	if !e.HasObject() {
+		// nothing was stored, the size check is enough
+		return nil
+	}
+
+	object := e.ResolvedObject
+	if object == nil {
+		var err error
+		object, err = v.snap.LookupObject(e.Object)
+		if err != nil {
+			return fmt.Errorf("could not fetch object: %w", err)
+		}
+	}
+
+	fp, err := os.Open(target)
+	if err != nil {
+		return err
+	}
+	defer fp.Close()
+
+	hasher := v.repo.GetMACHasher()
+	if _, err := io.Copy(hasher, fp); err != nil {
+		return err
+	}
+	if !bytes.Equal(hasher.Sum(nil), object.ContentMAC[:]) {
+		return fmt.Errorf("content differs")
+	}
+	return nil
+}
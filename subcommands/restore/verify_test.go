@@ -0,0 +1,89 @@
+package restore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PlakarKorp/plakar/exitcodes"
+	"github.com/PlakarKorp/plakar/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVerify(t *testing.T) {
+	_, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-verify"}))
+	require.True(t, cmd.OptVerify)
+
+	cmd = &Restore{}
+	require.Error(t, cmd.Parse(ctx, []string{"-verify", "-conflict", "skip"}))
+}
+
+func TestExecuteCmdRestoreVerify(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-verify", "-to", dir}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+}
+
+func TestVerifyRestore(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	dir := mkRestoreDir(t)
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-to", dir}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	filter, err := utils.NewPathFilter(nil, nil)
+	require.NoError(t, err)
+
+	report, err := verifyRestore(ctx, repo, snap, "/", dir, filter)
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{Verified: 3}, report)
+	require.False(t, report.Failed())
+
+	// same size, different content
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "subdir", "foo.txt"), []byte("hello FOO"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(dir, "subdir", "dummy.txt")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "another_subdir", "new.txt"), []byte("new"), 0644))
+
+	report, err = verifyRestore(ctx, repo, snap, "/", dir, filter)
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{Verified: 1, Missing: 1, Mismatched: 1, Extra: 1}, report)
+	require.True(t, report.Failed())
+
+	// only the selected entries are verified
+	filter, err = utils.NewPathFilter(nil, []string{"subdir/", "new.txt"})
+	require.NoError(t, err)
+	report, err = verifyRestore(ctx, repo, snap, "/", dir, filter)
+	require.NoError(t, err)
+	require.Equal(t, VerifyReport{Verified: 1}, report)
+}
+
+func TestExecuteCmdRestoreVerifyFailure(t *testing.T) {
+	repo, snap, ctx := generateSnapshot(t)
+	defer snap.Close()
+
+	// a directory where a file is expected cannot be overwritten
+	dir := mkRestoreDir(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "subdir", "foo.txt", "child"), 0755))
+
+	cmd := &Restore{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-verify", "-to", dir}))
+	status, err := cmd.Execute(ctx, repo)
+	require.Error(t, err)
+	require.Equal(t, exitcodes.IntegrityFailure, status)
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exitcodes"
 	"github.com/PlakarKorp/plakar/subcommands"
 	"github.com/PlakarKorp/plakar/utils"
 	"github.com/dustin/go-humanize"
@@ -82,6 +83,19 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 		return 0, nil
 	}
 
+	// held snapshots are never removed
+	now := time.Now()
+	held := make(map[objects.MAC]*utils.Hold)
+	for _, id := range matches {
+		hold, err := utils.ActiveHold(repo, id, now)
+		if err != nil {
+			return 1, fmt.Errorf("rm: %w", err)
+		}
+		if hold != nil {
+			held[id] = hold
+		}
+	}
+
 	// plan
 	if !cmd.Apply {
 		type planEntry struct {
@@ -112,6 +126,9 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 				snap.Header.Duration.Round(time.Second),
 				utils.SanitizeText(snap.Header.GetSource(0).Importer.Directory),
 				tags)
+			if hold, ok := held[id]; ok {
+				prefix += " " + hold.String()
+			}
 			snap.Close()
 			entries = append(entries, planEntry{prefix: prefix, id: id, key: key, ts: snap.Header.Timestamp})
 		}
@@ -130,7 +147,10 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 			}
 			return ti.After(tj)
 		})
-		fmt.Fprintf(ctx.Stdout, "rm: would remove these %d snapshot(s), run with -apply to proceed\n", len(matches))
+		fmt.Fprintf(ctx.Stdout, "rm: would remove these %d snapshot(s), run with -apply to proceed\n", len(matches)-len(held))
+		if len(held) != 0 {
+			fmt.Fprintf(ctx.Stdout, "rm: %d held snapshot(s) would be refused\n", len(held))
+		}
 		l := 0
 		for _, e := range entries {
 			l = max(l, len(e.prefix))
@@ -146,6 +166,10 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 	wg := sync.WaitGroup{}
 	repo.NoStateToLocalDisk = true
 	for _, matchID := range matches {
+		if hold, ok := held[matchID]; ok {
+			ctx.GetLogger().Error("rm: refusing to remove %x: %s", matchID[:4], hold)
+			continue
+		}
 		wg.Add(1)
 		go func(snapshotID objects.MAC) {
 			defer wg.Done()
@@ -163,5 +187,9 @@ func (cmd *Rm) Execute(ctx *appcontext.AppContext, repo *repository.Repository)
 		return 1, fmt.Errorf("failed to remove %d snapshots", errors)
 	}
 
+	if len(held) != 0 {
+		return exitcodes.SnapshotHeld, fmt.Errorf("rm: %d snapshot(s) held: %w", len(held), utils.ErrSnapshotHeld)
+	}
+
 	return 0, nil
 }
@@ -6,12 +6,16 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
+	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/kloset/repository"
 	"github.com/PlakarKorp/kloset/snapshot"
 	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/exitcodes"
 	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/PlakarKorp/plakar/utils"
 	"github.com/stretchr/testify/require"
 )
 
@@ -152,3 +156,54 @@ func TestRm_DryRun_ShowsPlan(t *testing.T) {
 	require.Contains(t, out, "rm: would remove these 1 snapshot(s), run with -apply to proceed")
 	require.NotContains(t, out, "rm: removal of") // no actual deletion
 }
+
+func TestRm_HeldSnapshotRefused(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, snap, ctx := generateSnapshot(t, bufOut, bufErr)
+	defer snap.Close()
+
+	require.NoError(t, utils.PutHold(repo, &utils.Hold{
+		Snapshot:  snap.Header.Identifier,
+		CreatedAt: time.Now(),
+		Reason:    "audit",
+	}))
+	id := hex.EncodeToString(snap.Header.GetIndexShortID())
+
+	cmd := &Rm{}
+	require.NoError(t, cmd.Parse(ctx, []string{id}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), "held indefinitely")
+	require.Contains(t, bufOut.String(), "rm: 1 held snapshot(s) would be refused")
+
+	cmd = &Rm{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-apply", id}))
+	status, err = cmd.Execute(ctx, repo)
+	require.ErrorIs(t, err, utils.ErrSnapshotHeld)
+	require.Equal(t, exitcodes.SnapshotHeld, status)
+	require.Contains(t, bufErr.String(), "rm: refusing to remove "+id)
+
+	require.NoError(t, repo.RebuildState())
+	ids, err := locate.LocateSnapshotIDs(repo, locate.NewDefaultLocateOptions())
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+
+	// an expired hold no longer protects the snapshot
+	require.NoError(t, utils.PutHold(repo, &utils.Hold{
+		Snapshot:  snap.Header.Identifier,
+		CreatedAt: time.Now().Add(-2 * time.Hour),
+		Until:     time.Now().Add(-time.Hour),
+	}))
+	cmd = &Rm{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-apply", id}))
+	status, err = cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.NoError(t, repo.RebuildState())
+	ids, err = locate.LocateSnapshotIDs(repo, locate.NewDefaultLocateOptions())
+	require.NoError(t, err)
+	require.Empty(t, ids)
+}
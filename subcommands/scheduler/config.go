@@ -0,0 +1,162 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package scheduler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+type Job struct {
+	Name     string   `yaml:"name"`
+	Command  string   `yaml:"command"`
+	Store    string   `yaml:"store"`
+	Cron     string   `yaml:"cron,omitempty"`
+	Interval string   `yaml:"interval,omitempty"`
+	Source   string   `yaml:"source,omitempty"`
+	Policy   string   `yaml:"policy,omitempty"`
+	Peer     string   `yaml:"peer,omitempty"`
+	Args     []string `yaml:"args,omitempty"`
+
+	schedule Schedule
+}
+
+type JobsConfig struct {
+	Version string `yaml:"version"`
+	Jobs    []*Job `yaml:"jobs"`
+}
+
+func LoadJobsFile(filename string) (*JobsConfig, error) {
+	rd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer rd.Close()
+
+	cfg, err := LoadJobs(rd)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return cfg, nil
+}
+
+func LoadJobs(rd io.Reader) (*JobsConfig, error) {
+	var cfg JobsConfig
+	if err := yaml.NewDecoder(rd).Decode(&cfg); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("no jobs defined")
+		}
+		return nil, err
+	}
+
+	if len(cfg.Jobs) == 0 {
+		return nil, fmt.Errorf("no jobs defined")
+	}
+
+	names := make(map[string]struct{})
+	for i, job := range cfg.Jobs {
+		if job.Name == "" {
+			return nil, fmt.Errorf("job #%d: missing name", i+1)
+		}
+		if _, ok := names[job.Name]; ok {
+			return nil, fmt.Errorf("job %q: duplicate name", job.Name)
+		}
+		names[job.Name] = struct{}{}
+
+		if err := job.validate(); err != nil {
+			return nil, fmt.Errorf("job %q: %w", job.Name, err)
+		}
+	}
+
+	return &cfg, nil
+}
+
+func (job *Job) validate() error {
+	if job.Store == "" {
+		return fmt.Errorf("missing store")
+	}
+	if !strings.HasPrefix(job.Store, "@") {
+		return fmt.Errorf("store must reference a configured store, e.g. @%s", job.Store)
+	}
+
+	switch job.Command {
+	case "backup":
+		if job.Policy != "" || job.Peer != "" {
+			return fmt.Errorf("backup only accepts a source")
+		}
+	case "check":
+		if job.Source != "" || job.Policy != "" || job.Peer != "" {
+			return fmt.Errorf("check accepts none of source, policy or peer")
+		}
+	case "prune":
+		if job.Policy == "" {
+			return fmt.Errorf("prune requires a policy")
+		}
+		if job.Source != "" || job.Peer != "" {
+			return fmt.Errorf("prune only accepts a policy")
+		}
+	case "sync":
+		if job.Peer == "" {
+			return fmt.Errorf("sync requires a peer")
+		}
+		if job.Source != "" || job.Policy != "" {
+			return fmt.Errorf("sync only accepts a peer")
+		}
+	case "":
+		return fmt.Errorf("missing command")
+	default:
+		return fmt.Errorf("unsupported command %q", job.Command)
+	}
+
+	schedule, err := ParseSchedule(job.Cron, job.Interval)
+	if err != nil {
+		return err
+	}
+	job.schedule = schedule
+
+	return nil
+}
+
+// Arguments returns the command line handed to the subcommand parser
+// for this job, the positional arguments derived from the job fields
+// come last.
+func (job *Job) Arguments() []string {
+	args := []string{job.Command}
+
+	switch job.Command {
+	case "backup":
+		args = append(args, "-no-progress")
+		args = append(args, job.Args...)
+		if job.Source != "" {
+			args = append(args, job.Source)
+		}
+	case "prune":
+		args = append(args, "-apply", "-policy", job.Policy)
+		args = append(args, job.Args...)
+	case "sync":
+		args = append(args, job.Args...)
+		args = append(args, "to", job.Peer)
+	default:
+		args = append(args, job.Args...)
+	}
+
+	return args
+}
@@ -0,0 +1,331 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package scheduler
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/PlakarKorp/kloset/connectors/storage"
+	"github.com/PlakarKorp/kloset/encryption"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/versioning"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/cached"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/subcommands/backup"
+	"github.com/PlakarKorp/plakar/task"
+	"github.com/PlakarKorp/plakar/utils"
+)
+
+// store holds what is needed to reopen a repository for every run: the
+// resolved configuration and the derived key, so that passphrases are
+// only ever obtained once, at startup.
+type store struct {
+	name   string
+	config map[string]string
+	secret []byte
+
+	// jobs targeting the same store never run concurrently
+	mu sync.Mutex
+}
+
+type runner struct {
+	ctx    *appcontext.AppContext
+	state  *State
+	stores map[string]*store
+
+	running map[string]*atomic.Bool
+
+	// runs are only started under mu, so that none starts once the
+	// scheduler is stopped and waits for them to terminate
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func newRunner(ctx *appcontext.AppContext, state *State) *runner {
+	return &runner{
+		ctx:     ctx,
+		state:   state,
+		stores:  make(map[string]*store),
+		running: make(map[string]*atomic.Bool),
+	}
+}
+
+func getPassphrase(ctx *appcontext.AppContext, storeConfig map[string]string) (string, error) {
+	if pass, ok := storeConfig["passphrase"]; ok {
+		delete(storeConfig, "passphrase")
+		return pass, nil
+	}
+
+	if cmd, ok := storeConfig["passphrase_cmd"]; ok {
+		delete(storeConfig, "passphrase_cmd")
+		return utils.GetPassphraseFromCommand(cmd)
+	}
+
+	if pass, ok := os.LookupEnv("PLAKAR_PASSPHRASE"); ok {
+		return pass, nil
+	}
+
+	if ctx.KeyFromFile != "" {
+		return ctx.KeyFromFile, nil
+	}
+
+	return "", fmt.Errorf("no passphrase available, set passphrase or passphrase_cmd in the store configuration")
+}
+
+// resolveStore opens the store once to validate its configuration and
+// derive the encryption key.
+func (r *runner) resolveStore(name string) (*store, error) {
+	if st, ok := r.stores[name]; ok {
+		return st, nil
+	}
+
+	storeConfig, err := r.ctx.Config.GetRepository(name)
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, passErr := getPassphrase(r.ctx, storeConfig)
+
+	st, serializedConfig, err := storage.Open(r.ctx.GetInner(), storeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the repository at %s: %w", storeConfig["location"], err)
+	}
+	defer st.Close(r.ctx)
+
+	repoConfig, err := storage.NewConfigurationFromWrappedBytes(serializedConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if repoConfig.Version != versioning.FromString(storage.VERSION) {
+		return nil, fmt.Errorf("incompatible repository version: %s != %s", repoConfig.Version, storage.VERSION)
+	}
+
+	var secret []byte
+	if repoConfig.Encryption != nil {
+		if passErr != nil {
+			return nil, passErr
+		}
+		key, err := encryption.DeriveKey(repoConfig.Encryption.KDFParams, []byte(passphrase))
+		if err != nil {
+			return nil, err
+		}
+		if !encryption.VerifyCanary(repoConfig.Encryption, key) {
+			return nil, fmt.Errorf("invalid passphrase")
+		}
+		secret = key
+	}
+
+	r.stores[name] = &store{
+		name:   name,
+		config: storeConfig,
+		secret: secret,
+	}
+	return r.stores[name], nil
+}
+
+// jobContext derives a context bound to the store of the job, so that
+// the subcommand picks the right key from it.
+func (r *runner) jobContext(st *store) *appcontext.AppContext {
+	jobCtx := appcontext.NewAppContextFrom(r.ctx)
+	jobCtx.Config = r.ctx.Config
+	jobCtx.StoreConfig = st.config
+	jobCtx.Quiet = r.ctx.Quiet
+	jobCtx.Silent = r.ctx.Silent
+	jobCtx.SetSecret(st.secret)
+	return jobCtx
+}
+
+func (r *runner) newCommand(ctx *appcontext.AppContext, job *Job) (subcommands.Subcommand, error) {
+	cmd, _, args := subcommands.Lookup(job.Arguments())
+	if cmd == nil {
+		return nil, fmt.Errorf("command not found: %s", job.Command)
+	}
+
+	if err := cmd.Parse(ctx, args); err != nil {
+		return nil, err
+	}
+
+	// a watching backup never returns and would hold its store forever
+	if b, ok := cmd.(*backup.Backup); ok && b.Watch {
+		return nil, fmt.Errorf("-watch cannot be used in a scheduled job")
+	}
+	return cmd, nil
+}
+
+// prepare validates every job up front: stores are resolved and the
+// command line of each job is parsed once, so that configuration errors
+// are reported at startup rather than at the first run.
+func (r *runner) prepare(jobs []*Job) error {
+	for _, job := range jobs {
+		st, err := r.resolveStore(job.Store)
+		if err != nil {
+			return fmt.Errorf("job %q: %w", job.Name, err)
+		}
+
+		jobCtx := r.jobContext(st)
+		_, err = r.newCommand(jobCtx, job)
+		jobCtx.Close()
+		if err != nil {
+			return fmt.Errorf("job %q: %w", job.Name, err)
+		}
+
+		r.running[job.Name] = &atomic.Bool{}
+	}
+	return nil
+}
+
+func (r *runner) execute(job *Job) (int, error) {
+	st := r.stores[job.Store]
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	ctx := r.jobContext(st)
+	defer ctx.Close()
+
+	kstore, serializedConfig, err := storage.Open(ctx.GetInner(), st.config)
+	if err != nil {
+		return 1, fmt.Errorf("failed to open the repository at %s: %w", st.config["location"], err)
+	}
+	defer kstore.Close(ctx)
+
+	repo, err := repository.NewNoRebuild(ctx.GetInner(), ctx.GetSecret(), kstore, serializedConfig, true)
+	if err != nil {
+		return 1, err
+	}
+	defer repo.Close()
+
+	if _, err := cached.RebuildStateFromStore(ctx, repo.Configuration().RepositoryID, st.config, false); err != nil {
+		return 1, err
+	}
+
+	cmd, err := r.newCommand(ctx, job)
+	if err != nil {
+		return 1, err
+	}
+
+	return task.RunCommand(ctx, cmd, repo, job.Name)
+}
+
+func (r *runner) run(job *Job) {
+	start := time.Now()
+	r.state.Update(job.Name, func(js *JobState) {
+		js.LastStart = start
+		js.Running = true
+	})
+
+	r.ctx.GetLogger().Info("scheduler: job %s started", job.Name)
+	status, err := r.execute(job)
+
+	r.state.Update(job.Name, func(js *JobState) {
+		js.LastEnd = time.Now()
+		js.Running = false
+		js.Runs++
+		if status == 0 && err == nil {
+			js.LastStatus = StatusOK
+			js.LastError = ""
+		} else {
+			js.LastStatus = StatusFailed
+			if err != nil {
+				js.LastError = err.Error()
+			} else {
+				js.LastError = fmt.Sprintf("exit status %d", status)
+			}
+		}
+	})
+
+	if err != nil {
+		r.ctx.GetLogger().Error("scheduler: job %s failed after %s: %s", job.Name, time.Since(start), err)
+	} else if status != 0 {
+		r.ctx.GetLogger().Error("scheduler: job %s failed after %s: exit status %d", job.Name, time.Since(start), status)
+	} else {
+		r.ctx.GetLogger().Info("scheduler: job %s completed in %s", job.Name, time.Since(start))
+	}
+}
+
+// loop waits for the next occurrence of the job and fires it, runs that
+// would overlap a previous one still in progress are skipped.
+func (r *runner) loop(job *Job) {
+	running := r.running[job.Name]
+	last := r.state.Get(job.Name).LastStart
+
+	for {
+		next := job.schedule.Next(time.Now(), last)
+		if next.IsZero() {
+			r.ctx.GetLogger().Warn("scheduler: job %s will never run again", job.Name)
+			return
+		}
+
+		r.state.Update(job.Name, func(js *JobState) {
+			js.NextRun = next
+		})
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		last = time.Now()
+		if !running.CompareAndSwap(false, true) {
+			r.ctx.GetLogger().Warn("scheduler: job %s still running, skipping this run", job.Name)
+			r.state.Update(job.Name, func(js *JobState) {
+				js.Skipped++
+			})
+			continue
+		}
+
+		if !r.start(job, running) {
+			running.Store(false)
+			return
+		}
+	}
+}
+
+// start runs the job in the background unless the scheduler is stopping.
+func (r *runner) start(job *Job, running *atomic.Bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped || r.ctx.Err() != nil {
+		return false
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		defer running.Store(false)
+		r.run(job)
+	}()
+	return true
+}
+
+// wait stops starting runs and waits for those in progress.
+func (r *runner) wait() {
+	r.mu.Lock()
+	r.stopped = true
+	r.mu.Unlock()
+
+	r.wg.Wait()
+}
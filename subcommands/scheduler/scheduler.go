@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package scheduler
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &SchedulerStatus{} }, subcommands.BeforeRepositoryOpen, "scheduler", "status")
+	subcommands.Register(func() subcommands.Subcommand { return &Scheduler{} }, subcommands.BeforeRepositoryOpen, "scheduler")
+}
+
+func defaultJobsFile(ctx *appcontext.AppContext) string {
+	return filepath.Join(ctx.ConfigDir, "scheduler.yml")
+}
+
+type Scheduler struct {
+	subcommands.SubcommandBase
+
+	JobsFile string
+	Jobs     *JobsConfig
+}
+
+func (cmd *Scheduler) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("scheduler", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "       %s status [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&cmd.JobsFile, "jobs", defaultJobsFile(ctx), "path to the jobs file")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		return fmt.Errorf("invalid argument: %s", flags.Arg(0))
+	}
+
+	jobs, err := LoadJobsFile(cmd.JobsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load jobs: %w", err)
+	}
+	cmd.Jobs = jobs
+
+	return nil
+}
+
+func (cmd *Scheduler) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	state, err := LoadState(StatePath(ctx.CacheDir))
+	if err != nil {
+		return 1, fmt.Errorf("failed to load scheduler state: %w", err)
+	}
+
+	// a previous scheduler may have been killed while running jobs
+	for _, job := range cmd.Jobs.Jobs {
+		state.Update(job.Name, func(js *JobState) {
+			js.Running = false
+		})
+	}
+
+	r := newRunner(ctx, state)
+	if err := r.prepare(cmd.Jobs.Jobs); err != nil {
+		return 1, err
+	}
+
+	ctx.GetLogger().Info("scheduler: started with %d jobs", len(cmd.Jobs.Jobs))
+
+	for _, job := range cmd.Jobs.Jobs {
+		go r.loop(job)
+	}
+
+	<-ctx.Done()
+
+	ctx.GetLogger().Info("scheduler: waiting for running jobs to terminate")
+	r.wait()
+
+	return 0, nil
+}
+
+type SchedulerStatus struct {
+	subcommands.SubcommandBase
+
+	JobsFile string
+	JSON     bool
+	Jobs     *JobsConfig
+}
+
+func (cmd *SchedulerStatus) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("scheduler status", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [OPTIONS]\n", flags.Name())
+		fmt.Fprintf(flags.Output(), "\nOPTIONS:\n")
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&cmd.JobsFile, "jobs", defaultJobsFile(ctx), "path to the jobs file")
+	flags.BoolVar(&cmd.JSON, "json", false, "output status as JSON")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		return fmt.Errorf("invalid argument: %s", flags.Arg(0))
+	}
+
+	jobs, err := LoadJobsFile(cmd.JobsFile)
+	if err != nil {
+		return fmt.Errorf("failed to load jobs: %w", err)
+	}
+	cmd.Jobs = jobs
+
+	return nil
+}
+
+type jobStatus struct {
+	Name     string    `json:"name"`
+	Command  string    `json:"command"`
+	Store    string    `json:"store"`
+	Schedule string    `json:"schedule"`
+	Status   string    `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	LastRun  time.Time `json:"last_run,omitzero"`
+	NextRun  time.Time `json:"next_run,omitzero"`
+	Runs     uint64    `json:"runs"`
+	Skipped  uint64    `json:"skipped"`
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func (cmd *SchedulerStatus) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	state, err := LoadState(StatePath(ctx.CacheDir))
+	if err != nil {
+		return 1, fmt.Errorf("failed to load scheduler state: %w", err)
+	}
+
+	now := time.Now()
+	statuses := make([]jobStatus, 0, len(cmd.Jobs.Jobs))
+	for _, job := range cmd.Jobs.Jobs {
+		js := state.Get(job.Name)
+
+		status := js.LastStatus
+		if js.Running {
+			status = StatusRunning
+		}
+
+		// the recorded next run is only meaningful while a scheduler is
+		// alive to honor it, otherwise compute it from the schedule.
+		next := js.NextRun
+		if next.IsZero() || next.Before(now) {
+			next = job.schedule.Next(now, js.LastStart)
+		}
+
+		statuses = append(statuses, jobStatus{
+			Name:     job.Name,
+			Command:  job.Command,
+			Store:    job.Store,
+			Schedule: job.schedule.String(),
+			Status:   status,
+			Error:    js.LastError,
+			LastRun:  js.LastStart,
+			NextRun:  next,
+			Runs:     js.Runs,
+			Skipped:  js.Skipped,
+		})
+	}
+
+	if cmd.JSON {
+		enc := json.NewEncoder(ctx.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(statuses); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+
+	for _, st := range statuses {
+		status := st.Status
+		if status == "" {
+			status = "-"
+		}
+		fmt.Fprintf(ctx.Stdout, "%s %s %s schedule=%q last=%s status=%s next=%s\n",
+			st.Name, st.Command, st.Store, st.Schedule,
+			formatTime(st.LastRun), status, formatTime(st.NextRun))
+		if st.Status == StatusFailed && st.Error != "" {
+			fmt.Fprintf(ctx.Stdout, "    error: %s\n", st.Error)
+		}
+	}
+
+	return 0, nil
+}
@@ -0,0 +1,255 @@
+package scheduler
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/config"
+	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	os.Setenv("TZ", "UTC")
+}
+
+func TestRegisteredFactory(t *testing.T) {
+	cmd, _, _ := subcommands.Lookup([]string{"scheduler"})
+	require.NotNil(t, cmd)
+	require.IsType(t, &Scheduler{}, cmd)
+
+	cmd, _, _ = subcommands.Lookup([]string{"scheduler", "status"})
+	require.NotNil(t, cmd)
+	require.IsType(t, &SchedulerStatus{}, cmd)
+}
+
+func TestParseScheduleInterval(t *testing.T) {
+	s, err := ParseSchedule("", "1h")
+	require.NoError(t, err)
+
+	now := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	require.Equal(t, now, s.Next(now, time.Time{}), "never ran jobs are due immediately")
+	require.Equal(t, now.Add(30*time.Minute), s.Next(now, now.Add(-30*time.Minute)))
+	require.Equal(t, now, s.Next(now, now.Add(-3*time.Hour)), "late jobs are due immediately")
+
+	_, err = ParseSchedule("", "10s")
+	require.Error(t, err)
+
+	_, err = ParseSchedule("", "whenever")
+	require.Error(t, err)
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	_, err := ParseSchedule("", "")
+	require.Error(t, err)
+
+	_, err = ParseSchedule("* * * * *", "1h")
+	require.Error(t, err)
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := ParseSchedule(spec, "")
+		require.Error(t, err, spec)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	now := time.Date(2026, 3, 1, 10, 17, 42, 0, time.UTC) // a sunday
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 3, 1, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2026, 3, 2, 2, 30, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 1, 11, 0, 0, 0, time.UTC)},
+		{"0 4 * * 1-5", time.Date(2026, 3, 2, 4, 0, 0, 0, time.UTC)},
+		{"0 4 * * 7", time.Date(2026, 3, 8, 4, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 1", time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0,45 9-11 * * *", time.Date(2026, 3, 1, 10, 45, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec, "")
+		require.NoError(t, err, tt.spec)
+		require.Equal(t, tt.want, s.Next(now, time.Time{}), tt.spec)
+		require.Equal(t, tt.spec, s.String())
+	}
+}
+
+func TestLoadJobs(t *testing.T) {
+	cfg, err := LoadJobs(strings.NewReader(`
+version: v1.0.0
+jobs:
+  - name: home
+    command: backup
+    store: "@nas"
+    source: "@home"
+    interval: 1h
+    args: ["-tag", "daily"]
+  - name: retention
+    command: prune
+    store: "@nas"
+    policy: weekly
+    cron: "0 4 * * *"
+  - name: verify
+    command: check
+    store: "@nas"
+    cron: "@weekly"
+    args: ["-fast"]
+  - name: offsite
+    command: sync
+    store: "@nas"
+    peer: "@s3"
+    cron: "0 5 * * *"
+`))
+	require.NoError(t, err)
+	require.Len(t, cfg.Jobs, 4)
+
+	require.Equal(t, []string{"backup", "-no-progress", "-tag", "daily", "@home"}, cfg.Jobs[0].Arguments())
+	require.Equal(t, []string{"prune", "-apply", "-policy", "weekly"}, cfg.Jobs[1].Arguments())
+	require.Equal(t, []string{"check", "-fast"}, cfg.Jobs[2].Arguments())
+	require.Equal(t, []string{"sync", "to", "@s3"}, cfg.Jobs[3].Arguments())
+}
+
+func TestLoadJobsErrors(t *testing.T) {
+	tests := map[string]string{
+		"empty":        ``,
+		"no jobs":      `jobs: []`,
+		"no name":      `jobs: [{command: check, store: "@a", cron: "@daily"}]`,
+		"duplicate":    `jobs: [{name: a, command: check, store: "@a", cron: "@daily"}, {name: a, command: check, store: "@a", cron: "@daily"}]`,
+		"no store":     `jobs: [{name: a, command: check, cron: "@daily"}]`,
+		"plain store":  `jobs: [{name: a, command: check, store: "/var/backups", cron: "@daily"}]`,
+		"no command":   `jobs: [{name: a, store: "@a", cron: "@daily"}]`,
+		"bad command":  `jobs: [{name: a, command: restore, store: "@a", cron: "@daily"}]`,
+		"no schedule":  `jobs: [{name: a, command: check, store: "@a"}]`,
+		"prune policy": `jobs: [{name: a, command: prune, store: "@a", cron: "@daily"}]`,
+		"sync peer":    `jobs: [{name: a, command: sync, store: "@a", cron: "@daily"}]`,
+		"check source": `jobs: [{name: a, command: check, store: "@a", source: "@b", cron: "@daily"}]`,
+		"backup peer":  `jobs: [{name: a, command: backup, store: "@a", peer: "@b", cron: "@daily"}]`,
+	}
+
+	for name, doc := range tests {
+		_, err := LoadJobs(strings.NewReader(doc))
+		require.Error(t, err, name)
+	}
+}
+
+func TestStateRoundTrip(t *testing.T) {
+	filename := StatePath(t.TempDir())
+
+	state, err := LoadState(filename)
+	require.NoError(t, err)
+	require.Equal(t, JobState{}, state.Get("home"))
+
+	start := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, state.Update("home", func(js *JobState) {
+		js.LastStart = start
+		js.LastStatus = StatusOK
+		js.Runs++
+	}))
+
+	reloaded, err := LoadState(filename)
+	require.NoError(t, err)
+
+	js := reloaded.Get("home")
+	require.True(t, js.LastStart.Equal(start))
+	require.Equal(t, StatusOK, js.LastStatus)
+	require.Equal(t, uint64(1), js.Runs)
+	require.Equal(t, os.Getpid(), reloaded.PID)
+}
+
+func newTestContext(t *testing.T) (*appcontext.AppContext, *bytes.Buffer) {
+	ctx := appcontext.NewAppContext()
+	ctx.Config = config.NewConfig()
+	ctx.ConfigDir = t.TempDir()
+	ctx.CacheDir = t.TempDir()
+
+	out := &bytes.Buffer{}
+	ctx.Stdout = out
+	t.Cleanup(ctx.Close)
+	return ctx, out
+}
+
+func TestSchedulerParseMissingJobsFile(t *testing.T) {
+	ctx, _ := newTestContext(t)
+
+	cmd := &Scheduler{}
+	require.Error(t, cmd.Parse(ctx, []string{}))
+}
+
+func TestSchedulerStatus(t *testing.T) {
+	ctx, out := newTestContext(t)
+
+	jobsFile := filepath.Join(ctx.ConfigDir, "scheduler.yml")
+	require.NoError(t, os.WriteFile(jobsFile, []byte(`
+jobs:
+  - name: home
+    command: backup
+    store: "@nas"
+    interval: 1h
+  - name: verify
+    command: check
+    store: "@nas"
+    cron: "@daily"
+`), 0600))
+
+	state, err := LoadState(StatePath(ctx.CacheDir))
+	require.NoError(t, err)
+	require.NoError(t, state.Update("verify", func(js *JobState) {
+		js.LastStart = time.Now().Add(-time.Hour)
+		js.LastStatus = StatusFailed
+		js.LastError = "check failed for 1 snapshot"
+	}))
+
+	cmd := &SchedulerStatus{}
+	require.NoError(t, cmd.Parse(ctx, []string{}))
+
+	status, err := cmd.Execute(ctx, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	output := out.String()
+	require.Contains(t, output, `home backup @nas schedule="every 1h0m0s"`)
+	require.Contains(t, output, `verify check @nas schedule="@daily"`)
+	require.Contains(t, output, "status=failed")
+	require.Contains(t, output, "error: check failed for 1 snapshot")
+
+	out.Reset()
+	cmd = &SchedulerStatus{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-json"}))
+	_, err = cmd.Execute(ctx, nil)
+	require.NoError(t, err)
+	require.Contains(t, out.String(), `"name": "verify"`)
+}
+
+func TestRunnerRejectsWatch(t *testing.T) {
+	ctx, _ := newTestContext(t)
+	r := newRunner(ctx, nil)
+
+	job := &Job{Name: "home", Command: "backup", Store: "@nas", Source: t.TempDir(), Args: []string{"-watch"}}
+	_, err := r.newCommand(ctx, job)
+	require.ErrorContains(t, err, "-watch cannot be used")
+
+	job.Args = nil
+	_, err = r.newCommand(ctx, job)
+	require.NoError(t, err)
+}
+
+func TestRunnerStartAfterWait(t *testing.T) {
+	ctx, _ := newTestContext(t)
+	r := newRunner(ctx, nil)
+	r.wait()
+
+	var running atomic.Bool
+	require.False(t, r.start(&Job{Name: "home"}, &running))
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package scheduler
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK      = "ok"
+	StatusFailed  = "failed"
+	StatusRunning = "running"
+)
+
+type JobState struct {
+	LastStart  time.Time `json:"last_start,omitzero"`
+	LastEnd    time.Time `json:"last_end,omitzero"`
+	LastStatus string    `json:"last_status,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+	NextRun    time.Time `json:"next_run,omitzero"`
+	Running    bool      `json:"running"`
+	Runs       uint64    `json:"runs"`
+	Skipped    uint64    `json:"skipped"`
+}
+
+// State is the scheduler bookkeeping shared between the running
+// scheduler, which updates it, and `plakar scheduler status` which
+// only reads it.
+type State struct {
+	mu       sync.Mutex
+	filename string
+
+	PID     int                  `json:"pid"`
+	Updated time.Time            `json:"updated"`
+	Jobs    map[string]*JobState `json:"jobs"`
+}
+
+func StatePath(cacheDir string) string {
+	return filepath.Join(cacheDir, "scheduler.json")
+}
+
+func LoadState(filename string) (*State, error) {
+	state := &State{
+		filename: filename,
+		Jobs:     make(map[string]*JobState),
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Jobs == nil {
+		state.Jobs = make(map[string]*JobState)
+	}
+
+	return state, nil
+}
+
+// Get returns a copy of the state of the given job.
+func (s *State) Get(name string) JobState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if js, ok := s.Jobs[name]; ok {
+		return *js
+	}
+	return JobState{}
+}
+
+// Update applies fn to the state of the given job and saves the
+// result to disk.
+func (s *State) Update(name string, fn func(*JobState)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	js, ok := s.Jobs[name]
+	if !ok {
+		js = &JobState{}
+		s.Jobs[name] = js
+	}
+	fn(js)
+
+	return s.save()
+}
+
+func (s *State) save() error {
+	s.PID = os.Getpid()
+	s.Updated = time.Now()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(s.filename), filepath.Base(s.filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(data)
+	tmpFile.Close()
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), s.filename)
+	}
+	os.Remove(tmpFile.Name())
+	return err
+}
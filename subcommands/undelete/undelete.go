@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2026 Gilles Chehade <gilles@poolp.org>
+ *
+ * Permission to use, copy, modify, and distribute this software for any
+ * purpose with or without fee is hereby granted, provided that the above
+ * copyright notice and this permission notice appear in all copies.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+ * WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+ * MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+ * ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+ * WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+ * ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+ * OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+ */
+
+package undelete
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/repository/state"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	"github.com/PlakarKorp/plakar/subcommands"
+)
+
+func init() {
+	subcommands.Register(func() subcommands.Subcommand { return &Undelete{} }, 0, "undelete")
+}
+
+type Undelete struct {
+	subcommands.SubcommandBase
+
+	Snapshots []string
+}
+
+func (cmd *Undelete) Parse(ctx *appcontext.AppContext, args []string) error {
+	flags := flag.NewFlagSet("undelete", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s SNAPSHOT...\n", flags.Name())
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no snapshot specified")
+	}
+
+	cmd.Snapshots = flags.Args()
+	cmd.RepositorySecret = ctx.GetSecret()
+
+	return nil
+}
+
+// locateDeleted resolves the snapshot identifiers given on the command
+// line among the deleted snapshots.
+func locateDeleted(repo *repository.Repository, ids []string) ([]objects.MAC, error) {
+	deleted := make([]objects.MAC, 0)
+	for snapshotID := range repo.ListDeletedSnapShots() {
+		deleted = append(deleted, snapshotID)
+	}
+
+	matches := make([]objects.MAC, 0, len(ids))
+	for _, id := range ids {
+		var found []objects.MAC
+		for _, snapshotID := range deleted {
+			if strings.HasPrefix(hex.EncodeToString(snapshotID[:]), strings.ToLower(id)) {
+				found = append(found, snapshotID)
+			}
+		}
+		switch len(found) {
+		case 0:
+			return nil, fmt.Errorf("%s: no deleted snapshot matches", id)
+		case 1:
+			matches = append(matches, found[0])
+		default:
+			return nil, fmt.Errorf("%s: ambiguous snapshot identifier", id)
+		}
+	}
+	return matches, nil
+}
+
+// listPackfiles adds the packfiles holding the data of the snapshot to
+// packfiles, and fails if some of it was reclaimed.
+func listPackfiles(repo *repository.Repository, live map[objects.MAC]struct{}, snapshotID objects.MAC, packfiles map[objects.MAC]struct{}) error {
+	snap, err := snapshot.Load(repo, snapshotID)
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	iter, err := snap.ListPackfiles()
+	if err != nil {
+		return err
+	}
+
+	for packfileMAC, err := range iter {
+		if err != nil {
+			return err
+		}
+		if _, ok := live[packfileMAC]; !ok {
+			return fmt.Errorf("packfile %x was reclaimed", packfileMAC[:4])
+		}
+		packfiles[packfileMAC] = struct{}{}
+	}
+	return nil
+}
+
+// snapshotPackfiles returns the packfiles holding the data of the
+// snapshots, or an error if one of them can no longer be restored.  The
+// state hides the blobs of the packfiles maintenance scheduled for
+// deletion, so the snapshots are resolved through a second handle on the
+// repository, backed by a throwaway copy of the state in a scan cache in
+// which none is.
+func snapshotPackfiles(ctx *appcontext.AppContext, repo *repository.Repository, secret []byte, coloured map[objects.MAC]struct{}, snapshotIDs []objects.MAC) (map[objects.MAC]struct{}, error) {
+	live := make(map[objects.MAC]struct{})
+	for packfileMAC := range repo.ListPackfiles() {
+		live[packfileMAC] = struct{}{}
+	}
+
+	resolver := repo
+	if len(coloured) != 0 {
+		serializedConfig, err := repo.Store().Open(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		shadow, err := repository.NewNoRebuild(ctx.GetInner(), secret, repo.Store(), serializedConfig, true)
+		if err != nil {
+			return nil, err
+		}
+		defer shadow.Close()
+
+		cache, err := ctx.GetCache().Scan(objects.RandomMAC())
+		if err != nil {
+			return nil, err
+		}
+		defer cache.Close()
+
+		if err := shadow.RebuildStateWithCache(cache); err != nil {
+			return nil, err
+		}
+		for packfileMAC := range coloured {
+			if err := cache.DelColoured(resources.RT_PACKFILE, packfileMAC); err != nil {
+				return nil, err
+			}
+		}
+		resolver = shadow
+	}
+
+	packfiles := make(map[objects.MAC]struct{})
+	for _, snapshotID := range snapshotIDs {
+		if err := listPackfiles(resolver, live, snapshotID, packfiles); err != nil {
+			return nil, fmt.Errorf("%x can no longer be restored: %w", snapshotID[:4], err)
+		}
+	}
+	return packfiles, nil
+}
+
+func (cmd *Undelete) Execute(ctx *appcontext.AppContext, repo *repository.Repository) (int, error) {
+	matches, err := locateDeleted(repo, cmd.Snapshots)
+	if err != nil {
+		return 1, fmt.Errorf("undelete: %w", err)
+	}
+
+	coloured := make(map[objects.MAC]struct{})
+	for packfileMAC := range repo.ListColouredPackfiles() {
+		coloured[packfileMAC] = struct{}{}
+	}
+
+	// nothing is written to the repository before all the snapshots are
+	// known to be restorable
+	packfiles, err := snapshotPackfiles(ctx, repo, cmd.RepositorySecret, coloured, matches)
+	if err != nil {
+		return 1, fmt.Errorf("undelete: %w", err)
+	}
+
+	stateID := objects.RandomMAC()
+	sc, err := repo.AppContext().GetCache().Scan(stateID)
+	if err != nil {
+		return 1, err
+	}
+	repoWriter := repo.NewRepositoryWriter(sc, stateID, repository.DefaultType, "")
+
+	// cancel the removal of the packfiles the snapshots need, the others
+	// scheduled for deletion are left alone
+	restored := 0
+	for packfileMAC := range packfiles {
+		if _, ok := coloured[packfileMAC]; !ok {
+			continue
+		}
+		if err := repoWriter.UncolourPackfile(packfileMAC); err != nil {
+			return 1, fmt.Errorf("undelete: %w", err)
+		}
+		restored++
+	}
+	if restored != 0 {
+		ctx.GetLogger().Warn("undelete: restoring %d packfiles scheduled for deletion", restored)
+	}
+
+	// the repository writer only knows how to uncolour packfiles, the
+	// colour of the snapshots is removed from the delta state it commits
+	delta, err := state.NewLocalState(sc)
+	if err != nil {
+		return 1, fmt.Errorf("undelete: %w", err)
+	}
+	for _, snapshotID := range matches {
+		if err := delta.DelColouredResource(resources.RT_SNAPSHOT, snapshotID); err != nil {
+			return 1, fmt.Errorf("undelete: %x: %w", snapshotID[:4], err)
+		}
+	}
+
+	if err := repoWriter.CommitTransaction(stateID); err != nil {
+		return 1, fmt.Errorf("undelete: %w", err)
+	}
+	if err := repo.RebuildState(); err != nil {
+		return 1, fmt.Errorf("undelete: %w", err)
+	}
+
+	for _, snapshotID := range matches {
+		ctx.GetLogger().Info("undelete: %x restored", snapshotID[:4])
+	}
+	return 0, nil
+}
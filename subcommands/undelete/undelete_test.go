@@ -0,0 +1,214 @@
+package undelete
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/plakar/appcontext"
+	ptesting "github.com/PlakarKorp/plakar/testing"
+	"github.com/stretchr/testify/require"
+)
+
+func generateSnapshot(t *testing.T, bufOut, bufErr *bytes.Buffer) (*repository.Repository, *appcontext.AppContext, *snapshot.Snapshot) {
+	repo, ctx := ptesting.GenerateRepository(t, bufOut, bufErr, nil)
+	snap := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("subdir"),
+		ptesting.NewMockFile("subdir/dummy.txt", 0644, "hello dummy"),
+	})
+	t.Cleanup(func() { snap.Close() })
+	return repo, ctx, snap
+}
+
+func listSnapshots(t *testing.T, repo *repository.Repository) []objects.MAC {
+	ids := make([]objects.MAC, 0)
+	for id, err := range repo.ListSnapshots() {
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// colourPackfiles flags the packfiles for deletion the way maintenance
+// does.
+func colourPackfiles(t *testing.T, repo *repository.Repository, packfiles map[objects.MAC]struct{}) {
+	stateID := objects.RandomMAC()
+	sc, err := repo.AppContext().GetCache().Scan(stateID)
+	require.NoError(t, err)
+	repoWriter := repo.NewRepositoryWriter(sc, stateID, repository.DefaultType, "")
+
+	for packfileMAC := range packfiles {
+		require.NoError(t, repoWriter.DeleteStateResource(resources.RT_PACKFILE, packfileMAC))
+	}
+	require.NoError(t, repoWriter.CommitTransaction(stateID))
+	require.NoError(t, repo.RebuildState())
+}
+
+func packfilesOf(t *testing.T, snap *snapshot.Snapshot) map[objects.MAC]struct{} {
+	packfiles := make(map[objects.MAC]struct{})
+	iter, err := snap.ListPackfiles()
+	require.NoError(t, err)
+	for packfileMAC, err := range iter {
+		require.NoError(t, err)
+		packfiles[packfileMAC] = struct{}{}
+	}
+	return packfiles
+}
+
+func colouredPackfiles(repo *repository.Repository) map[objects.MAC]struct{} {
+	packfiles := make(map[objects.MAC]struct{})
+	for packfileMAC := range repo.ListColouredPackfiles() {
+		packfiles[packfileMAC] = struct{}{}
+	}
+	return packfiles
+}
+
+// deleteSnapshots deletes the snapshots and flags all their packfiles for
+// deletion.
+func deleteSnapshots(t *testing.T, repo *repository.Repository, snaps ...*snapshot.Snapshot) {
+	packfiles := make(map[objects.MAC]struct{})
+	for _, snap := range snaps {
+		for packfileMAC := range packfilesOf(t, snap) {
+			packfiles[packfileMAC] = struct{}{}
+		}
+	}
+	for _, snap := range snaps {
+		require.NoError(t, repo.DeleteSnapshot(snap.Header.Identifier))
+	}
+	require.NoError(t, repo.RebuildState())
+	colourPackfiles(t, repo, packfiles)
+}
+
+func TestUndeleteParse(t *testing.T) {
+	ctx := appcontext.NewAppContext()
+
+	cmd := &Undelete{}
+	require.ErrorContains(t, cmd.Parse(ctx, []string{}), "no snapshot specified")
+
+	cmd = &Undelete{}
+	require.NoError(t, cmd.Parse(ctx, []string{"abcd", "ef01"}))
+	require.Equal(t, []string{"abcd", "ef01"}, cmd.Snapshots)
+}
+
+func TestUndelete(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx, snap := generateSnapshot(t, bufOut, bufErr)
+	id := fmt.Sprintf("%x", snap.Header.GetIndexID())
+
+	deleteSnapshots(t, repo, snap)
+	require.Empty(t, listSnapshots(t, repo))
+
+	cmd := &Undelete{}
+	require.NoError(t, cmd.Parse(ctx, []string{id[:8]}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+	require.Contains(t, bufOut.String(), id[:8]+" restored")
+	require.Contains(t, bufErr.String(), "packfiles scheduled for deletion")
+
+	require.Equal(t, []objects.MAC{snap.Header.Identifier}, listSnapshots(t, repo))
+	for range repo.ListDeletedSnapShots() {
+		t.Fatal("no snapshot should remain deleted")
+	}
+	for packfileMAC := range repo.ListColouredPackfiles() {
+		t.Fatalf("packfile %x should have been uncoloured", packfileMAC)
+	}
+}
+
+func TestUndeleteLeavesOtherPackfilesColoured(t *testing.T) {
+	bufOut := bytes.NewBuffer(nil)
+	bufErr := bytes.NewBuffer(nil)
+	repo, ctx, snap := generateSnapshot(t, bufOut, bufErr)
+	other := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("elsewhere"),
+		ptesting.NewMockFile("elsewhere/other.txt", 0644, "hello other"),
+	})
+	t.Cleanup(func() { other.Close() })
+
+	// the packfiles only the other snapshot needs
+	needed := packfilesOf(t, snap)
+	unrelated := make(map[objects.MAC]struct{})
+	for packfileMAC := range packfilesOf(t, other) {
+		if _, ok := needed[packfileMAC]; !ok {
+			unrelated[packfileMAC] = struct{}{}
+		}
+	}
+	require.NotEmpty(t, unrelated)
+
+	deleteSnapshots(t, repo, snap, other)
+
+	cmd := &Undelete{}
+	require.NoError(t, cmd.Parse(ctx, []string{fmt.Sprintf("%x", snap.Header.GetIndexID())}))
+	status, err := cmd.Execute(ctx, repo)
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+
+	require.Equal(t, []objects.MAC{snap.Header.Identifier}, listSnapshots(t, repo))
+	require.Equal(t, unrelated, colouredPackfiles(repo))
+}
+
+func TestUndeleteReclaimedSnapshot(t *testing.T) {
+	repo, ctx, snap := generateSnapshot(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+	other := ptesting.GenerateSnapshot(t, repo, []ptesting.MockFile{
+		ptesting.NewMockDir("elsewhere"),
+		ptesting.NewMockFile("elsewhere/other.txt", 0644, "hello other"),
+	})
+	t.Cleanup(func() { other.Close() })
+
+	// maintenance reclaims a packfile only the snapshot needs
+	shared := packfilesOf(t, other)
+	var reclaimed objects.MAC
+	for packfileMAC := range packfilesOf(t, snap) {
+		if _, ok := shared[packfileMAC]; !ok {
+			reclaimed = packfileMAC
+			break
+		}
+	}
+	require.NotEqual(t, objects.MAC{}, reclaimed)
+	deleteSnapshots(t, repo, snap, other)
+
+	stateID := objects.RandomMAC()
+	sc, err := repo.AppContext().GetCache().Scan(stateID)
+	require.NoError(t, err)
+	repoWriter := repo.NewRepositoryWriter(sc, stateID, repository.DefaultType, "")
+	require.NoError(t, repoWriter.RemovePackfile(reclaimed))
+	require.NoError(t, repoWriter.CommitTransaction(stateID))
+	require.NoError(t, repo.RebuildState())
+
+	coloured := colouredPackfiles(repo)
+
+	cmd := &Undelete{}
+	require.NoError(t, cmd.Parse(ctx, []string{
+		fmt.Sprintf("%x", other.Header.GetIndexID()),
+		fmt.Sprintf("%x", snap.Header.GetIndexID()),
+	}))
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "can no longer be restored")
+	require.Equal(t, 1, status)
+
+	// nothing changed, not even for the snapshot that could be restored
+	require.Empty(t, listSnapshots(t, repo))
+	require.Equal(t, coloured, colouredPackfiles(repo))
+	deleted := 0
+	for range repo.ListDeletedSnapShots() {
+		deleted++
+	}
+	require.Equal(t, 2, deleted)
+}
+
+func TestUndeleteUnknownSnapshot(t *testing.T) {
+	repo, ctx, snap := generateSnapshot(t, bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+	id := fmt.Sprintf("%x", snap.Header.GetIndexID())
+
+	// live snapshots are not candidates
+	cmd := &Undelete{}
+	require.NoError(t, cmd.Parse(ctx, []string{id}))
+	status, err := cmd.Execute(ctx, repo)
+	require.ErrorContains(t, err, "no deleted snapshot matches")
+	require.Equal(t, 1, status)
+}
@@ -6,9 +6,11 @@ import (
 	"github.com/PlakarKorp/plakar/appcontext"
 	"github.com/PlakarKorp/plakar/reporting"
 	"github.com/PlakarKorp/plakar/subcommands"
+	"github.com/PlakarKorp/plakar/subcommands/anomaly"
 	"github.com/PlakarKorp/plakar/subcommands/backup"
 	"github.com/PlakarKorp/plakar/subcommands/check"
 	"github.com/PlakarKorp/plakar/subcommands/maintenance"
+	"github.com/PlakarKorp/plakar/subcommands/prune"
 	"github.com/PlakarKorp/plakar/subcommands/restore"
 	"github.com/PlakarKorp/plakar/subcommands/rm"
 	"github.com/PlakarKorp/plakar/subcommands/sync"
@@ -27,6 +29,8 @@ func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *re
 
 	var taskKind string
 	switch cmd.(type) {
+	case *anomaly.Anomaly:
+		taskKind = "anomaly"
 	case *backup.Backup:
 		taskKind = "backup"
 	case *check.Check:
@@ -39,6 +43,8 @@ func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *re
 		taskKind = "rm"
 	case *maintenance.Maintenance:
 		taskKind = "maintenance"
+	case *prune.Prune:
+		taskKind = "prune"
 	default:
 		report.SetIgnore()
 	}
@@ -55,7 +61,12 @@ func RunCommand(ctx *appcontext.AppContext, cmd subcommands.Subcommand, repo *re
 	if _, ok := cmd.(*backup.Backup); ok {
 		cmd := cmd.(*backup.Backup)
 		status, err, snapshotID, warning = cmd.DoBackup(ctx, repo)
-		if !cmd.DryRun && err == nil {
+		if !cmd.DryRun && err == nil && snapshotID != (objects.MAC{}) {
+			report.WithSnapshotID(snapshotID)
+		}
+	} else if anomalyCmd, ok := cmd.(*anomaly.Anomaly); ok {
+		status, err, snapshotID, warning = anomalyCmd.DoAnomaly(ctx, repo)
+		if snapshotID != (objects.MAC{}) {
 			report.WithSnapshotID(snapshotID)
 		}
 	} else {
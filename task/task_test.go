@@ -3,6 +3,7 @@ package task
 import (
 	"bytes"
 	"os"
+	"path/filepath"
 	"testing"
 
 	_ "github.com/PlakarKorp/integrations/fs/exporter"
@@ -13,9 +14,12 @@ import (
 	"github.com/PlakarKorp/plakar/ui/stdio"
 	"github.com/stretchr/testify/require"
 
+	"github.com/PlakarKorp/plakar/subcommands/anomaly"
+	"github.com/PlakarKorp/plakar/subcommands/backup"
 	"github.com/PlakarKorp/plakar/subcommands/check"
 	"github.com/PlakarKorp/plakar/subcommands/ls"
 	"github.com/PlakarKorp/plakar/subcommands/maintenance"
+	"github.com/PlakarKorp/plakar/subcommands/prune"
 	"github.com/PlakarKorp/plakar/subcommands/restore"
 	"github.com/PlakarKorp/plakar/subcommands/rm"
 )
@@ -59,6 +63,17 @@ func TestRunCommandCheck(t *testing.T) {
 	require.Equal(t, 0, status)
 }
 
+func TestRunCommandAnomaly(t *testing.T) {
+	ctx, repo := newRepoWithSnapshot(t)
+
+	cmd := &anomaly.Anomaly{}
+	require.NoError(t, cmd.Parse(ctx, []string{}))
+
+	status, err := RunCommand(ctx, cmd, repo, "task-anomaly")
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+}
+
 func TestRunCommandRm(t *testing.T) {
 	ctx, repo := newRepoWithSnapshot(t)
 
@@ -70,13 +85,30 @@ func TestRunCommandRm(t *testing.T) {
 	require.Equal(t, 0, status)
 }
 
-// NOTE: the *backup.Backup branch of RunCommand (DoBackup + WithSnapshotID) is
-// intentionally not tested here. backup wires a package-level stateRefresher
+// NOTE: a real backup through the *backup.Backup branch of RunCommand (DoBackup
+// + WithSnapshotID) is intentionally not tested here. backup wires a package-level stateRefresher
 // that connects to the `cached` daemon; the backup package's own tests override
 // that unexported hook with a stub, but it isn't reachable from this package, so
 // a real backup deadlocks waiting on the daemon. Covering that branch would
 // require the same override mechanism to be exported.
 
+func TestRunCommandBackupWatch(t *testing.T) {
+	// -watch must be honored by the DoBackup path RunCommand takes and
+	// not quietly run a single backup: the watch setup rejects a source
+	// that isn't a directory before any backup is attempted.
+	ctx, repo := newRepoWithSnapshot(t)
+
+	source := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(source, []byte("data"), 0644))
+
+	cmd := &backup.Backup{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-watch", source}))
+
+	status, err := RunCommand(ctx, cmd, repo, "task-backup-watch")
+	require.ErrorContains(t, err, "watch mode requires a directory")
+	require.Equal(t, 1, status)
+}
+
 func TestRunCommandRestore(t *testing.T) {
 	ctx, repo := newRepoWithSnapshot(t)
 
@@ -99,6 +131,17 @@ func TestRunCommandMaintenance(t *testing.T) {
 	require.Equal(t, 0, status)
 }
 
+func TestRunCommandPrune(t *testing.T) {
+	ctx, repo := newRepoWithSnapshot(t)
+
+	cmd := &prune.Prune{}
+	require.NoError(t, cmd.Parse(ctx, []string{"-days", "1"}))
+
+	status, err := RunCommand(ctx, cmd, repo, "task-prune")
+	require.NoError(t, err)
+	require.Equal(t, 0, status)
+}
+
 func TestRunCommandDefaultKindIsIgnored(t *testing.T) {
 	// ls is not one of the recognized task kinds, so the report is ignored.
 	ctx, repo := newRepoWithSnapshot(t)
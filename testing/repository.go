@@ -8,7 +8,6 @@ import (
 	"testing"
 
 	bfs "github.com/PlakarKorp/integrations/fs/storage"
-	"github.com/PlakarKorp/kloset/caching"
 	"github.com/PlakarKorp/kloset/caching/pebble"
 	"github.com/PlakarKorp/kloset/encryption"
 	"github.com/PlakarKorp/kloset/hashing"
@@ -85,8 +84,7 @@ func GenerateRepository(t *testing.T, bufout *bytes.Buffer, buferr *bytes.Buffer
 		ctx.Stdout = bufout
 		ctx.Stderr = buferr
 	}
-	cache := caching.NewManager(pebble.Constructor(tmpCacheDir))
-	ctx.SetCache(cache)
+	ctx.SetCacheConstructor(pebble.Constructor(tmpCacheDir))
 
 	if passphrase != nil {
 		ctx.SetSecret(key)
@@ -148,8 +146,7 @@ func GenerateRepositoryWithoutConfig(t *testing.T, bufout *bytes.Buffer, buferr
 		ctx.Stdout = bufout
 		ctx.Stderr = buferr
 	}
-	cache := caching.NewManager(pebble.Constructor(tmpCacheDir))
-	ctx.SetCache(cache)
+	ctx.SetCacheConstructor(pebble.Constructor(tmpCacheDir))
 
 	if passphrase != nil {
 		ctx.SetSecret(key)
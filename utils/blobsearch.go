@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"iter"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+)
+
+// BlobLocation is a copy of a blob found in the index of a packfile.
+type BlobLocation struct {
+	Packfile objects.MAC
+	Type     resources.Type
+	MAC      objects.MAC
+	Offset   uint64
+	Length   uint32
+}
+
+// SearchBlobs reads the index of every packfile of the repository to find
+// the copies of the blobs match accepts, whatever the state says about
+// them.  This is slow and expensive.  Errors are yielded with the MAC of
+// the packfile that couldn't be read, if any, the search going on with
+// the next one.
+func SearchBlobs(repo *repository.Repository, match func(objects.MAC) bool) iter.Seq2[BlobLocation, error] {
+	return func(yield func(BlobLocation, error) bool) {
+		packfiles, err := repo.GetPackfiles()
+		if err != nil {
+			yield(BlobLocation{}, err)
+			return
+		}
+
+		for _, packfileMAC := range packfiles {
+			p, err := repo.GetPackfile(packfileMAC)
+			if err != nil {
+				if !yield(BlobLocation{Packfile: packfileMAC}, err) {
+					return
+				}
+				continue
+			}
+
+			for _, entry := range p.Index {
+				if !match(entry.MAC) {
+					continue
+				}
+				location := BlobLocation{
+					Packfile: packfileMAC,
+					Type:     entry.Type,
+					MAC:      entry.MAC,
+					Offset:   entry.Offset,
+					Length:   entry.Length,
+				}
+				if !yield(location, nil) {
+					return
+				}
+			}
+		}
+	}
+}
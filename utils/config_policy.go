@@ -10,13 +10,80 @@ import (
 	"strings"
 	"time"
 
+	"github.com/PlakarKorp/go-human2duration"
 	"github.com/PlakarKorp/kloset/locate"
+	"github.com/dustin/go-humanize"
 	"go.yaml.in/yaml/v3"
 )
 
+// Policy is a named set of locate options along with the retention
+// bounds enforced by prune on top of them.
+type Policy struct {
+	locate.LocateOptions `yaml:",inline"`
+
+	// bound of the repository footprint, 0 for no bound
+	MaxSize uint64 `json:"max_size,omitempty" yaml:"max_size,omitempty"`
+
+	// snapshots younger than this are never removed
+	MinAge Duration `json:"min_age,omitempty" yaml:"min_age,omitempty"`
+}
+
+// Duration is a duration written in the policies as the flags take it,
+// such as 7d or 24h.  Durations written by earlier versions, in Go's
+// notation or in nanoseconds, are still read.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	switch v := time.Duration(d); {
+	case v == 0:
+		return "0s"
+	case v%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", v/(24*time.Hour))
+	case v%time.Hour == 0:
+		return fmt.Sprintf("%dh", v/time.Hour)
+	case v%time.Minute == 0:
+		return fmt.Sprintf("%dmin", v/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", v/time.Second)
+	}
+}
+
+func (d *Duration) parse(value string) error {
+	if ns, err := strconv.ParseInt(value, 10, 64); err == nil {
+		*d = Duration(ns)
+		return nil
+	}
+	v, err := human2duration.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.parse(node.Value)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return d.parse(string(data))
+	}
+	return d.parse(value)
+}
+
 type policiesConfig struct {
-	Version  string                           `yaml:"version"`
-	Policies map[string]*locate.LocateOptions `yaml:"policies"`
+	Version  string             `yaml:"version"`
+	Policies map[string]*Policy `yaml:"policies"`
 }
 
 func (c *policiesConfig) Has(name string) bool {
@@ -25,7 +92,7 @@ func (c *policiesConfig) Has(name string) bool {
 }
 
 func (c *policiesConfig) Add(name string) {
-	c.Policies[name] = &locate.LocateOptions{}
+	c.Policies[name] = &Policy{}
 }
 
 func (c *policiesConfig) setInt(value string, p *int) error {
@@ -49,6 +116,27 @@ func (c *policiesConfig) setTime(value string, p *time.Time) error {
 	return nil
 }
 
+func (c *policiesConfig) setSize(value string, p *uint64) error {
+	size, err := humanize.ParseBytes(value)
+	if err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	*p = size
+	return nil
+}
+
+func (c *policiesConfig) setDuration(value string, p *Duration) error {
+	d, err := human2duration.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid value: %w", err)
+	}
+	if d < 0 {
+		return fmt.Errorf("negative value")
+	}
+	*p = Duration(d)
+	return nil
+}
+
 func (c *policiesConfig) setStringList(value string, p *[]string) error {
 	*p = strings.Split(value, ",")
 	return nil
@@ -138,6 +226,11 @@ func (c *policiesConfig) locateField(name string, key string) (any, error) {
 	case "per-sunday":
 		return &p.Periods.Sunday.Cap, nil
 
+	case "max-size":
+		return &p.MaxSize, nil
+	case "min-age":
+		return &p.MinAge, nil
+
 	default:
 		return nil, fmt.Errorf("invalid key")
 	}
@@ -154,6 +247,10 @@ func (c *policiesConfig) Set(name string, key string, value string) error {
 		return c.setInt(value, p)
 	case *time.Time:
 		return c.setTime(value, p)
+	case *uint64:
+		return c.setSize(value, p)
+	case *Duration:
+		return c.setDuration(value, p)
 	case *string:
 		*p = value
 		return nil
@@ -182,6 +279,10 @@ func (c *policiesConfig) Unset(name string, key string) error {
 		*p = 0
 	case *time.Time:
 		*p = time.Time{}
+	case *uint64:
+		*p = 0
+	case *Duration:
+		*p = 0
 	case *string:
 		*p = ""
 	case *[]string:
@@ -232,9 +333,9 @@ func (c *policiesConfig) Dump(w io.Writer, format string, names []string) error
 		var err error
 		switch format {
 		case "json":
-			err = json.NewEncoder(w).Encode(map[string]*locate.LocateOptions{name: c.Policies[name]})
+			err = json.NewEncoder(w).Encode(map[string]*Policy{name: c.Policies[name]})
 		case "yaml":
-			err = yaml.NewEncoder(w).Encode(map[string]*locate.LocateOptions{name: c.Policies[name]})
+			err = yaml.NewEncoder(w).Encode(map[string]*Policy{name: c.Policies[name]})
 		default:
 			return fmt.Errorf("unknown format %q", format)
 		}
@@ -249,7 +350,7 @@ func (c *policiesConfig) Dump(w io.Writer, format string, names []string) error
 func LoadPolicyConfigFile(filename string) (*policiesConfig, error) {
 	var cfg policiesConfig
 	cfg.Version = "v1.0.0"
-	cfg.Policies = make(map[string]*locate.LocateOptions)
+	cfg.Policies = make(map[string]*Policy)
 
 	rd, err := os.Open(filename)
 	if err != nil {
@@ -272,5 +373,5 @@ func (c *policiesConfig) ApplyConfig(name string, po *locate.LocateOptions) {
 	if !ok {
 		return
 	}
-	*po = *p
+	*po = p.LocateOptions
 }
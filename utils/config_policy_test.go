@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/PlakarKorp/kloset/locate"
 )
@@ -14,7 +15,7 @@ import (
 func newTestPolicies() *policiesConfig {
 	return &policiesConfig{
 		Version:  "v1.0.0",
-		Policies: make(map[string]*locate.LocateOptions),
+		Policies: make(map[string]*Policy),
 	}
 }
 
@@ -118,6 +119,93 @@ func TestPoliciesSetTime(t *testing.T) {
 	}
 }
 
+func TestPoliciesSetRetention(t *testing.T) {
+	c := newTestPolicies()
+	c.Add("p")
+	if err := c.Set("p", "max-size", "10GiB"); err != nil {
+		t.Fatalf("Set max-size: %v", err)
+	}
+	if err := c.Set("p", "min-age", "2d"); err != nil {
+		t.Fatalf("Set min-age: %v", err)
+	}
+	if err := c.Set("p", "max-size", "huge"); err == nil {
+		t.Fatal("expected error for invalid size")
+	}
+	if err := c.Set("p", "min-age", "soon"); err == nil {
+		t.Fatal("expected error for invalid duration")
+	}
+
+	path := filepath.Join(t.TempDir(), "policies.yml")
+	if err := c.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile: %v", err)
+	}
+	loaded, err := LoadPolicyConfigFile(path)
+	if err != nil {
+		t.Fatalf("LoadPolicyConfigFile: %v", err)
+	}
+	if loaded.Policies["p"].MaxSize != 10<<30 {
+		t.Fatalf("MaxSize = %d", loaded.Policies["p"].MaxSize)
+	}
+	if loaded.Policies["p"].MinAge != Duration(48*time.Hour) {
+		t.Fatalf("MinAge = %s", loaded.Policies["p"].MinAge)
+	}
+
+	if err := c.Unset("p", "max-size"); err != nil {
+		t.Fatalf("Unset max-size: %v", err)
+	}
+	if c.Policies["p"].MaxSize != 0 {
+		t.Fatal("MaxSize should be unset")
+	}
+}
+
+func TestPoliciesLoadMinAge(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"7d":              7 * 24 * time.Hour,
+		"24h":             24 * time.Hour,
+		"90min":           90 * time.Minute,
+		"48h0m0s":         48 * time.Hour,
+		"172800000000000": 48 * time.Hour,
+	} {
+		c := newTestPolicies()
+		data := "version: v1.0.0\npolicies:\n  p:\n    min_age: " + value + "\n"
+		if err := c.Load(strings.NewReader(data)); err != nil {
+			t.Fatalf("Load %q: %v", value, err)
+		}
+		if got := time.Duration(c.Policies["p"].MinAge); got != expected {
+			t.Fatalf("MinAge %q = %s, expected %s", value, got, expected)
+		}
+	}
+
+	c := newTestPolicies()
+	if err := c.Load(strings.NewReader("policies:\n  p:\n    min_age: soon\n")); err == nil {
+		t.Fatal("expected error for invalid duration")
+	}
+
+	c = newTestPolicies()
+	c.Add("p")
+	if err := c.Set("p", "min-age", "1w"); err != nil {
+		t.Fatalf("Set min-age: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := c.Dump(&buf, "yaml", []string{"p"}); err != nil {
+		t.Fatalf("Dump yaml: %v", err)
+	}
+	if !strings.Contains(buf.String(), "min_age: 7d") {
+		t.Fatalf("dump did not contain min_age: %q", buf.String())
+	}
+	buf.Reset()
+	if err := c.Dump(&buf, "json", []string{"p"}); err != nil {
+		t.Fatalf("Dump json: %v", err)
+	}
+	var got map[string]*Policy
+	if err := json.NewDecoder(&buf).Decode(&got); err != nil {
+		t.Fatalf("decode dumped json: %v", err)
+	}
+	if got["p"].MinAge != Duration(7*24*time.Hour) {
+		t.Fatalf("MinAge = %s", got["p"].MinAge)
+	}
+}
+
 func TestPoliciesSetUnknownKey(t *testing.T) {
 	c := newTestPolicies()
 	c.Add("p")
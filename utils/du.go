@@ -0,0 +1,438 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+const (
+	DiskUsageByType      = "type"
+	DiskUsageByExtension = "ext"
+)
+
+type DiskUsageOptions struct {
+	// depth of the tree below the pathname, negative for no limit
+	Depth int
+
+	// include regular files in the tree, not only directories
+	Files bool
+
+	// compute the bytes not shared with any other snapshot, with Owners
+	// if set or with an index built for the occasion otherwise
+	Unique bool
+	Owners *ChunkOwners
+}
+
+// DiskUsage is the size of a file or directory and the number of regular
+// files it accounts for.  Unique is the part of the size stored in
+// chunks that no other snapshot references, a chunk used by several
+// files of the snapshot being counted once.
+type DiskUsage struct {
+	Path     string       `json:"path"`
+	Name     string       `json:"name"`
+	Dir      bool         `json:"dir"`
+	Size     uint64       `json:"size"`
+	Files    uint64       `json:"files"`
+	Unique   uint64       `json:"unique,omitempty"`
+	Children []*DiskUsage `json:"children,omitempty"`
+}
+
+// DiskUsageGroup is the disk usage of the regular files sharing a
+// content type or an extension.
+type DiskUsageGroup struct {
+	Group  string `json:"group"`
+	Size   uint64 `json:"size"`
+	Files  uint64 `json:"files"`
+	Unique uint64 `json:"unique,omitempty"`
+}
+
+// LoadEntrySummary loads the summary of the directory entry from the
+// snapshot summary index, unless it is already set.
+func LoadEntrySummary(repo *repository.Repository, snap *snapshot.Snapshot, entry *vfs.Entry) error {
+	if entry.Summary != nil {
+		return nil
+	}
+
+	tree, err := snap.SummaryIdx()
+	if err != nil {
+		return err
+	}
+
+	key, found, err := tree.Find(entry.Path())
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("could not resolve pathname: %s", entry.Path())
+	}
+
+	serializedSummary, err := repo.GetBlobBytes(resources.RT_VFS_SUMMARY, key)
+	if err != nil {
+		return err
+	}
+
+	entry.Summary, err = vfs.SummaryFromBytes(serializedSummary)
+	return err
+}
+
+// GetDiskUsage returns the disk usage tree rooted at pathname in snap.
+// Directory sizes come from the VFS summaries, so that only the
+// directories within opts.Depth are visited unless unique bytes are
+// requested, which requires resolving every file below pathname and
+// every file of the other snapshots.
+func GetDiskUsage(ctx context.Context, repo *repository.Repository, snap *snapshot.Snapshot, pathname string, opts *DiskUsageOptions) (*DiskUsage, error) {
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := fs.GetEntry(pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]*DiskUsage)
+
+	var walk func(entry *vfs.Entry, depth int) (*DiskUsage, error)
+	walk = func(entry *vfs.Entry, depth int) (*DiskUsage, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		du := &DiskUsage{
+			Path: entry.Path(),
+			Name: entry.Name(),
+			Dir:  entry.IsDir(),
+		}
+		nodes[du.Path] = du
+
+		if !entry.IsDir() {
+			du.Size = uint64(entry.Size())
+			if entry.Stat().Mode().IsRegular() {
+				du.Files = 1
+			}
+			return du, nil
+		}
+
+		if err := LoadEntrySummary(repo, snap, entry); err != nil {
+			return nil, err
+		}
+		du.Size = entry.Summary.Directory.Size + entry.Summary.Below.Size
+		du.Files = entry.Summary.Directory.Files + entry.Summary.Below.Files
+
+		if depth == 0 {
+			return du, nil
+		}
+
+		iter, err := entry.Getdents(fs)
+		if err != nil {
+			return nil, err
+		}
+		for child, err := range iter {
+			if err != nil {
+				return nil, err
+			}
+			if !child.IsDir() && !(opts.Files && child.Stat().Mode().IsRegular()) {
+				continue
+			}
+			node, err := walk(child, depth-1)
+			if err != nil {
+				return nil, err
+			}
+			du.Children = append(du.Children, node)
+		}
+		return du, nil
+	}
+
+	du, err := walk(root, opts.Depth)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Unique {
+		owners := opts.Owners
+		if owners == nil {
+			owners, err = NewChunkOwners(ctx, repo)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = walkUnique(ctx, snap, fs, root, owners, func(entry *vfs.Entry, unique uint64) error {
+			// files below the depth account for their closest ancestor
+			for p := entry.Path(); ; p = path.Dir(p) {
+				if node, ok := nodes[p]; ok {
+					node.Unique += unique
+				}
+				if p == root.Path() || p == "/" {
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return du, nil
+}
+
+// GetDiskUsageGroups returns the disk usage of the regular files at or
+// below pathname in snap grouped by content type or by extension, from
+// the largest group to the smallest, by unique bytes if requested.
+func GetDiskUsageGroups(ctx context.Context, repo *repository.Repository, snap *snapshot.Snapshot, pathname string, by string, unique bool) ([]DiskUsageGroup, error) {
+	var key func(entry *vfs.Entry) string
+	switch by {
+	case DiskUsageByType:
+		key = func(entry *vfs.Entry) string {
+			contentType, _, _ := strings.Cut(entry.GetContentType(), ";")
+			if contentType == "" {
+				return "(unknown)"
+			}
+			return contentType
+		}
+	case DiskUsageByExtension:
+		key = func(entry *vfs.Entry) string {
+			ext := strings.ToLower(path.Ext(entry.Name()))
+			if ext == "" {
+				return "(none)"
+			}
+			return ext
+		}
+	default:
+		return nil, fmt.Errorf("invalid grouping %q, must be %q or %q", by, DiskUsageByType, DiskUsageByExtension)
+	}
+
+	fs, err := snap.Filesystem()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := fs.GetEntry(pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string]*DiskUsageGroup)
+	add := func(entry *vfs.Entry, unique uint64) error {
+		name := key(entry)
+		group, ok := groups[name]
+		if !ok {
+			group = &DiskUsageGroup{Group: name}
+			groups[name] = group
+		}
+		group.Size += uint64(entry.Size())
+		group.Files++
+		group.Unique += unique
+		return nil
+	}
+
+	if unique {
+		var owners *ChunkOwners
+		owners, err = NewChunkOwners(ctx, repo)
+		if err == nil {
+			err = walkUnique(ctx, snap, fs, root, owners, add)
+		}
+	} else {
+		err = walkFiles(ctx, fs, root, func(entry *vfs.Entry) error {
+			return add(entry, 0)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]DiskUsageGroup, 0, len(groups))
+	for _, group := range groups {
+		ret = append(ret, *group)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if unique && ret[i].Unique != ret[j].Unique {
+			return ret[i].Unique > ret[j].Unique
+		}
+		if ret[i].Size != ret[j].Size {
+			return ret[i].Size > ret[j].Size
+		}
+		return ret[i].Group < ret[j].Group
+	})
+	return ret, nil
+}
+
+// walkFiles calls fn with the regular files at or below root.
+func walkFiles(ctx context.Context, fs *vfs.Filesystem, root *vfs.Entry, fn func(*vfs.Entry) error) error {
+	if !root.IsDir() {
+		if root.Stat().Mode().IsRegular() {
+			return fn(root)
+		}
+		return nil
+	}
+
+	for entry, err := range fs.Files(root.Path()) {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !entry.Stat().Mode().IsRegular() {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkUnique calls fn with the regular files at or below root along with
+// the bytes of their chunks not referenced by any other snapshot and not
+// already accounted for by a previous file.
+func walkUnique(ctx context.Context, snap *snapshot.Snapshot, fs *vfs.Filesystem, root *vfs.Entry, owners *ChunkOwners, fn func(*vfs.Entry, uint64) error) error {
+	seen := make(map[objects.MAC]struct{})
+	return walkFiles(ctx, fs, root, func(entry *vfs.Entry) error {
+		if !entry.HasObject() {
+			return fn(entry, 0)
+		}
+
+		object, err := snap.LookupObject(entry.Object)
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Path(), err)
+		}
+
+		var unique uint64
+		for _, chunk := range object.Chunks {
+			if !owners.unique(snap.Header.Identifier, chunk.ContentMAC) {
+				continue
+			}
+			if _, ok := seen[chunk.ContentMAC]; ok {
+				continue
+			}
+			seen[chunk.ContentMAC] = struct{}{}
+			unique += uint64(chunk.Length)
+		}
+		return fn(entry, unique)
+	})
+}
+
+// sharedOwner marks the chunks and objects of several snapshots.
+const sharedOwner = -1
+
+// ChunkOwners records which snapshot references each chunk of the files
+// of a repository, or that several do.  It only depends on the snapshots
+// of the repository: built once, it answers for any of them until one is
+// added or removed, which Current tells.
+type ChunkOwners struct {
+	snapshots map[objects.MAC]int
+	chunks    map[objects.MAC]int
+}
+
+// NewChunkOwners resolves the files of every snapshot of the repository
+// to index the owners of their chunks.  Each object is resolved once, and
+// a second time if it turns out to be shared.
+func NewChunkOwners(ctx context.Context, repo *repository.Repository) (*ChunkOwners, error) {
+	owners := &ChunkOwners{
+		snapshots: make(map[objects.MAC]int),
+		chunks:    make(map[objects.MAC]int),
+	}
+	objectOwners := make(map[objects.MAC]int)
+
+	for snapshotID, err := range repo.ListSnapshots() {
+		if err != nil {
+			return nil, err
+		}
+		owner := len(owners.snapshots)
+		owners.snapshots[snapshotID] = owner
+
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			return nil, fmt.Errorf("%x: %w", snapshotID[:4], err)
+		}
+
+		err = func() error {
+			defer snap.Close()
+
+			fs, err := snap.Filesystem()
+			if err != nil {
+				return err
+			}
+			root, err := fs.GetEntry("/")
+			if err != nil {
+				return err
+			}
+
+			return walkFiles(ctx, fs, root, func(entry *vfs.Entry) error {
+				if !entry.HasObject() {
+					return nil
+				}
+
+				current, ok := objectOwners[entry.Object]
+				switch {
+				case !ok:
+					objectOwners[entry.Object] = owner
+				case current == owner || current == sharedOwner:
+					return nil
+				default:
+					objectOwners[entry.Object] = sharedOwner
+				}
+
+				object, err := snap.LookupObject(entry.Object)
+				if err != nil {
+					return fmt.Errorf("%s: %w", entry.Path(), err)
+				}
+				for _, chunk := range object.Chunks {
+					owners.add(chunk.ContentMAC, objectOwners[entry.Object])
+				}
+				return nil
+			})
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("%x: %w", snapshotID[:4], err)
+		}
+	}
+	return owners, nil
+}
+
+func (o *ChunkOwners) add(chunk objects.MAC, owner int) {
+	current, ok := o.chunks[chunk]
+	if !ok {
+		o.chunks[chunk] = owner
+	} else if current != owner {
+		o.chunks[chunk] = sharedOwner
+	}
+}
+
+// unique reports whether no snapshot but the given one references the
+// chunk.
+func (o *ChunkOwners) unique(snapshotID objects.MAC, chunk objects.MAC) bool {
+	current, ok := o.chunks[chunk]
+	if !ok {
+		return true
+	}
+	owner, ok := o.snapshots[snapshotID]
+	return ok && current == owner
+}
+
+// Current reports whether the index still matches the snapshots of the
+// repository.
+func (o *ChunkOwners) Current(repo *repository.Repository) (bool, error) {
+	count := 0
+	for snapshotID, err := range repo.ListSnapshots() {
+		if err != nil {
+			return false, err
+		}
+		if _, ok := o.snapshots[snapshotID]; !ok {
+			return false, nil
+		}
+		count++
+	}
+	return count == len(o.snapshots), nil
+}
@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+// Footprint estimates the space used by the chunks of the snapshots of a
+// repository, a chunk referenced by several snapshots being counted once,
+// and keeps track of the space reclaimed as snapshots are removed from
+// the estimate.
+type Footprint struct {
+	size      uint64
+	lengths   map[objects.MAC]uint32
+	refs      map[objects.MAC]int
+	snapshots map[objects.MAC][]objects.MAC
+}
+
+func NewFootprint(ctx context.Context, repo *repository.Repository) (*Footprint, error) {
+	f := &Footprint{
+		lengths:   make(map[objects.MAC]uint32),
+		refs:      make(map[objects.MAC]int),
+		snapshots: make(map[objects.MAC][]objects.MAC),
+	}
+
+	// objects are shared between snapshots, only resolve each of them once
+	resolved := make(map[objects.MAC][]objects.Chunk)
+
+	for snapshotID, err := range repo.ListSnapshots() {
+		if err != nil {
+			return nil, err
+		}
+
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			return nil, fmt.Errorf("%x: %w", snapshotID[:4], err)
+		}
+
+		chunks := make(map[objects.MAC]struct{})
+		err = func() error {
+			defer snap.Close()
+
+			fs, err := snap.Filesystem()
+			if err != nil {
+				return err
+			}
+			root, err := fs.GetEntry("/")
+			if err != nil {
+				return err
+			}
+
+			return walkFiles(ctx, fs, root, func(entry *vfs.Entry) error {
+				if !entry.HasObject() {
+					return nil
+				}
+				objectChunks, ok := resolved[entry.Object]
+				if !ok {
+					object, err := snap.LookupObject(entry.Object)
+					if err != nil {
+						return fmt.Errorf("%s: %w", entry.Path(), err)
+					}
+					objectChunks = object.Chunks
+					resolved[entry.Object] = objectChunks
+				}
+				for _, chunk := range objectChunks {
+					if _, ok := chunks[chunk.ContentMAC]; ok {
+						continue
+					}
+					chunks[chunk.ContentMAC] = struct{}{}
+					if f.refs[chunk.ContentMAC] == 0 {
+						f.lengths[chunk.ContentMAC] = chunk.Length
+						f.size += uint64(chunk.Length)
+					}
+					f.refs[chunk.ContentMAC]++
+				}
+				return nil
+			})
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("%x: %w", snapshotID[:4], err)
+		}
+
+		list := make([]objects.MAC, 0, len(chunks))
+		for mac := range chunks {
+			list = append(list, mac)
+		}
+		f.snapshots[snapshotID] = list
+	}
+	return f, nil
+}
+
+// Size returns the bytes used by the chunks of the remaining snapshots.
+func (f *Footprint) Size() uint64 {
+	return f.size
+}
+
+// Exclusive returns the bytes of the chunks no other remaining snapshot
+// references, those the removal of the snapshot would reclaim.
+func (f *Footprint) Exclusive(snapshotID objects.MAC) uint64 {
+	var size uint64
+	for _, mac := range f.snapshots[snapshotID] {
+		if f.refs[mac] == 1 {
+			size += uint64(f.lengths[mac])
+		}
+	}
+	return size
+}
+
+// Remove removes the snapshot from the estimate and returns the bytes it
+// reclaims, those of the chunks no remaining snapshot references.
+func (f *Footprint) Remove(snapshotID objects.MAC) uint64 {
+	var reclaimed uint64
+	for _, mac := range f.snapshots[snapshotID] {
+		f.refs[mac]--
+		if f.refs[mac] == 0 {
+			reclaimed += uint64(f.lengths[mac])
+			delete(f.refs, mac)
+			delete(f.lengths, mac)
+		}
+	}
+	delete(f.snapshots, snapshotID)
+	f.size -= reclaimed
+	return reclaimed
+}
@@ -0,0 +1,238 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+// GrepMaxLine is the length at which lines are truncated, only their
+// beginning being matched and reported.
+const GrepMaxLine = 64 * 1024
+
+// GrepOptions controls how file contents are matched by Grep.
+type GrepOptions struct {
+	Pattern    string
+	Fixed      bool
+	IgnoreCase bool
+	FilesOnly  bool
+
+	// content types to search, either a type such as "text" or a full
+	// type such as "text/plain"; all files are searched if empty.
+	Mimes []string
+
+	Concurrency int
+}
+
+// GrepMatch is a line matching the pattern, truncated to GrepMaxLine
+// bytes, only Path is set when
+// FilesOnly is requested.  A binary file is reported once, without
+// its content, and a file that could not be read with Error set.
+type GrepMatch struct {
+	Path   string `json:"path"`
+	Line   int    `json:"line,omitempty"`
+	Text   string `json:"text,omitempty"`
+	Binary bool   `json:"binary,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Compile returns the regular expression matching the pattern.
+func (opts *GrepOptions) Compile() (*regexp.Regexp, error) {
+	pattern := opts.Pattern
+	if opts.Fixed {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if opts.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// MatchMime returns true if the content type is one of mimes.
+func MatchMime(mimes []string, contentType string) bool {
+	if len(mimes) == 0 {
+		return true
+	}
+
+	contentType, _, _ = strings.Cut(contentType, ";")
+	typ, _, _ := strings.Cut(contentType, "/")
+	for _, mime := range mimes {
+		if strings.Contains(mime, "/") {
+			if strings.EqualFold(mime, contentType) {
+				return true
+			}
+		} else if strings.EqualFold(mime, typ) {
+			return true
+		}
+	}
+	return false
+}
+
+type grepJob struct {
+	entry   *vfs.Entry
+	matches chan []GrepMatch
+}
+
+// Grep searches the regular files at or below pathname in fs and calls
+// fn with their matching lines, in the order of the files.  Up to
+// opts.Concurrency files are read at once.
+func Grep(ctx context.Context, fs *vfs.Filesystem, pathname string, opts *GrepOptions, fn func(GrepMatch) error) error {
+	re, err := opts.Compile()
+	if err != nil {
+		return err
+	}
+
+	root, err := fs.GetEntry(pathname)
+	if err != nil {
+		return err
+	}
+
+	concurrency := max(opts.Concurrency, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan grepJob)
+	// results are consumed in the order the files were queued
+	pending := make(chan grepJob, concurrency)
+
+	for range concurrency {
+		go func() {
+			for job := range jobs {
+				matches, err := grepEntry(ctx, fs, job.entry, re, opts.FilesOnly)
+				if err != nil {
+					matches = append(matches, GrepMatch{Path: job.entry.Path(), Error: err.Error()})
+				}
+				job.matches <- matches
+			}
+		}()
+	}
+
+	walkErr := make(chan error, 1)
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+
+		queue := func(entry *vfs.Entry) bool {
+			if !entry.Stat().Mode().IsRegular() || !MatchMime(opts.Mimes, entry.GetContentType()) {
+				return true
+			}
+			job := grepJob{entry: entry, matches: make(chan []GrepMatch, 1)}
+			select {
+			case pending <- job:
+			case <-ctx.Done():
+				return false
+			}
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return false
+			}
+			return true
+		}
+
+		if !root.IsDir() {
+			queue(root)
+			walkErr <- nil
+			return
+		}
+
+		for entry, err := range fs.Files(root.Path()) {
+			if err != nil {
+				walkErr <- err
+				return
+			}
+			if !queue(entry) {
+				break
+			}
+		}
+		walkErr <- nil
+	}()
+
+	for job := range pending {
+		var matches []GrepMatch
+		select {
+		case matches = <-job.matches:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		for _, match := range matches {
+			if err := fn(match); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := <-walkErr; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func grepEntry(ctx context.Context, fs *vfs.Filesystem, entry *vfs.Entry, re *regexp.Regexp, filesOnly bool) ([]GrepMatch, error) {
+	pathname := entry.Path()
+
+	fp, err := entry.Open(fs)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var matches []GrepMatch
+	var buf []byte
+	rd := bufio.NewReader(fp)
+	for lineno := 1; ; lineno++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		line, more, err := readLine(rd, buf[:0], GrepMaxLine)
+		buf = line
+		if more {
+			line = bytes.TrimSuffix(line, []byte("\n"))
+			if re.Match(line) {
+				if bytes.IndexByte(line, 0) != -1 {
+					return []GrepMatch{{Path: pathname, Binary: true}}, nil
+				}
+				if filesOnly {
+					return []GrepMatch{{Path: pathname}}, nil
+				}
+				matches = append(matches, GrepMatch{
+					Path: pathname,
+					Line: lineno,
+					Text: string(bytes.TrimSuffix(line, []byte("\r"))),
+				})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return matches, err
+		}
+	}
+	return matches, nil
+}
+
+// readLine appends to buf the next line of rd, up to max bytes, and skips
+// the rest of it.  It returns false if there was nothing left to read.
+func readLine(rd *bufio.Reader, buf []byte, max int) ([]byte, bool, error) {
+	more := false
+	for {
+		chunk, err := rd.ReadSlice('\n')
+		if len(chunk) != 0 {
+			more = true
+		}
+		if room := max - len(buf); room > 0 {
+			buf = append(buf, chunk[:min(len(chunk), room)]...)
+		}
+		if err != bufio.ErrBufferFull {
+			return buf, more, err
+		}
+	}
+}
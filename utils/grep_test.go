@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrepCompile(t *testing.T) {
+	opts := GrepOptions{Pattern: "a.c", Fixed: true}
+	re, err := opts.Compile()
+	require.NoError(t, err)
+	require.True(t, re.MatchString("xa.cx"))
+	require.False(t, re.MatchString("abc"))
+
+	opts = GrepOptions{Pattern: "a.c", IgnoreCase: true}
+	re, err = opts.Compile()
+	require.NoError(t, err)
+	require.True(t, re.MatchString("ABC"))
+
+	opts = GrepOptions{Pattern: "("}
+	_, err = opts.Compile()
+	require.Error(t, err)
+}
+
+func TestMatchMime(t *testing.T) {
+	require.True(t, MatchMime(nil, "application/pdf"))
+	require.True(t, MatchMime([]string{"text"}, "text/plain; charset=utf-8"))
+	require.True(t, MatchMime([]string{"application/pdf", "text/plain"}, "text/plain"))
+	require.False(t, MatchMime([]string{"text/html"}, "text/plain"))
+	require.False(t, MatchMime([]string{"text"}, "application/json"))
+	require.False(t, MatchMime([]string{"text"}, ""))
+}
+
+func TestReadLine(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	rd := bufio.NewReaderSize(strings.NewReader("short\n"+long+"\n\nlast"), 16)
+
+	line, more, err := readLine(rd, nil, 100)
+	require.NoError(t, err)
+	require.True(t, more)
+	require.Equal(t, "short\n", string(line))
+
+	// an overlong line is truncated and the rest of it skipped
+	line, more, err = readLine(rd, line[:0], 100)
+	require.NoError(t, err)
+	require.True(t, more)
+	require.Equal(t, long[:100], string(line))
+
+	line, more, err = readLine(rd, line[:0], 100)
+	require.NoError(t, err)
+	require.True(t, more)
+	require.Equal(t, "\n", string(line))
+
+	line, more, err = readLine(rd, line[:0], 100)
+	require.Equal(t, io.EOF, err)
+	require.True(t, more)
+	require.Equal(t, "last", string(line))
+
+	_, more, err = readLine(rd, line[:0], 100)
+	require.Equal(t, io.EOF, err)
+	require.False(t, more)
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/resources"
+	"github.com/PlakarKorp/kloset/versioning"
+	"golang.org/x/mod/semver"
+)
+
+// RT_HOLD is the type of the blobs recording the holds placed on
+// snapshots.  kloset has no room for resource types of its own: the
+// packer and the states refuse the types missing from resources.Types(),
+// and snapshot headers can't change once committed.  The configuration is
+// never stored as a blob, so the hold records borrow its type, under MACs
+// of their own (see HoldMAC) and with their own version, HOLD_VERSION.
+const RT_HOLD = resources.RT_CONFIG
+
+// HOLD_VERSION is the version of the hold records written, records of a
+// newer version are refused.
+const HOLD_VERSION = "1.0.0"
+
+var ErrSnapshotHeld = errors.New("snapshot is held")
+
+// Hold prevents the removal of a snapshot until a given time, or forever
+// if Until is zero.
+type Hold struct {
+	Version   versioning.Version `json:"version"`
+	Snapshot  objects.MAC        `json:"snapshot"`
+	CreatedAt time.Time          `json:"created_at"`
+	Until     time.Time          `json:"until"`
+	Reason    string             `json:"reason,omitempty"`
+}
+
+// Active reports whether the hold still prevents the removal at now.
+func (h *Hold) Active(now time.Time) bool {
+	return h.Until.IsZero() || now.Before(h.Until)
+}
+
+func (h *Hold) String() string {
+	if h.Until.IsZero() {
+		return "held indefinitely"
+	}
+	return "held until " + h.Until.UTC().Format(time.RFC3339)
+}
+
+// HoldMAC returns the MAC of the blob recording the hold of a snapshot.
+func HoldMAC(repo *repository.Repository, snapshotID objects.MAC) objects.MAC {
+	return repo.ComputeMAC(append([]byte("hold:"), snapshotID[:]...))
+}
+
+// GetHold returns the hold of a snapshot, or nil if it is not held.
+func GetHold(repo *repository.Repository, snapshotID objects.MAC) (*Hold, error) {
+	mac := HoldMAC(repo, snapshotID)
+	if !repo.BlobExists(RT_HOLD, mac) {
+		return nil, nil
+	}
+
+	data, err := repo.GetBlobBytes(RT_HOLD, mac)
+	if err != nil {
+		return nil, err
+	}
+
+	var hold Hold
+	if err := json.Unmarshal(data, &hold); err != nil {
+		return nil, fmt.Errorf("invalid hold record: %w", err)
+	}
+
+	current := versioning.FromString(HOLD_VERSION)
+	if semver.Compare(hold.Version.String(), current.String()) > 0 {
+		return nil, fmt.Errorf("hold version %q is newer than current version %q",
+			hold.Version, current)
+	}
+	return &hold, nil
+}
+
+// ActiveHold returns the hold preventing the removal of a snapshot at now,
+// or nil if it is not held or its hold expired.
+func ActiveHold(repo *repository.Repository, snapshotID objects.MAC, now time.Time) (*Hold, error) {
+	hold, err := GetHold(repo, snapshotID)
+	if err != nil {
+		return nil, fmt.Errorf("%x: %w", snapshotID[:4], err)
+	}
+	if hold == nil || !hold.Active(now) {
+		return nil, nil
+	}
+	return hold, nil
+}
+
+// ListHolds returns the holds of the snapshots of the repository, oldest
+// first.
+func ListHolds(repo *repository.Repository) ([]*Hold, error) {
+	holds := make([]*Hold, 0)
+	for snapshotID, err := range repo.ListSnapshots() {
+		if err != nil {
+			return nil, err
+		}
+		hold, err := GetHold(repo, snapshotID)
+		if err != nil {
+			return nil, fmt.Errorf("%x: %w", snapshotID[:4], err)
+		}
+		if hold != nil {
+			holds = append(holds, hold)
+		}
+	}
+
+	sort.Slice(holds, func(i, j int) bool {
+		return holds[i].CreatedAt.Before(holds[j].CreatedAt)
+	})
+	return holds, nil
+}
+
+// PutHold records the hold in the repository, replacing the previous hold
+// of the snapshot if any.
+func PutHold(repo *repository.Repository, hold *Hold) error {
+	hold.Version = versioning.FromString(HOLD_VERSION)
+	data, err := json.Marshal(hold)
+	if err != nil {
+		return err
+	}
+	return updateHold(repo, hold.Snapshot, data)
+}
+
+// RemoveHold removes the hold of a snapshot from the repository.
+func RemoveHold(repo *repository.Repository, snapshotID objects.MAC) error {
+	return updateHold(repo, snapshotID, nil)
+}
+
+func updateHold(repo *repository.Repository, snapshotID objects.MAC, data []byte) error {
+	stateID := objects.RandomMAC()
+	sc, err := repo.AppContext().GetCache().Scan(stateID)
+	if err != nil {
+		return err
+	}
+	repoWriter := repo.NewRepositoryWriter(sc, stateID, repository.DefaultType, "")
+
+	mac := HoldMAC(repo, snapshotID)
+	packfileMAC, exists, err := repo.GetPackfileForBlob(RT_HOLD, mac)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := repoWriter.RemoveBlob(RT_HOLD, mac, packfileMAC); err != nil {
+			return err
+		}
+	}
+
+	if data != nil {
+		if err := repoWriter.PutBlob(RT_HOLD, mac, data, false); err != nil {
+			return err
+		}
+	}
+
+	repoWriter.PackerManager.Wait()
+	if err := repoWriter.CommitTransaction(stateID); err != nil {
+		return err
+	}
+
+	// make the change visible to the callers sharing the repository
+	return repo.RebuildState()
+}
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/PlakarKorp/kloset/exclude"
+)
+
+// PatternsFlag collects the values of a repeatable flag.
+type PatternsFlag []string
+
+func (p *PatternsFlag) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *PatternsFlag) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// LoadIgnoreFile reads a file of gitignore-style patterns, one per
+// line, skipping blank lines and comments.
+func LoadIgnoreFile(filename string) ([]string, error) {
+	fp, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open excludes file: %w", err)
+	}
+	defer fp.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Trim(line, " \t\r") == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// PathFilter selects pathnames using the gitignore-style rules of
+// kloset/exclude: a pathname is selected when it matches one of the
+// include patterns, if any, and none of the exclude patterns.
+type PathFilter struct {
+	includes *exclude.RuleSet
+	excludes *exclude.RuleSet
+}
+
+func NewPathFilter(includes []string, excludes []string) (*PathFilter, error) {
+	filter := &PathFilter{}
+
+	if len(includes) != 0 {
+		filter.includes = exclude.NewRuleSet()
+		if err := filter.includes.AddRulesFromArray(includes); err != nil {
+			return nil, fmt.Errorf("failed to setup include rules: %w", err)
+		}
+	}
+
+	if len(excludes) != 0 {
+		filter.excludes = exclude.NewRuleSet()
+		if err := filter.excludes.AddRulesFromArray(excludes); err != nil {
+			return nil, fmt.Errorf("failed to setup exclude rules: %w", err)
+		}
+	}
+
+	return filter, nil
+}
+
+// IsEmpty returns true if the filter selects every pathname.
+func (filter *PathFilter) IsEmpty() bool {
+	return filter.includes == nil && filter.excludes == nil
+}
+
+// Includes returns true if pathname matches an include pattern, or if
+// there are none.
+func (filter *PathFilter) Includes(pathname string, isDir bool) bool {
+	return filter.includes == nil || filter.includes.IsExcluded(pathname, isDir)
+}
+
+// Excludes returns true if pathname matches an exclude pattern.
+func (filter *PathFilter) Excludes(pathname string, isDir bool) bool {
+	return filter.excludes != nil && filter.excludes.IsExcluded(pathname, isDir)
+}
+
+func (filter *PathFilter) Match(pathname string, isDir bool) bool {
+	return filter.Includes(pathname, isDir) && !filter.Excludes(pathname, isDir)
+}
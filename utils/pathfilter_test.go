@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternsFlag(t *testing.T) {
+	var patterns PatternsFlag
+	require.NoError(t, patterns.Set("*.sql"))
+	require.NoError(t, patterns.Set("node_modules/"))
+	require.Equal(t, PatternsFlag{"*.sql", "node_modules/"}, patterns)
+	require.Equal(t, "*.sql,node_modules/", patterns.String())
+}
+
+func TestLoadIgnoreFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "patterns")
+	require.NoError(t, os.WriteFile(filename, []byte("# comment\n\n*.sql\n  \n/data/\n"), 0o600))
+
+	lines, err := LoadIgnoreFile(filename)
+	require.NoError(t, err)
+	require.Equal(t, []string{"*.sql", "/data/"}, lines)
+
+	_, err = LoadIgnoreFile(filepath.Join(t.TempDir(), "missing"))
+	require.Error(t, err)
+}
+
+func TestPathFilter(t *testing.T) {
+	filter, err := NewPathFilter(nil, nil)
+	require.NoError(t, err)
+	require.True(t, filter.IsEmpty())
+	require.True(t, filter.Match("/any/path", false))
+
+	filter, err = NewPathFilter([]string{"*.sql"}, []string{"node_modules/", "/tmp"})
+	require.NoError(t, err)
+	require.False(t, filter.IsEmpty())
+
+	require.True(t, filter.Match("/db/dump.sql", false))
+	require.False(t, filter.Match("/db/dump.txt", false))
+	require.False(t, filter.Match("/app/node_modules/x/dump.sql", false))
+	require.False(t, filter.Match("/tmp/dump.sql", false))
+	require.True(t, filter.Match("/db/tmp/dump.sql", false))
+
+	require.False(t, filter.Includes("/db", true))
+	require.True(t, filter.Excludes("/app/node_modules", true))
+	require.False(t, filter.Excludes("/app/node_modules", false))
+
+	filter, err = NewPathFilter(nil, []string{"*.log", "!keep.log"})
+	require.NoError(t, err)
+	require.False(t, filter.Match("/var/app.log", false))
+	require.True(t, filter.Match("/var/keep.log", false))
+	require.True(t, filter.Match("/var/app.txt", false))
+}
@@ -48,20 +48,20 @@ func (t *TimeFlag) Set(s string) error {
 	return nil
 }
 
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"2006/01/02",
+}
+
 func ParseTimeFlag(input string) (time.Time, error) {
 	if input == "" {
 		return time.Time{}, nil
 	}
 
-	layouts := []string{
-		time.RFC3339,
-		"2006-01-02 15:04",
-		"2006-01-02 15:04:05",
-		"2006-01-02",
-		"2006/01/02",
-	}
-
-	for _, layout := range layouts {
+	for _, layout := range timeLayouts {
 		if t, err := time.Parse(layout, input); err == nil {
 			return t, nil
 		}
@@ -75,3 +75,24 @@ func ParseTimeFlag(input string) (time.Time, error) {
 
 	return time.Time{}, fmt.Errorf("invalid time format: %q", input)
 }
+
+// ParseUntilFlag is like ParseTimeFlag but a duration is counted from now
+// into the future.
+func ParseUntilFlag(input string, now time.Time) (time.Time, error) {
+	if input == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, input); err == nil {
+			return t, nil
+		}
+	}
+
+	d, err := human2duration.ParseDuration(input)
+	if err == nil {
+		return now.Add(d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time format: %q", input)
+}
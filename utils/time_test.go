@@ -56,6 +56,26 @@ func TestParseTimeFlag(t *testing.T) {
 	require.True(t, t7.IsZero())
 }
 
+func TestParseUntilFlag(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	t1, err := ParseUntilFlag("", now)
+	require.NoError(t, err)
+	require.True(t, t1.IsZero())
+
+	t2, err := ParseUntilFlag("2027-01-01", now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC), t2)
+
+	// durations are counted into the future
+	t3, err := ParseUntilFlag("2d", now)
+	require.NoError(t, err)
+	require.Equal(t, now.Add(48*time.Hour), t3)
+
+	_, err = ParseUntilFlag("whenever", now)
+	require.ErrorContains(t, err, "invalid time format")
+}
+
 func TestTimeFlag_String(t *testing.T) {
 	var dest time.Time
 	tf := NewTimeFlag(&dest)
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"github.com/PlakarKorp/kloset/objects"
+	"github.com/PlakarKorp/kloset/repository"
+	"github.com/PlakarKorp/kloset/snapshot"
+	"github.com/PlakarKorp/kloset/snapshot/header"
+	"github.com/PlakarKorp/kloset/snapshot/vfs"
+)
+
+// TimelineLocation is the entry found at a pathname in a snapshot.
+type TimelineLocation struct {
+	Snapshot header.Header `json:"snapshot"`
+	Entry    vfs.Entry     `json:"vfs_entry"`
+}
+
+// LocatePathname resolves pathname in each of the snapshots accepted by
+// match, or in all of them if match is nil.  Snapshots where pathname
+// does not exist are skipped, the locations are returned in the order
+// of snapshotIDs.
+func LocatePathname(repo *repository.Repository, snapshotIDs []objects.MAC, pathname string, match func(*header.Header) bool) ([]TimelineLocation, error) {
+	locations := make([]TimelineLocation, 0)
+	for _, snapshotID := range snapshotIDs {
+		snap, err := snapshot.Load(repo, snapshotID)
+		if err != nil {
+			return nil, err
+		}
+
+		if match != nil && !match(snap.Header) {
+			snap.Close()
+			continue
+		}
+
+		pvfs, err := snap.Filesystem()
+		if err != nil {
+			snap.Close()
+			continue
+		}
+
+		entry, err := pvfs.GetEntry(pathname)
+		if err != nil {
+			snap.Close()
+			continue
+		}
+
+		locations = append(locations, TimelineLocation{
+			Snapshot: *snap.Header,
+			Entry:    *entry,
+		})
+		snap.Close()
+	}
+	return locations, nil
+}
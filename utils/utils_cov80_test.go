@@ -7,7 +7,6 @@ import (
 	"strings"
 	"testing"
 
-	"github.com/PlakarKorp/kloset/locate"
 	"github.com/PlakarKorp/plakar/config"
 	"github.com/stretchr/testify/require"
 )
@@ -113,7 +112,7 @@ func TestGetConfMissingLocationCov80(t *testing.T) {
 // Set on a *time.Time field with an invalid value must surface an error
 // (covers the setTime error branch via Set).
 func TestPolicySetTimeInvalidCov80(t *testing.T) {
-	c := &policiesConfig{Policies: map[string]*locate.LocateOptions{}}
+	c := &policiesConfig{Policies: map[string]*Policy{}}
 	c.Add("p")
 	err := c.Set("p", "before", "not-a-real-time")
 	require.Error(t, err)
@@ -122,7 +121,7 @@ func TestPolicySetTimeInvalidCov80(t *testing.T) {
 
 // Set on a *bool field with a non-boolean value must error.
 func TestPolicySetBoolInvalidCov80(t *testing.T) {
-	c := &policiesConfig{Policies: map[string]*locate.LocateOptions{}}
+	c := &policiesConfig{Policies: map[string]*Policy{}}
 	c.Add("p")
 	err := c.Set("p", "latest", "notabool")
 	require.Error(t, err)
@@ -131,7 +130,7 @@ func TestPolicySetBoolInvalidCov80(t *testing.T) {
 
 // Dump to a writer that fails should surface the encode error.
 func TestPolicyDumpEncodeErrorCov80(t *testing.T) {
-	c := &policiesConfig{Policies: map[string]*locate.LocateOptions{}}
+	c := &policiesConfig{Policies: map[string]*Policy{}}
 	c.Add("p")
 	err := c.Dump(failWriterCov80{}, "json", []string{"p"})
 	require.Error(t, err)